@@ -0,0 +1,39 @@
+// Command delta writes the delta between the previous release of the dataset and a freshly built
+// database, for the Alfred workflow to apply instead of downloading the full dataset.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ngshiheng/michelin-my-maps/v3/internal/delta"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	cfg := delta.DefaultConfig()
+	flag.StringVar(&cfg.BasePath, "base", "", "database of the previous release")
+	flag.StringVar(&cfg.DatabasePath, "db", cfg.DatabasePath, "freshly built database")
+	flag.StringVar(&cfg.OutputPath, "out", cfg.OutputPath, "gzipped JSON delta to write")
+	flag.StringVar(&cfg.BaseVersion, "base-version", "", "dataset version of the previous release")
+	flag.StringVar(&cfg.Version, "version", "", "dataset version of the new database")
+	logLevel := flag.String("log", "info", "log level (debug, info, warn, error)")
+	flag.Parse()
+
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid log level %q\n", *logLevel)
+		os.Exit(2)
+	}
+	log.SetLevel(level)
+
+	if cfg.BasePath == "" || cfg.BaseVersion == "" || cfg.Version == "" {
+		fmt.Fprintln(os.Stderr, "Usage: go run ./cmd/delta -base previous/michelin.db -base-version 2025.04 -version 2025.07 [-db data/michelin.db] [-out data/michelin.delta.json.gz]")
+		os.Exit(2)
+	}
+
+	if _, err := delta.Run(cfg); err != nil {
+		log.WithError(err).Fatal("failed to build delta")
+	}
+}
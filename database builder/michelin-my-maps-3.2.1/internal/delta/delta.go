@@ -0,0 +1,249 @@
+package delta
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ngshiheng/michelin-my-maps/v3/internal/models"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// FormatVersion is the version of the delta file format, checked by the reader.
+const FormatVersion = 1
+
+// Config holds configuration for building a delta.
+type Config struct {
+	BasePath     string // database of the previous release
+	DatabasePath string // freshly scraped database
+	OutputPath   string // gzipped JSON delta
+	BaseVersion  string // dataset version of BasePath
+	Version      string // dataset version of DatabasePath
+}
+
+// DefaultConfig returns a default config for building a delta.
+func DefaultConfig() *Config {
+	return &Config{
+		DatabasePath: "data/michelin.db",
+		OutputPath:   "data/michelin.delta.json.gz",
+	}
+}
+
+// Delta lists the changes between two releases of the dataset, keyed by restaurant URL so that
+// it does not depend on row IDs. Award rows are only ever added or updated.
+type Delta struct {
+	Format      int          `json:"format"`
+	BaseVersion string       `json:"base_version"`
+	Version     string       `json:"version"`
+	DatasetDate string       `json:"dataset_date"`
+	Restaurants int          `json:"restaurants"` // restaurant count once applied
+	Added       []Restaurant `json:"added,omitempty"`
+	Changed     []Restaurant `json:"changed,omitempty"`
+	Removed     []string     `json:"removed,omitempty"`
+	Awards      []Award      `json:"awards,omitempty"`
+}
+
+// Restaurant is a restaurant row of a delta.
+type Restaurant struct {
+	URL                   string `json:"url"`
+	Name                  string `json:"name"`
+	Description           string `json:"description"`
+	Address               string `json:"address"`
+	Location              string `json:"location"`
+	Latitude              string `json:"latitude"`
+	Longitude             string `json:"longitude"`
+	Cuisine               string `json:"cuisine"`
+	PhoneNumber           string `json:"phone_number,omitempty"`
+	FacilitiesAndServices string `json:"facilities_and_services,omitempty"`
+	WebsiteURL            string `json:"website_url,omitempty"`
+	ImageURL              string `json:"image_url,omitempty"`
+	InGuide               bool   `json:"in_guide"`
+}
+
+// Award is a new or updated award row of a delta.
+type Award struct {
+	URL         string `json:"url"`
+	Year        int    `json:"year"`
+	Distinction string `json:"distinction"`
+	Price       string `json:"price"`
+	GreenStar   bool   `json:"green_star"`
+	WaybackURL  string `json:"wayback_url,omitempty"`
+}
+
+// Run builds the delta between the databases of a config and writes it.
+func Run(cfg *Config) (*Delta, error) {
+	if cfg.BasePath == "" || cfg.BaseVersion == "" || cfg.Version == "" {
+		return nil, fmt.Errorf("a base database, base version and version are required")
+	}
+
+	base, err := openReadOnly(cfg.BasePath)
+	if err != nil {
+		return nil, err
+	}
+	target, err := openReadOnly(cfg.DatabasePath)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := Build(base, target, cfg.BaseVersion, cfg.Version)
+	if err != nil {
+		return nil, err
+	}
+	if err := Write(cfg.OutputPath, d); err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"base":    cfg.BaseVersion,
+		"version": cfg.Version,
+		"added":   len(d.Added),
+		"changed": len(d.Changed),
+		"removed": len(d.Removed),
+		"awards":  len(d.Awards),
+		"output":  cfg.OutputPath,
+	}).Info("delta written")
+	return d, nil
+}
+
+// Build compares two databases and returns the changes that turn base into target.
+func Build(base, target *gorm.DB, baseVersion, version string) (*Delta, error) {
+	baseRestaurants, err := loadRestaurants(base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load base restaurants: %w", err)
+	}
+	targetRestaurants, err := loadRestaurants(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load restaurants: %w", err)
+	}
+
+	d := &Delta{
+		Format:      FormatVersion,
+		BaseVersion: baseVersion,
+		Version:     version,
+		DatasetDate: time.Now().UTC().Format("2006-01-02"),
+		Restaurants: len(targetRestaurants),
+	}
+
+	for url, r := range targetRestaurants {
+		row := toRestaurant(r)
+		old, found := baseRestaurants[url]
+		switch {
+		case !found:
+			d.Added = append(d.Added, row)
+		case toRestaurant(old) != row:
+			d.Changed = append(d.Changed, row)
+		}
+
+		oldAwards := make(map[int]Award)
+		if found {
+			for _, a := range old.Awards {
+				oldAwards[a.Year] = toAward(url, a)
+			}
+		}
+		for _, a := range r.Awards {
+			award := toAward(url, a)
+			if previous, ok := oldAwards[a.Year]; !ok || previous != award {
+				d.Awards = append(d.Awards, award)
+			}
+		}
+	}
+
+	for url := range baseRestaurants {
+		if _, found := targetRestaurants[url]; !found {
+			d.Removed = append(d.Removed, url)
+		}
+	}
+
+	// Sorted output keeps deltas of the same data identical
+	sort.Slice(d.Added, func(i, j int) bool { return d.Added[i].URL < d.Added[j].URL })
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].URL < d.Changed[j].URL })
+	sort.Strings(d.Removed)
+	sort.Slice(d.Awards, func(i, j int) bool {
+		if d.Awards[i].URL != d.Awards[j].URL {
+			return d.Awards[i].URL < d.Awards[j].URL
+		}
+		return d.Awards[i].Year < d.Awards[j].Year
+	})
+
+	return d, nil
+}
+
+// Write saves a delta as gzipped JSON.
+func Write(path string, d *Delta) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create delta file: %w", err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	if err := json.NewEncoder(gz).Encode(d); err != nil {
+		return fmt.Errorf("failed to encode delta: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress delta: %w", err)
+	}
+	return f.Close()
+}
+
+// openReadOnly opens a database without migrating or otherwise changing it.
+func openReadOnly(path string) (*gorm.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=ro", path)), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// loadRestaurants reads all restaurants with their awards, keyed by URL.
+func loadRestaurants(db *gorm.DB) (map[string]models.Restaurant, error) {
+	var restaurants []models.Restaurant
+	if err := db.Preload("Awards").Where("url != ''").Find(&restaurants).Error; err != nil {
+		return nil, err
+	}
+	byURL := make(map[string]models.Restaurant, len(restaurants))
+	for _, r := range restaurants {
+		byURL[r.URL] = r
+	}
+	return byURL, nil
+}
+
+func toRestaurant(r models.Restaurant) Restaurant {
+	return Restaurant{
+		URL:                   r.URL,
+		Name:                  r.Name,
+		Description:           r.Description,
+		Address:               r.Address,
+		Location:              r.Location,
+		Latitude:              r.Latitude,
+		Longitude:             r.Longitude,
+		Cuisine:               r.Cuisine,
+		PhoneNumber:           r.PhoneNumber,
+		FacilitiesAndServices: r.FacilitiesAndServices,
+		WebsiteURL:            r.WebsiteURL,
+		ImageURL:              r.ImageURL,
+		InGuide:               r.InGuide,
+	}
+}
+
+func toAward(url string, a models.RestaurantAward) Award {
+	return Award{
+		URL:         url,
+		Year:        a.Year,
+		Distinction: a.Distinction,
+		Price:       a.Price,
+		GreenStar:   a.GreenStar,
+		WaybackURL:  a.WaybackURL,
+	}
+}
@@ -0,0 +1,74 @@
+package delta
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ngshiheng/michelin-my-maps/v3/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T, restaurants ...models.Restaurant) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()+fmt.Sprint(len(restaurants)))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Restaurant{}, &models.RestaurantAward{}))
+	for i := range restaurants {
+		require.NoError(t, db.Create(&restaurants[i]).Error)
+	}
+	return db
+}
+
+func testRestaurant(url, name, distinction string, years ...int) models.Restaurant {
+	r := models.Restaurant{
+		URL: url, Name: name, Description: "d", Address: "a", Location: "Paris, France",
+		Latitude: "48.85", Longitude: "2.35", Cuisine: "French", InGuide: true,
+	}
+	for _, year := range years {
+		r.Awards = append(r.Awards, models.RestaurantAward{Year: year, Distinction: distinction, Price: "€€€"})
+	}
+	return r
+}
+
+func TestBuild(t *testing.T) {
+	base := newTestDB(t,
+		testRestaurant("https://guide/a", "A", models.OneStar, 2024),
+		testRestaurant("https://guide/b", "B", models.BibGourmand, 2024),
+		testRestaurant("https://guide/c", "C", models.OneStar, 2024),
+	)
+	target := newTestDB(t,
+		testRestaurant("https://guide/a", "A", models.OneStar, 2024, 2025),
+		testRestaurant("https://guide/b", "B renamed", models.BibGourmand, 2024),
+		testRestaurant("https://guide/d", "D", models.TwoStars, 2025),
+		testRestaurant("https://guide/e", "E", models.SelectedRestaurants, 2025),
+	)
+
+	d, err := Build(base, target, "2025.01", "2025.07")
+	require.NoError(t, err)
+
+	assert.Equal(t, FormatVersion, d.Format)
+	assert.Equal(t, "2025.01", d.BaseVersion)
+	assert.Equal(t, 4, d.Restaurants)
+
+	urls := func(rs []Restaurant) []string {
+		var out []string
+		for _, r := range rs {
+			out = append(out, r.URL)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"https://guide/d", "https://guide/e"}, urls(d.Added))
+	assert.Equal(t, []string{"https://guide/b"}, urls(d.Changed))
+	assert.Equal(t, []string{"https://guide/c"}, d.Removed)
+
+	var awards []string
+	for _, a := range d.Awards {
+		awards = append(awards, fmt.Sprintf("%s %d", a.URL, a.Year))
+	}
+	assert.Equal(t, []string{"https://guide/a 2025", "https://guide/d 2025", "https://guide/e 2025"}, awards)
+}
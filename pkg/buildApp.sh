@@ -23,14 +23,21 @@ mkdir -p "$OUTPUT_DIR"
 # Clean up any existing binaries
 rm -f "${OUTPUT_DIR}/${APP_NAME}" "${OUTPUT_DIR}/${APP_NAME}_x86_64" "${OUTPUT_DIR}/${APP_NAME}_arm64"
 
+# Full-text search tests only build with the FTS5 tag, so run them the way the binary is built
+echo "Running tests..."
+CGO_ENABLED=1 go test -tags sqlite_fts5 ./... || {
+    echo "Error: Tests failed"
+    exit 1
+}
+
 echo "Compiling for x86_64..."
-CGO_ENABLED=1 GOARCH=amd64 GOOS=darwin go build -o "${OUTPUT_DIR}/${APP_NAME}_x86_64" . || {
+CGO_ENABLED=1 GOARCH=amd64 GOOS=darwin go build -tags sqlite_fts5 -o "${OUTPUT_DIR}/${APP_NAME}_x86_64" . || {
     echo "Error: Failed to compile for x86_64"
     exit 1
 }
 
 echo "Compiling for arm64..."
-CGO_ENABLED=1 GOARCH=arm64 GOOS=darwin go build -o "${OUTPUT_DIR}/${APP_NAME}_arm64" . || {
+CGO_ENABLED=1 GOARCH=arm64 GOOS=darwin go build -tags sqlite_fts5 -o "${OUTPUT_DIR}/${APP_NAME}_arm64" . || {
     echo "Error: Failed to compile for arm64"
     exit 1
 }
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// currentAwardQuery selects, per restaurant, the award of its latest year with the first year it held
+// that distinction. Correlated lookups use the award indexes, where aggregating the whole history
+// and joining it back does not. %s narrows the restaurants, and is empty to select all.
+const currentAwardQuery = `
+	SELECT
+		ra.restaurant_id,
+		ra.distinction,
+		ra.price,
+		ra.green_star,
+		(SELECT MIN(earliest.year) FROM restaurant_awards earliest
+			WHERE earliest.restaurant_id = ra.restaurant_id AND earliest.distinction = ra.distinction),
+		ra.year
+	FROM restaurant_awards ra
+	WHERE ra.year = (SELECT MAX(latest.year) FROM restaurant_awards latest WHERE latest.restaurant_id = ra.restaurant_id)
+		%s
+`
+
+// refreshCurrentAward recomputes the current award of the restaurant of a trigger row, OLD or NEW
+func refreshCurrentAward(row string) string {
+	return `
+		DELETE FROM restaurant_current_award WHERE restaurant_id = ` + row + `.restaurant_id;
+		INSERT OR REPLACE INTO restaurant_current_award
+			(restaurant_id, distinction, price, green_star, first_year, last_year)
+		` + fmt.Sprintf(currentAwardQuery, "AND ra.restaurant_id = "+row+".restaurant_id") + `;
+	`
+}
+
+// currentAwardTable holds the current award of every restaurant, so that searches read one row
+// instead of aggregating the award history on every keystroke. Full updates rebuild it and triggers
+// keep it in step with any other change to restaurant_awards, such as a delta; applied by migration 7.
+var currentAwardTable = `
+	CREATE TABLE IF NOT EXISTS restaurant_current_award (
+		restaurant_id INTEGER PRIMARY KEY,
+		distinction TEXT,
+		price TEXT,
+		green_star NUMERIC,
+		first_year INTEGER,
+		last_year INTEGER
+	);
+
+	CREATE TRIGGER IF NOT EXISTS restaurant_awards_current_insert AFTER INSERT ON restaurant_awards BEGIN
+		` + refreshCurrentAward("NEW") + `
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS restaurant_awards_current_update AFTER UPDATE ON restaurant_awards BEGIN
+		` + refreshCurrentAward("OLD") + `
+		` + refreshCurrentAward("NEW") + `
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS restaurant_awards_current_delete AFTER DELETE ON restaurant_awards BEGIN
+		` + refreshCurrentAward("OLD") + `
+	END;
+
+	DELETE FROM restaurant_current_award;
+	INSERT OR REPLACE INTO restaurant_current_award
+		(restaurant_id, distinction, price, green_star, first_year, last_year)
+	` + fmt.Sprintf(currentAwardQuery, "") + `;
+`
+
+// RebuildCurrentAwards recomputes restaurant_current_award from the award history
+func RebuildCurrentAwards(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin current award rebuild: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM restaurant_current_award"); err != nil {
+		return fmt.Errorf("failed to clear current awards: %v", err)
+	}
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO restaurant_current_award
+			(restaurant_id, distinction, price, green_star, first_year, last_year)
+		` + fmt.Sprintf(currentAwardQuery, ""))
+	if err != nil {
+		return fmt.Errorf("failed to compute current awards: %v", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestCurrentAwardTriggers(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createTestDatabase(t, dbPath, 2, `
+		INSERT INTO restaurant_awards (restaurant_id, year, distinction, price) VALUES
+			(1, 2022, '1 Star', '€€€'), (1, 2023, '2 Stars', '€€€'), (1, 2024, '2 Stars', '€€€€'),
+			(2, 2024, 'Bib Gourmand', '€€');
+	`)
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+	if err := Migrate(database); err != nil {
+		t.Fatalf("Migrate() returned error: %v", err)
+	}
+
+	current := func(id int64) string {
+		var award sql.NullString
+		var first, last sql.NullInt64
+		err := database.QueryRow("SELECT distinction, first_year, last_year FROM restaurant_current_award WHERE restaurant_id = ?", id).
+			Scan(&award, &first, &last)
+		if err == sql.ErrNoRows {
+			return "none"
+		}
+		if err != nil {
+			t.Fatalf("failed to read current award: %v", err)
+		}
+		return fmt.Sprintf("%s %d-%d", award.String, first.Int64, last.Int64)
+	}
+
+	cases := []struct {
+		Name     string
+		Change   string
+		ID       int64
+		Expected string
+	}{
+		{"built by the migration", "", 1, "2 Stars 2023-2024"},
+		{"new year", "INSERT INTO restaurant_awards (restaurant_id, year, distinction, price) VALUES (1, 2025, '3 Stars', '€€€€')", 1, "3 Stars 2025-2025"},
+		{"corrected award", "UPDATE restaurant_awards SET distinction = '2 Stars' WHERE restaurant_id = 1 AND year = 2025", 1, "2 Stars 2023-2025"},
+		{"moved award", "UPDATE restaurant_awards SET restaurant_id = 2 WHERE restaurant_id = 1 AND year = 2025", 1, "2 Stars 2023-2024"},
+		{"moved award target", "", 2, "2 Stars 2025-2025"},
+		{"removed awards", "DELETE FROM restaurant_awards WHERE restaurant_id = 2", 2, "none"},
+	}
+
+	for _, tt := range cases {
+		if tt.Change != "" {
+			if _, err := database.Exec(tt.Change); err != nil {
+				t.Fatalf("%s: %v", tt.Name, err)
+			}
+		}
+		if got := current(tt.ID); got != tt.Expected {
+			t.Errorf("%s: current award of %d = %q, expected %q", tt.Name, tt.ID, got, tt.Expected)
+		}
+	}
+
+	// A rebuild agrees with what the triggers maintained
+	if _, err := database.Exec("UPDATE restaurant_current_award SET distinction = 'stale'"); err != nil {
+		t.Fatalf("failed to corrupt current awards: %v", err)
+	}
+	if err := RebuildCurrentAwards(database); err != nil {
+		t.Fatalf("RebuildCurrentAwards() returned error: %v", err)
+	}
+	if got := current(1); got != "2 Stars 2023-2024" {
+		t.Errorf("current award of 1 after rebuild = %q, expected %q", got, "2 Stars 2023-2024")
+	}
+}
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// AwardChangeType is a kind of change between two guide years
+type AwardChangeType string
+
+const (
+	ChangeNewStar     AwardChangeType = "new_star"
+	ChangePromotion   AwardChangeType = "promotion"
+	ChangeDemotion    AwardChangeType = "demotion"
+	ChangeNewBib      AwardChangeType = "new_bib"
+	ChangeGreenGained AwardChangeType = "green_gained"
+	ChangeGreenLost   AwardChangeType = "green_lost"
+	ChangeDropped     AwardChangeType = "dropped"
+)
+
+// AwardChangeTypes lists the change types in display order
+var AwardChangeTypes = []AwardChangeType{
+	ChangeNewStar, ChangePromotion, ChangeNewBib, ChangeGreenGained,
+	ChangeDemotion, ChangeGreenLost, ChangeDropped,
+}
+
+// AwardChange is a change of a restaurant's distinction from one guide year to the next
+type AwardChange struct {
+	Type                AwardChangeType
+	Restaurant          Restaurant
+	Distinction         string // empty when the restaurant dropped out
+	GreenStar           bool
+	PreviousDistinction string // empty when the restaurant is new in the guide
+	PreviousGreenStar   bool
+}
+
+// AwardChangeReport holds the changes between a guide year and the previous one
+type AwardChangeReport struct {
+	Year         int
+	PreviousYear int
+	Changes      []AwardChange
+}
+
+// distinctionRank orders distinctions from Selected Restaurants (1) to 3 Stars (5)
+func distinctionRank(distinction string) int {
+	d := strings.ToLower(distinction)
+	switch {
+	case strings.Contains(d, "3 star"):
+		return 5
+	case strings.Contains(d, "2 star"):
+		return 4
+	case strings.Contains(d, "1 star"):
+		return 3
+	case strings.Contains(d, "bib gourmand"):
+		return 2
+	case strings.Contains(d, "selected"):
+		return 1
+	}
+	return 0
+}
+
+// classifyAwardChange returns the changes between a previous and a current distinction;
+// an empty distinction means the restaurant was not in the guide that year
+func classifyAwardChange(previous, current string, previousGreen, currentGreen bool) []AwardChangeType {
+	if current == "" {
+		if previous == "" {
+			return nil
+		}
+		return []AwardChangeType{ChangeDropped}
+	}
+
+	var changes []AwardChangeType
+	previousRank, currentRank := distinctionRank(previous), distinctionRank(current)
+	starRank := distinctionRank("1 Star")
+	bibRank := distinctionRank("Bib Gourmand")
+
+	switch {
+	case currentRank >= starRank && previousRank < starRank:
+		changes = append(changes, ChangeNewStar)
+	case currentRank > previousRank && currentRank >= starRank:
+		changes = append(changes, ChangePromotion)
+	case currentRank == bibRank && previousRank < bibRank:
+		changes = append(changes, ChangeNewBib)
+	case previous != "" && currentRank < previousRank:
+		changes = append(changes, ChangeDemotion)
+	}
+
+	if currentGreen && !previousGreen {
+		changes = append(changes, ChangeGreenGained)
+	} else if previousGreen && !currentGreen && previous != "" {
+		changes = append(changes, ChangeGreenLost)
+	}
+
+	return changes
+}
+
+// GetAwardChanges compares a guide year (0 for the latest) with the previous guide year,
+// optionally restricted to restaurants whose location matches place (a city or country)
+func GetAwardChanges(db *sql.DB, year int, place string) (AwardChangeReport, error) {
+	var report AwardChangeReport
+
+	if year == 0 {
+		var latest sql.NullInt64
+		if err := db.QueryRow("SELECT MAX(year) FROM restaurant_awards").Scan(&latest); err != nil {
+			return report, fmt.Errorf("failed to get latest guide year: %v", err)
+		}
+		if !latest.Valid {
+			return report, fmt.Errorf("no award history in the database")
+		}
+		year = int(latest.Int64)
+	}
+
+	var previous sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(year) FROM restaurant_awards WHERE year < ?", year).Scan(&previous); err != nil {
+		return report, fmt.Errorf("failed to get previous guide year: %v", err)
+	}
+	if !previous.Valid {
+		return report, fmt.Errorf("no guide year before %d to compare with", year)
+	}
+	report.Year = year
+	report.PreviousYear = int(previous.Int64)
+
+	// The restaurants come with their user data and current award; the award of the year and the
+	// previous one are the extra columns
+	from := `restaurants r
+		LEFT JOIN (
+			SELECT restaurant_id, distinction, green_star FROM restaurant_awards WHERE year = ? GROUP BY restaurant_id
+		) cur ON cur.restaurant_id = r.id
+		LEFT JOIN (
+			SELECT restaurant_id, distinction, green_star FROM restaurant_awards WHERE year = ? GROUP BY restaurant_id
+		) prev ON prev.restaurant_id = r.id`
+	extra := "COALESCE(cur.distinction, ''), COALESCE(cur.green_star, 0), COALESCE(prev.distinction, ''), COALESCE(prev.green_star, 0)"
+	clauses := "WHERE (cur.restaurant_id IS NOT NULL OR prev.restaurant_id IS NOT NULL)"
+	args := []interface{}{year, report.PreviousYear}
+
+	if place = strings.TrimSpace(place); place != "" {
+		clauses += " AND (COALESCE(r.location_normalized, LOWER(r.location)) LIKE ? OR r.location_transliterated LIKE ?)"
+		pattern := "%" + normalizeForSearch(place) + "%"
+		args = append(args, pattern, pattern)
+	}
+	clauses += " ORDER BY r.name"
+
+	rows, err := db.Query(restaurantQuery(from, extra, clauses), args...)
+	if err != nil {
+		return report, fmt.Errorf("failed to get award changes: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var current, previous string
+		var currentGreen, previousGreen bool
+		r, err := scanRestaurant(rows, &current, &currentGreen, &previous, &previousGreen)
+		if err != nil {
+			return report, fmt.Errorf("failed to scan award change: %v", err)
+		}
+
+		for _, changeType := range classifyAwardChange(previous, current, previousGreen, currentGreen) {
+			report.Changes = append(report.Changes, AwardChange{
+				Type:                changeType,
+				Restaurant:          r,
+				Distinction:         current,
+				GreenStar:           currentGreen,
+				PreviousDistinction: previous,
+				PreviousGreenStar:   previousGreen,
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return report, fmt.Errorf("failed to read award changes: %v", err)
+	}
+
+	return report, nil
+}
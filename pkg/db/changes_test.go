@@ -0,0 +1,113 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClassifyAwardChange(t *testing.T) {
+	cases := []struct {
+		Name                        string
+		Previous, Current           string
+		PreviousGreen, CurrentGreen bool
+		Expected                    []AwardChangeType
+	}{
+		{"unchanged", "1 Star", "1 Star", false, false, nil},
+		{"new entry with a star", "", "1 Star", false, false, []AwardChangeType{ChangeNewStar}},
+		{"first star from Bib", "Bib Gourmand", "1 Star", false, false, []AwardChangeType{ChangeNewStar}},
+		{"promotion", "1 Star", "2 Stars", false, false, []AwardChangeType{ChangePromotion}},
+		{"demotion", "3 Stars", "2 Stars", false, false, []AwardChangeType{ChangeDemotion}},
+		{"star lost to Bib", "1 Star", "Bib Gourmand", false, false, []AwardChangeType{ChangeDemotion}},
+		{"new Bib", "Selected Restaurants", "Bib Gourmand", false, false, []AwardChangeType{ChangeNewBib}},
+		{"new Bib entry", "", "Bib Gourmand", false, false, []AwardChangeType{ChangeNewBib}},
+		{"new selected is not reported", "", "Selected Restaurants", false, false, nil},
+		{"green gained", "2 Stars", "2 Stars", false, true, []AwardChangeType{ChangeGreenGained}},
+		{"promotion and green lost", "1 Star", "2 Stars", true, false, []AwardChangeType{ChangePromotion, ChangeGreenLost}},
+		{"dropped", "1 Star", "", true, false, []AwardChangeType{ChangeDropped}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			got := classifyAwardChange(tt.Previous, tt.Current, tt.PreviousGreen, tt.CurrentGreen)
+			if !reflect.DeepEqual(got, tt.Expected) {
+				t.Errorf("classifyAwardChange(%q, %q) = %v, expected %v", tt.Previous, tt.Current, got, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestGetAwardChanges(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createDeltaBase(t, dbPath)
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	// 2024 has no guide, so 2025 compares with 2023
+	_, err = database.Exec(`
+		INSERT INTO restaurants (url, name, description, address, location, latitude, longitude, cuisine, in_guide) VALUES
+			('https://guide.michelin.com/d', 'Delta', '', '', 'Lyon, France', '0', '0', 'French', 0);
+		INSERT INTO restaurant_awards (restaurant_id, year, distinction, price, green_star) VALUES
+			(3, 2021, '3 Stars', '€€€€', 0),
+			(1, 2023, 'Bib Gourmand', '€€', 0), (2, 2023, '1 Star', '€€€', 0), (4, 2023, '2 Stars', '€€€€', 1);
+	`)
+	if err != nil {
+		t.Fatalf("failed to add award history: %v", err)
+	}
+	if err := populateNormalizedColumns(database); err != nil {
+		t.Fatalf("populateNormalizedColumns() returned error: %v", err)
+	}
+
+	cases := []struct {
+		Name     string
+		Year     int
+		Place    string
+		Previous int
+		Expected string
+	}{
+		{"latest", 0, "", 2023, "Alpha new_star,Beta demotion,Delta dropped"},
+		{"earlier year", 2023, "", 2021, "Alpha new_bib,Beta new_star,Delta new_star,Delta green_gained,Gamma dropped"},
+		{"city", 0, "lyon", 2023, "Beta demotion,Delta dropped"},
+		{"country", 2023, "FRANCE", 2021, "Alpha new_bib,Beta new_star,Delta new_star,Delta green_gained,Gamma dropped"},
+		{"no match", 0, "rome", 2023, ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			report, err := GetAwardChanges(database, tt.Year, tt.Place)
+			if err != nil {
+				t.Fatalf("GetAwardChanges() returned error: %v", err)
+			}
+			if report.PreviousYear != tt.Previous {
+				t.Errorf("previous year = %d, expected %d", report.PreviousYear, tt.Previous)
+			}
+
+			var changes []string
+			for _, change := range report.Changes {
+				changes = append(changes, *change.Restaurant.Name+" "+string(change.Type))
+			}
+			if got := strings.Join(changes, ","); got != tt.Expected {
+				t.Errorf("changes = %s, expected %s", got, tt.Expected)
+			}
+		})
+	}
+
+	// The restaurants come with their award details and user data
+	report, _ := GetAwardChanges(database, 2023, "nice")
+	if len(report.Changes) != 1 {
+		t.Fatalf("expected one change in Nice, got %d", len(report.Changes))
+	}
+	gamma := report.Changes[0]
+	if gamma.PreviousDistinction != "3 Stars" || gamma.Distinction != "" || !gamma.Restaurant.IsFavorite {
+		t.Errorf("change of Gamma = %+v, expected it to drop its 3 Stars and stay a favorite", gamma)
+	}
+
+	if _, err := GetAwardChanges(database, 2021, ""); err == nil {
+		t.Errorf("GetAwardChanges() compared the first guide year with an earlier one")
+	}
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	// busyTimeoutMs is how long a connection waits for another run of the workflow to finish writing
+	busyTimeoutMs = 5000
+	// lockFileName guards replacing the database: runs that write hold it shared, migrations and
+	// updates exclusively
+	lockFileName = ".michelin.lock"
+)
+
+// Open connects to the database at dbPath. Writable connections use WAL journaling, so searches keep
+// reading while another run writes, and take the write lock when a transaction begins rather than at
+// its first write, so concurrent runs wait for each other instead of failing with "database is
+// locked". Read-only connections are for commands that only search and list.
+func Open(dbPath string, readOnly bool) (*sql.DB, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	dsn := "file:" + (&url.URL{Path: dbPath}).EscapedPath() + fmt.Sprintf("?_busy_timeout=%d", busyTimeoutMs)
+	if readOnly {
+		dsn += "&mode=ro"
+	} else {
+		dsn += "&_journal_mode=WAL&_synchronous=NORMAL&_txlock=immediate"
+	}
+
+	database, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	if err := database.Ping(); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	return database, nil
+}
+
+// NeedsMigration reports whether the database at dbPath is missing a migration or the full-text
+// index, using a read-only connection so that the usual case costs no write
+func NeedsMigration(dbPath string) (bool, error) {
+	database, err := Open(dbPath, true)
+	if err != nil {
+		return false, err
+	}
+	defer database.Close()
+
+	var recorded bool
+	err = database.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = 'schema_migrations')").Scan(&recorded)
+	if err != nil {
+		return false, fmt.Errorf("failed to check schema migrations: %v", err)
+	}
+	if !recorded {
+		return true, nil
+	}
+	version, err := SchemaVersion(database)
+	if err != nil {
+		return false, err
+	}
+	if version < migrations[len(migrations)-1].Version {
+		return true, nil
+	}
+
+	// The index is only built by binaries with FTS5, see MigrateFullTextIndex
+	var fullText, indexed bool
+	err = database.QueryRow(`
+		SELECT sqlite_compileoption_used('ENABLE_FTS5'),
+			EXISTS(SELECT 1 FROM sqlite_master WHERE name = ?)
+	`, fullTextTable).Scan(&fullText, &indexed)
+	if err != nil {
+		return false, fmt.Errorf("failed to check full-text index: %v", err)
+	}
+	return fullText && !indexed, nil
+}
+
+// Prepare applies pending migrations to the database at dbPath. It only takes the write lock and a
+// writable connection when NeedsMigration finds something to do, so it runs once per schema version.
+func Prepare(dbPath string) error {
+	pending, err := NeedsMigration(dbPath)
+	if err != nil || !pending {
+		return err
+	}
+
+	unlock, err := Lock(dbPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Another run may have migrated while this one waited for the lock
+	if pending, err = NeedsMigration(dbPath); err != nil || !pending {
+		return err
+	}
+
+	database, err := Open(dbPath, false)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	if err := Migrate(database); err != nil {
+		return err
+	}
+	if err := MigrateFullTextIndex(database); err != nil {
+		return fmt.Errorf("failed to migrate full-text index: %v", err)
+	}
+	return nil
+}
+
+// Lock takes the workflow's write lock for the database at dbPath exclusively, waiting for any other
+// run that holds it, and returns the function releasing it. Migrations and updates hold it.
+func Lock(dbPath string) (func(), error) {
+	unlock, _, err := lockDatabase(dbPath, syscall.LOCK_EX, true)
+	return unlock, err
+}
+
+// TryLock takes the write lock exclusively if no other run holds it; ok is false when it is taken
+func TryLock(dbPath string) (unlock func(), ok bool, err error) {
+	return lockDatabase(dbPath, syscall.LOCK_EX, false)
+}
+
+// LockShared takes the write lock for the database at dbPath shared with other writing runs, waiting
+// for a migration or update that holds it. Runs hold it while they have a writable connection open,
+// so that an update never replaces the file under them. A run holding it must not call Lock.
+func LockShared(dbPath string) (func(), error) {
+	unlock, _, err := lockDatabase(dbPath, syscall.LOCK_SH, true)
+	return unlock, err
+}
+
+// lockDatabase takes an advisory lock on the lock file next to the database. The lock belongs to
+// the open file, so the system releases it when a run exits or crashes.
+func lockDatabase(dbPath string, how int, wait bool) (func(), bool, error) {
+	f, err := os.OpenFile(filepath.Join(filepath.Dir(dbPath), lockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open lock file: %v", err)
+	}
+
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to lock database: %v", err)
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, true, nil
+}
+
+// checkpoint moves the WAL of a database into its main file and empties it, so that the file can be
+// copied or replaced without leaving committed changes behind in a WAL that no longer matches it
+func checkpoint(dbPath string) error {
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+	_, err = database.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	return err
+}
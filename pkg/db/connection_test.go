@@ -0,0 +1,155 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fullTextColumns completes the test schema for binaries built with FTS5, whose Prepare indexes them
+const fullTextColumns = "ALTER TABLE restaurants ADD COLUMN description TEXT; ALTER TABLE restaurants ADD COLUMN facilities_and_services TEXT;"
+
+func TestPrepareMigratesOnce(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createTestDatabase(t, dbPath, 3, fullTextColumns)
+
+	pending, err := NeedsMigration(dbPath)
+	if err != nil || !pending {
+		t.Fatalf("NeedsMigration() on a new database = %v, %v, expected pending migrations", pending, err)
+	}
+	if err := Prepare(dbPath); err != nil {
+		t.Fatalf("Prepare() returned error: %v", err)
+	}
+	pending, err = NeedsMigration(dbPath)
+	if err != nil || pending {
+		t.Errorf("NeedsMigration() after Prepare() = %v, %v, expected nothing pending", pending, err)
+	}
+
+	database, err := Open(dbPath, false)
+	if err != nil {
+		t.Fatalf("Open() returned error: %v", err)
+	}
+	defer database.Close()
+	var mode string
+	if err := database.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("journal mode = %q, %v, expected wal", mode, err)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createTestDatabase(t, dbPath, 3, fullTextColumns)
+	if err := Prepare(dbPath); err != nil {
+		t.Fatalf("Prepare() returned error: %v", err)
+	}
+
+	database, err := Open(dbPath, true)
+	if err != nil {
+		t.Fatalf("Open() returned error: %v", err)
+	}
+	defer database.Close()
+
+	var count int
+	if err := database.QueryRow("SELECT COUNT(*) FROM restaurants").Scan(&count); err != nil || count != 3 {
+		t.Errorf("read-only count = %d, %v, expected 3", count, err)
+	}
+	_, err = database.Exec("INSERT INTO user_favorites (restaurant_id) VALUES (1)")
+	if err == nil || !strings.Contains(err.Error(), "readonly") {
+		t.Errorf("write on a read-only connection = %v, expected a readonly error", err)
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createTestDatabase(t, dbPath, 20, fullTextColumns)
+	if err := Prepare(dbPath); err != nil {
+		t.Fatalf("Prepare() returned error: %v", err)
+	}
+
+	// Separate connections stand in for Alfred runs started while the previous ones are still writing
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			database, err := Open(dbPath, false)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer database.Close()
+
+			tx, err := database.Begin()
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer tx.Rollback()
+			var favorites int
+			if err := tx.QueryRow("SELECT COUNT(*) FROM user_favorites").Scan(&favorites); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := tx.Exec("INSERT INTO user_favorites (restaurant_id) VALUES (?)", id); err != nil {
+				errs <- fmt.Errorf("favorite %d: %v", id, err)
+				return
+			}
+			errs <- tx.Commit()
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent write failed: %v", err)
+		}
+	}
+}
+
+func TestTryLock(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+
+	unlock, err := Lock(dbPath)
+	if err != nil {
+		t.Fatalf("Lock() returned error: %v", err)
+	}
+	if _, ok, err := TryLock(dbPath); ok || err != nil {
+		t.Errorf("TryLock() while locked = %v, %v, expected the lock to be taken", ok, err)
+	}
+	unlock()
+
+	unlock, ok, err := TryLock(dbPath)
+	if !ok || err != nil {
+		t.Fatalf("TryLock() after unlocking = %v, %v, expected the lock", ok, err)
+	}
+	unlock()
+}
+
+func TestLockShared(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+
+	// Writing runs share the lock, an update cannot take it until they are done
+	first, err := LockShared(dbPath)
+	if err != nil {
+		t.Fatalf("LockShared() returned error: %v", err)
+	}
+	second, err := LockShared(dbPath)
+	if err != nil {
+		t.Fatalf("second LockShared() returned error: %v", err)
+	}
+	first()
+	if _, ok, err := TryLock(dbPath); ok || err != nil {
+		t.Errorf("TryLock() while shared = %v, %v, expected the lock to be taken", ok, err)
+	}
+	second()
+
+	unlock, ok, err := TryLock(dbPath)
+	if !ok || err != nil {
+		t.Fatalf("TryLock() after the writers = %v, %v, expected the lock", ok, err)
+	}
+	unlock()
+}
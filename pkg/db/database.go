@@ -7,9 +7,7 @@ import (
 	"strings"
 	"unicode"
 
-	"archive/zip"
 	"io"
-	"path/filepath"
 
 	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/text/runes"
@@ -21,13 +19,13 @@ const (
 	DbFileName = "michelin.db"
 )
 
-// NoUpdateAvailableError indicates that no database update file was found
+// NoUpdateAvailableError indicates that the update source has nothing new to install
 type NoUpdateAvailableError struct {
 	path string
 }
 
 func (e *NoUpdateAvailableError) Error() string {
-	return fmt.Sprintf("no database update file found at %s", e.path)
+	return fmt.Sprintf("no database update available from %s", e.path)
 }
 
 // IsNoUpdateAvailable checks if an error is a NoUpdateAvailableError
@@ -36,12 +34,31 @@ func IsNoUpdateAvailable(err error) bool {
 	return ok
 }
 
-// normalizeForSearch removes accents and converts to lowercase for search comparison
+// transliterations spells out letters that are not plain accented letters
+// (German umlauts, Scandinavian letters, ligatures), so that "Köln" matches "koeln"
+var transliterations = strings.NewReplacer(
+	"ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss",
+	"æ", "ae", "œ", "oe", "ø", "oe", "å", "aa",
+	"þ", "th", "ð", "d", "ł", "l", "đ", "d",
+)
+
+// normalizeForSearch removes accents and converts to lowercase for search comparison, so that
+// "Köln" matches "koln"
 func normalizeForSearch(text string) string {
-	// First normalize to NFD (decomposed form)
+	return stripAccents(strings.ToLower(text))
+}
+
+// transliterateForSearch also spells out special letters, so that "Köln" matches "koeln". Searches
+// compare against both forms, kept in the *_normalized and *_transliterated columns.
+func transliterateForSearch(text string) string {
+	return stripAccents(transliterations.Replace(strings.ToLower(text)))
+}
+
+// stripAccents normalizes to NFD (decomposed form) and drops the combining marks
+func stripAccents(text string) string {
 	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
 	normalized, _, _ := transform.String(t, text)
-	return strings.ToLower(normalized)
+	return normalized
 }
 
 // isCaseSensitiveSearch detects if a search term should be case-sensitive
@@ -72,37 +89,21 @@ func isCaseSensitiveSearch(term string) bool {
 	return false
 }
 
-// MigrateNormalizedColumns adds normalized columns for accent-insensitive search
-func MigrateNormalizedColumns(db *sql.DB) error {
-	// Check if normalized columns already exist
-	var hasNormalized bool
-
-	// Check if name_normalized column exists
-	rows, err := db.Query("PRAGMA table_info(restaurants)")
+// migrateNormalizedColumns adds and fills the normalized and transliterated columns used for
+// accent-insensitive search, and the search vocabulary built from them. Shipped databases may
+// already have the normalized columns, filled by another normalizer, so they are always refilled,
+// together with a full-text index built from them.
+func migrateNormalizedColumns(db *sql.DB) error {
+	hasNormalized, err := hasColumn(db, "restaurants", "name_normalized")
 	if err != nil {
 		return fmt.Errorf("failed to check table info: %v", err)
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var cid int
-		var name, dataType string
-		var notnull, pk int
-		var defaultValue sql.NullString
-
-		err := rows.Scan(&cid, &name, &dataType, &notnull, &defaultValue, &pk)
-		if err != nil {
-			return fmt.Errorf("failed to scan table info: %v", err)
-		}
-
-		if name == "name_normalized" {
-			hasNormalized = true
-			break
-		}
+	hasTransliterated, err := hasColumn(db, "restaurants", "name_transliterated")
+	if err != nil {
+		return fmt.Errorf("failed to check table info: %v", err)
 	}
 
 	if !hasNormalized {
-		// Add normalized columns
 		_, err := db.Exec(`
 			ALTER TABLE restaurants ADD COLUMN name_normalized TEXT;
 			ALTER TABLE restaurants ADD COLUMN location_normalized TEXT;
@@ -111,65 +112,105 @@ func MigrateNormalizedColumns(db *sql.DB) error {
 		if err != nil {
 			return fmt.Errorf("failed to add normalized columns: %v", err)
 		}
-
-		// Populate normalized columns
-		rows, err := db.Query("SELECT id, name, location, cuisine FROM restaurants")
+	}
+	if !hasTransliterated {
+		_, err := db.Exec(`
+			ALTER TABLE restaurants ADD COLUMN name_transliterated TEXT;
+			ALTER TABLE restaurants ADD COLUMN location_transliterated TEXT;
+			ALTER TABLE restaurants ADD COLUMN cuisine_transliterated TEXT;
+		`)
 		if err != nil {
-			return fmt.Errorf("failed to query restaurants: %v", err)
+			return fmt.Errorf("failed to add transliterated columns: %v", err)
 		}
-		defer rows.Close()
+	}
 
-		for rows.Next() {
-			var id int64
-			var name, location, cuisine sql.NullString
+	if err := populateNormalizedColumns(db); err != nil {
+		return err
+	}
 
-			err := rows.Scan(&id, &name, &location, &cuisine)
-			if err != nil {
-				return fmt.Errorf("failed to scan restaurant: %v", err)
-			}
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_name_normalized ON restaurants(name_normalized);
+		CREATE INDEX IF NOT EXISTS idx_location_normalized ON restaurants(location_normalized);
+		CREATE INDEX IF NOT EXISTS idx_cuisine_normalized ON restaurants(cuisine_normalized);
+		CREATE INDEX IF NOT EXISTS idx_name_transliterated ON restaurants(name_transliterated);
+		CREATE INDEX IF NOT EXISTS idx_location_transliterated ON restaurants(location_transliterated);
+		CREATE INDEX IF NOT EXISTS idx_cuisine_transliterated ON restaurants(cuisine_transliterated);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create indexes on normalized columns: %v", err)
+	}
 
-			// Normalize the text fields
-			var nameNorm, locationNorm, cuisineNorm sql.NullString
+	if hasFullTextIndex(db) {
+		if err := RebuildFullTextIndex(db); err != nil {
+			return err
+		}
+	}
+	return migrateSearchVocabulary(db)
+}
 
-			if name.Valid {
-				nameNorm = sql.NullString{String: normalizeForSearch(name.String), Valid: true}
-			}
-			if location.Valid {
-				locationNorm = sql.NullString{String: normalizeForSearch(location.String), Valid: true}
-			}
-			if cuisine.Valid {
-				cuisineNorm = sql.NullString{String: normalizeForSearch(cuisine.String), Valid: true}
-			}
+// populateNormalizedColumns fills the normalized and transliterated columns from name, location and cuisine
+func populateNormalizedColumns(db *sql.DB) error {
+	type normalizedRow struct {
+		id                      int64
+		name, location, cuisine sql.NullString
+	}
 
-			// Update the restaurant with normalized values
-			_, err = db.Exec(`
-				UPDATE restaurants 
-				SET name_normalized = ?, location_normalized = ?, cuisine_normalized = ?
-				WHERE id = ?
-			`, nameNorm, locationNorm, cuisineNorm, id)
-			if err != nil {
-				return fmt.Errorf("failed to update normalized columns for restaurant %d: %v", id, err)
-			}
+	// Read everything first: updating while the read is still open would lock the database
+	rows, err := db.Query("SELECT id, name, location, cuisine FROM restaurants")
+	if err != nil {
+		return fmt.Errorf("failed to query restaurants: %v", err)
+	}
+	var restaurants []normalizedRow
+	for rows.Next() {
+		var row normalizedRow
+		if err := rows.Scan(&row.id, &row.name, &row.location, &row.cuisine); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan restaurant: %v", err)
 		}
+		restaurants = append(restaurants, row)
+	}
+	rows.Close()
 
-		// Add indexes on normalized columns
-		_, err = db.Exec(`
-			CREATE INDEX IF NOT EXISTS idx_name_normalized ON restaurants(name_normalized);
-			CREATE INDEX IF NOT EXISTS idx_location_normalized ON restaurants(location_normalized);
-			CREATE INDEX IF NOT EXISTS idx_cuisine_normalized ON restaurants(cuisine_normalized);
-			
-			-- Critical indexes for restaurant_awards table performance
-			CREATE INDEX IF NOT EXISTS idx_restaurant_awards_restaurant_id ON restaurant_awards(restaurant_id);
-			CREATE INDEX IF NOT EXISTS idx_restaurant_awards_year ON restaurant_awards(year);
-			CREATE INDEX IF NOT EXISTS idx_restaurant_awards_distinction ON restaurant_awards(distinction);
-			CREATE INDEX IF NOT EXISTS idx_restaurant_awards_composite ON restaurant_awards(restaurant_id, distinction, year);
-		`)
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin normalization: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		UPDATE restaurants
+		SET name_normalized = ?, location_normalized = ?, cuisine_normalized = ?,
+			name_transliterated = ?, location_transliterated = ?, cuisine_transliterated = ?
+		WHERE id = ?
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare normalization: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, row := range restaurants {
+		_, err := stmt.Exec(
+			normalizeNullString(row.name, normalizeForSearch),
+			normalizeNullString(row.location, normalizeForSearch),
+			normalizeNullString(row.cuisine, normalizeForSearch),
+			normalizeNullString(row.name, transliterateForSearch),
+			normalizeNullString(row.location, transliterateForSearch),
+			normalizeNullString(row.cuisine, transliterateForSearch),
+			row.id)
 		if err != nil {
-			return fmt.Errorf("failed to create indexes on normalized columns: %v", err)
+			return fmt.Errorf("failed to update normalized columns for restaurant %d: %v", row.id, err)
 		}
 	}
 
-	return nil
+	return tx.Commit()
+}
+
+// normalizeNullString applies normalize to a nullable text column
+func normalizeNullString(value sql.NullString, normalize func(string) string) sql.NullString {
+	if !value.Valid {
+		return value
+	}
+	return sql.NullString{String: normalize(value.String), Valid: true}
 }
 
 // Restaurant represents a Michelin restaurant
@@ -189,15 +230,23 @@ type Restaurant struct {
 	Description           *string
 	IsFavorite            bool
 	IsVisited             bool
-	VisitedDate           *string
-	VisitedNotes          *string
+	VisitedDate           *string // date of the last visit
+	VisitedNotes          *string // notes of the last visit
+	VisitCount            int
+	UserRating            *int // personal rating, 1 to 5
 	InGuide               int
 	// Award info from latest award
 	CurrentAward         *string
 	CurrentPrice         *string
+	CurrentPriceLevel    int // 1 to 4, 0 when unknown
 	CurrentGreenStar     *bool
 	CurrentAwardYear     *int
 	CurrentAwardLastYear *int
+	// Distance from the near: location, only set for geographic searches
+	DistanceKm *float64
+	// Position and notes within a list, only set when browsing a list
+	ListPosition int
+	ListNotes    *string
 }
 
 // RestaurantAward represents a restaurant's award history
@@ -210,55 +259,12 @@ type RestaurantAward struct {
 	GreenStar    *bool
 }
 
-// Initialize opens the database connection and ensures favorite/visited tables exist
+// Initialize applies pending migrations with Prepare and opens a writable connection
 func Initialize(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %v", err)
-	}
-
-	// Create additional tables for our custom features if they don't exist
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS user_favorites (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			restaurant_id INTEGER NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (restaurant_id) REFERENCES restaurants(id),
-			UNIQUE (restaurant_id)
-		);
-		
-		CREATE TABLE IF NOT EXISTS user_visits (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			restaurant_id INTEGER NOT NULL,
-			visited_date TEXT,
-			notes TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (restaurant_id) REFERENCES restaurants(id),
-			UNIQUE (restaurant_id)
-		);
-		
-		CREATE INDEX IF NOT EXISTS idx_user_favorites_restaurant ON user_favorites(restaurant_id);
-		CREATE INDEX IF NOT EXISTS idx_user_visits_restaurant ON user_visits(restaurant_id);
-		
-		-- Ensure critical performance indexes exist on restaurant_awards
-		CREATE INDEX IF NOT EXISTS idx_restaurant_awards_restaurant_id ON restaurant_awards(restaurant_id);
-		CREATE INDEX IF NOT EXISTS idx_restaurant_awards_year ON restaurant_awards(year);
-		CREATE INDEX IF NOT EXISTS idx_restaurant_awards_distinction ON restaurant_awards(distinction);
-		CREATE INDEX IF NOT EXISTS idx_restaurant_awards_composite ON restaurant_awards(restaurant_id, distinction, year);
-	`)
-	if err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to create user tables: %v", err)
-	}
-
-	// Run migration for normalized columns
-	err = MigrateNormalizedColumns(db)
-	if err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to migrate normalized columns: %v", err)
+	if err := Prepare(dbPath); err != nil {
+		return nil, err
 	}
-
-	return db, nil
+	return Open(dbPath, false)
 }
 
 // ImportCSV is deprecated - the new database comes pre-populated
@@ -269,335 +275,140 @@ func ImportCSV(db *sql.DB, csvPath string) error {
 	return nil
 }
 
-// SearchRestaurants searches for restaurants based on name, location, cuisine, and awards
-func SearchRestaurants(db *sql.DB, query string) ([]Restaurant, bool, error) {
-	// Check if we should include former restaurants (not in guide)
-	includeFormer := os.Getenv("INCLUDE_FORMER") == "1"
-	// Parse search terms - preserve original case for case-sensitive detection
-	originalTerms := strings.Fields(query)
-	lowerTerms := strings.Fields(strings.ToLower(query))
-	awardFilters := []string{}
-	searchTerms := []string{}
-	originalSearchTerms := []string{}
-	greenStarFilter := false
-
-	// Separate award filters from search terms
-	for i, term := range lowerTerms {
-		switch term {
-		case "1s":
-			awardFilters = append(awardFilters, "1 Star")
-		case "2s":
-			awardFilters = append(awardFilters, "2 Stars")
-		case "3s":
-			awardFilters = append(awardFilters, "3 Stars")
-		case "bg":
-			awardFilters = append(awardFilters, "Bib Gourmand")
-		case "sr":
-			awardFilters = append(awardFilters, "Selected Restaurants")
-		case "gs":
-			greenStarFilter = true
-		default:
-			searchTerms = append(searchTerms, term)
-			originalSearchTerms = append(originalSearchTerms, originalTerms[i])
-		}
-	}
+// restaurantColumns are the columns of a restaurant with its user data and current award, read by
+// scanRestaurant; restaurantJoins provides the aliases they use for a FROM clause naming restaurants r
+const restaurantColumns = `
+	r.id, r.name, r.address, r.location, r.cuisine, r.longitude, r.latitude,
+	r.phone_number, r.url, r.website_url, r.image_url, r.facilities_and_services,
+	r.description, r.in_guide,
+	CASE WHEN uf.restaurant_id IS NOT NULL THEN 1 ELSE 0 END as is_favorite,
+	CASE WHEN uv.restaurant_id IS NOT NULL THEN 1 ELSE 0 END as is_visited,
+	uv.visited_date, uv.notes, COALESCE(uv.visit_count, 0), rt.rating,
+	ra.distinction, ra.price, COALESCE(pl.level, 0), ra.green_star, ra.first_year, ra.last_year`
+
+var restaurantJoins = `
+	LEFT JOIN user_favorites uf ON r.id = uf.restaurant_id
+	LEFT JOIN ` + visitSummaryTable + ` uv ON r.id = uv.restaurant_id
+	LEFT JOIN user_ratings rt ON r.id = rt.restaurant_id
+	LEFT JOIN restaurant_current_award ra ON r.id = ra.restaurant_id
+	LEFT JOIN price_levels pl ON ra.price = pl.price`
+
+// awardOrder sorts restaurants by their current distinction, best first
+const awardOrder = `
+	CASE
+		WHEN ra.distinction = '3 Stars' THEN 1
+		WHEN ra.distinction = '2 Stars' THEN 2
+		WHEN ra.distinction = '1 Star' THEN 3
+		WHEN ra.distinction = 'Bib Gourmand' THEN 4
+		WHEN ra.distinction = 'Selected Restaurants' THEN 5
+		WHEN ra.distinction = 'Green Star' THEN 6
+		ELSE 7
+	END`
+
+// restaurantQuery builds the SELECT shared by the functions returning restaurants. from names the
+// restaurants r, joined to any table that narrows them, extra adds columns scanned after the
+// standard ones, and clauses holds the WHERE, ORDER BY and LIMIT clauses.
+func restaurantQuery(from, extra, clauses string) string {
+	columns := restaurantColumns
+	if extra != "" {
+		columns += ", " + extra
+	}
+	return "SELECT " + columns + "\nFROM " + from + restaurantJoins + "\n" + clauses
+}
 
-	// Build WHERE clause with both SQL filtering (for speed) and normalization (for accuracy)
-	whereClause := "WHERE 1=1"
-	args := []interface{}{}
-
-	// Add text search using case-sensitive or case-insensitive matching based on input
-	if len(searchTerms) > 0 {
-		for i, term := range searchTerms {
-			originalTerm := originalSearchTerms[i]
-			normalizedTerm := normalizeForSearch(term)
-			if isCaseSensitiveSearch(originalTerm) {
-				// Case-sensitive search for terms like "USA", "Italy", "New York"
-				// Use GLOB for whole word matching
-				sqlCondition := `(r.name GLOB ? OR r.location GLOB ? OR r.cuisine GLOB ?)`
-				searchTerm := "*" + originalTerm + "*"
-				args = append(args, searchTerm, searchTerm, searchTerm)
-				whereClause += " AND (" + sqlCondition + ")"
-			} else {
-				// Case-insensitive search for normal terms
-				sqlCondition := `(LOWER(r.name) LIKE ? OR LOWER(r.location) LIKE ? OR LOWER(r.cuisine) LIKE ? OR 
-				  r.name_normalized LIKE ? OR r.location_normalized LIKE ? OR r.cuisine_normalized LIKE ?)`
-				searchTerm := "%" + term + "%"
-				normalizedSearchTerm := "%" + normalizedTerm + "%"
-				args = append(args, searchTerm, searchTerm, searchTerm,
-					normalizedSearchTerm, normalizedSearchTerm, normalizedSearchTerm)
-				whereClause += " AND (" + sqlCondition + ")"
-			}
-		}
-	}
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	// Add award filters
-	if len(awardFilters) > 0 {
-		whereClause += " AND ra.distinction IN ("
-		for i, filter := range awardFilters {
-			if i > 0 {
-				whereClause += ", "
-			}
-			whereClause += "?"
-			args = append(args, filter)
-		}
-		whereClause += ")"
+// scanRestaurant reads a row of restaurantQuery, with its extra columns into extra
+func scanRestaurant(row rowScanner, extra ...interface{}) (Restaurant, error) {
+	var r Restaurant
+	dest := []interface{}{
+		&r.ID, &r.Name, &r.Address, &r.Location, &r.Cuisine,
+		&r.Longitude, &r.Latitude, &r.PhoneNumber,
+		&r.Url, &r.WebsiteUrl, &r.ImageURL, &r.FacilitiesAndServices, &r.Description, &r.InGuide,
+		&r.IsFavorite, &r.IsVisited, &r.VisitedDate, &r.VisitedNotes, &r.VisitCount, &r.UserRating,
+		&r.CurrentAward, &r.CurrentPrice, &r.CurrentPriceLevel, &r.CurrentGreenStar, &r.CurrentAwardYear, &r.CurrentAwardLastYear,
 	}
+	err := row.Scan(append(dest, extra...)...)
+	return r, err
+}
 
-	// Add green star filter
-	if greenStarFilter {
-		whereClause += " AND ra.green_star = 1"
+// queryRestaurants runs a restaurantQuery and scans all of its rows
+func queryRestaurants(db *sql.DB, query string, args ...interface{}) ([]Restaurant, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	// Add filter for restaurants not in guide based on INCLUDE_FORMER setting
-	if !includeFormer {
-		whereClause += " AND r.in_guide = 1"
+	var restaurants []Restaurant
+	for rows.Next() {
+		r, err := scanRestaurant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		restaurants = append(restaurants, r)
 	}
+	return restaurants, rows.Err()
+}
 
-	// Add LIMIT clause if no search terms provided (empty query)
-	limitClause := ""
-	if len(searchTerms) == 0 && len(awardFilters) == 0 && !greenStarFilter {
-		limitClause = " LIMIT 100"
+// SearchRestaurants searches for restaurants based on name, location, cuisine, and awards
+func SearchRestaurants(db *sql.DB, query string) ([]Restaurant, bool, error) {
+	// Parse the query into a WHERE clause shared by all search functions
+	filter, err := buildSearchFilter(db, query)
+	if err != nil {
+		return nil, false, err
 	}
 
 	// Query restaurants with enhanced search and sorting
-	queryStr := `
-		SELECT 
-			r.id, r.name, r.address, r.location, r.cuisine, r.longitude, r.latitude,
-			r.phone_number, r.url, r.website_url, r.image_url, r.facilities_and_services, 
-			r.description, r.in_guide,
-			CASE WHEN uf.restaurant_id IS NOT NULL THEN 1 ELSE 0 END as is_favorite,
-			CASE WHEN uv.restaurant_id IS NOT NULL THEN 1 ELSE 0 END as is_visited,
-			uv.visited_date, uv.notes,
-			ra.distinction, ra.price, ra.green_star, ra.first_year, ra.last_year
-		FROM restaurants r
-		LEFT JOIN user_favorites uf ON r.id = uf.restaurant_id
-		LEFT JOIN user_visits uv ON r.id = uv.restaurant_id
-		LEFT JOIN (
-			SELECT 
-				ra1.restaurant_id,
-				ra1.distinction,
-				ra1.price,
-				ra1.green_star,
-				MIN(ra_range.year) as first_year,
-				MAX(ra_range.year) as last_year
-			FROM restaurant_awards ra1
-			JOIN (
-				SELECT restaurant_id, MAX(year) as max_year
-				FROM restaurant_awards
-				GROUP BY restaurant_id
-			) latest ON ra1.restaurant_id = latest.restaurant_id AND ra1.year = latest.max_year
-			JOIN restaurant_awards ra_range ON ra1.restaurant_id = ra_range.restaurant_id 
-				AND ra1.distinction = ra_range.distinction
-			GROUP BY ra1.restaurant_id, ra1.distinction, ra1.price, ra1.green_star
-		) ra ON r.id = ra.restaurant_id
-		` + whereClause + `
-		ORDER BY 
+	queryStr := restaurantQuery("restaurants r", "", filter.rankJoin+`
+		`+filter.whereClause+`
+		ORDER BY
 			CASE WHEN uf.restaurant_id IS NOT NULL THEN 0 ELSE 1 END,
-			CASE 
-				WHEN ra.distinction = '3 Stars' THEN 1
-				WHEN ra.distinction = '2 Stars' THEN 2
-				WHEN ra.distinction = '1 Star' THEN 3
-				WHEN ra.distinction = 'Bib Gourmand' THEN 4
-				WHEN ra.distinction = 'Selected Restaurants' THEN 5
-				WHEN ra.distinction = 'Green Star' THEN 6
-				ELSE 7
-			END,
+			`+filter.rankOrder()+awardOrder+`,
 			r.name
-		` + limitClause
+		`+filter.limitClause())
 
 	// Debug: Print the query and args
 	fmt.Fprintf(os.Stderr, "[DEBUG] SQL Query: %s\n", queryStr)
-	fmt.Fprintf(os.Stderr, "[DEBUG] Args: %v\n", args)
+	fmt.Fprintf(os.Stderr, "[DEBUG] Args: %v\n", filter.queryArgs())
 
-	rows, err := db.Query(queryStr, args...)
+	restaurants, err := queryRestaurants(db, queryStr, filter.queryArgs()...)
 	if err != nil {
 		return nil, false, fmt.Errorf("search query failed: %v", err)
 	}
-	defer rows.Close()
 
-	var restaurants []Restaurant
-	for rows.Next() {
-		var r Restaurant
-		err := rows.Scan(
-			&r.ID, &r.Name, &r.Address, &r.Location, &r.Cuisine,
-			&r.Longitude, &r.Latitude, &r.PhoneNumber,
-			&r.Url, &r.WebsiteUrl, &r.ImageURL, &r.FacilitiesAndServices, &r.Description, &r.InGuide,
-			&r.IsFavorite, &r.IsVisited, &r.VisitedDate, &r.VisitedNotes,
-			&r.CurrentAward, &r.CurrentPrice, &r.CurrentGreenStar, &r.CurrentAwardYear, &r.CurrentAwardLastYear,
-		)
-		if err != nil {
-			return nil, false, fmt.Errorf("failed to scan row: %v", err)
-		}
-
-		// No need for additional filtering - all accent-insensitive matching is now handled by the database query
-
-		restaurants = append(restaurants, r)
+	// Keep only restaurants within the radius, nearest first
+	if filter.geo != nil {
+		restaurants = filter.geo.apply(restaurants)
 	}
 
-	// Check if this was an empty search (no search terms, no filters)
-	isEmptySearch := len(searchTerms) == 0 && len(awardFilters) == 0 && !greenStarFilter
-
-	return restaurants, isEmptySearch, nil
+	return restaurants, filter.isEmpty, nil
 }
 
 // SearchFavoriteRestaurants searches within favorite restaurants only
 func SearchFavoriteRestaurants(db *sql.DB, query string) ([]Restaurant, error) {
-	// Check if we should include former restaurants (not in guide)
-	includeFormer := os.Getenv("INCLUDE_FORMER") == "1"
-	// Parse search terms - preserve original case for case-sensitive detection
-	originalTerms := strings.Fields(query)
-	lowerTerms := strings.Fields(strings.ToLower(query))
-	awardFilters := []string{}
-	searchTerms := []string{}
-	originalSearchTerms := []string{}
-	greenStarFilter := false
-
-	// Separate award filters from search terms
-	for i, term := range lowerTerms {
-		switch term {
-		case "1s":
-			awardFilters = append(awardFilters, "1 Star")
-		case "2s":
-			awardFilters = append(awardFilters, "2 Stars")
-		case "3s":
-			awardFilters = append(awardFilters, "3 Stars")
-		case "bg":
-			awardFilters = append(awardFilters, "Bib Gourmand")
-		case "sr":
-			awardFilters = append(awardFilters, "Selected Restaurants")
-		case "gs":
-			greenStarFilter = true
-		default:
-			searchTerms = append(searchTerms, term)
-			originalSearchTerms = append(originalSearchTerms, originalTerms[i])
-		}
-	}
-
-	// Build WHERE clause with both SQL filtering (for speed) and normalization (for accuracy)
-	whereClause := "WHERE 1=1"
-	args := []interface{}{}
-
-	// Add text search using case-sensitive or case-insensitive matching based on input
-	if len(searchTerms) > 0 {
-		for i, term := range searchTerms {
-			originalTerm := originalSearchTerms[i]
-			normalizedTerm := normalizeForSearch(term)
-			if isCaseSensitiveSearch(originalTerm) {
-				// Case-sensitive search for terms like "USA", "Italy", "New York"
-				// Use GLOB for whole word matching
-				sqlCondition := `(r.name GLOB ? OR r.location GLOB ? OR r.cuisine GLOB ?)`
-				searchTerm := "*" + originalTerm + "*"
-				args = append(args, searchTerm, searchTerm, searchTerm)
-				whereClause += " AND (" + sqlCondition + ")"
-			} else {
-				// Case-insensitive search for normal terms
-				sqlCondition := `(LOWER(r.name) LIKE ? OR LOWER(r.location) LIKE ? OR LOWER(r.cuisine) LIKE ? OR 
-				  r.name_normalized LIKE ? OR r.location_normalized LIKE ? OR r.cuisine_normalized LIKE ?)`
-				searchTerm := "%" + term + "%"
-				normalizedSearchTerm := "%" + normalizedTerm + "%"
-				args = append(args, searchTerm, searchTerm, searchTerm,
-					normalizedSearchTerm, normalizedSearchTerm, normalizedSearchTerm)
-				whereClause += " AND (" + sqlCondition + ")"
-			}
-		}
-	}
-
-	// Add award filters
-	if len(awardFilters) > 0 {
-		whereClause += " AND ra.distinction IN ("
-		for i, filter := range awardFilters {
-			if i > 0 {
-				whereClause += ", "
-			}
-			whereClause += "?"
-			args = append(args, filter)
-		}
-		whereClause += ")"
-	}
-
-	// Add green star filter
-	if greenStarFilter {
-		whereClause += " AND ra.green_star = 1"
-	}
-
-	// Add filter for restaurants not in guide based on INCLUDE_FORMER setting
-	if !includeFormer {
-		whereClause += " AND r.in_guide = 1"
-	}
-
-	// Add LIMIT clause if no search terms provided (empty query)
-	limitClause := ""
-	if len(searchTerms) == 0 && len(awardFilters) == 0 && !greenStarFilter {
-		limitClause = " LIMIT 100"
+	// Parse the query into a WHERE clause shared by all search functions
+	filter, err := buildSearchFilter(db, query)
+	if err != nil {
+		return nil, err
 	}
 
 	// Query restaurants with enhanced search and sorting - INNER JOIN with user_favorites to only get favorites
-	queryStr := `
-		SELECT 
-			r.id, r.name, r.address, r.location, r.cuisine, r.longitude, r.latitude,
-			r.phone_number, r.url, r.website_url, r.image_url, r.facilities_and_services, 
-			r.description, r.in_guide,
-			1 as is_favorite,
-			CASE WHEN uv.restaurant_id IS NOT NULL THEN 1 ELSE 0 END as is_visited,
-			uv.visited_date, uv.notes,
-			ra.distinction, ra.price, ra.green_star, ra.first_year, ra.last_year
-		FROM restaurants r
-		INNER JOIN user_favorites uf ON r.id = uf.restaurant_id
-		LEFT JOIN user_visits uv ON r.id = uv.restaurant_id
-		LEFT JOIN (
-			SELECT 
-				ra1.restaurant_id,
-				ra1.distinction,
-				ra1.price,
-				ra1.green_star,
-				MIN(ra_range.year) as first_year,
-				MAX(ra_range.year) as last_year
-			FROM restaurant_awards ra1
-			JOIN (
-				SELECT restaurant_id, MAX(year) as max_year
-				FROM restaurant_awards
-				GROUP BY restaurant_id
-			) latest ON ra1.restaurant_id = latest.restaurant_id AND ra1.year = latest.max_year
-			JOIN restaurant_awards ra_range ON ra1.restaurant_id = ra_range.restaurant_id 
-				AND ra1.distinction = ra_range.distinction
-			GROUP BY ra1.restaurant_id, ra1.distinction, ra1.price, ra1.green_star
-		) ra ON r.id = ra.restaurant_id
-		` + whereClause + `
-		ORDER BY 
-			CASE 
-				WHEN ra.distinction = '3 Stars' THEN 1
-				WHEN ra.distinction = '2 Stars' THEN 2
-				WHEN ra.distinction = '1 Star' THEN 3
-				WHEN ra.distinction = 'Bib Gourmand' THEN 4
-				WHEN ra.distinction = 'Selected Restaurants' THEN 5
-				WHEN ra.distinction = 'Green Star' THEN 6
-				ELSE 7
-			END,
-			r.name
-		` + limitClause
+	queryStr := restaurantQuery("restaurants r INNER JOIN user_favorites f ON r.id = f.restaurant_id", "", filter.whereClause+`
+		ORDER BY `+awardOrder+`, r.name
+		`+filter.limitClause())
 
-	rows, err := db.Query(queryStr, args...)
+	restaurants, err := queryRestaurants(db, queryStr, filter.args...)
 	if err != nil {
 		return nil, fmt.Errorf("search favorite restaurants query failed: %v", err)
 	}
-	defer rows.Close()
-
-	var restaurants []Restaurant
-	for rows.Next() {
-		var r Restaurant
-		err := rows.Scan(
-			&r.ID, &r.Name, &r.Address, &r.Location, &r.Cuisine,
-			&r.Longitude, &r.Latitude, &r.PhoneNumber,
-			&r.Url, &r.WebsiteUrl, &r.ImageURL, &r.FacilitiesAndServices, &r.Description, &r.InGuide,
-			&r.IsFavorite, &r.IsVisited, &r.VisitedDate, &r.VisitedNotes,
-			&r.CurrentAward, &r.CurrentPrice, &r.CurrentGreenStar, &r.CurrentAwardYear, &r.CurrentAwardLastYear,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan row: %v", err)
-		}
 
-		restaurants = append(restaurants, r)
+	// Keep only restaurants within the radius, nearest first
+	if filter.geo != nil {
+		restaurants = filter.geo.apply(restaurants)
 	}
 
 	return restaurants, nil
@@ -605,161 +416,26 @@ func SearchFavoriteRestaurants(db *sql.DB, query string) ([]Restaurant, error) {
 
 // SearchVisitedRestaurants searches within visited restaurants only
 func SearchVisitedRestaurants(db *sql.DB, query string) ([]Restaurant, error) {
-	// Check if we should include former restaurants (not in guide)
-	includeFormer := os.Getenv("INCLUDE_FORMER") == "1"
-	// Parse search terms - preserve original case for case-sensitive detection
-	originalTerms := strings.Fields(query)
-	lowerTerms := strings.Fields(strings.ToLower(query))
-	awardFilters := []string{}
-	searchTerms := []string{}
-	originalSearchTerms := []string{}
-	greenStarFilter := false
-
-	// Separate award filters from search terms
-	for i, term := range lowerTerms {
-		switch term {
-		case "1s":
-			awardFilters = append(awardFilters, "1 Star")
-		case "2s":
-			awardFilters = append(awardFilters, "2 Stars")
-		case "3s":
-			awardFilters = append(awardFilters, "3 Stars")
-		case "bg":
-			awardFilters = append(awardFilters, "Bib Gourmand")
-		case "sr":
-			awardFilters = append(awardFilters, "Selected Restaurants")
-		case "gs":
-			greenStarFilter = true
-		default:
-			searchTerms = append(searchTerms, term)
-			originalSearchTerms = append(originalSearchTerms, originalTerms[i])
-		}
-	}
-
-	// Build WHERE clause with both SQL filtering (for speed) and normalization (for accuracy)
-	whereClause := "WHERE 1=1"
-	args := []interface{}{}
-
-	// Add text search using case-sensitive or case-insensitive matching based on input
-	if len(searchTerms) > 0 {
-		for i, term := range searchTerms {
-			originalTerm := originalSearchTerms[i]
-			normalizedTerm := normalizeForSearch(term)
-			if isCaseSensitiveSearch(originalTerm) {
-				// Case-sensitive search for terms like "USA", "Italy", "New York"
-				// Use GLOB for whole word matching
-				sqlCondition := `(r.name GLOB ? OR r.location GLOB ? OR r.cuisine GLOB ?)`
-				searchTerm := "*" + originalTerm + "*"
-				args = append(args, searchTerm, searchTerm, searchTerm)
-				whereClause += " AND (" + sqlCondition + ")"
-			} else {
-				// Case-insensitive search for normal terms
-				sqlCondition := `(LOWER(r.name) LIKE ? OR LOWER(r.location) LIKE ? OR LOWER(r.cuisine) LIKE ? OR 
-				  r.name_normalized LIKE ? OR r.location_normalized LIKE ? OR r.cuisine_normalized LIKE ?)`
-				searchTerm := "%" + term + "%"
-				normalizedSearchTerm := "%" + normalizedTerm + "%"
-				args = append(args, searchTerm, searchTerm, searchTerm,
-					normalizedSearchTerm, normalizedSearchTerm, normalizedSearchTerm)
-				whereClause += " AND (" + sqlCondition + ")"
-			}
-		}
-	}
-
-	// Add award filters
-	if len(awardFilters) > 0 {
-		whereClause += " AND ra.distinction IN ("
-		for i, filter := range awardFilters {
-			if i > 0 {
-				whereClause += ", "
-			}
-			whereClause += "?"
-			args = append(args, filter)
-		}
-		whereClause += ")"
-	}
-
-	// Add green star filter
-	if greenStarFilter {
-		whereClause += " AND ra.green_star = 1"
-	}
-
-	// Add filter for restaurants not in guide based on INCLUDE_FORMER setting
-	if !includeFormer {
-		whereClause += " AND r.in_guide = 1"
+	// Parse the query into a WHERE clause shared by all search functions
+	filter, err := buildSearchFilter(db, query)
+	if err != nil {
+		return nil, err
 	}
 
-	// Add LIMIT clause if no search terms provided (empty query)
-	limitClause := ""
-	if len(searchTerms) == 0 && len(awardFilters) == 0 && !greenStarFilter {
-		limitClause = " LIMIT 100"
-	}
+	// Query restaurants with enhanced search and sorting - only restaurants with a visit
+	queryStr := restaurantQuery("restaurants r", "", filter.whereClause+`
+			AND uv.restaurant_id IS NOT NULL
+		ORDER BY `+awardOrder+`, r.name
+		`+filter.limitClause())
 
-	// Query restaurants with enhanced search and sorting - INNER JOIN with user_visits to only get visited
-	queryStr := `
-		SELECT 
-			r.id, r.name, r.address, r.location, r.cuisine, r.longitude, r.latitude,
-			r.phone_number, r.url, r.website_url, r.image_url, r.facilities_and_services, 
-			r.description, r.in_guide,
-			CASE WHEN uf.restaurant_id IS NOT NULL THEN 1 ELSE 0 END as is_favorite,
-			1 as is_visited,
-			uv.visited_date, uv.notes,
-			ra.distinction, ra.price, ra.green_star, ra.first_year, ra.last_year
-		FROM restaurants r
-		INNER JOIN user_visits uv ON r.id = uv.restaurant_id
-		LEFT JOIN user_favorites uf ON r.id = uf.restaurant_id
-		LEFT JOIN (
-			SELECT 
-				ra1.restaurant_id,
-				ra1.distinction,
-				ra1.price,
-				ra1.green_star,
-				MIN(ra_range.year) as first_year,
-				MAX(ra_range.year) as last_year
-			FROM restaurant_awards ra1
-			JOIN (
-				SELECT restaurant_id, MAX(year) as max_year
-				FROM restaurant_awards
-				GROUP BY restaurant_id
-			) latest ON ra1.restaurant_id = latest.restaurant_id AND ra1.year = latest.max_year
-			JOIN restaurant_awards ra_range ON ra1.restaurant_id = ra_range.restaurant_id 
-				AND ra1.distinction = ra_range.distinction
-			GROUP BY ra1.restaurant_id, ra1.distinction, ra1.price, ra1.green_star
-		) ra ON r.id = ra.restaurant_id
-		` + whereClause + `
-		ORDER BY 
-			CASE 
-				WHEN ra.distinction = '3 Stars' THEN 1
-				WHEN ra.distinction = '2 Stars' THEN 2
-				WHEN ra.distinction = '1 Star' THEN 3
-				WHEN ra.distinction = 'Bib Gourmand' THEN 4
-				WHEN ra.distinction = 'Selected Restaurants' THEN 5
-				WHEN ra.distinction = 'Green Star' THEN 6
-				ELSE 7
-			END,
-			r.name
-		` + limitClause
-
-	rows, err := db.Query(queryStr, args...)
+	restaurants, err := queryRestaurants(db, queryStr, filter.args...)
 	if err != nil {
 		return nil, fmt.Errorf("search visited restaurants query failed: %v", err)
 	}
-	defer rows.Close()
-
-	var restaurants []Restaurant
-	for rows.Next() {
-		var r Restaurant
-		err := rows.Scan(
-			&r.ID, &r.Name, &r.Address, &r.Location, &r.Cuisine,
-			&r.Longitude, &r.Latitude, &r.PhoneNumber,
-			&r.Url, &r.WebsiteUrl, &r.ImageURL, &r.FacilitiesAndServices, &r.Description, &r.InGuide,
-			&r.IsFavorite, &r.IsVisited, &r.VisitedDate, &r.VisitedNotes,
-			&r.CurrentAward, &r.CurrentPrice, &r.CurrentGreenStar, &r.CurrentAwardYear, &r.CurrentAwardLastYear,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan row: %v", err)
-		}
 
-		restaurants = append(restaurants, r)
+	// Keep only restaurants within the radius, nearest first
+	if filter.geo != nil {
+		restaurants = filter.geo.apply(restaurants)
 	}
 
 	return restaurants, nil
@@ -767,46 +443,7 @@ func SearchVisitedRestaurants(db *sql.DB, query string) ([]Restaurant, error) {
 
 // GetRestaurantByID retrieves a restaurant by its ID
 func GetRestaurantByID(db *sql.DB, id int64) (Restaurant, error) {
-	var r Restaurant
-	err := db.QueryRow(`
-		SELECT 
-			r.id, r.name, r.address, r.location, r.cuisine, r.longitude, r.latitude,
-			r.phone_number, r.url, r.website_url, r.image_url, r.facilities_and_services, 
-			r.description, r.in_guide,
-			CASE WHEN uf.restaurant_id IS NOT NULL THEN 1 ELSE 0 END as is_favorite,
-			CASE WHEN uv.restaurant_id IS NOT NULL THEN 1 ELSE 0 END as is_visited,
-			uv.visited_date, uv.notes,
-			ra.distinction, ra.price, ra.green_star, ra.first_year, ra.last_year
-		FROM restaurants r
-		LEFT JOIN user_favorites uf ON r.id = uf.restaurant_id
-		LEFT JOIN user_visits uv ON r.id = uv.restaurant_id
-		LEFT JOIN (
-			SELECT 
-				ra1.restaurant_id,
-				ra1.distinction,
-				ra1.price,
-				ra1.green_star,
-				MIN(ra_range.year) as first_year,
-				MAX(ra_range.year) as last_year
-			FROM restaurant_awards ra1
-			JOIN (
-				SELECT restaurant_id, MAX(year) as max_year
-				FROM restaurant_awards
-				GROUP BY restaurant_id
-			) latest ON ra1.restaurant_id = latest.restaurant_id AND ra1.year = latest.max_year
-			JOIN restaurant_awards ra_range ON ra1.restaurant_id = ra_range.restaurant_id 
-				AND ra1.distinction = ra_range.distinction
-			GROUP BY ra1.restaurant_id, ra1.distinction, ra1.price, ra1.green_star
-		) ra ON r.id = ra.restaurant_id
-		WHERE r.id = ?
-	`, id).Scan(
-		&r.ID, &r.Name, &r.Address, &r.Location, &r.Cuisine,
-		&r.Longitude, &r.Latitude, &r.PhoneNumber,
-		&r.Url, &r.WebsiteUrl, &r.ImageURL, &r.FacilitiesAndServices, &r.Description, &r.InGuide,
-		&r.IsFavorite, &r.IsVisited, &r.VisitedDate, &r.VisitedNotes,
-		&r.CurrentAward, &r.CurrentPrice, &r.CurrentGreenStar, &r.CurrentAwardYear, &r.CurrentAwardLastYear,
-	)
-
+	r, err := scanRestaurant(db.QueryRow(restaurantQuery("restaurants r", "", "WHERE r.id = ?"), id))
 	if err != nil {
 		return Restaurant{}, fmt.Errorf("failed to get restaurant: %v", err)
 	}
@@ -838,159 +475,26 @@ func ToggleFavorite(db *sql.DB, id int64) error {
 	return nil
 }
 
-// ToggleVisited toggles a restaurant's visited status
-func ToggleVisited(db *sql.DB, id int64, date, notes string) error {
-	// Check if restaurant is already in visited
-	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM user_visits WHERE restaurant_id = ?)", id).Scan(&exists)
-	if err != nil {
-		return fmt.Errorf("failed to check visited status: %v", err)
-	}
-
-	if exists {
-		// Remove from visited
-		_, err = db.Exec("DELETE FROM user_visits WHERE restaurant_id = ?", id)
-	} else {
-		// Add to visited
-		// Handle empty string inputs
-		var dateParam, notesParam interface{}
-
-		if date == "" {
-			dateParam = nil
-		} else {
-			dateParam = date
-		}
-
-		if notes == "" {
-			notesParam = nil
-		} else {
-			notesParam = notes
-		}
-
-		_, err = db.Exec("INSERT INTO user_visits (restaurant_id, visited_date, notes) VALUES (?, ?, ?)", id, dateParam, notesParam)
-	}
-
-	if err != nil {
-		return fmt.Errorf("failed to update visited status: %v", err)
-	}
-
-	return nil
-}
-
 // GetFavoriteRestaurants retrieves all favorite restaurants
 func GetFavoriteRestaurants(db *sql.DB) ([]Restaurant, error) {
-	rows, err := db.Query(`
-		SELECT 
-			r.id, r.name, r.address, r.location, r.cuisine, r.longitude, r.latitude,
-			r.phone_number, r.url, r.website_url, r.image_url, r.facilities_and_services, 
-			r.description, r.in_guide,
-			1 as is_favorite,
-			CASE WHEN uv.restaurant_id IS NOT NULL THEN 1 ELSE 0 END as is_visited,
-			uv.visited_date, uv.notes,
-			ra.distinction, ra.price, ra.green_star, ra.first_year, ra.last_year
-		FROM restaurants r
-		INNER JOIN user_favorites uf ON r.id = uf.restaurant_id
-		LEFT JOIN user_visits uv ON r.id = uv.restaurant_id
-		LEFT JOIN (
-			SELECT 
-				ra1.restaurant_id,
-				ra1.distinction,
-				ra1.price,
-				ra1.green_star,
-				MIN(ra_range.year) as first_year,
-				MAX(ra_range.year) as last_year
-			FROM restaurant_awards ra1
-			JOIN (
-				SELECT restaurant_id, MAX(year) as max_year
-				FROM restaurant_awards
-				GROUP BY restaurant_id
-			) latest ON ra1.restaurant_id = latest.restaurant_id AND ra1.year = latest.max_year
-			JOIN restaurant_awards ra_range ON ra1.restaurant_id = ra_range.restaurant_id 
-				AND ra1.distinction = ra_range.distinction
-			GROUP BY ra1.restaurant_id, ra1.distinction, ra1.price, ra1.green_star
-		) ra ON r.id = ra.restaurant_id
-		ORDER BY r.name
-	`)
-
+	restaurants, err := queryRestaurants(db, restaurantQuery(
+		"restaurants r INNER JOIN user_favorites f ON r.id = f.restaurant_id", "", "ORDER BY r.name"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get favorite restaurants: %v", err)
 	}
-	defer rows.Close()
-
-	var restaurants []Restaurant
-	for rows.Next() {
-		var r Restaurant
-		err := rows.Scan(
-			&r.ID, &r.Name, &r.Address, &r.Location, &r.Cuisine,
-			&r.Longitude, &r.Latitude, &r.PhoneNumber,
-			&r.Url, &r.WebsiteUrl, &r.ImageURL, &r.FacilitiesAndServices, &r.Description, &r.InGuide,
-			&r.IsFavorite, &r.IsVisited, &r.VisitedDate, &r.VisitedNotes,
-			&r.CurrentAward, &r.CurrentPrice, &r.CurrentGreenStar, &r.CurrentAwardYear, &r.CurrentAwardLastYear,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan row: %v", err)
-		}
-		restaurants = append(restaurants, r)
-	}
 
 	return restaurants, nil
 }
 
 // GetVisitedRestaurants retrieves all visited restaurants
 func GetVisitedRestaurants(db *sql.DB) ([]Restaurant, error) {
-	rows, err := db.Query(`
-		SELECT 
-			r.id, r.name, r.address, r.location, r.cuisine, r.longitude, r.latitude,
-			r.phone_number, r.url, r.website_url, r.image_url, r.facilities_and_services, 
-			r.description, r.in_guide,
-			CASE WHEN uf.restaurant_id IS NOT NULL THEN 1 ELSE 0 END as is_favorite,
-			1 as is_visited,
-			uv.visited_date, uv.notes,
-			ra.distinction, ra.price, ra.green_star, ra.first_year, ra.last_year
-		FROM restaurants r
-		INNER JOIN user_visits uv ON r.id = uv.restaurant_id
-		LEFT JOIN user_favorites uf ON r.id = uf.restaurant_id
-		LEFT JOIN (
-			SELECT 
-				ra1.restaurant_id,
-				ra1.distinction,
-				ra1.price,
-				ra1.green_star,
-				MIN(ra_range.year) as first_year,
-				MAX(ra_range.year) as last_year
-			FROM restaurant_awards ra1
-			JOIN (
-				SELECT restaurant_id, MAX(year) as max_year
-				FROM restaurant_awards
-				GROUP BY restaurant_id
-			) latest ON ra1.restaurant_id = latest.restaurant_id AND ra1.year = latest.max_year
-			JOIN restaurant_awards ra_range ON ra1.restaurant_id = ra_range.restaurant_id 
-				AND ra1.distinction = ra_range.distinction
-			GROUP BY ra1.restaurant_id, ra1.distinction, ra1.price, ra1.green_star
-		) ra ON r.id = ra.restaurant_id
+	restaurants, err := queryRestaurants(db, restaurantQuery("restaurants r", "", `
+		WHERE uv.restaurant_id IS NOT NULL
 		ORDER BY uv.visited_date DESC, r.name
-	`)
-
+	`))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get visited restaurants: %v", err)
 	}
-	defer rows.Close()
-
-	var restaurants []Restaurant
-	for rows.Next() {
-		var r Restaurant
-		err := rows.Scan(
-			&r.ID, &r.Name, &r.Address, &r.Location, &r.Cuisine,
-			&r.Longitude, &r.Latitude, &r.PhoneNumber,
-			&r.Url, &r.WebsiteUrl, &r.ImageURL, &r.FacilitiesAndServices, &r.Description, &r.InGuide,
-			&r.IsFavorite, &r.IsVisited, &r.VisitedDate, &r.VisitedNotes,
-			&r.CurrentAward, &r.CurrentPrice, &r.CurrentGreenStar, &r.CurrentAwardYear, &r.CurrentAwardLastYear,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan row: %v", err)
-		}
-		restaurants = append(restaurants, r)
-	}
 
 	return restaurants, nil
 }
@@ -1025,127 +529,6 @@ func GetRestaurantAwardHistory(db *sql.DB, restaurantID int64) ([]RestaurantAwar
 	return awards, nil
 }
 
-// UpdateDatabase checks for a zipped database in the workflow directory and updates the main database
-// Returns nil if update was successful, an error if update failed, or a special "no update available" error
-func UpdateDatabase(currentDbPath string) error {
-	workflowDir := "/Users/giovanni/gDrive/GitHub repos/alfred-michelin/source"
-	zipPath := filepath.Join(workflowDir, "michelin.db.zip")
-
-	// Check if the zip file exists
-	if _, err := os.Stat(zipPath); os.IsNotExist(err) {
-		// Return a special error type that indicates no update is available (not a real error)
-		return &NoUpdateAvailableError{path: zipPath}
-	}
-
-	fmt.Printf("[UPDATE INFO] Found database update file: %s\n", zipPath)
-
-	// Extract the zip file to a temporary location
-	tempDir, err := os.MkdirTemp("", "michelin_update_")
-	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	newDbPath := filepath.Join(tempDir, "michelin.db")
-	err = extractZipFile(zipPath, newDbPath)
-	if err != nil {
-		return fmt.Errorf("failed to extract zip file: %v", err)
-	}
-
-	// Backup the current database
-	backupPath := strings.Replace(currentDbPath, ".db", "_backup.db", 1)
-	err = copyFile(currentDbPath, backupPath)
-	if err != nil {
-		return fmt.Errorf("failed to backup current database: %v", err)
-	}
-
-	fmt.Printf("Backed up current database to: %s\n", backupPath)
-
-	// Open both databases
-	currentDb, err := sql.Open("sqlite3", currentDbPath)
-	if err != nil {
-		return fmt.Errorf("failed to open current database: %v", err)
-	}
-	defer currentDb.Close()
-
-	newDb, err := sql.Open("sqlite3", newDbPath)
-	if err != nil {
-		return fmt.Errorf("failed to open new database: %v", err)
-	}
-	defer newDb.Close()
-
-	// Preserve user data during update
-	err = preserveUserDataDuringUpdate(currentDb, newDb)
-	if err != nil {
-		return fmt.Errorf("failed to preserve user data during update: %v", err)
-	}
-
-	// Replace the current database with the updated one
-	currentDb.Close()
-	newDb.Close()
-
-	err = os.Remove(currentDbPath)
-	if err != nil {
-		return fmt.Errorf("failed to remove old database: %v", err)
-	}
-
-	err = copyFile(newDbPath, currentDbPath)
-	if err != nil {
-		return fmt.Errorf("failed to copy updated database: %v", err)
-	}
-
-	// Delete the zip file to prevent re-updating
-	err = os.Remove(zipPath)
-	if err != nil {
-		return fmt.Errorf("failed to remove zip file: %v", err)
-	}
-
-	fmt.Printf("[UPDATE SUCCESS] Database update completed successfully\n")
-	fmt.Printf("[UPDATE SUCCESS] Backup created at: %s\n", backupPath)
-	fmt.Printf("[UPDATE SUCCESS] Update zip file removed: %s\n", zipPath)
-	fmt.Printf("[UPDATE SUCCESS] ===== DATABASE UPDATE SUMMARY =====\n")
-	return nil
-}
-
-// extractZipFile extracts a zip file containing a database to the specified path
-func extractZipFile(zipPath, extractPath string) error {
-	// Open zip file
-	r, err := zip.OpenReader(zipPath)
-	if err != nil {
-		return err
-	}
-	defer r.Close()
-
-	// Find the database file in the zip
-	var dbFile *zip.File
-	for _, f := range r.File {
-		if strings.HasSuffix(f.Name, ".db") {
-			dbFile = f
-			break
-		}
-	}
-
-	if dbFile == nil {
-		return fmt.Errorf("no .db file found in zip archive")
-	}
-
-	// Extract the database file
-	rc, err := dbFile.Open()
-	if err != nil {
-		return err
-	}
-	defer rc.Close()
-
-	outFile, err := os.OpenFile(extractPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, dbFile.Mode())
-	if err != nil {
-		return err
-	}
-	defer outFile.Close()
-
-	_, err = io.Copy(outFile, rc)
-	return err
-}
-
 // copyFile copies a file from src to dst
 func copyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
@@ -1166,6 +549,11 @@ func copyFile(src, dst string) error {
 
 // preserveUserDataDuringUpdate handles the complex logic of updating the database while preserving user data
 func preserveUserDataDuringUpdate(currentDb, newDb *sql.DB) error {
+	// The current database may come from an older version of the workflow
+	if err := Migrate(currentDb); err != nil {
+		return fmt.Errorf("failed to migrate current database: %v", err)
+	}
+
 	// Get user favorites and visits from current database
 	favorites, err := getUserFavorites(currentDb)
 	if err != nil {
@@ -1194,39 +582,41 @@ func preserveUserDataDuringUpdate(currentDb, newDb *sql.DB) error {
 		return fmt.Errorf("failed to create restaurant mapping: %v", err)
 	}
 
-	// Print statistics to STDERR
-	fmt.Printf("[UPDATE STATS] Old database: %d restaurants\n", oldRestaurantCount)
-	fmt.Printf("[UPDATE STATS] New database: %d restaurants\n", newRestaurantCount)
-	fmt.Printf("[UPDATE STATS] Restaurants added: %d\n", newRestaurantCount-oldRestaurantCount)
-	fmt.Printf("[UPDATE STATS] Restaurant ID mappings found: %d\n", len(oldToNewRestaurantMap))
-	fmt.Printf("[UPDATE STATS] User favorites to migrate: %d\n", len(favorites))
-	fmt.Printf("[UPDATE STATS] User visits to migrate: %d\n", len(visits))
-
-	// Create user tables in the new database
-	_, err = newDb.Exec(`
-		CREATE TABLE IF NOT EXISTS user_favorites (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			restaurant_id INTEGER NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (restaurant_id) REFERENCES restaurants(id),
-			UNIQUE (restaurant_id)
-		);
-		
-		CREATE TABLE IF NOT EXISTS user_visits (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			restaurant_id INTEGER NOT NULL,
-			visited_date TEXT,
-			notes TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (restaurant_id) REFERENCES restaurants(id),
-			UNIQUE (restaurant_id)
-		);
-		
-		CREATE INDEX IF NOT EXISTS idx_user_favorites_restaurant ON user_favorites(restaurant_id);
-		CREATE INDEX IF NOT EXISTS idx_user_visits_restaurant ON user_visits(restaurant_id);
-	`)
+	// Find restaurants with user data whose URL changed by website, name and location, or phone
+	matchReport, err := matchOrphanedRestaurants(currentDb, newDb, oldToNewRestaurantMap)
 	if err != nil {
-		return fmt.Errorf("failed to create user tables in new database: %v", err)
+		return fmt.Errorf("failed to match orphaned restaurants: %v", err)
+	}
+
+	// Print statistics to STDERR
+	if oldDataset, err := GetDatasetInfo(currentDb); err == nil {
+		if newDataset, err := GetDatasetInfo(newDb); err == nil {
+			fmt.Fprintf(os.Stderr, "[UPDATE STATS] Dataset: %s -> %s\n", datasetLabel(oldDataset), datasetLabel(newDataset))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Old database: %d restaurants\n", oldRestaurantCount)
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] New database: %d restaurants\n", newRestaurantCount)
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Restaurants added: %d\n", newRestaurantCount-oldRestaurantCount)
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Restaurant ID mappings found: %d\n", len(oldToNewRestaurantMap))
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] User favorites to migrate: %d\n", len(favorites))
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] User visits to migrate: %d\n", len(visits))
+	for _, match := range matchReport.Matched {
+		fmt.Fprintf(os.Stderr, "[UPDATE STATS] Matched by %s (%.0f%%): %s -> %s\n", match.Method, match.Confidence*100, match.Name, match.CandidateName)
+	}
+	for _, match := range matchReport.Pending {
+		if match.Ambiguous {
+			fmt.Fprintf(os.Stderr, "[UPDATE WARN] Ambiguous match by %s (%.0f%%): %s -> %s\n", match.Method, match.Confidence*100, match.Name, match.CandidateName)
+		} else {
+			fmt.Fprintf(os.Stderr, "[UPDATE WARN] Low-confidence match by %s (%.0f%%): %s -> %s\n", match.Method, match.Confidence*100, match.Name, match.CandidateName)
+		}
+	}
+	for _, name := range matchReport.Unmatched {
+		fmt.Fprintf(os.Stderr, "[UPDATE WARN] No match found for: %s\n", name)
+	}
+
+	// Create user tables and search columns in the new database
+	if err := Migrate(newDb); err != nil {
+		return fmt.Errorf("failed to migrate new database: %v", err)
 	}
 
 	// Migrate user favorites
@@ -1242,7 +632,7 @@ func preserveUserDataDuringUpdate(currentDb, newDb *sql.DB) error {
 			migratedFavorites++
 		} else {
 			orphanedFavorites++
-			fmt.Printf("[UPDATE WARN] Orphaned favorite: restaurant ID %d not found in new database\n", favorite.RestaurantID)
+			fmt.Fprintf(os.Stderr, "[UPDATE WARN] Orphaned favorite: restaurant ID %d not found in new database\n", favorite.RestaurantID)
 		}
 	}
 
@@ -1251,32 +641,148 @@ func preserveUserDataDuringUpdate(currentDb, newDb *sql.DB) error {
 	orphanedVisits := 0
 	for _, visit := range visits {
 		if newRestaurantID, exists := oldToNewRestaurantMap[visit.RestaurantID]; exists {
-			_, err = newDb.Exec("INSERT OR IGNORE INTO user_visits (restaurant_id, visited_date, notes, created_at) VALUES (?, ?, ?, ?)",
-				newRestaurantID, visit.VisitedDate, visit.Notes, visit.CreatedAt)
+			_, err = newDb.Exec(`
+				INSERT INTO user_visits (restaurant_id, visited_date, notes, party_size, rating, spend, created_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+			`, newRestaurantID, visit.VisitedDate, visit.Notes, visit.PartySize, visit.Rating, visit.Spend, visit.CreatedAt)
 			if err != nil {
 				return fmt.Errorf("failed to migrate visit for restaurant %d: %v", visit.RestaurantID, err)
 			}
 			migratedVisits++
 		} else {
 			orphanedVisits++
-			fmt.Printf("[UPDATE WARN] Orphaned visit: restaurant ID %d not found in new database\n", visit.RestaurantID)
+			fmt.Fprintf(os.Stderr, "[UPDATE WARN] Orphaned visit: restaurant ID %d not found in new database\n", visit.RestaurantID)
 		}
 	}
 
 	// Print migration results
-	fmt.Printf("[UPDATE STATS] Favorites successfully migrated: %d/%d\n", migratedFavorites, len(favorites))
-	fmt.Printf("[UPDATE STATS] Visits successfully migrated: %d/%d\n", migratedVisits, len(visits))
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Favorites successfully migrated: %d/%d\n", migratedFavorites, len(favorites))
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Visits successfully migrated: %d/%d\n", migratedVisits, len(visits))
 	if orphanedFavorites > 0 {
-		fmt.Printf("[UPDATE WARN] Orphaned favorites (restaurant no longer exists): %d\n", orphanedFavorites)
+		fmt.Fprintf(os.Stderr, "[UPDATE WARN] Orphaned favorites (restaurant no longer exists): %d\n", orphanedFavorites)
 	}
 	if orphanedVisits > 0 {
-		fmt.Printf("[UPDATE WARN] Orphaned visits (restaurant no longer exists): %d\n", orphanedVisits)
+		fmt.Fprintf(os.Stderr, "[UPDATE WARN] Orphaned visits (restaurant no longer exists): %d\n", orphanedVisits)
+	}
+
+	// Migrate personal ratings and tags
+	ratings, err := getUserRatings(currentDb)
+	if err != nil {
+		return fmt.Errorf("failed to get user ratings: %v", err)
 	}
+	migratedRatings := 0
+	for _, rating := range ratings {
+		if newRestaurantID, exists := oldToNewRestaurantMap[rating.RestaurantID]; exists {
+			_, err = newDb.Exec("INSERT OR REPLACE INTO user_ratings (restaurant_id, rating, review, updated_at) VALUES (?, ?, ?, ?)",
+				newRestaurantID, rating.Rating, rating.Review, rating.UpdatedAt)
+			if err != nil {
+				return fmt.Errorf("failed to migrate rating for restaurant %d: %v", rating.RestaurantID, err)
+			}
+			migratedRatings++
+		} else {
+			fmt.Fprintf(os.Stderr, "[UPDATE WARN] Orphaned rating: restaurant ID %d not found in new database\n", rating.RestaurantID)
+		}
+	}
+
+	tags, err := getUserTags(currentDb)
+	if err != nil {
+		return fmt.Errorf("failed to get user tags: %v", err)
+	}
+	migratedTags := 0
+	for _, tag := range tags {
+		if newRestaurantID, exists := oldToNewRestaurantMap[tag.RestaurantID]; exists {
+			_, err = newDb.Exec("INSERT OR IGNORE INTO user_tags (restaurant_id, tag, created_at) VALUES (?, ?, ?)",
+				newRestaurantID, tag.Tag, tag.CreatedAt)
+			if err != nil {
+				return fmt.Errorf("failed to migrate tag for restaurant %d: %v", tag.RestaurantID, err)
+			}
+			migratedTags++
+		} else {
+			fmt.Fprintf(os.Stderr, "[UPDATE WARN] Orphaned tag '%s': restaurant ID %d not found in new database\n", tag.Tag, tag.RestaurantID)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Ratings successfully migrated: %d/%d\n", migratedRatings, len(ratings))
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Tags successfully migrated: %d/%d\n", migratedTags, len(tags))
 
-	// Run migration for normalized columns on the new database
-	err = MigrateNormalizedColumns(newDb)
+	// Migrate lists, keeping their IDs and order and dropping entries for removed restaurants
+	lists, listItems, err := getUserLists(currentDb)
+	if err != nil {
+		return fmt.Errorf("failed to get user lists: %v", err)
+	}
+	for _, list := range lists {
+		_, err = newDb.Exec("INSERT OR REPLACE INTO user_lists (id, name, created_at, updated_at) VALUES (?, ?, ?, ?)",
+			list.ID, list.Name, list.CreatedAt, list.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to migrate list %s: %v", list.Name, err)
+		}
+	}
+	migratedListItems := 0
+	positions := make(map[int64]int)
+	for _, item := range listItems {
+		if newRestaurantID, exists := oldToNewRestaurantMap[item.RestaurantID]; exists {
+			positions[item.ListID]++
+			_, err = newDb.Exec("INSERT OR IGNORE INTO user_list_items (list_id, restaurant_id, position, notes, added_at) VALUES (?, ?, ?, ?, ?)",
+				item.ListID, newRestaurantID, positions[item.ListID], item.Notes, item.AddedAt)
+			if err != nil {
+				return fmt.Errorf("failed to migrate list entry for restaurant %d: %v", item.RestaurantID, err)
+			}
+			migratedListItems++
+		} else {
+			fmt.Fprintf(os.Stderr, "[UPDATE WARN] Orphaned list entry: restaurant ID %d not found in new database\n", item.RestaurantID)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Lists migrated: %d, entries: %d/%d\n", len(lists), migratedListItems, len(listItems))
+
+	// Keep uncertain matches for the user to confirm instead of discarding their data
+	pendingMatches, err := savePendingMatches(currentDb, newDb, matchReport.Pending, oldToNewRestaurantMap)
+	if err != nil {
+		return fmt.Errorf("failed to save pending matches: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Matches waiting for confirmation: %d\n", pendingMatches)
+
+	// Record award and guide changes to favorite and visited restaurants, shown by whats-new
+	events, err := RecordUpdateEvents(currentDb, newDb, oldToNewRestaurantMap)
+	if err != nil {
+		return fmt.Errorf("failed to record update events: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Changes to favorite and visited restaurants: %d\n", events)
+
+	// Migrate saved locations (not tied to restaurant IDs)
+	locations, err := getUserLocations(currentDb)
+	if err != nil {
+		return fmt.Errorf("failed to get saved locations: %v", err)
+	}
+	for _, location := range locations {
+		err = SaveLocation(newDb, location.Name, location.Latitude, location.Longitude)
+		if err != nil {
+			return fmt.Errorf("failed to migrate saved location %s: %v", location.Name, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Saved locations migrated: %d\n", len(locations))
+
+	// Price levels, facilities, the search vocabulary and current awards shipped with the new data
+	// may predate it
+	if err := UpdatePriceLevels(newDb); err != nil {
+		return fmt.Errorf("failed to record price levels in new database: %v", err)
+	}
+	if err := RebuildFacilities(newDb); err != nil {
+		return fmt.Errorf("failed to parse facilities in new database: %v", err)
+	}
+	if err := RebuildSearchVocabulary(newDb); err != nil {
+		return fmt.Errorf("failed to build search vocabulary in new database: %v", err)
+	}
+	if err := RebuildCurrentAwards(newDb); err != nil {
+		return fmt.Errorf("failed to build current awards in new database: %v", err)
+	}
+
+	// Build the full-text index for the new data, rebuilding any index shipped with it
+	if hasFullTextIndex(newDb) {
+		err = RebuildFullTextIndex(newDb)
+	} else {
+		err = MigrateFullTextIndex(newDb)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to migrate normalized columns in new database: %v", err)
+		return fmt.Errorf("failed to build full-text index in new database: %v", err)
 	}
 
 	return nil
@@ -1295,6 +801,9 @@ type UserVisit struct {
 	RestaurantID int64
 	VisitedDate  *string
 	Notes        *string
+	PartySize    *int
+	Rating       *int
+	Spend        *float64
 	CreatedAt    string
 }
 
@@ -1345,7 +854,7 @@ func getUserFavorites(db *sql.DB) ([]UserFavorite, error) {
 
 // getUserVisits retrieves all user visits from the database
 func getUserVisits(db *sql.DB) ([]UserVisit, error) {
-	rows, err := db.Query("SELECT id, restaurant_id, visited_date, notes, created_at FROM user_visits")
+	rows, err := db.Query("SELECT id, restaurant_id, visited_date, notes, party_size, rating, spend, created_at FROM user_visits")
 	if err != nil {
 		return nil, err
 	}
@@ -1354,7 +863,8 @@ func getUserVisits(db *sql.DB) ([]UserVisit, error) {
 	var visits []UserVisit
 	for rows.Next() {
 		var visit UserVisit
-		err := rows.Scan(&visit.ID, &visit.RestaurantID, &visit.VisitedDate, &visit.Notes, &visit.CreatedAt)
+		err := rows.Scan(&visit.ID, &visit.RestaurantID, &visit.VisitedDate, &visit.Notes,
+			&visit.PartySize, &visit.Rating, &visit.Spend, &visit.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -1413,17 +923,17 @@ func createRestaurantMapping(oldDb, newDb *sql.DB) (map[int64]int64, error) {
 				unchangedIDs++
 			} else {
 				changedIDs++
-				fmt.Printf("[UPDATE DEBUG] Restaurant ID changed: %d -> %d (URL: %s)\n", oldID, newID, url)
+				fmt.Fprintf(os.Stderr, "[UPDATE DEBUG] Restaurant ID changed: %d -> %d (URL: %s)\n", oldID, newID, url)
 			}
 		}
 	}
 
 	// Print mapping statistics
-	fmt.Printf("[UPDATE STATS] Old restaurants with URLs: %d\n", oldRestaurantsWithURL)
-	fmt.Printf("[UPDATE STATS] New restaurants with URLs: %d\n", newRestaurantsWithURL)
-	fmt.Printf("[UPDATE STATS] Restaurant IDs unchanged: %d\n", unchangedIDs)
-	fmt.Printf("[UPDATE STATS] Restaurant IDs changed: %d\n", changedIDs)
-	fmt.Printf("[UPDATE STATS] Total mappings created: %d\n", len(mapping))
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Old restaurants with URLs: %d\n", oldRestaurantsWithURL)
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] New restaurants with URLs: %d\n", newRestaurantsWithURL)
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Restaurant IDs unchanged: %d\n", unchangedIDs)
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Restaurant IDs changed: %d\n", changedIDs)
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Total mappings created: %d\n", len(mapping))
 
 	return mapping, nil
 }
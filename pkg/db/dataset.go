@@ -0,0 +1,111 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// datasetInfoTable holds key/value metadata describing the restaurant data a database ships with.
+// It belongs to the dataset rather than the schema, so an update replaces it with the new one.
+const datasetInfoTable = `
+	CREATE TABLE IF NOT EXISTS dataset_info (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+`
+
+// DatasetInfo describes the restaurant data in a database
+type DatasetInfo struct {
+	Version       string // empty for databases built before dataset versions were recorded
+	BuiltAt       string
+	DatasetDate   string // from the manifest it was installed with
+	Source        string
+	ArchiveSHA256 string // checksum of the archive the dataset was installed from
+	Signed        bool   // the manifest signature was verified on install
+	SchemaVersion int    // PRAGMA user_version of the restaurant data
+	LatestYear    int    // newest guide year in restaurant_awards
+	Restaurants   int
+}
+
+// StampDataset records the dataset version of a database before it is shipped
+func StampDataset(db *sql.DB, version, source string) error {
+	return setDatasetValues(db, map[string]string{
+		"version":  version,
+		"built_at": time.Now().UTC().Format(time.RFC3339),
+		"source":   source,
+	})
+}
+
+// setDatasetValues writes dataset_info entries
+func setDatasetValues(db *sql.DB, values map[string]string) error {
+	if _, err := db.Exec(datasetInfoTable); err != nil {
+		return fmt.Errorf("failed to create dataset_info table: %v", err)
+	}
+	for key, value := range values {
+		if _, err := db.Exec("INSERT OR REPLACE INTO dataset_info (key, value) VALUES (?, ?)", key, value); err != nil {
+			return fmt.Errorf("failed to record dataset %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// GetDatasetInfo returns the dataset version of a database along with what can be read from the data
+func GetDatasetInfo(db *sql.DB) (DatasetInfo, error) {
+	var info DatasetInfo
+
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'dataset_info')").Scan(&exists); err != nil {
+		return info, fmt.Errorf("failed to check dataset_info table: %v", err)
+	}
+	if exists {
+		rows, err := db.Query("SELECT key, value FROM dataset_info")
+		if err != nil {
+			return info, fmt.Errorf("failed to read dataset info: %v", err)
+		}
+		for rows.Next() {
+			var key, value string
+			if err := rows.Scan(&key, &value); err != nil {
+				rows.Close()
+				return info, fmt.Errorf("failed to read dataset info: %v", err)
+			}
+			switch key {
+			case "version":
+				info.Version = value
+			case "built_at":
+				info.BuiltAt = value
+			case "source":
+				info.Source = value
+			case "dataset_date":
+				info.DatasetDate = value
+			case "archive_sha256":
+				info.ArchiveSHA256 = value
+			case "signature":
+				info.Signed = value == "verified"
+			}
+		}
+		rows.Close()
+	}
+
+	var latest sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(year) FROM restaurant_awards").Scan(&latest); err != nil {
+		return info, fmt.Errorf("failed to get latest guide year: %v", err)
+	}
+	info.LatestYear = int(latest.Int64)
+
+	if err := db.QueryRow("PRAGMA user_version").Scan(&info.SchemaVersion); err != nil {
+		return info, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM restaurants").Scan(&info.Restaurants); err != nil {
+		return info, fmt.Errorf("failed to count restaurants: %v", err)
+	}
+	return info, nil
+}
+
+// datasetLabel describes a dataset version for log output
+func datasetLabel(info DatasetInfo) string {
+	if info.Version == "" {
+		return fmt.Sprintf("unversioned (guide %d)", info.LatestYear)
+	}
+	return fmt.Sprintf("%s (guide %d)", info.Version, info.LatestYear)
+}
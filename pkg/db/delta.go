@@ -0,0 +1,363 @@
+package db
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// deltaFormatVersion is the delta file format this workflow reads, written by the database
+// builder's internal/delta package
+const deltaFormatVersion = 1
+
+// retiredRestaurantsTable lists the restaurants a delta removed from the dataset but kept for their
+// user data. They are not part of the dataset later deltas are built against; applied by migration 10.
+const retiredRestaurantsTable = `
+	CREATE TABLE IF NOT EXISTS retired_restaurants (
+		restaurant_id INTEGER PRIMARY KEY,
+		retired_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (restaurant_id) REFERENCES restaurants(id)
+	);
+`
+
+// Delta lists the changes between two releases of the dataset, keyed by restaurant URL
+type Delta struct {
+	Format      int               `json:"format"`
+	BaseVersion string            `json:"base_version"`
+	Version     string            `json:"version"`
+	DatasetDate string            `json:"dataset_date"`
+	Restaurants int               `json:"restaurants"` // restaurant count once applied
+	Added       []DeltaRestaurant `json:"added"`
+	Changed     []DeltaRestaurant `json:"changed"`
+	Removed     []string          `json:"removed"`
+	Awards      []DeltaAward      `json:"awards"`
+}
+
+// DeltaRestaurant is a restaurant row of a delta
+type DeltaRestaurant struct {
+	URL                   string `json:"url"`
+	Name                  string `json:"name"`
+	Description           string `json:"description"`
+	Address               string `json:"address"`
+	Location              string `json:"location"`
+	Latitude              string `json:"latitude"`
+	Longitude             string `json:"longitude"`
+	Cuisine               string `json:"cuisine"`
+	PhoneNumber           string `json:"phone_number"`
+	FacilitiesAndServices string `json:"facilities_and_services"`
+	WebsiteURL            string `json:"website_url"`
+	ImageURL              string `json:"image_url"`
+	InGuide               bool   `json:"in_guide"`
+}
+
+// DeltaAward is a new or updated award row of a delta
+type DeltaAward struct {
+	URL         string `json:"url"`
+	Year        int    `json:"year"`
+	Distinction string `json:"distinction"`
+	Price       string `json:"price"`
+	GreenStar   bool   `json:"green_star"`
+	WaybackURL  string `json:"wayback_url"`
+}
+
+// DeltaStats summarizes an applied delta
+type DeltaStats struct {
+	Added   int
+	Changed int
+	Removed int
+	Retired int // removed restaurants kept out of the guide because they hold user data
+	Awards  int
+	Events  int
+}
+
+// ReadDelta reads a gzipped JSON delta
+func ReadDelta(path string) (*Delta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, &UpdateRejectedError{Reason: fmt.Sprintf("the delta is not a gzip file: %v", err)}
+	}
+	defer gz.Close()
+
+	var d Delta
+	if err := json.NewDecoder(gz).Decode(&d); err != nil {
+		return nil, &UpdateRejectedError{Reason: fmt.Sprintf("the delta cannot be read: %v", err)}
+	}
+	if d.Format != deltaFormatVersion {
+		return nil, &UpdateRejectedError{Reason: fmt.Sprintf("delta format %d is not supported, please update the workflow", d.Format)}
+	}
+	return &d, nil
+}
+
+// ApplyDelta applies a delta to the live database at dbPath in a single transaction. Restaurants are
+// matched by URL, so their IDs and the user tables stay as they are; removed restaurants that hold
+// user data are kept and marked as no longer in the guide. expected, when given, is the manifest the
+// result must match. The database is backed up first. Callers hold Lock, like for ApplyUpdate.
+func ApplyDelta(dbPath string, d *Delta, expected *UpdateManifest) (DeltaStats, error) {
+	var stats DeltaStats
+
+	database, err := Open(dbPath, false)
+	if err != nil {
+		return stats, err
+	}
+	defer database.Close()
+
+	if err := Migrate(database); err != nil {
+		return stats, fmt.Errorf("failed to migrate database: %v", err)
+	}
+	installed, err := GetDatasetInfo(database)
+	if err != nil {
+		return stats, err
+	}
+	if installed.Version != d.BaseVersion {
+		return stats, &UpdateRejectedError{Reason: fmt.Sprintf("the delta applies to dataset %s, the installed dataset is %s", d.BaseVersion, datasetLabel(installed))}
+	}
+
+	// Favorite and visited restaurants as they were, to report what the delta changes about them
+	tracked, err := getTrackedRestaurants(database)
+	if err != nil {
+		return stats, fmt.Errorf("failed to get favorite and visited restaurants: %v", err)
+	}
+	before := make(map[int64]restaurantState, len(tracked))
+	for _, t := range tracked {
+		if state, found, err := getRestaurantState(database, t.ID); err == nil && found {
+			before[t.ID] = state
+		}
+	}
+
+	backup, err := createBackup(dbPath)
+	if err != nil {
+		return stats, fmt.Errorf("failed to back up database: %v", err)
+	}
+
+	if err := applyDeltaTx(database, d, expected, hasFullTextIndex(database), &stats); err != nil {
+		// Nothing was written, the backup is not needed
+		os.Remove(backup)
+		return stats, err
+	}
+
+	var events []UpdateEvent
+	for _, t := range tracked {
+		old, ok := before[t.ID]
+		if !ok {
+			continue
+		}
+		after, found, err := getRestaurantState(database, t.ID)
+		if err != nil || !found {
+			continue
+		}
+		for _, event := range diffRestaurantStates(old, after) {
+			id := t.ID
+			event.RestaurantID = &id
+			event.RestaurantName = old.Name
+			event.RestaurantURL = old.URL
+			event.IsFavorite = t.IsFavorite
+			event.IsVisited = t.IsVisited
+			events = append(events, event)
+		}
+	}
+	if stats.Events, err = insertUpdateEvents(database, events); err != nil {
+		fmt.Fprintf(os.Stderr, "[UPDATE WARN] Failed to record update events: %v\n", err)
+	}
+
+	if err := pruneBackups(dbPath, backupCount()); err != nil {
+		fmt.Fprintf(os.Stderr, "[UPDATE WARN] Failed to remove old backups: %v\n", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "[UPDATE STATS] Delta %s -> %s: %d added, %d changed, %d removed (%d kept for your data), %d awards\n",
+		d.BaseVersion, d.Version, stats.Added, stats.Changed, stats.Removed, stats.Retired, stats.Awards)
+	return stats, nil
+}
+
+// applyDeltaTx writes a delta in one transaction, checking the result before committing
+func applyDeltaTx(database *sql.DB, d *Delta, expected *UpdateManifest, fullText bool, stats *DeltaStats) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin delta: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+
+	upsert, err := tx.Prepare(`
+		INSERT INTO restaurants (url, name, description, address, location, latitude, longitude, cuisine,
+			phone_number, facilities_and_services, website_url, image_url, in_guide,
+			name_normalized, location_normalized, cuisine_normalized,
+			name_transliterated, location_transliterated, cuisine_transliterated, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET
+			name = excluded.name, description = excluded.description, address = excluded.address,
+			location = excluded.location, latitude = excluded.latitude, longitude = excluded.longitude,
+			cuisine = excluded.cuisine, phone_number = excluded.phone_number,
+			facilities_and_services = excluded.facilities_and_services, website_url = excluded.website_url,
+			image_url = excluded.image_url, in_guide = excluded.in_guide,
+			name_normalized = excluded.name_normalized, location_normalized = excluded.location_normalized,
+			cuisine_normalized = excluded.cuisine_normalized, name_transliterated = excluded.name_transliterated,
+			location_transliterated = excluded.location_transliterated,
+			cuisine_transliterated = excluded.cuisine_transliterated, updated_at = excluded.updated_at
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare restaurant update: %v", err)
+	}
+	defer upsert.Close()
+
+	for _, group := range []struct {
+		rows  []DeltaRestaurant
+		count *int
+	}{{d.Added, &stats.Added}, {d.Changed, &stats.Changed}} {
+		for _, r := range group.rows {
+			_, err := upsert.Exec(r.URL, r.Name, r.Description, r.Address, r.Location, r.Latitude, r.Longitude, r.Cuisine,
+				r.PhoneNumber, r.FacilitiesAndServices, r.WebsiteURL, r.ImageURL, r.InGuide,
+				normalizeForSearch(r.Name), normalizeForSearch(r.Location), normalizeForSearch(r.Cuisine),
+				transliterateForSearch(r.Name), transliterateForSearch(r.Location), transliterateForSearch(r.Cuisine), now, now)
+			if err != nil {
+				return fmt.Errorf("failed to update restaurant %s: %v", r.URL, err)
+			}
+			*group.count++
+
+			var id int64
+			if err := tx.QueryRow("SELECT id FROM restaurants WHERE url = ?", r.URL).Scan(&id); err != nil {
+				return fmt.Errorf("failed to find restaurant %s: %v", r.URL, err)
+			}
+			// A restaurant back in the dataset is no longer kept only for its user data
+			if _, err := tx.Exec("DELETE FROM retired_restaurants WHERE restaurant_id = ?", id); err != nil {
+				return fmt.Errorf("failed to reinstate restaurant %s: %v", r.URL, err)
+			}
+			if err := setFacilities(tx, id, r.FacilitiesAndServices); err != nil {
+				return fmt.Errorf("failed to update facilities of %s: %v", r.URL, err)
+			}
+
+			if fullText {
+				if _, err := tx.Exec("DELETE FROM "+fullTextTable+" WHERE rowid = ?", id); err != nil {
+					return fmt.Errorf("failed to update full-text index: %v", err)
+				}
+				_, err := tx.Exec("INSERT INTO "+fullTextTable+" (rowid, name, location, cuisine, description, facilities) VALUES (?, ?, ?, ?, ?, ?)",
+					id, withTransliteration(r.Name), withTransliteration(r.Location), withTransliteration(r.Cuisine), r.Description, r.FacilitiesAndServices)
+				if err != nil {
+					return fmt.Errorf("failed to update full-text index: %v", err)
+				}
+			}
+		}
+	}
+
+	for _, url := range d.Removed {
+		var id int64
+		err := tx.QueryRow("SELECT id FROM restaurants WHERE url = ?", url).Scan(&id)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find restaurant %s: %v", url, err)
+		}
+
+		var referenced bool
+		err = tx.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM user_favorites WHERE restaurant_id = ?1)
+				OR EXISTS(SELECT 1 FROM user_visits WHERE restaurant_id = ?1)
+				OR EXISTS(SELECT 1 FROM user_ratings WHERE restaurant_id = ?1)
+				OR EXISTS(SELECT 1 FROM user_tags WHERE restaurant_id = ?1)
+				OR EXISTS(SELECT 1 FROM user_list_items WHERE restaurant_id = ?1)
+		`, id).Scan(&referenced)
+		if err != nil {
+			return fmt.Errorf("failed to check user data of %s: %v", url, err)
+		}
+
+		if referenced {
+			if _, err := tx.Exec("UPDATE restaurants SET in_guide = 0, updated_at = ? WHERE id = ?", now, id); err != nil {
+				return fmt.Errorf("failed to retire restaurant %s: %v", url, err)
+			}
+			if _, err := tx.Exec("INSERT OR IGNORE INTO retired_restaurants (restaurant_id, retired_at) VALUES (?, ?)", id, now); err != nil {
+				return fmt.Errorf("failed to retire restaurant %s: %v", url, err)
+			}
+			stats.Retired++
+		} else {
+			if _, err := tx.Exec("DELETE FROM restaurant_awards WHERE restaurant_id = ?", id); err != nil {
+				return fmt.Errorf("failed to remove awards of %s: %v", url, err)
+			}
+			if _, err := tx.Exec("DELETE FROM restaurant_facilities WHERE restaurant_id = ?", id); err != nil {
+				return fmt.Errorf("failed to remove facilities of %s: %v", url, err)
+			}
+			if _, err := tx.Exec("DELETE FROM restaurants WHERE id = ?", id); err != nil {
+				return fmt.Errorf("failed to remove restaurant %s: %v", url, err)
+			}
+			if fullText {
+				if _, err := tx.Exec("DELETE FROM "+fullTextTable+" WHERE rowid = ?", id); err != nil {
+					return fmt.Errorf("failed to update full-text index: %v", err)
+				}
+			}
+		}
+		stats.Removed++
+	}
+
+	for _, a := range d.Awards {
+		var id int64
+		if err := tx.QueryRow("SELECT id FROM restaurants WHERE url = ?", a.URL).Scan(&id); err != nil {
+			return &UpdateRejectedError{Reason: fmt.Sprintf("the delta has an award for unknown restaurant %s", a.URL)}
+		}
+		result, err := tx.Exec(`
+			UPDATE restaurant_awards SET distinction = ?, price = ?, green_star = ?, wayback_url = ?, updated_at = ?
+			WHERE restaurant_id = ? AND year = ?
+		`, a.Distinction, a.Price, a.GreenStar, a.WaybackURL, now, id, a.Year)
+		if err != nil {
+			return fmt.Errorf("failed to update award of %s: %v", a.URL, err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			_, err := tx.Exec(`
+				INSERT INTO restaurant_awards (restaurant_id, year, distinction, price, green_star, wayback_url, created_at, updated_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			`, id, a.Year, a.Distinction, a.Price, a.GreenStar, a.WaybackURL, now, now)
+			if err != nil {
+				return fmt.Errorf("failed to add award of %s: %v", a.URL, err)
+			}
+		}
+		if level := PriceLevel(a.Price); level > 0 {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO price_levels (price, level) VALUES (?, ?)", a.Price, level); err != nil {
+				return fmt.Errorf("failed to record price level of %s: %v", a.Price, err)
+			}
+		}
+		stats.Awards++
+	}
+
+	// The result, leaving out restaurants kept for user data by this or an earlier delta, must be the
+	// dataset the delta was built for
+	var restaurants, awards int
+	err = tx.QueryRow(`
+		WITH kept AS (SELECT restaurant_id FROM retired_restaurants)
+		SELECT
+			(SELECT COUNT(*) FROM restaurants WHERE id NOT IN kept),
+			(SELECT COUNT(*) FROM restaurant_awards WHERE restaurant_id NOT IN kept)
+	`).Scan(&restaurants, &awards)
+	if err != nil {
+		return fmt.Errorf("failed to count restaurants: %v", err)
+	}
+	if restaurants != d.Restaurants {
+		return &UpdateRejectedError{Reason: fmt.Sprintf("the delta leaves %d restaurants instead of %d, the installed dataset differs from its base", restaurants, d.Restaurants)}
+	}
+	if expected != nil && expected.Awards > 0 && awards != expected.Awards {
+		return &UpdateRejectedError{Reason: fmt.Sprintf("the delta leaves %d awards instead of %d, the installed dataset differs from its base", awards, expected.Awards)}
+	}
+
+	if err := rebuildSearchVocabulary(tx); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(datasetInfoTable); err != nil {
+		return fmt.Errorf("failed to create dataset_info table: %v", err)
+	}
+	values := map[string]string{"version": d.Version, "dataset_date": d.DatasetDate}
+	for key, value := range values {
+		if _, err := tx.Exec("INSERT OR REPLACE INTO dataset_info (key, value) VALUES (?, ?)", key, value); err != nil {
+			return fmt.Errorf("failed to record dataset %s: %v", key, err)
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,249 @@
+package db
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// createShippedDatabase writes a database with the shipped restaurant schema, as an update downloads
+// it, holding the given restaurants and awards
+func createShippedDatabase(t *testing.T, path, data string) *sql.DB {
+	t.Helper()
+	database, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+
+	_, err = database.Exec(`
+		CREATE TABLE restaurants (id INTEGER PRIMARY KEY AUTOINCREMENT, url TEXT NOT NULL UNIQUE, name TEXT,
+			description TEXT NOT NULL, address TEXT NOT NULL, location TEXT NOT NULL, latitude TEXT NOT NULL,
+			longitude TEXT NOT NULL, cuisine TEXT NOT NULL, phone_number TEXT, facilities_and_services TEXT,
+			website_url TEXT, image_url TEXT, in_guide NUMERIC, created_at DATETIME, updated_at DATETIME);
+		CREATE TABLE restaurant_awards (id INTEGER PRIMARY KEY AUTOINCREMENT, restaurant_id INTEGER NOT NULL,
+			year INTEGER NOT NULL, distinction TEXT NOT NULL, price TEXT NOT NULL, green_star NUMERIC,
+			wayback_url TEXT, created_at DATETIME, updated_at DATETIME);
+	` + data)
+	if err != nil {
+		database.Close()
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	return database
+}
+
+// createDeltaBase writes a database with the shipped restaurant schema, stamped as dataset 2025.07,
+// holding restaurants a, b and c with one award each and a favorite on c
+func createDeltaBase(t *testing.T, path string) {
+	t.Helper()
+	database := createShippedDatabase(t, path, `
+		INSERT INTO restaurants (url, name, description, address, location, latitude, longitude, cuisine, in_guide) VALUES
+			('https://guide.michelin.com/a', 'Alpha', '', '', 'Paris, France', '0', '0', 'French', 1),
+			('https://guide.michelin.com/b', 'Beta', '', '', 'Lyon, France', '0', '0', 'French', 1),
+			('https://guide.michelin.com/c', 'Gamma', '', '', 'Nice, France', '0', '0', 'French', 1);
+		INSERT INTO restaurant_awards (restaurant_id, year, distinction, price) VALUES
+			(1, 2025, '1 Star', '€€€'), (2, 2025, 'Bib Gourmand', '€€'), (3, 2025, 'Selected Restaurants', '€€');
+	`)
+	defer database.Close()
+
+	if err := Migrate(database); err != nil {
+		t.Fatalf("Migrate() returned error: %v", err)
+	}
+	if err := StampDataset(database, "2025.07", "test"); err != nil {
+		t.Fatalf("StampDataset() returned error: %v", err)
+	}
+	if _, err := database.Exec("INSERT INTO user_favorites (restaurant_id) VALUES (3)"); err != nil {
+		t.Fatalf("failed to add favorite: %v", err)
+	}
+}
+
+// updateDeltaBase runs a full update of current to a new dataset holding the given restaurants and
+// awards, and returns the new database with the user data carried over
+func updateDeltaBase(t *testing.T, current *sql.DB, data string) *sql.DB {
+	t.Helper()
+	newDb := createShippedDatabase(t, filepath.Join(t.TempDir(), "new.db"), data)
+	t.Cleanup(func() { newDb.Close() })
+
+	if err := preserveUserDataDuringUpdate(current, newDb); err != nil {
+		t.Fatalf("preserveUserDataDuringUpdate() returned error: %v", err)
+	}
+	return newDb
+}
+
+// testDelta changes a, removes b and c and adds d
+func testDelta() *Delta {
+	return &Delta{
+		Format:      deltaFormatVersion,
+		BaseVersion: "2025.07",
+		Version:     "2025.10",
+		DatasetDate: "2025-10-01",
+		Restaurants: 2,
+		Added: []DeltaRestaurant{
+			{URL: "https://guide.michelin.com/d", Name: "Delta", Location: "Rome, Italy", Cuisine: "Italian", InGuide: true},
+		},
+		Changed: []DeltaRestaurant{
+			{URL: "https://guide.michelin.com/a", Name: "Alpha", Location: "Paris, France", Cuisine: "Modern French", InGuide: true},
+		},
+		Removed: []string{"https://guide.michelin.com/b", "https://guide.michelin.com/c"},
+		Awards: []DeltaAward{
+			{URL: "https://guide.michelin.com/a", Year: 2025, Distinction: "2 Stars", Price: "€€€€"},
+			{URL: "https://guide.michelin.com/d", Year: 2025, Distinction: "Bib Gourmand", Price: "€€"},
+		},
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createDeltaBase(t, dbPath)
+
+	stats, err := ApplyDelta(dbPath, testDelta(), &UpdateManifest{Awards: 2})
+	if err != nil {
+		t.Fatalf("ApplyDelta() returned error: %v", err)
+	}
+	expected := DeltaStats{Added: 1, Changed: 1, Removed: 2, Retired: 1, Awards: 2}
+	stats.Events = 0
+	if stats != expected {
+		t.Errorf("ApplyDelta() = %+v, expected %+v", stats, expected)
+	}
+
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	cases := []struct {
+		Query    string
+		Expected string
+	}{
+		{"SELECT group_concat(name, ',') FROM (SELECT name FROM restaurants ORDER BY id)", "Alpha,Gamma,Delta"},
+		{"SELECT cuisine FROM restaurants WHERE id = 1", "Modern French"},
+		{"SELECT distinction FROM restaurant_awards WHERE restaurant_id = 1", "2 Stars"},
+		{"SELECT in_guide FROM restaurants WHERE id = 3", "0"},
+		{"SELECT restaurant_id FROM user_favorites", "3"},
+		{"SELECT location_transliterated FROM restaurants WHERE id = 4", "rome, italy"},
+		{"SELECT frequency FROM search_vocabulary WHERE word = 'rome'", "1"},
+		{"SELECT COUNT(*) FROM search_vocabulary WHERE word = 'lyon'", "0"},
+		{"SELECT value FROM dataset_info WHERE key = 'version'", "2025.10"},
+		{"PRAGMA journal_mode", "wal"},
+	}
+	for _, tt := range cases {
+		var got string
+		if err := database.QueryRow(tt.Query).Scan(&got); err != nil {
+			t.Errorf("%s returned error: %v", tt.Query, err)
+		} else if got != tt.Expected {
+			t.Errorf("%s = %q, expected %q", tt.Query, got, tt.Expected)
+		}
+	}
+}
+
+func TestApplyDeltaSequence(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createDeltaBase(t, dbPath)
+
+	// The first delta retires the favorite Gamma, which later deltas must not count as part of the
+	// dataset, unlike Epsilon, a restaurant no longer in the guide that the dataset itself keeps
+	deltas := []*Delta{testDelta(), {
+		Format:      deltaFormatVersion,
+		BaseVersion: "2025.10",
+		Version:     "2026.01",
+		Restaurants: 3,
+		Added: []DeltaRestaurant{
+			{URL: "https://guide.michelin.com/e", Name: "Epsilon", Location: "Milan, Italy", Cuisine: "Italian", InGuide: false},
+		},
+		Changed: []DeltaRestaurant{
+			{URL: "https://guide.michelin.com/d", Name: "Delta", Location: "Rome, Italy", Cuisine: "Roman", InGuide: true},
+		},
+	}, {
+		Format:      deltaFormatVersion,
+		BaseVersion: "2026.01",
+		Version:     "2026.04",
+		Restaurants: 4,
+		Added: []DeltaRestaurant{
+			{URL: "https://guide.michelin.com/c", Name: "Gamma", Location: "Nice, France", Cuisine: "French", InGuide: true},
+		},
+	}}
+	for _, d := range deltas {
+		if _, err := ApplyDelta(dbPath, d, nil); err != nil {
+			t.Fatalf("ApplyDelta(%s) returned error: %v", d.Version, err)
+		}
+	}
+
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+	var inGuide, favorites, retired int
+	err = database.QueryRow(`
+		SELECT in_guide, (SELECT COUNT(*) FROM user_favorites WHERE restaurant_id = 3), (SELECT COUNT(*) FROM retired_restaurants)
+		FROM restaurants WHERE id = 3
+	`).Scan(&inGuide, &favorites, &retired)
+	if err != nil {
+		t.Fatalf("failed to read Gamma: %v", err)
+	}
+	if inGuide != 1 || favorites != 1 || retired != 0 {
+		t.Errorf("Gamma back in the guide has in_guide = %d, %d favorites, %d retired restaurants, expected 1, 1 and 0", inGuide, favorites, retired)
+	}
+}
+
+func TestApplyDeltaRejects(t *testing.T) {
+	cases := []struct {
+		Name  string
+		Edit  func(*Delta)
+		Error string
+	}{
+		{"other base", func(d *Delta) { d.BaseVersion = "2025.01" }, "applies to dataset 2025.01"},
+		{"wrong count", func(d *Delta) { d.Restaurants = 3 }, "leaves 2 restaurants instead of 3"},
+		{"unknown award", func(d *Delta) { d.Awards[0].URL = "https://guide.michelin.com/x" }, "unknown restaurant"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			dbPath := filepath.Join(t.TempDir(), DbFileName)
+			createDeltaBase(t, dbPath)
+			d := testDelta()
+			tt.Edit(d)
+
+			_, err := ApplyDelta(dbPath, d, nil)
+			if !IsUpdateRejected(err) || !strings.Contains(err.Error(), tt.Error) {
+				t.Fatalf("ApplyDelta() = %v, expected a rejection containing %q", err, tt.Error)
+			}
+
+			// Nothing of a rejected delta is written
+			database, err := sql.Open("sqlite3", dbPath)
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+			defer database.Close()
+			var restaurants int
+			var version string
+			database.QueryRow("SELECT COUNT(*) FROM restaurants").Scan(&restaurants)
+			database.QueryRow("SELECT value FROM dataset_info WHERE key = 'version'").Scan(&version)
+			if restaurants != 3 || version != "2025.07" {
+				t.Errorf("rejected delta left %d restaurants and dataset %q", restaurants, version)
+			}
+		})
+	}
+}
+
+func TestReadDelta(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delta.json.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create delta: %v", err)
+	}
+	gz := gzip.NewWriter(f)
+	d := testDelta()
+	d.Format = deltaFormatVersion + 1
+	json.NewEncoder(gz).Encode(d)
+	gz.Close()
+	f.Close()
+
+	if _, err := ReadDelta(path); !IsUpdateRejected(err) || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("ReadDelta() = %v, expected an unsupported format", err)
+	}
+}
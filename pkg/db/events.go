@@ -0,0 +1,374 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// UpdateEventType is a kind of change to a favorite or visited restaurant found during a database update
+type UpdateEventType string
+
+const (
+	EventAwardChanged    UpdateEventType = "award_changed"
+	EventGreenStarGained UpdateEventType = "green_star_gained"
+	EventGreenStarLost   UpdateEventType = "green_star_lost"
+	EventPriceChanged    UpdateEventType = "price_changed"
+	EventLeftGuide       UpdateEventType = "left_guide"
+	EventUnmapped        UpdateEventType = "unmapped"
+)
+
+// updateEventsTable creates the update event log; applied by migration 4
+const updateEventsTable = `
+		CREATE TABLE IF NOT EXISTS user_update_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			update_id TEXT NOT NULL,
+			restaurant_id INTEGER,
+			restaurant_name TEXT,
+			restaurant_url TEXT,
+			event_type TEXT NOT NULL,
+			old_value TEXT,
+			new_value TEXT,
+			is_favorite INTEGER NOT NULL DEFAULT 0,
+			is_visited INTEGER NOT NULL DEFAULT 0,
+			seen INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_user_update_events_update ON user_update_events(update_id);
+`
+
+// UpdateEvent is a change to a favorite or visited restaurant recorded during a database update
+type UpdateEvent struct {
+	ID             int64
+	UpdateID       string
+	RestaurantID   *int64 // ID in the updated database, nil when the restaurant could not be mapped
+	RestaurantName string
+	RestaurantURL  string
+	Type           UpdateEventType
+	OldValue       string
+	NewValue       string
+	IsFavorite     bool
+	IsVisited      bool
+	Seen           bool
+	CreatedAt      string
+}
+
+// restaurantState is what an update can change about a restaurant
+type restaurantState struct {
+	Name      string
+	URL       string
+	InGuide   bool
+	Award     string
+	Price     string
+	GreenStar bool
+}
+
+// diffRestaurantStates lists the events between the state of a restaurant before and after an update
+func diffRestaurantStates(before, after restaurantState) []UpdateEvent {
+	var events []UpdateEvent
+	add := func(eventType UpdateEventType, oldValue, newValue string) {
+		events = append(events, UpdateEvent{Type: eventType, OldValue: oldValue, NewValue: newValue})
+	}
+
+	if before.InGuide && !after.InGuide {
+		add(EventLeftGuide, before.Award, after.Award)
+		return events
+	}
+	if before.Award != after.Award {
+		add(EventAwardChanged, before.Award, after.Award)
+	}
+	if !before.GreenStar && after.GreenStar {
+		add(EventGreenStarGained, "", "")
+	} else if before.GreenStar && !after.GreenStar {
+		add(EventGreenStarLost, "", "")
+	}
+	if before.Price != after.Price && before.Price != "" && after.Price != "" {
+		add(EventPriceChanged, before.Price, after.Price)
+	}
+	return events
+}
+
+// getRestaurantState reads the guide status and latest award of a restaurant
+func getRestaurantState(db *sql.DB, id int64) (restaurantState, bool, error) {
+	var state restaurantState
+	var name, url, award, price sql.NullString
+	var inGuide sql.NullInt64
+	var greenStar sql.NullBool
+	err := db.QueryRow(`
+		SELECT r.name, r.url, r.in_guide, ra.distinction, ra.price, ra.green_star
+		FROM restaurants r
+		LEFT JOIN restaurant_awards ra ON ra.restaurant_id = r.id
+			AND ra.year = (SELECT MAX(year) FROM restaurant_awards WHERE restaurant_id = r.id)
+		WHERE r.id = ?
+		LIMIT 1
+	`, id).Scan(&name, &url, &inGuide, &award, &price, &greenStar)
+	if err == sql.ErrNoRows {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+
+	state = restaurantState{
+		Name:      name.String,
+		URL:       url.String,
+		InGuide:   !inGuide.Valid || inGuide.Int64 == 1,
+		Award:     award.String,
+		Price:     price.String,
+		GreenStar: greenStar.Valid && greenStar.Bool,
+	}
+	return state, true, nil
+}
+
+// trackedRestaurant is a favorite or visited restaurant followed across updates
+type trackedRestaurant struct {
+	ID         int64
+	IsFavorite bool
+	IsVisited  bool
+}
+
+// getTrackedRestaurants lists the favorite and visited restaurants of a database
+func getTrackedRestaurants(db *sql.DB) ([]trackedRestaurant, error) {
+	var parts []string
+	for _, source := range [][2]string{
+		{"user_favorites", "restaurant_id, 1 AS favorite, 0 AS visited"},
+		{"user_visits", "restaurant_id, 0 AS favorite, 1 AS visited"},
+	} {
+		table, columns := source[0], source[1]
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name=?)", table).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			parts = append(parts, "SELECT "+columns+" FROM "+table)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
+
+	query := "SELECT restaurant_id, MAX(favorite), MAX(visited) FROM (" + parts[0]
+	for _, part := range parts[1:] {
+		query += " UNION ALL " + part
+	}
+	query += ") GROUP BY restaurant_id ORDER BY restaurant_id"
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tracked []trackedRestaurant
+	for rows.Next() {
+		var t trackedRestaurant
+		if err := rows.Scan(&t.ID, &t.IsFavorite, &t.IsVisited); err != nil {
+			return nil, err
+		}
+		tracked = append(tracked, t)
+	}
+	return tracked, nil
+}
+
+// RecordUpdateEvents compares every favorite or visited restaurant before and after a database update
+// and stores the award, green star, price and guide status changes in user_update_events of the new
+// database, together with the events of earlier updates. oldToNew maps old restaurant IDs to new ones;
+// when nil, IDs are assumed unchanged. It returns the number of new events.
+func RecordUpdateEvents(oldDb, newDb *sql.DB, oldToNew map[int64]int64) (int, error) {
+	tracked, err := getTrackedRestaurants(oldDb)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get favorite and visited restaurants: %v", err)
+	}
+
+	if err := copyUpdateEvents(oldDb, newDb, oldToNew); err != nil {
+		return 0, err
+	}
+
+	var events []UpdateEvent
+	for _, t := range tracked {
+		before, found, err := getRestaurantState(oldDb, t.ID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read restaurant %d: %v", t.ID, err)
+		}
+		if !found {
+			continue
+		}
+
+		newID, mapped := t.ID, true
+		if oldToNew != nil {
+			newID, mapped = oldToNew[t.ID]
+		}
+		var after restaurantState
+		if mapped {
+			after, mapped, err = getRestaurantState(newDb, newID)
+			if err != nil {
+				return 0, fmt.Errorf("failed to read restaurant %d in new database: %v", newID, err)
+			}
+		}
+
+		var changes []UpdateEvent
+		if mapped {
+			changes = diffRestaurantStates(before, after)
+		} else {
+			changes = []UpdateEvent{{Type: EventUnmapped, OldValue: before.Award}}
+		}
+
+		for _, event := range changes {
+			event.RestaurantName = before.Name
+			event.RestaurantURL = before.URL
+			event.IsFavorite = t.IsFavorite
+			event.IsVisited = t.IsVisited
+			if mapped {
+				id := newID
+				event.RestaurantID = &id
+			}
+			events = append(events, event)
+		}
+	}
+
+	return insertUpdateEvents(newDb, events)
+}
+
+// insertUpdateEvents stores the events of one update and returns their number
+func insertUpdateEvents(db *sql.DB, events []UpdateEvent) (int, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin recording update events: %v", err)
+	}
+	defer tx.Rollback()
+
+	updateID := time.Now().UTC().Format(time.RFC3339)
+	for _, event := range events {
+		_, err := tx.Exec(`
+			INSERT INTO user_update_events
+				(update_id, restaurant_id, restaurant_name, restaurant_url, event_type, old_value, new_value, is_favorite, is_visited)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, updateID, event.RestaurantID, event.RestaurantName, event.RestaurantURL, string(event.Type),
+			nullIfEmpty(event.OldValue), nullIfEmpty(event.NewValue), event.IsFavorite, event.IsVisited)
+		if err != nil {
+			return 0, fmt.Errorf("failed to record update event: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit update events: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "[DEBUG] Recorded %d changes to favorite and visited restaurants\n", len(events))
+	return len(events), nil
+}
+
+// copyUpdateEvents carries the events of earlier updates over to the new database
+func copyUpdateEvents(oldDb, newDb *sql.DB, oldToNew map[int64]int64) error {
+	var exists bool
+	err := oldDb.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='user_update_events')").Scan(&exists)
+	if err != nil || !exists {
+		return err
+	}
+
+	rows, err := oldDb.Query(`
+		SELECT update_id, restaurant_id, restaurant_name, restaurant_url, event_type, old_value, new_value,
+			is_favorite, is_visited, seen, created_at
+		FROM user_update_events ORDER BY id
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to read update events: %v", err)
+	}
+	var events []UpdateEvent
+	for rows.Next() {
+		var e UpdateEvent
+		var restaurantID sql.NullInt64
+		var name, url, oldValue, newValue sql.NullString
+		if err := rows.Scan(&e.UpdateID, &restaurantID, &name, &url, &e.Type, &oldValue, &newValue,
+			&e.IsFavorite, &e.IsVisited, &e.Seen, &e.CreatedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read update events: %v", err)
+		}
+		if restaurantID.Valid {
+			id := restaurantID.Int64
+			if oldToNew != nil {
+				if newID, ok := oldToNew[id]; ok {
+					id = newID
+				}
+			}
+			e.RestaurantID = &id
+		}
+		e.RestaurantName, e.RestaurantURL = name.String, url.String
+		e.OldValue, e.NewValue = oldValue.String, newValue.String
+		events = append(events, e)
+	}
+	rows.Close()
+
+	for _, e := range events {
+		_, err := newDb.Exec(`
+			INSERT INTO user_update_events
+				(update_id, restaurant_id, restaurant_name, restaurant_url, event_type, old_value, new_value,
+				is_favorite, is_visited, seen, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, e.UpdateID, e.RestaurantID, e.RestaurantName, e.RestaurantURL, string(e.Type),
+			nullIfEmpty(e.OldValue), nullIfEmpty(e.NewValue), e.IsFavorite, e.IsVisited, e.Seen, e.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to copy update event: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetUpdateEvents retrieves the events of the latest update, or of all updates, most recent first
+func GetUpdateEvents(db *sql.DB, all bool) ([]UpdateEvent, error) {
+	query := `
+		SELECT id, update_id, restaurant_id, COALESCE(restaurant_name, ''), COALESCE(restaurant_url, ''), event_type,
+			COALESCE(old_value, ''), COALESCE(new_value, ''), is_favorite, is_visited, seen, created_at
+		FROM user_update_events`
+	if !all {
+		query += " WHERE update_id = (SELECT MAX(update_id) FROM user_update_events)"
+	}
+	query += " ORDER BY update_id DESC, is_favorite DESC, restaurant_name"
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get update events: %v", err)
+	}
+	defer rows.Close()
+
+	var events []UpdateEvent
+	for rows.Next() {
+		var e UpdateEvent
+		if err := rows.Scan(&e.ID, &e.UpdateID, &e.RestaurantID, &e.RestaurantName, &e.RestaurantURL, &e.Type,
+			&e.OldValue, &e.NewValue, &e.IsFavorite, &e.IsVisited, &e.Seen, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan update event: %v", err)
+		}
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+// CountUnseenUpdateEvents returns the number of update events not shown by whats-new yet
+func CountUnseenUpdateEvents(db *sql.DB) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM user_update_events WHERE seen = 0").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count update events: %v", err)
+	}
+	return count, nil
+}
+
+// MarkUpdateEventsSeen marks all update events as shown
+func MarkUpdateEventsSeen(db *sql.DB) error {
+	_, err := db.Exec("UPDATE user_update_events SET seen = 1 WHERE seen = 0")
+	if err != nil {
+		return fmt.Errorf("failed to mark update events as seen: %v", err)
+	}
+	return nil
+}
+
+// Improved reports whether an award change is a promotion
+func (e UpdateEvent) Improved() bool {
+	return distinctionRank(e.NewValue) > distinctionRank(e.OldValue)
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffRestaurantStates(t *testing.T) {
+	base := restaurantState{Name: "Seta", InGuide: true, Award: "2 Stars", Price: "€€€€"}
+	with := func(change func(*restaurantState)) restaurantState {
+		s := base
+		change(&s)
+		return s
+	}
+
+	cases := []struct {
+		Name     string
+		After    restaurantState
+		Expected []UpdateEvent
+	}{
+		{"unchanged", base, nil},
+		{"lost a star", with(func(s *restaurantState) { s.Award = "1 Star" }),
+			[]UpdateEvent{{Type: EventAwardChanged, OldValue: "2 Stars", NewValue: "1 Star"}}},
+		{"green star and price", with(func(s *restaurantState) { s.GreenStar = true; s.Price = "€€€" }),
+			[]UpdateEvent{{Type: EventGreenStarGained}, {Type: EventPriceChanged, OldValue: "€€€€", NewValue: "€€€"}}},
+		{"left the guide", with(func(s *restaurantState) { s.InGuide = false; s.Award = "1 Star" }),
+			[]UpdateEvent{{Type: EventLeftGuide, OldValue: "2 Stars", NewValue: "1 Star"}}},
+		{"price removed", with(func(s *restaurantState) { s.Price = "" }), nil},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			got := diffRestaurantStates(base, tt.After)
+			if !reflect.DeepEqual(got, tt.Expected) {
+				t.Errorf("diffRestaurantStates() = %+v, expected %+v", got, tt.Expected)
+			}
+		})
+	}
+}
+
+// describeUpdateEvents lists events as name:type:restaurant ID, with an ID of 0 for unmapped restaurants
+func describeUpdateEvents(events []UpdateEvent) string {
+	var described []string
+	for _, e := range events {
+		var id int64
+		if e.RestaurantID != nil {
+			id = *e.RestaurantID
+		}
+		described = append(described, fmt.Sprintf("%s:%s:%d", e.RestaurantName, e.Type, id))
+	}
+	return strings.Join(described, ",")
+}
+
+func TestUpdateEventsAcrossUpdate(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createDeltaBase(t, dbPath)
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	// Alpha and Beta were visited, Gamma is a favorite; an earlier update left a seen event for
+	// Alpha and an unseen one for Gamma
+	_, err = database.Exec(`
+		INSERT INTO user_visits (restaurant_id, visited_date) VALUES (1, '2025-03-14'), (2, '2025-04-01');
+		INSERT INTO user_update_events (update_id, restaurant_id, restaurant_name, restaurant_url, event_type, old_value, new_value, is_visited, seen) VALUES
+			('2025-01-01T00:00:00Z', 1, 'Alpha', 'https://guide.michelin.com/a', 'award_changed', 'Bib Gourmand', '1 Star', 1, 1);
+		INSERT INTO user_update_events (update_id, restaurant_id, restaurant_name, restaurant_url, event_type, is_favorite, seen) VALUES
+			('2025-01-01T00:00:00Z', 3, 'Gamma', 'https://guide.michelin.com/c', 'green_star_gained', 1, 0);
+	`)
+	if err != nil {
+		t.Fatalf("failed to add user data: %v", err)
+	}
+
+	// Beta leaves the dataset, Alpha leaves the guide, Gamma earns a star and the IDs change
+	newDb := updateDeltaBase(t, database, `
+		INSERT INTO restaurants (url, name, description, address, location, latitude, longitude, cuisine, in_guide) VALUES
+			('https://guide.michelin.com/d', 'Delta', '', '', 'Rome, Italy', '0', '0', 'Italian', 1),
+			('https://guide.michelin.com/c', 'Gamma', '', '', 'Nice, France', '0', '0', 'French', 1),
+			('https://guide.michelin.com/a', 'Alpha', '', '', 'Paris, France', '0', '0', 'French', 0);
+		INSERT INTO restaurant_awards (restaurant_id, year, distinction, price) VALUES
+			(1, 2025, 'Bib Gourmand', '€€'), (2, 2025, '1 Star', '€€'), (3, 2025, '1 Star', '€€€');
+	`)
+
+	latest, err := GetUpdateEvents(newDb, false)
+	if err != nil {
+		t.Fatalf("GetUpdateEvents() returned error: %v", err)
+	}
+	if got, expected := describeUpdateEvents(latest), "Gamma:award_changed:2,Alpha:left_guide:3,Beta:unmapped:0"; got != expected {
+		t.Errorf("latest events = %s, expected %s", got, expected)
+	}
+
+	// The events of the earlier update follow their restaurants to the new IDs and keep their state
+	all, err := GetUpdateEvents(newDb, true)
+	if err != nil {
+		t.Fatalf("GetUpdateEvents() returned error: %v", err)
+	}
+	if len(all) != 5 {
+		t.Fatalf("GetUpdateEvents() returned %d events, expected 5", len(all))
+	}
+	earlier := all[3:]
+	if got, expected := describeUpdateEvents(earlier), "Gamma:green_star_gained:2,Alpha:award_changed:3"; got != expected {
+		t.Errorf("earlier events = %s, expected %s", got, expected)
+	}
+	if earlier[0].Seen || !earlier[1].Seen || earlier[1].OldValue != "Bib Gourmand" {
+		t.Errorf("earlier events = %+v, expected Gamma unseen and Alpha seen with its values", earlier)
+	}
+
+	if count, err := CountUnseenUpdateEvents(newDb); err != nil || count != 4 {
+		t.Errorf("CountUnseenUpdateEvents() = %d, %v, expected 4", count, err)
+	}
+	if err := MarkUpdateEventsSeen(newDb); err != nil {
+		t.Fatalf("MarkUpdateEventsSeen() returned error: %v", err)
+	}
+	if count, err := CountUnseenUpdateEvents(newDb); err != nil || count != 0 {
+		t.Errorf("CountUnseenUpdateEvents() = %d, %v after marking them seen", count, err)
+	}
+	if count, _ := CountUnseenUpdateEvents(database); count != 1 {
+		t.Errorf("marking events seen changed the old database, %d unseen events left", count)
+	}
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// restaurantFacilitiesTable holds the facilities and services of each restaurant, parsed from the
+// comma-separated facilities_and_services column into facility keys; applied by migration 9
+const restaurantFacilitiesTable = `
+	CREATE TABLE IF NOT EXISTS restaurant_facilities (
+		restaurant_id INTEGER NOT NULL,
+		facility TEXT NOT NULL,
+		name TEXT NOT NULL,
+		PRIMARY KEY (restaurant_id, facility)
+	) WITHOUT ROWID;
+
+	CREATE INDEX IF NOT EXISTS idx_restaurant_facilities_facility ON restaurant_facilities(facility);
+`
+
+// facilityVocabulary maps the facilities listed by the guide, in any of their wordings, to a
+// facility key. The first entry with a phrase contained in the facility wins, and facilities are
+// listed in this order, accessibility first.
+var facilityVocabulary = []struct {
+	Key     string
+	Phrases []string
+}{
+	{"wheelchair", []string{"wheelchair"}},
+	{"terrace", []string{"terrace", "outdoor dining"}},
+	{"parking", []string{"car park", "parking"}},
+	{"view", []string{"view"}},
+	{"garden", []string{"garden"}},
+	{"aircon", []string{"air conditioning"}},
+	{"counter", []string{"counter"}},
+	{"private", []string{"private dining", "private room"}},
+	{"vegetarian", []string{"vegetarian", "vegan"}},
+	{"wine", []string{"wine"}},
+	{"sake", []string{"sake"}},
+	{"cocktails", []string{"cocktail"}},
+	{"cash", []string{"cash only", "credit cards not accepted"}},
+	{"brunch", []string{"brunch"}},
+	{"accommodation", []string{"accommodation"}},
+	{"shoes", []string{"shoes"}},
+}
+
+// Facility is a facility or service of a restaurant, with its key and the guide's wording
+type Facility struct {
+	Key  string
+	Name string
+}
+
+// FacilityKey returns the key of a facility, from the guide's wording or from a has: search term.
+// Facilities outside the vocabulary get their normalized wording as key, e.g. "dog-friendly".
+func FacilityKey(name string) string {
+	lower := strings.ToLower(strings.TrimSpace(name))
+	for _, f := range facilityVocabulary {
+		if lower == f.Key {
+			return f.Key
+		}
+	}
+	for _, f := range facilityVocabulary {
+		for _, phrase := range f.Phrases {
+			if strings.Contains(lower, phrase) {
+				return f.Key
+			}
+		}
+	}
+	return strings.Join(strings.Fields(strings.NewReplacer("-", " ", "_", " ").Replace(transliterateForSearch(lower))), "-")
+}
+
+// ParseFacilities splits a facilities_and_services value into facilities, one per key
+func ParseFacilities(value string) []Facility {
+	var facilities []Facility
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		key := FacilityKey(name)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		facilities = append(facilities, Facility{Key: key, Name: name})
+	}
+	return facilities
+}
+
+// setFacilities replaces the facilities of a restaurant with those parsed from value
+func setFacilities(tx *sql.Tx, id int64, value string) error {
+	if _, err := tx.Exec("DELETE FROM restaurant_facilities WHERE restaurant_id = ?", id); err != nil {
+		return err
+	}
+	for _, f := range ParseFacilities(value) {
+		if _, err := tx.Exec("INSERT INTO restaurant_facilities (restaurant_id, facility, name) VALUES (?, ?, ?)", id, f.Key, f.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateFacilities creates restaurant_facilities and fills it from the restaurants
+func migrateFacilities(db *sql.DB) error {
+	if _, err := db.Exec(restaurantFacilitiesTable); err != nil {
+		return fmt.Errorf("failed to create restaurant facilities: %v", err)
+	}
+	return RebuildFacilities(db)
+}
+
+// RebuildFacilities parses the facilities of every restaurant into restaurant_facilities, for a
+// newly imported dataset
+func RebuildFacilities(db *sql.DB) error {
+	type facilitiesRow struct {
+		id    int64
+		value string
+	}
+
+	// Datasets without the column have no facilities to parse
+	exists, err := hasColumn(db, "restaurants", "facilities_and_services")
+	if err != nil {
+		return fmt.Errorf("failed to check table info: %v", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	// Read everything first: updating while the read is still open would lock the database
+	rows, err := db.Query("SELECT id, facilities_and_services FROM restaurants WHERE COALESCE(facilities_and_services, '') != ''")
+	if err != nil {
+		return fmt.Errorf("failed to query facilities: %v", err)
+	}
+	var restaurants []facilitiesRow
+	for rows.Next() {
+		var row facilitiesRow
+		if err := rows.Scan(&row.id, &row.value); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan facilities: %v", err)
+		}
+		restaurants = append(restaurants, row)
+	}
+	rows.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin facilities rebuild: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM restaurant_facilities"); err != nil {
+		return fmt.Errorf("failed to clear facilities: %v", err)
+	}
+	for _, row := range restaurants {
+		if err := setFacilities(tx, row.id, row.value); err != nil {
+			return fmt.Errorf("failed to record facilities of restaurant %d: %v", row.id, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetRestaurantFacilities returns the facilities of a restaurant in the order of the vocabulary,
+// followed by the others by name
+func GetRestaurantFacilities(db *sql.DB, id int64) ([]Facility, error) {
+	rows, err := db.Query("SELECT facility, name FROM restaurant_facilities WHERE restaurant_id = ? ORDER BY name", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query facilities: %v", err)
+	}
+	defer rows.Close()
+
+	var facilities []Facility
+	for rows.Next() {
+		var f Facility
+		if err := rows.Scan(&f.Key, &f.Name); err != nil {
+			return nil, fmt.Errorf("failed to scan facility: %v", err)
+		}
+		facilities = append(facilities, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read facilities: %v", err)
+	}
+
+	rank := func(key string) int {
+		for i, f := range facilityVocabulary {
+			if f.Key == key {
+				return i
+			}
+		}
+		return len(facilityVocabulary)
+	}
+	sort.SliceStable(facilities, func(i, j int) bool {
+		return rank(facilities[i].Key) < rank(facilities[j].Key)
+	})
+	return facilities, nil
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFacilities(t *testing.T) {
+	got := ParseFacilities("Terrace, Wheelchair access,Car park,Valet parking,Dog-friendly,,Great view")
+	expected := []Facility{
+		{"terrace", "Terrace"},
+		{"wheelchair", "Wheelchair access"},
+		{"parking", "Car park"},
+		{"dog-friendly", "Dog-friendly"},
+		{"view", "Great view"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("ParseFacilities() = %+v, expected %+v", got, expected)
+	}
+
+	cases := []struct {
+		Got      string
+		Expected string
+	}{
+		{"wheelchair", "wheelchair"},
+		{"Terrace", "terrace"},
+		{"car park", "parking"},
+		{"counter-dining", "counter"},
+		{"dog_friendly", "dog-friendly"},
+	}
+	for _, tt := range cases {
+		if got := FacilityKey(tt.Got); got != tt.Expected {
+			t.Errorf("FacilityKey(%q) = %q, expected %q", tt.Got, got, tt.Expected)
+		}
+	}
+}
+
+func TestSearchFacilities(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createDeltaBase(t, dbPath)
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	_, err = database.Exec(`
+		UPDATE restaurants SET facilities_and_services = 'Terrace,Wheelchair access' WHERE name = 'Alpha';
+		UPDATE restaurants SET facilities_and_services = 'Car park,Terrace' WHERE name = 'Beta';
+	`)
+	if err != nil {
+		t.Fatalf("failed to set facilities: %v", err)
+	}
+	if err := RebuildFacilities(database); err != nil {
+		t.Fatalf("RebuildFacilities() returned error: %v", err)
+	}
+
+	cases := []struct {
+		Query    string
+		Expected string
+	}{
+		{"has:terrace", "Alpha,Beta"},
+		{"has:wheelchair", "Alpha"},
+		{"has:terrace -has:parking", "Alpha"},
+		{`has:"car park"`, "Beta"},
+	}
+	for _, tt := range cases {
+		restaurants, _, err := SearchRestaurants(database, tt.Query)
+		if err != nil {
+			t.Fatalf("SearchRestaurants(%q) returned error: %v", tt.Query, err)
+		}
+		var names []string
+		for _, r := range restaurants {
+			names = append(names, *r.Name)
+		}
+		if got := strings.Join(names, ","); got != tt.Expected {
+			t.Errorf("%s = %q, expected %q", tt.Query, got, tt.Expected)
+		}
+	}
+
+	facilities, err := GetRestaurantFacilities(database, 1)
+	if err != nil || len(facilities) != 2 || facilities[0].Key != "wheelchair" {
+		t.Errorf("GetRestaurantFacilities() = %+v, %v, expected wheelchair access first", facilities, err)
+	}
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// fullTextTable is the FTS5 index over restaurants, keyed by restaurants.id as rowid
+const fullTextTable = "restaurants_fts"
+
+// fullTextRankExpr ranks matches with bm25, weighting name over location and cuisine,
+// and those over description and facilities (lower is better)
+const fullTextRankExpr = "bm25(restaurants_fts, 10.0, 5.0, 5.0, 1.0, 1.0)"
+
+// isFullTextUnsupported reports whether an error means SQLite was built without FTS5
+// (the binary must be compiled with -tags sqlite_fts5, see buildApp.sh)
+func isFullTextUnsupported(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "no such module: fts5")
+}
+
+// hasFullTextIndex checks that the FTS5 index exists and can be queried by this binary
+func hasFullTextIndex(db *sql.DB) bool {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM (SELECT rowid FROM " + fullTextTable + " LIMIT 1)").Scan(&n)
+	return err == nil
+}
+
+// MigrateFullTextIndex creates and populates the FTS5 index if it does not exist yet.
+// Databases opened by a binary without FTS5 support are left untouched.
+func MigrateFullTextIndex(db *sql.DB) error {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = ?)", fullTextTable).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check full-text index: %v", err)
+	}
+	if exists {
+		return nil
+	}
+
+	_, err = db.Exec(`
+		CREATE VIRTUAL TABLE ` + fullTextTable + ` USING fts5(
+			name, location, cuisine, description, facilities,
+			tokenize = 'unicode61 remove_diacritics 2'
+		)
+	`)
+	if isFullTextUnsupported(err) {
+		fmt.Fprintf(os.Stderr, "[DEBUG] SQLite built without FTS5, full-text search disabled\n")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create full-text index: %v", err)
+	}
+
+	return RebuildFullTextIndex(db)
+}
+
+// RebuildFullTextIndex repopulates the FTS5 index from the restaurants table.
+// Text with transliterated letters is indexed in both spellings, so "köln" is found as "koeln" and "koln".
+func RebuildFullTextIndex(db *sql.DB) error {
+	rows, err := db.Query(`
+		SELECT id, COALESCE(name, ''), COALESCE(location, ''), COALESCE(cuisine, ''),
+			COALESCE(description, ''), COALESCE(facilities_and_services, '')
+		FROM restaurants
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query restaurants for full-text index: %v", err)
+	}
+
+	type indexedRow struct {
+		id     int64
+		fields [5]string
+	}
+	var indexed []indexedRow
+	for rows.Next() {
+		var row indexedRow
+		if err := rows.Scan(&row.id, &row.fields[0], &row.fields[1], &row.fields[2], &row.fields[3], &row.fields[4]); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan restaurant for full-text index: %v", err)
+		}
+		indexed = append(indexed, row)
+	}
+	rows.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin full-text rebuild: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM " + fullTextTable); err != nil {
+		return fmt.Errorf("failed to clear full-text index: %v", err)
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO " + fullTextTable + " (rowid, name, location, cuisine, description, facilities) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare full-text index: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, row := range indexed {
+		// Only the short columns get a transliterated copy, the bm25 weights favour them anyway
+		_, err := stmt.Exec(row.id, withTransliteration(row.fields[0]), withTransliteration(row.fields[1]),
+			withTransliteration(row.fields[2]), row.fields[3], row.fields[4])
+		if err != nil {
+			return fmt.Errorf("failed to populate full-text index: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// withTransliteration appends the transliterated spelling of text when it differs from the accent-stripped one
+func withTransliteration(text string) string {
+	transliterated := transliterateForSearch(text)
+	if transliterated == normalizeForSearch(text) {
+		return text
+	}
+	return text + " " + transliterated
+}
+
+// fullTextPhrase turns a search term into an FTS5 prefix phrase, e.g. truffle -> "truffle"*.
+// Returns false for terms without any letter or digit, which FTS5 cannot match.
+func fullTextPhrase(term string) (string, bool) {
+	normalized := normalizeForSearch(term)
+	if strings.IndexFunc(normalized, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+		return "", false
+	}
+	return `"` + strings.ReplaceAll(normalized, `"`, `""`) + `"*`, true
+}
+
+// fullTextRankQuery builds an FTS5 expression matching any of the positive free-text terms,
+// used only to rank results by relevance
+func (q *SearchQuery) fullTextRankQuery() string {
+	var phrases []string
+	var collect func(node queryNode)
+	collect = func(node queryNode) {
+		switch n := node.(type) {
+		case *andNode:
+			for _, child := range n.children {
+				collect(child)
+			}
+		case *orNode:
+			for _, child := range n.children {
+				collect(child)
+			}
+		case *termNode:
+			if n.field == "" && isFullTextTerm(n) {
+				if phrase, ok := fullTextPhrase(n.value); ok {
+					phrases = append(phrases, phrase)
+				}
+			}
+		}
+		// Negated terms do not contribute to relevance
+	}
+	collect(q.root)
+
+	return strings.Join(phrases, " OR ")
+}
+
+// isFullTextTerm reports whether a free-text term is matched through the FTS5 index:
+// award shorthands and case-sensitive terms keep their dedicated conditions
+func isFullTextTerm(t *termNode) bool {
+	lower := strings.ToLower(t.value)
+	if !t.quoted {
+		if _, ok := awardShorthands[lower]; ok || lower == "gs" {
+			return false
+		}
+	}
+	return !isCaseSensitiveSearch(t.value)
+}
@@ -0,0 +1,76 @@
+//go:build sqlite_fts5
+
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// createFullTextBase writes a migrated database with a full-text index over restaurants whose
+// descriptions mention truffles and pasta
+func createFullTextBase(t *testing.T) *sql.DB {
+	t.Helper()
+	database := createShippedDatabase(t, filepath.Join(t.TempDir(), DbFileName), `
+		INSERT INTO restaurants (url, name, description, address, location, latitude, longitude, cuisine, in_guide) VALUES
+			('https://guide.michelin.com/a', 'Alpha', 'Fresh pasta with white truffles', '', 'Alba, Italy', '0', '0', 'Italian', 1),
+			('https://guide.michelin.com/b', 'Truffle House', 'Seasonal cooking', '', 'Paris, France', '0', '0', 'French', 1),
+			('https://guide.michelin.com/c', 'Gamma', 'Handmade pasta', '', 'Köln, Germany', '0', '0', 'Italian', 1);
+		INSERT INTO restaurant_awards (restaurant_id, year, distinction, price) VALUES
+			(1, 2025, '1 Star', '€€€'), (2, 2025, '1 Star', '€€€'), (3, 2025, 'Bib Gourmand', '€€');
+	`)
+	t.Cleanup(func() { database.Close() })
+
+	if err := Migrate(database); err != nil {
+		t.Fatalf("Migrate() returned error: %v", err)
+	}
+	if err := MigrateFullTextIndex(database); err != nil {
+		t.Fatalf("MigrateFullTextIndex() returned error: %v", err)
+	}
+	if !hasFullTextIndex(database) {
+		t.Fatal("full-text index missing: SQLite was built without FTS5 despite -tags sqlite_fts5")
+	}
+	return database
+}
+
+func TestFullTextSearch(t *testing.T) {
+	database := createFullTextBase(t)
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"truffle", "Alpha,Truffle House"},
+		{"truffle pasta", "Alpha"},
+		{"truf", "Alpha,Truffle House"},
+		{"pasta -truffle", "Gamma"},
+		{"koeln", "Gamma"},
+		{"koln", "Gamma"},
+		// Prefix phrases match the start of words only
+		{"ruffle", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := searchNames(t, database, tt.query); got != tt.expected {
+				t.Errorf("SearchRestaurants(%q) = %q, expected %q", tt.query, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFullTextRanking(t *testing.T) {
+	database := createFullTextBase(t)
+
+	restaurants, _, err := SearchRestaurants(database, "truffle")
+	if err != nil {
+		t.Fatalf("SearchRestaurants() returned error: %v", err)
+	}
+	if len(restaurants) != 2 {
+		t.Fatalf("SearchRestaurants() found %d restaurants, expected 2", len(restaurants))
+	}
+	if *restaurants[0].Name != "Truffle House" {
+		t.Errorf("SearchRestaurants() ranked %s first, expected the name match Truffle House", *restaurants[0].Name)
+	}
+}
@@ -0,0 +1,347 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	// minFuzzyWordLength is the shortest query word that is ever corrected
+	minFuzzyWordLength = 3
+	// minTrigramSimilarity discards candidates sharing too few trigrams with the query word
+	minTrigramSimilarity = 0.3
+)
+
+// fuzzyFields are the field prefixes whose values are corrected; other fields are left as typed
+var fuzzyFields = map[string]bool{"": true, "name": true, "city": true, "country": true, "cuisine": true}
+
+// searchVocabularyTable holds the distinct words of the normalized and transliterated search columns
+// with the number of times they occur, and a trigram index of them
+const searchVocabularyTable = `
+	CREATE TABLE IF NOT EXISTS search_vocabulary (
+		word TEXT PRIMARY KEY,
+		frequency INTEGER NOT NULL
+	) WITHOUT ROWID;
+
+	CREATE TABLE IF NOT EXISTS search_vocabulary_trigrams (
+		trigram TEXT NOT NULL,
+		word TEXT NOT NULL,
+		PRIMARY KEY (trigram, word)
+	) WITHOUT ROWID;
+`
+
+// searchVocabulary holds the candidate words for correcting a query, from restaurant names,
+// locations and cuisines
+type searchVocabulary struct {
+	words     []string
+	frequency map[string]int
+}
+
+// SuggestQuery proposes a corrected version of a search string whose words are replaced by the
+// closest words found in restaurant names, locations and cuisines.
+// Returns false when no word needs (or can be given) a correction.
+func SuggestQuery(db *sql.DB, query string) (string, bool, error) {
+	words := strings.Fields(query)
+	changed := false
+	for i, word := range words {
+		_, value, _, ok := splitCorrectableWord(word)
+		if !ok {
+			continue
+		}
+		vocabulary, err := loadSearchVocabulary(db, normalizeForSearch(value))
+		if err != nil {
+			return "", false, err
+		}
+		if corrected, ok := vocabulary.correctWord(word); ok {
+			words[i] = corrected
+			changed = true
+		}
+	}
+
+	if !changed {
+		return "", false, nil
+	}
+	return strings.Join(words, " "), true, nil
+}
+
+// loadSearchVocabulary loads the vocabulary words sharing a trigram with a normalized query word.
+// Any word within the edit distance or containing the query word shares one, so the others are
+// never read.
+func loadSearchVocabulary(db *sql.DB, word string) (*searchVocabulary, error) {
+	vocabulary := &searchVocabulary{frequency: make(map[string]int)}
+	if len([]rune(word)) < minFuzzyWordLength {
+		return vocabulary, nil
+	}
+
+	var placeholders []string
+	var args []interface{}
+	for trigram := range trigrams(word) {
+		placeholders = append(placeholders, "?")
+		args = append(args, trigram)
+	}
+	rows, err := db.Query(`
+		SELECT word, frequency FROM search_vocabulary
+		WHERE word IN (SELECT word FROM search_vocabulary_trigrams WHERE trigram IN (`+strings.Join(placeholders, ", ")+`))
+		ORDER BY frequency DESC, word
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load search vocabulary: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var candidate string
+		var frequency int
+		if err := rows.Scan(&candidate, &frequency); err != nil {
+			return nil, fmt.Errorf("failed to scan search vocabulary: %v", err)
+		}
+		vocabulary.words = append(vocabulary.words, candidate)
+		vocabulary.frequency[candidate] = frequency
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read search vocabulary: %v", err)
+	}
+
+	return vocabulary, nil
+}
+
+// migrateSearchVocabulary creates the search vocabulary and fills it from the restaurants
+func migrateSearchVocabulary(db *sql.DB) error {
+	if _, err := db.Exec(searchVocabularyTable); err != nil {
+		return fmt.Errorf("failed to create search vocabulary: %v", err)
+	}
+	return RebuildSearchVocabulary(db)
+}
+
+// RebuildSearchVocabulary recomputes the search vocabulary from the search columns, for a newly
+// imported dataset
+func RebuildSearchVocabulary(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin search vocabulary rebuild: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := rebuildSearchVocabulary(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rebuildSearchVocabulary recomputes the search vocabulary within tx. A word counts once per
+// field, whether it comes from the accent-free or the transliterated spelling.
+func rebuildSearchVocabulary(tx *sql.Tx) error {
+	rows, err := tx.Query(`
+		SELECT COALESCE(name_normalized, ''), COALESCE(location_normalized, ''), COALESCE(cuisine_normalized, ''),
+			COALESCE(name_transliterated, ''), COALESCE(location_transliterated, ''), COALESCE(cuisine_transliterated, '')
+		FROM restaurants
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query search columns: %v", err)
+	}
+
+	// Read everything first: updating while the read is still open would lock the database
+	var words []string
+	frequency := make(map[string]int)
+	for rows.Next() {
+		var fields [6]string
+		if err := rows.Scan(&fields[0], &fields[1], &fields[2], &fields[3], &fields[4], &fields[5]); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan search columns: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			seen := make(map[string]bool)
+			for _, word := range append(splitWords(fields[i]), splitWords(fields[i+3])...) {
+				if seen[word] {
+					continue
+				}
+				seen[word] = true
+				if frequency[word] == 0 {
+					words = append(words, word)
+				}
+				frequency[word]++
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read search columns: %v", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM search_vocabulary; DELETE FROM search_vocabulary_trigrams"); err != nil {
+		return fmt.Errorf("failed to clear search vocabulary: %v", err)
+	}
+	insertWord, err := tx.Prepare("INSERT INTO search_vocabulary (word, frequency) VALUES (?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare search vocabulary: %v", err)
+	}
+	defer insertWord.Close()
+	insertTrigram, err := tx.Prepare("INSERT INTO search_vocabulary_trigrams (trigram, word) VALUES (?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare search vocabulary: %v", err)
+	}
+	defer insertTrigram.Close()
+
+	for _, word := range words {
+		if _, err := insertWord.Exec(word, frequency[word]); err != nil {
+			return fmt.Errorf("failed to add %s to the search vocabulary: %v", word, err)
+		}
+		for trigram := range trigrams(word) {
+			if _, err := insertTrigram.Exec(trigram, word); err != nil {
+				return fmt.Errorf("failed to index %s in the search vocabulary: %v", word, err)
+			}
+		}
+	}
+	return nil
+}
+
+// splitWords splits normalized text on anything that is not a letter or a digit
+func splitWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// correctWord corrects a single whitespace-separated word of a search string, keeping
+// its syntax (negation, parentheses, field prefix) intact.
+// Quoted phrases, operators, shorthands and words already found in the vocabulary are left alone.
+func (v *searchVocabulary) correctWord(word string) (string, bool) {
+	prefix, value, suffix, ok := splitCorrectableWord(word)
+	if !ok {
+		return "", false
+	}
+
+	corrected, ok := v.closest(normalizeForSearch(value))
+	if !ok {
+		return "", false
+	}
+	return prefix + corrected + suffix, true
+}
+
+// splitCorrectableWord splits the syntax around the value of a search word, e.g. -(city:koeln) into
+// "-(city:", "koeln" and ")". Returns false for words that are never corrected.
+func splitCorrectableWord(word string) (string, string, string, bool) {
+	if strings.ContainsRune(word, '"') || word == "OR" || word == "|" {
+		return "", "", "", false
+	}
+
+	start := strings.IndexFunc(word, func(r rune) bool { return r != '-' && r != '(' })
+	end := strings.LastIndexFunc(word, func(r rune) bool { return r != ')' })
+	if start < 0 || end < start {
+		return "", "", "", false
+	}
+	prefix, value, suffix := word[:start], word[start:end+1], word[end+1:]
+
+	if idx := strings.Index(value, ":"); idx > 0 {
+		field := strings.ToLower(value[:idx])
+		if !fuzzyFields[field] {
+			return "", "", "", false
+		}
+		prefix += value[:idx+1]
+		value = value[idx+1:]
+	}
+
+	if _, ok := awardShorthands[strings.ToLower(value)]; ok || strings.EqualFold(value, "gs") {
+		return "", "", "", false
+	}
+	return prefix, value, suffix, true
+}
+
+// closest finds the vocabulary word nearest to a normalized query word.
+// Words that already occur inside a vocabulary word match as they are and are not corrected.
+func (v *searchVocabulary) closest(word string) (string, bool) {
+	length := len([]rune(word))
+	if length < minFuzzyWordLength {
+		return "", false
+	}
+	for _, candidate := range v.words {
+		if strings.Contains(candidate, word) {
+			return "", false
+		}
+	}
+
+	// Allow one edit for short words and two for longer ones
+	maxDistance := 1
+	if length > 5 {
+		maxDistance = 2
+	}
+
+	best := ""
+	bestDistance := maxDistance + 1
+	bestSimilarity := 0.0
+	for _, candidate := range v.words {
+		candidateLength := len([]rune(candidate))
+		if candidateLength < length-maxDistance || candidateLength > length+maxDistance {
+			continue
+		}
+
+		similarity := trigramSimilarity(word, candidate)
+		if similarity < minTrigramSimilarity {
+			continue
+		}
+		distance := levenshteinDistance(word, candidate)
+		if distance > maxDistance {
+			continue
+		}
+
+		// Fewer edits first, then more shared trigrams, then the more common word
+		if distance < bestDistance ||
+			(distance == bestDistance && similarity > bestSimilarity) ||
+			(distance == bestDistance && similarity == bestSimilarity && v.frequency[candidate] > v.frequency[best]) {
+			best, bestDistance, bestSimilarity = candidate, distance, similarity
+		}
+	}
+
+	return best, best != ""
+}
+
+// trigrams returns the set of trigrams of a word padded with spaces, as in pg_trgm
+func trigrams(word string) map[string]bool {
+	padded := []rune("  " + word + " ")
+	set := make(map[string]bool, len(padded))
+	for i := 0; i+3 <= len(padded); i++ {
+		set[string(padded[i:i+3])] = true
+	}
+	return set
+}
+
+// trigramSimilarity returns the Dice coefficient of the trigram sets of two words (0 to 1)
+func trigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta)+len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return 2 * float64(shared) / float64(len(ta)+len(tb))
+}
+
+// levenshteinDistance returns the number of single-rune edits turning a into b
+func levenshteinDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeForSearch(t *testing.T) {
+	cases := []struct {
+		Got            string
+		Normalized     string
+		Transliterated string
+	}{
+		{"Köln", "koln", "koeln"},
+		{"Zürich", "zurich", "zuerich"},
+		{"Straße", "straße", "strasse"},
+		{"Café Müller", "cafe muller", "cafe mueller"},
+		{"Kødbyens Fiskebar", "kødbyens fiskebar", "koedbyens fiskebar"},
+		{"Ålesund", "alesund", "aalesund"},
+		{"Œuvre", "œuvre", "oeuvre"},
+		{"Łódź", "łodz", "lodz"},
+		{"Señor", "senor", "senor"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Got, func(t *testing.T) {
+			if got := normalizeForSearch(tt.Got); got != tt.Normalized {
+				t.Errorf("normalizeForSearch(%q) = %q, expected %q", tt.Got, got, tt.Normalized)
+			}
+			if got := transliterateForSearch(tt.Got); got != tt.Transliterated {
+				t.Errorf("transliterateForSearch(%q) = %q, expected %q", tt.Got, got, tt.Transliterated)
+			}
+		})
+	}
+}
+
+func TestLevenshteinDistance(t *testing.T) {
+	cases := []struct {
+		A, B     string
+		Expected int
+	}{
+		{"noma", "noma", 0},
+		{"nomma", "noma", 1},
+		{"koln", "koeln", 1},
+		{"bernadin", "bernardin", 1},
+		{"kitten", "sitting", 3},
+		{"", "abc", 3},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.A+"/"+tt.B, func(t *testing.T) {
+			if got := levenshteinDistance(tt.A, tt.B); got != tt.Expected {
+				t.Errorf("levenshteinDistance() = %d, expected %d", got, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestCorrectWord(t *testing.T) {
+	vocabulary := &searchVocabulary{
+		words:     []string{"noma", "koeln", "germany", "le", "bernardin", "milan", "italy", "sushi"},
+		frequency: map[string]int{"noma": 1, "koeln": 1, "germany": 1, "le": 1, "bernardin": 1, "milan": 2, "italy": 2, "sushi": 1},
+	}
+
+	cases := []struct {
+		Got      string
+		Expected string
+		Changed  bool
+	}{
+		{"Nomma", "noma", true},
+		{"koln", "koeln", true},
+		{"city:koln", "city:koeln", true},
+		{"-(bernadin)", "-(bernardin)", true},
+		{"milan", "", false},
+		{"mil", "", false},
+		{"1s", "", false},
+		{"near:hotel", "", false},
+		{"year:2024", "", false},
+		{`"nomma"`, "", false},
+		{"xyzzy", "", false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Got, func(t *testing.T) {
+			got, changed := vocabulary.correctWord(tt.Got)
+			if changed != tt.Changed || got != tt.Expected {
+				t.Errorf("correctWord(%q) = %q, %v, expected %q, %v", tt.Got, got, changed, tt.Expected, tt.Changed)
+			}
+		})
+	}
+}
+
+func TestSuggestQuery(t *testing.T) {
+	database, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "michelin.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	_, err = database.Exec(`
+		CREATE TABLE restaurants (id INTEGER PRIMARY KEY, name TEXT, location TEXT, cuisine TEXT);
+		CREATE TABLE restaurant_awards (id INTEGER PRIMARY KEY, restaurant_id INTEGER, year INTEGER, distinction TEXT);
+		INSERT INTO restaurants (name, location, cuisine) VALUES
+			('Alpha', 'Paris, France', 'French'),
+			('Beta', 'Lyon, France', 'French'),
+			('Zunfthaus', 'Zürich, Switzerland', 'Swiss');
+	`)
+	if err != nil {
+		t.Fatalf("failed to create restaurants: %v", err)
+	}
+	if err := migrateNormalizedColumns(database); err != nil {
+		t.Fatalf("migrateNormalizedColumns() returned error: %v", err)
+	}
+
+	cases := []struct {
+		Query    string
+		Expected string
+		Changed  bool
+	}{
+		{"zurch", "zurich", true},
+		{"zuerch", "zuerich", true},
+		{"zürich", "", false},
+		{"zuerich", "", false},
+		{"city:lyonn frennch", "city:lyon french", true},
+		{"1s swizs", "1s swiss", true},
+		{"paris", "", false},
+		{"romee", "", false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Query, func(t *testing.T) {
+			got, ok, err := SuggestQuery(database, tt.Query)
+			if err != nil {
+				t.Fatalf("SuggestQuery(%q) returned error: %v", tt.Query, err)
+			}
+			if ok != tt.Changed || got != tt.Expected {
+				t.Errorf("SuggestQuery(%q) = %q, %v, expected %q, %v", tt.Query, got, ok, tt.Expected, tt.Changed)
+			}
+		})
+	}
+}
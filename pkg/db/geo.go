@@ -0,0 +1,245 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultNearRadiusKm is used when a near: token is given without a within: token
+	DefaultNearRadiusKm = 5.0
+	// DefaultLocationName is the saved location used by within: when no near: token is given
+	DefaultLocationName = "home"
+
+	earthRadiusKm = 6371.0
+	kmPerDegree   = 111.32
+)
+
+// GeoFilter restricts a search to restaurants within a radius of a point
+type GeoFilter struct {
+	Label     string
+	Latitude  float64
+	Longitude float64
+	RadiusKm  float64
+}
+
+// SavedLocation represents a named location (home, hotel, office...) stored by the user
+type SavedLocation struct {
+	Name      string
+	Latitude  float64
+	Longitude float64
+	UpdatedAt string
+}
+
+// HaversineKm returns the great-circle distance in kilometres between two points
+func HaversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// ParseCoordinates parses a "lat,lon" pair such as "45.46,9.19"
+func ParseCoordinates(s string) (float64, float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid coordinates %q, expected lat,lon", s)
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid latitude %q", parts[0])
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid longitude %q", parts[1])
+	}
+
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("coordinates out of range: %s", s)
+	}
+
+	return lat, lon, nil
+}
+
+// parseDistanceKm parses a distance such as "5km", "800m" or "2mi" into kilometres.
+// A bare number is interpreted as kilometres.
+func parseDistanceKm(s string) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "km"):
+		s = strings.TrimSuffix(s, "km")
+	case strings.HasSuffix(s, "mi"):
+		s = strings.TrimSuffix(s, "mi")
+		multiplier = 1.609344
+	case strings.HasSuffix(s, "m"):
+		s = strings.TrimSuffix(s, "m")
+		multiplier = 0.001
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid distance %q, expected e.g. 5km or 800m", s)
+	}
+
+	return value * multiplier, nil
+}
+
+// resolveGeoFilter builds a GeoFilter from the values of the near: and within: tokens.
+// Either value may be empty; a saved location name may be used instead of coordinates.
+func resolveGeoFilter(db *sql.DB, near, within string) (*GeoFilter, error) {
+	if near == "" && within == "" {
+		return nil, nil
+	}
+
+	filter := &GeoFilter{RadiusKm: DefaultNearRadiusKm}
+
+	if within != "" {
+		radius, err := parseDistanceKm(within)
+		if err != nil {
+			return nil, err
+		}
+		filter.RadiusKm = radius
+	}
+
+	if near == "" || near == "me" {
+		near = DefaultLocationName
+	}
+
+	if lat, lon, err := ParseCoordinates(near); err == nil {
+		filter.Latitude, filter.Longitude = lat, lon
+		filter.Label = fmt.Sprintf("%.4f,%.4f", lat, lon)
+		return filter, nil
+	}
+
+	location, found, err := GetLocation(db, near)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown location '%s' (save it with set-location %s <lat,lon>)", near, near)
+	}
+
+	filter.Latitude, filter.Longitude = location.Latitude, location.Longitude
+	filter.Label = location.Name
+	return filter, nil
+}
+
+// boundingBoxClause returns a coarse SQL pre-filter so that only nearby rows are scanned in Go
+func (f *GeoFilter) boundingBoxClause() (string, []interface{}) {
+	dLat := f.RadiusKm / kmPerDegree
+	clause := " AND CAST(r.latitude AS REAL) BETWEEN ? AND ?"
+	args := []interface{}{f.Latitude - dLat, f.Latitude + dLat}
+
+	// Skip the longitude bound close to the poles or across the antimeridian
+	cosLat := math.Cos(f.Latitude * math.Pi / 180)
+	if cosLat > 0.01 {
+		dLon := f.RadiusKm / (kmPerDegree * cosLat)
+		if f.Longitude-dLon >= -180 && f.Longitude+dLon <= 180 {
+			clause += " AND CAST(r.longitude AS REAL) BETWEEN ? AND ?"
+			args = append(args, f.Longitude-dLon, f.Longitude+dLon)
+		}
+	}
+
+	return clause, args
+}
+
+// apply computes the distance of each restaurant, drops those outside the radius
+// and sorts the remainder nearest-first
+func (f *GeoFilter) apply(restaurants []Restaurant) []Restaurant {
+	filtered := restaurants[:0]
+	for _, r := range restaurants {
+		if r.Latitude == nil || r.Longitude == nil {
+			continue
+		}
+		lat, errLat := strconv.ParseFloat(*r.Latitude, 64)
+		lon, errLon := strconv.ParseFloat(*r.Longitude, 64)
+		if errLat != nil || errLon != nil {
+			continue
+		}
+
+		distance := HaversineKm(f.Latitude, f.Longitude, lat, lon)
+		if distance > f.RadiusKm {
+			continue
+		}
+		r.DistanceKm = &distance
+		filtered = append(filtered, r)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return *filtered[i].DistanceKm < *filtered[j].DistanceKm
+	})
+
+	return filtered
+}
+
+// SaveLocation stores or replaces a named location
+func SaveLocation(db *sql.DB, name string, latitude, longitude float64) error {
+	_, err := db.Exec(`
+		INSERT INTO user_locations (name, latitude, longitude, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(name) DO UPDATE SET
+			latitude = excluded.latitude,
+			longitude = excluded.longitude,
+			updated_at = CURRENT_TIMESTAMP
+	`, strings.ToLower(name), latitude, longitude)
+	if err != nil {
+		return fmt.Errorf("failed to save location: %v", err)
+	}
+	return nil
+}
+
+// GetLocation retrieves a saved location by name
+func GetLocation(db *sql.DB, name string) (SavedLocation, bool, error) {
+	var location SavedLocation
+	err := db.QueryRow(
+		"SELECT name, latitude, longitude, updated_at FROM user_locations WHERE name = ?",
+		strings.ToLower(name),
+	).Scan(&location.Name, &location.Latitude, &location.Longitude, &location.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return SavedLocation{}, false, nil
+	}
+	if err != nil {
+		return SavedLocation{}, false, fmt.Errorf("failed to get location: %v", err)
+	}
+	return location, true, nil
+}
+
+// GetLocations retrieves all saved locations
+func GetLocations(db *sql.DB) ([]SavedLocation, error) {
+	rows, err := db.Query("SELECT name, latitude, longitude, updated_at FROM user_locations ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get locations: %v", err)
+	}
+	defer rows.Close()
+
+	var locations []SavedLocation
+	for rows.Next() {
+		var location SavedLocation
+		if err := rows.Scan(&location.Name, &location.Latitude, &location.Longitude, &location.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan location: %v", err)
+		}
+		locations = append(locations, location)
+	}
+
+	return locations, nil
+}
+
+// getUserLocations retrieves all saved locations for migration, tolerating databases without the table
+func getUserLocations(db *sql.DB) ([]SavedLocation, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='user_locations')").Scan(&exists)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return GetLocations(db)
+}
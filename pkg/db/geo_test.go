@@ -0,0 +1,80 @@
+package db
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHaversineKm(t *testing.T) {
+	cases := []struct {
+		Name                   string
+		Lat1, Lon1, Lat2, Lon2 float64
+		Expected               float64
+	}{
+		{"same point", 45.46, 9.19, 45.46, 9.19, 0},
+		{"Milan to Rome", 45.4642, 9.1900, 41.9028, 12.4964, 477},
+		{"Paris to New York", 48.8566, 2.3522, 40.7128, -74.0060, 5837},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			got := HaversineKm(tt.Lat1, tt.Lon1, tt.Lat2, tt.Lon2)
+			if math.Abs(got-tt.Expected) > 2 {
+				t.Errorf("HaversineKm() = %.1f, expected about %.1f", got, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestParseCoordinates(t *testing.T) {
+	cases := []struct {
+		Got      string
+		Lat, Lon float64
+		WantErr  bool
+	}{
+		{"45.46,9.19", 45.46, 9.19, false},
+		{"-33.8688, 151.2093", -33.8688, 151.2093, false},
+		{"45.46", 0, 0, true},
+		{"hotel", 0, 0, true},
+		{"95,10", 0, 0, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Got, func(t *testing.T) {
+			lat, lon, err := ParseCoordinates(tt.Got)
+			if (err != nil) != tt.WantErr {
+				t.Fatalf("ParseCoordinates(%q) error = %v, wantErr %v", tt.Got, err, tt.WantErr)
+			}
+			if lat != tt.Lat || lon != tt.Lon {
+				t.Errorf("ParseCoordinates(%q) = %v,%v, expected %v,%v", tt.Got, lat, lon, tt.Lat, tt.Lon)
+			}
+		})
+	}
+}
+
+func TestParseDistanceKm(t *testing.T) {
+	cases := []struct {
+		Got      string
+		Expected float64
+		WantErr  bool
+	}{
+		{"5km", 5, false},
+		{"800m", 0.8, false},
+		{"2", 2, false},
+		{"1mi", 1.609344, false},
+		{"far", 0, true},
+		{"-1km", 0, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Got, func(t *testing.T) {
+			got, err := parseDistanceKm(tt.Got)
+			if (err != nil) != tt.WantErr {
+				t.Fatalf("parseDistanceKm(%q) error = %v, wantErr %v", tt.Got, err, tt.WantErr)
+			}
+			if math.Abs(got-tt.Expected) > 1e-9 {
+				t.Errorf("parseDistanceKm(%q) = %v, expected %v", tt.Got, got, tt.Expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// UserList represents a named list of restaurants, e.g. "Tokyo trip 2026"
+type UserList struct {
+	ID        int64
+	Name      string
+	ItemCount int
+	CreatedAt string
+	UpdatedAt string
+}
+
+// UserListItem represents a restaurant in a list, for migration
+type UserListItem struct {
+	ListID       int64
+	RestaurantID int64
+	Position     int
+	Notes        *string
+	AddedAt      string
+}
+
+// CreateList creates a new empty list and returns its ID
+func CreateList(db *sql.DB, name string) (int64, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, fmt.Errorf("list name cannot be empty")
+	}
+
+	result, err := db.Exec("INSERT INTO user_lists (name) VALUES (?)", name)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return 0, fmt.Errorf("a list named '%s' already exists", name)
+		}
+		return 0, fmt.Errorf("failed to create list: %v", err)
+	}
+
+	return result.LastInsertId()
+}
+
+// RenameList changes the name of a list
+func RenameList(db *sql.DB, listID int64, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("list name cannot be empty")
+	}
+
+	result, err := db.Exec("UPDATE user_lists SET name = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", name, listID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return fmt.Errorf("a list named '%s' already exists", name)
+		}
+		return fmt.Errorf("failed to rename list: %v", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("list %d not found", listID)
+	}
+	return nil
+}
+
+// DeleteList removes a list and its entries
+func DeleteList(db *sql.DB, listID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin list deletion: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_list_items WHERE list_id = ?", listID); err != nil {
+		return fmt.Errorf("failed to delete list entries: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM user_lists WHERE id = ?", listID); err != nil {
+		return fmt.Errorf("failed to delete list: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetLists retrieves all lists with their number of entries, most recently changed first
+func GetLists(db *sql.DB) ([]UserList, error) {
+	rows, err := db.Query(`
+		SELECT l.id, l.name, COUNT(li.id), l.created_at, l.updated_at
+		FROM user_lists l
+		LEFT JOIN user_list_items li ON li.list_id = l.id
+		GROUP BY l.id
+		ORDER BY l.updated_at DESC, l.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lists: %v", err)
+	}
+	defer rows.Close()
+
+	var lists []UserList
+	for rows.Next() {
+		var list UserList
+		if err := rows.Scan(&list.ID, &list.Name, &list.ItemCount, &list.CreatedAt, &list.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan list: %v", err)
+		}
+		lists = append(lists, list)
+	}
+
+	return lists, nil
+}
+
+// ResolveList finds a list by ID or, for non-numeric references, by name (case-insensitive)
+func ResolveList(db *sql.DB, ref string) (UserList, error) {
+	ref = strings.TrimSpace(ref)
+
+	query := `
+		SELECT l.id, l.name, COUNT(li.id), l.created_at, l.updated_at
+		FROM user_lists l
+		LEFT JOIN user_list_items li ON li.list_id = l.id
+		WHERE `
+	var arg interface{}
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		query += "l.id = ?"
+		arg = id
+	} else {
+		query += "l.name = ? COLLATE NOCASE"
+		arg = ref
+	}
+	query += " GROUP BY l.id"
+
+	var list UserList
+	err := db.QueryRow(query, arg).Scan(&list.ID, &list.Name, &list.ItemCount, &list.CreatedAt, &list.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return UserList{}, fmt.Errorf("list '%s' not found", ref)
+	}
+	if err != nil {
+		return UserList{}, fmt.Errorf("failed to get list: %v", err)
+	}
+
+	return list, nil
+}
+
+// AddToList appends a restaurant to the end of a list. Adding a restaurant already in the list
+// only updates its notes, when given.
+func AddToList(db *sql.DB, listID, restaurantID int64, notes string) error {
+	var notesParam interface{}
+	if notes != "" {
+		notesParam = notes
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO user_list_items (list_id, restaurant_id, position, notes)
+		VALUES (?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM user_list_items WHERE list_id = ?), ?)
+		ON CONFLICT(list_id, restaurant_id) DO UPDATE SET
+			notes = COALESCE(excluded.notes, notes)
+	`, listID, restaurantID, listID, notesParam)
+	if err != nil {
+		return fmt.Errorf("failed to add to list: %v", err)
+	}
+
+	return touchList(db, listID)
+}
+
+// RemoveFromList removes a restaurant from a list and closes the gap in the ordering
+func RemoveFromList(db *sql.DB, listID, restaurantID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin list update: %v", err)
+	}
+	defer tx.Rollback()
+
+	var position int
+	err = tx.QueryRow("SELECT position FROM user_list_items WHERE list_id = ? AND restaurant_id = ?", listID, restaurantID).Scan(&position)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("restaurant is not in this list")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find list entry: %v", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM user_list_items WHERE list_id = ? AND restaurant_id = ?", listID, restaurantID); err != nil {
+		return fmt.Errorf("failed to remove from list: %v", err)
+	}
+	if _, err := tx.Exec("UPDATE user_list_items SET position = position - 1 WHERE list_id = ? AND position > ?", listID, position); err != nil {
+		return fmt.Errorf("failed to reorder list: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return touchList(db, listID)
+}
+
+// MoveListItem moves a restaurant to a new 1-based position in a list, shifting the entries in between
+func MoveListItem(db *sql.DB, listID, restaurantID int64, newPosition int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin list update: %v", err)
+	}
+	defer tx.Rollback()
+
+	var position, count int
+	err = tx.QueryRow("SELECT position FROM user_list_items WHERE list_id = ? AND restaurant_id = ?", listID, restaurantID).Scan(&position)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("restaurant is not in this list")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find list entry: %v", err)
+	}
+	if err := tx.QueryRow("SELECT COUNT(*) FROM user_list_items WHERE list_id = ?", listID).Scan(&count); err != nil {
+		return fmt.Errorf("failed to count list entries: %v", err)
+	}
+
+	newPosition = max(1, min(newPosition, count))
+	if newPosition < position {
+		_, err = tx.Exec("UPDATE user_list_items SET position = position + 1 WHERE list_id = ? AND position >= ? AND position < ?",
+			listID, newPosition, position)
+	} else if newPosition > position {
+		_, err = tx.Exec("UPDATE user_list_items SET position = position - 1 WHERE list_id = ? AND position > ? AND position <= ?",
+			listID, position, newPosition)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reorder list: %v", err)
+	}
+
+	_, err = tx.Exec("UPDATE user_list_items SET position = ? WHERE list_id = ? AND restaurant_id = ?", newPosition, listID, restaurantID)
+	if err != nil {
+		return fmt.Errorf("failed to move list entry: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return touchList(db, listID)
+}
+
+// SetListItemNotes replaces the notes of a restaurant in a list; empty notes clear them
+func SetListItemNotes(db *sql.DB, listID, restaurantID int64, notes string) error {
+	var notesParam interface{}
+	if notes != "" {
+		notesParam = notes
+	}
+
+	result, err := db.Exec("UPDATE user_list_items SET notes = ? WHERE list_id = ? AND restaurant_id = ?", notesParam, listID, restaurantID)
+	if err != nil {
+		return fmt.Errorf("failed to update list notes: %v", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("restaurant is not in this list")
+	}
+
+	return touchList(db, listID)
+}
+
+// touchList marks a list as changed, so that recently used lists come first
+func touchList(db *sql.DB, listID int64) error {
+	_, err := db.Exec("UPDATE user_lists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", listID)
+	if err != nil {
+		return fmt.Errorf("failed to update list: %v", err)
+	}
+	return nil
+}
+
+// GetListRestaurants retrieves the restaurants of a list in list order
+func GetListRestaurants(db *sql.DB, listID int64) ([]Restaurant, error) {
+	rows, err := db.Query(restaurantQuery(
+		"user_list_items li INNER JOIN restaurants r ON r.id = li.restaurant_id",
+		"li.position, li.notes",
+		"WHERE li.list_id = ? ORDER BY li.position",
+	), listID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get list restaurants: %v", err)
+	}
+	defer rows.Close()
+
+	var restaurants []Restaurant
+	for rows.Next() {
+		var position int
+		var notes *string
+		r, err := scanRestaurant(rows, &position, &notes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		r.ListPosition, r.ListNotes = position, notes
+		restaurants = append(restaurants, r)
+	}
+
+	return restaurants, nil
+}
+
+// WriteListCSV writes the restaurants of a list as CSV and returns the number of rows written
+func WriteListCSV(db *sql.DB, listID int64, w io.Writer) (int, error) {
+	restaurants, err := GetListRestaurants(db, listID)
+	if err != nil {
+		return 0, err
+	}
+
+	deref := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+
+	writer := csv.NewWriter(w)
+	header := []string{"Position", "Name", "Location", "Address", "Cuisine", "Award", "Price",
+		"Phone", "Website", "Michelin URL", "Latitude", "Longitude", "Notes"}
+	if err := writer.Write(header); err != nil {
+		return 0, fmt.Errorf("failed to write CSV: %v", err)
+	}
+
+	for _, r := range restaurants {
+		record := []string{
+			strconv.Itoa(r.ListPosition), deref(r.Name), deref(r.Location), deref(r.Address), deref(r.Cuisine),
+			deref(r.CurrentAward), deref(r.CurrentPrice), deref(r.PhoneNumber), deref(r.WebsiteUrl), deref(r.Url),
+			deref(r.Latitude), deref(r.Longitude), deref(r.ListNotes),
+		}
+		if err := writer.Write(record); err != nil {
+			return 0, fmt.Errorf("failed to write CSV: %v", err)
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return 0, fmt.Errorf("failed to write CSV: %v", err)
+	}
+
+	return len(restaurants), nil
+}
+
+// getUserLists retrieves all lists and their entries for migration, tolerating databases without the tables
+func getUserLists(db *sql.DB) ([]UserList, []UserListItem, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='user_list_items')").Scan(&exists)
+	if err != nil || !exists {
+		return nil, nil, err
+	}
+
+	lists, err := GetLists(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := db.Query("SELECT list_id, restaurant_id, position, notes, added_at FROM user_list_items ORDER BY list_id, position")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var items []UserListItem
+	for rows.Next() {
+		var item UserListItem
+		if err := rows.Scan(&item.ListID, &item.RestaurantID, &item.Position, &item.Notes, &item.AddedAt); err != nil {
+			return nil, nil, err
+		}
+		items = append(items, item)
+	}
+
+	return lists, items, nil
+}
@@ -0,0 +1,221 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// listEntries describes the entries of a list in order as name:position, with notes after a slash
+func listEntries(t *testing.T, database *sql.DB, listID int64) string {
+	t.Helper()
+	rows, err := database.Query(`
+		SELECT r.name, li.position, COALESCE(li.notes, '')
+		FROM user_list_items li
+		INNER JOIN restaurants r ON r.id = li.restaurant_id
+		WHERE li.list_id = ?
+		ORDER BY li.position
+	`, listID)
+	if err != nil {
+		t.Fatalf("failed to get list entries: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []string
+	for rows.Next() {
+		var name, notes string
+		var position int
+		if err := rows.Scan(&name, &position, &notes); err != nil {
+			t.Fatalf("failed to scan list entry: %v", err)
+		}
+		entry := fmt.Sprintf("%s:%d", name, position)
+		if notes != "" {
+			entry += "/" + notes
+		}
+		entries = append(entries, entry)
+	}
+	return strings.Join(entries, ",")
+}
+
+// createTestList opens the delta base with a list holding Alpha, Beta and Gamma in that order
+func createTestList(t *testing.T) (*sql.DB, int64) {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createDeltaBase(t, dbPath)
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	listID, err := CreateList(database, "Trip")
+	if err != nil {
+		t.Fatalf("CreateList() returned error: %v", err)
+	}
+	for _, id := range []int64{1, 2, 3} {
+		if err := AddToList(database, listID, id, ""); err != nil {
+			t.Fatalf("AddToList(%d) returned error: %v", id, err)
+		}
+	}
+	return database, listID
+}
+
+func TestMoveListItem(t *testing.T) {
+	cases := []struct {
+		Name         string
+		RestaurantID int64
+		Position     int
+		Expected     string
+	}{
+		{"down", 1, 3, "Beta:1,Gamma:2,Alpha:3"},
+		{"up", 3, 1, "Gamma:1,Alpha:2,Beta:3"},
+		{"one step", 2, 3, "Alpha:1,Gamma:2,Beta:3"},
+		{"same position", 2, 2, "Alpha:1,Beta:2,Gamma:3"},
+		{"clamped to the end", 1, 99, "Beta:1,Gamma:2,Alpha:3"},
+		{"clamped to the start", 3, 0, "Gamma:1,Alpha:2,Beta:3"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			database, listID := createTestList(t)
+			if err := MoveListItem(database, listID, tt.RestaurantID, tt.Position); err != nil {
+				t.Fatalf("MoveListItem() returned error: %v", err)
+			}
+			if got := listEntries(t, database, listID); got != tt.Expected {
+				t.Errorf("list = %s, expected %s", got, tt.Expected)
+			}
+		})
+	}
+
+	database, listID := createTestList(t)
+	if err := RemoveFromList(database, listID, 2); err != nil {
+		t.Fatalf("RemoveFromList() returned error: %v", err)
+	}
+	if err := MoveListItem(database, listID, 2, 1); err == nil {
+		t.Errorf("MoveListItem() accepted a restaurant that is not in the list")
+	}
+}
+
+func TestRemoveFromList(t *testing.T) {
+	cases := []struct {
+		Name         string
+		RestaurantID int64
+		Expected     string
+	}{
+		{"first", 1, "Beta:1,Gamma:2"},
+		{"middle", 2, "Alpha:1,Gamma:2"},
+		{"last", 3, "Alpha:1,Beta:2"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			database, listID := createTestList(t)
+			if err := RemoveFromList(database, listID, tt.RestaurantID); err != nil {
+				t.Fatalf("RemoveFromList() returned error: %v", err)
+			}
+			if got := listEntries(t, database, listID); got != tt.Expected {
+				t.Errorf("list = %s, expected %s", got, tt.Expected)
+			}
+			if err := RemoveFromList(database, listID, tt.RestaurantID); err == nil {
+				t.Errorf("RemoveFromList() removed a restaurant twice")
+			}
+		})
+	}
+
+	// Adding after a removal appends after the remaining entries
+	database, listID := createTestList(t)
+	if err := RemoveFromList(database, listID, 1); err != nil {
+		t.Fatalf("RemoveFromList() returned error: %v", err)
+	}
+	if err := AddToList(database, listID, 1, ""); err != nil {
+		t.Fatalf("AddToList() returned error: %v", err)
+	}
+	if got, expected := listEntries(t, database, listID), "Beta:1,Gamma:2,Alpha:3"; got != expected {
+		t.Errorf("list = %s, expected %s", got, expected)
+	}
+}
+
+func TestAddToListKeepsNotes(t *testing.T) {
+	database, listID := createTestList(t)
+
+	steps := []struct {
+		Name     string
+		Notes    string
+		Expected string
+	}{
+		{"adds notes", "book early", "Alpha:1,Beta:2/book early,Gamma:3"},
+		{"keeps notes and position", "", "Alpha:1,Beta:2/book early,Gamma:3"},
+		{"replaces notes", "lunch only", "Alpha:1,Beta:2/lunch only,Gamma:3"},
+	}
+	for _, step := range steps {
+		if err := AddToList(database, listID, 2, step.Notes); err != nil {
+			t.Fatalf("%s: AddToList() returned error: %v", step.Name, err)
+		}
+		if got := listEntries(t, database, listID); got != step.Expected {
+			t.Errorf("%s: list = %s, expected %s", step.Name, got, step.Expected)
+		}
+	}
+
+	if err := SetListItemNotes(database, listID, 2, ""); err != nil {
+		t.Fatalf("SetListItemNotes() returned error: %v", err)
+	}
+	if got, expected := listEntries(t, database, listID), "Alpha:1,Beta:2,Gamma:3"; got != expected {
+		t.Errorf("list = %s, expected %s", got, expected)
+	}
+}
+
+func TestRenameList(t *testing.T) {
+	database, listID := createTestList(t)
+	if _, err := CreateList(database, "Lyon"); err != nil {
+		t.Fatalf("CreateList() returned error: %v", err)
+	}
+
+	if err := RenameList(database, listID, " Paris "); err != nil {
+		t.Fatalf("RenameList() returned error: %v", err)
+	}
+	if list, err := ResolveList(database, "paris"); err != nil || list.ID != listID {
+		t.Errorf("ResolveList(paris) = %+v, %v, expected list %d", list, err, listID)
+	}
+	if err := RenameList(database, listID, "Lyon"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("RenameList() to a taken name = %v, expected an error", err)
+	}
+	if err := RenameList(database, listID+100, "Rome"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("RenameList() of an unknown list = %v, expected an error", err)
+	}
+}
+
+func TestUpdateKeepsLists(t *testing.T) {
+	database, listID := createTestList(t)
+	if err := SetListItemNotes(database, listID, 3, "terrace"); err != nil {
+		t.Fatalf("SetListItemNotes() returned error: %v", err)
+	}
+
+	// Beta leaves the dataset and the remaining restaurants get new IDs
+	newDb := updateDeltaBase(t, database, `
+		INSERT INTO restaurants (url, name, description, address, location, latitude, longitude, cuisine, in_guide) VALUES
+			('https://guide.michelin.com/d', 'Delta', '', '', 'Rome, Italy', '0', '0', 'Italian', 1),
+			('https://guide.michelin.com/c', 'Gamma', '', '', 'Nice, France', '0', '0', 'French', 1),
+			('https://guide.michelin.com/a', 'Alpha', '', '', 'Paris, France', '0', '0', 'French', 1);
+	`)
+
+	list, err := ResolveList(newDb, "Trip")
+	if err != nil {
+		t.Fatalf("ResolveList() returned error: %v", err)
+	}
+	if list.ID != listID || list.ItemCount != 2 {
+		t.Errorf("list = %+v, expected ID %d with 2 entries", list, listID)
+	}
+	if got, expected := listEntries(t, newDb, listID), "Alpha:1,Gamma:2/terrace"; got != expected {
+		t.Errorf("list = %s, expected %s", got, expected)
+	}
+
+	// The renumbered positions leave no gap for the next entry
+	if err := AddToList(newDb, listID, 1, ""); err != nil {
+		t.Fatalf("AddToList() returned error: %v", err)
+	}
+	if got, expected := listEntries(t, newDb, listID), "Alpha:1,Gamma:2/terrace,Delta:3"; got != expected {
+		t.Errorf("list = %s, expected %s", got, expected)
+	}
+}
@@ -0,0 +1,359 @@
+package db
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// datasetPublicKey is the base64 ed25519 key release manifests are signed with. UPDATE_PUBLIC_KEY
+// overrides it; once either is set, unsigned datasets are refused.
+var datasetPublicKey = ""
+
+// UpdateManifest describes a published dataset. It is embedded in the archive as manifest.json or
+// published alongside it; the archive fields (sha256, url, size) are only needed alongside.
+type UpdateManifest struct {
+	Version        string `json:"version"`
+	DatasetDate    string `json:"dataset_date,omitempty"` // YYYY-MM-DD
+	SchemaVersion  int    `json:"schema_version"`         // PRAGMA user_version of the database
+	Restaurants    int    `json:"restaurants,omitempty"`
+	Awards         int    `json:"awards,omitempty"`
+	DatabaseSHA256 string `json:"database_sha256,omitempty"`
+	Signature      string `json:"signature,omitempty"` // base64 ed25519 signature of signedPayload
+
+	SHA256 string    `json:"sha256,omitempty"`
+	URL    string    `json:"url,omitempty"` // archive location, relative to the manifest
+	Size   int64     `json:"size,omitempty"`
+	Delta  *DeltaRef `json:"delta,omitempty"`
+}
+
+// DeltaRef points to a delta that turns an earlier dataset version into this one
+type DeltaRef struct {
+	BaseVersion string `json:"base_version"`
+	URL         string `json:"url"` // relative to the manifest
+	SHA256      string `json:"sha256"`
+}
+
+// UpdateRejectedError indicates that an update was refused because it is older than the installed
+// dataset, needs a newer workflow, or does not match its manifest
+type UpdateRejectedError struct {
+	Reason string
+}
+
+func (e *UpdateRejectedError) Error() string {
+	return "update rejected: " + e.Reason
+}
+
+// IsUpdateRejected checks if an error is an UpdateRejectedError
+func IsUpdateRejected(err error) bool {
+	_, ok := err.(*UpdateRejectedError)
+	return ok
+}
+
+// signedPayload is what a manifest signature covers: the dataset fields, which pin the database
+// contents through its checksum, but not where the archive happens to be published
+func (m UpdateManifest) signedPayload() []byte {
+	payload := fmt.Sprintf("michelin-dataset\nversion=%s\ndataset_date=%s\nschema_version=%d\nrestaurants=%d\nawards=%d\ndatabase_sha256=%s\n",
+		m.Version, m.DatasetDate, m.SchemaVersion, m.Restaurants, m.Awards, strings.ToLower(m.DatabaseSHA256))
+	if m.Delta != nil {
+		payload += fmt.Sprintf("delta_base_version=%s\ndelta_sha256=%s\n", m.Delta.BaseVersion, strings.ToLower(m.Delta.SHA256))
+	}
+	return []byte(payload)
+}
+
+// BuildManifest describes a database for publishing; the database must not change afterwards
+func BuildManifest(dbPath string) (UpdateManifest, error) {
+	var m UpdateManifest
+
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return m, fmt.Errorf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	info, err := GetDatasetInfo(database)
+	if err != nil {
+		return m, err
+	}
+	m.Version = info.Version
+	m.SchemaVersion = info.SchemaVersion
+	m.Restaurants = info.Restaurants
+	m.DatasetDate = time.Now().UTC().Format("2006-01-02")
+	if len(info.BuiltAt) >= 10 {
+		m.DatasetDate = info.BuiltAt[:10]
+	}
+
+	if err := database.QueryRow("SELECT COUNT(*) FROM restaurant_awards").Scan(&m.Awards); err != nil {
+		return m, fmt.Errorf("failed to count awards: %v", err)
+	}
+	database.Close()
+
+	if m.DatabaseSHA256, err = fileSHA256(dbPath); err != nil {
+		return m, fmt.Errorf("failed to checksum database: %v", err)
+	}
+	return m, nil
+}
+
+// SignManifest signs the dataset fields of a manifest
+func SignManifest(m *UpdateManifest, key ed25519.PrivateKey) {
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, m.signedPayload()))
+}
+
+// trustedPublicKey returns the key manifests must be signed with, or nil if none is configured
+func trustedPublicKey() (ed25519.PublicKey, error) {
+	encoded := strings.TrimSpace(os.Getenv("UPDATE_PUBLIC_KEY"))
+	if encoded == "" {
+		encoded = datasetPublicKey
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("UPDATE_PUBLIC_KEY is not a base64 ed25519 public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// verifySignature checks the signature of a manifest against the trusted key and reports whether it
+// was verified. Without a trusted key signatures cannot be checked and are not required.
+func verifySignature(m *UpdateManifest) (bool, error) {
+	key, err := trustedPublicKey()
+	if err != nil {
+		return false, err
+	}
+	if key == nil {
+		return false, nil
+	}
+	if m == nil || m.Signature == "" {
+		return false, &UpdateRejectedError{Reason: "the dataset is not signed"}
+	}
+	if m.DatabaseSHA256 == "" {
+		return false, &UpdateRejectedError{Reason: "the signed manifest has no database checksum"}
+	}
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil || !ed25519.Verify(key, m.signedPayload(), signature) {
+		return false, &UpdateRejectedError{Reason: "the manifest signature is invalid, the dataset may have been tampered with"}
+	}
+	return true, nil
+}
+
+// verifyManifest checks an extracted database against its manifest and the installed dataset
+func verifyManifest(m *UpdateManifest, newDbPath string, installed DatasetInfo) error {
+	if m.SchemaVersion > DatabaseSchemaVersion {
+		return &UpdateRejectedError{Reason: fmt.Sprintf("the dataset needs schema version %d but this workflow reads up to %d, please update the workflow", m.SchemaVersion, DatabaseSchemaVersion)}
+	}
+
+	if m.DatabaseSHA256 != "" {
+		sum, err := fileSHA256(newDbPath)
+		if err != nil {
+			return fmt.Errorf("failed to checksum database: %v", err)
+		}
+		if !strings.EqualFold(sum, m.DatabaseSHA256) {
+			return &UpdateRejectedError{Reason: "the database does not match its manifest checksum, the file is corrupted or was modified"}
+		}
+	}
+
+	if m.Restaurants > 0 || m.Awards > 0 {
+		database, err := sql.Open("sqlite3", newDbPath)
+		if err != nil {
+			return fmt.Errorf("failed to open database: %v", err)
+		}
+		defer database.Close()
+
+		var restaurants, awards int
+		if err := database.QueryRow("SELECT (SELECT COUNT(*) FROM restaurants), (SELECT COUNT(*) FROM restaurant_awards)").Scan(&restaurants, &awards); err != nil {
+			return &UpdateRejectedError{Reason: fmt.Sprintf("the database cannot be read: %v", err)}
+		}
+		if (m.Restaurants > 0 && restaurants != m.Restaurants) || (m.Awards > 0 && awards != m.Awards) {
+			return &UpdateRejectedError{Reason: fmt.Sprintf("the database has %d restaurants and %d awards, the manifest lists %d and %d", restaurants, awards, m.Restaurants, m.Awards)}
+		}
+	}
+
+	return checkDowngrade(*m, installed)
+}
+
+// checkDowngrade refuses a dataset older than the installed one, comparing versions when both are
+// known and dataset dates otherwise
+func checkDowngrade(m UpdateManifest, installed DatasetInfo) error {
+	if m.Version != "" && installed.Version != "" {
+		if compareVersions(m.Version, installed.Version) < 0 {
+			return &UpdateRejectedError{Reason: fmt.Sprintf("dataset %s is older than the installed %s", m.Version, installed.Version)}
+		}
+		return nil
+	}
+	installedDate := installed.DatasetDate
+	if installedDate == "" && len(installed.BuiltAt) >= 10 {
+		installedDate = installed.BuiltAt[:10]
+	}
+	if m.DatasetDate != "" && installedDate != "" && m.DatasetDate < installedDate {
+		return &UpdateRejectedError{Reason: fmt.Sprintf("the dataset from %s is older than the installed one from %s", m.DatasetDate, installedDate)}
+	}
+	return nil
+}
+
+// compareVersions orders dataset versions such as 2025.07 or 2025.7.1 by their numeric parts,
+// comparing any other parts as text
+func compareVersions(a, b string) int {
+	split := func(v string) []string {
+		return strings.FieldsFunc(v, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	}
+	pa, pb := split(a), split(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		if i >= len(pa) {
+			return -1
+		}
+		if i >= len(pb) {
+			return 1
+		}
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case errA == nil && errB == nil && na != nb:
+			if na < nb {
+				return -1
+			}
+			return 1
+		case (errA != nil || errB != nil) && pa[i] != pb[i]:
+			return strings.Compare(pa[i], pb[i])
+		}
+	}
+	return 0
+}
+
+// extractArchive extracts the database of an update archive into dir and returns its path along
+// with the embedded manifest, if any. The archive must hold exactly one database.
+func extractArchive(zipPath, dir string) (string, *UpdateManifest, error) {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return "", nil, &UpdateRejectedError{Reason: fmt.Sprintf("the archive cannot be opened: %v", err)}
+	}
+	defer r.Close()
+
+	var dbFile, manifestFile *zip.File
+	for _, f := range r.File {
+		name := filepath.Base(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(name, ".db"):
+			if dbFile != nil {
+				return "", nil, &UpdateRejectedError{Reason: fmt.Sprintf("the archive holds more than one database (%s, %s)", dbFile.Name, f.Name)}
+			}
+			dbFile = f
+		case name == UpdateManifestName:
+			manifestFile = f
+		}
+	}
+	if dbFile == nil {
+		return "", nil, &UpdateRejectedError{Reason: "no .db file found in the archive"}
+	}
+
+	var manifest *UpdateManifest
+	if manifestFile != nil {
+		manifest = &UpdateManifest{}
+		if err := readZipJSON(manifestFile, manifest); err != nil {
+			return "", nil, &UpdateRejectedError{Reason: fmt.Sprintf("the embedded manifest cannot be read: %v", err)}
+		}
+	}
+
+	rc, err := dbFile.Open()
+	if err != nil {
+		return "", nil, err
+	}
+	defer rc.Close()
+
+	path := filepath.Join(dir, DbFileName)
+	out, err := os.Create(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return "", nil, &UpdateRejectedError{Reason: fmt.Sprintf("the archive is corrupted: %v", err)}
+	}
+	if err := out.Close(); err != nil {
+		return "", nil, err
+	}
+	return path, manifest, nil
+}
+
+// readEmbeddedManifest returns the manifest inside an archive without extracting the database,
+// or nil if it has none
+func readEmbeddedManifest(zipPath string) (*UpdateManifest, error) {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if f.Name == UpdateManifestName {
+			manifest := &UpdateManifest{}
+			if err := readZipJSON(f, manifest); err != nil {
+				return nil, err
+			}
+			return manifest, nil
+		}
+	}
+	return nil, nil
+}
+
+// readZipJSON decodes a JSON entry of an archive
+func readZipJSON(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return json.NewDecoder(io.LimitReader(rc, maxManifestSize)).Decode(v)
+}
+
+// WriteArchive packages a database and its manifest into an update archive
+func WriteArchive(zipPath, dbPath string, m UpdateManifest) error {
+	out, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w := zip.NewWriter(out)
+	entry, err := w.Create(UpdateManifestName)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(m); err != nil {
+		return err
+	}
+
+	entry, err = w.Create(DbFileName)
+	if err != nil {
+		return err
+	}
+	database, err := os.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+	if _, err := io.Copy(entry, database); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
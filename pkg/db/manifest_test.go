@@ -0,0 +1,141 @@
+package db
+
+import (
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		A, B     string
+		Expected int
+	}{
+		{"2025.07", "2025.07", 0},
+		{"2025.07", "2025.10", -1},
+		{"2025.7", "2025.07", 0},
+		{"2025.10", "2025.9", 1},
+		{"2025.07.1", "2025.07", 1},
+		{"2026", "2025.12", 1},
+		{"2025.07-b", "2025.07-a", 1},
+	}
+
+	for _, tt := range cases {
+		if got := compareVersions(tt.A, tt.B); got != tt.Expected {
+			t.Errorf("compareVersions(%q, %q) = %d, expected %d", tt.A, tt.B, got, tt.Expected)
+		}
+	}
+}
+
+// packageTestDataset stamps a test database, builds its manifest and writes an update archive
+func packageTestDataset(t *testing.T, dir, version string, key ed25519.PrivateKey, edit func(*UpdateManifest)) string {
+	t.Helper()
+	dbPath := filepath.Join(dir, "build.db")
+	os.Remove(dbPath)
+	createTestDatabase(t, dbPath, 3, "INSERT INTO restaurant_awards (restaurant_id, year) VALUES (1, 2025);")
+
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := StampDataset(database, version, "test"); err != nil {
+		t.Fatalf("StampDataset() returned error: %v", err)
+	}
+	database.Close()
+
+	manifest, err := BuildManifest(dbPath)
+	if err != nil {
+		t.Fatalf("BuildManifest() returned error: %v", err)
+	}
+	if key != nil {
+		SignManifest(&manifest, key)
+	}
+	if edit != nil {
+		edit(&manifest)
+	}
+
+	zipPath := filepath.Join(dir, version+".zip")
+	if err := WriteArchive(zipPath, dbPath, manifest); err != nil {
+		t.Fatalf("WriteArchive() returned error: %v", err)
+	}
+	return zipPath
+}
+
+func TestUpdateFromZipVerifiesManifest(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+
+	cases := []struct {
+		Name      string
+		PublicKey string
+		Key       ed25519.PrivateKey
+		Edit      func(*UpdateManifest)
+		Error     string
+	}{
+		{"valid", "", nil, nil, ""},
+		{"signed", base64.StdEncoding.EncodeToString(public), private, nil, ""},
+		{"unsigned with a trusted key", base64.StdEncoding.EncodeToString(public), nil, nil, "not signed"},
+		{"signed by another key", base64.StdEncoding.EncodeToString(public), otherKey, nil, "signature is invalid"},
+		{"tampered after signing", base64.StdEncoding.EncodeToString(public), private, func(m *UpdateManifest) { m.Restaurants = 4 }, "signature is invalid"},
+		{"wrong checksum", "", nil, func(m *UpdateManifest) { m.DatabaseSHA256 = strings.Repeat("0", 64) }, "does not match its manifest checksum"},
+		{"wrong counts", "", nil, func(m *UpdateManifest) { m.Restaurants = 4 }, "the manifest lists 4"},
+		{"newer schema", "", nil, func(m *UpdateManifest) { m.SchemaVersion = DatabaseSchemaVersion + 1 }, "please update the workflow"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			t.Setenv("UPDATE_PUBLIC_KEY", tt.PublicKey)
+			dir := t.TempDir()
+			zipPath := packageTestDataset(t, dir, "2025.07", tt.Key, tt.Edit)
+			dbPath := filepath.Join(dir, DbFileName)
+
+			err := UpdateFromZip(dbPath, zipPath)
+			if tt.Error == "" {
+				if err != nil {
+					t.Fatalf("UpdateFromZip() returned error: %v", err)
+				}
+				return
+			}
+			if !IsUpdateRejected(err) || !strings.Contains(err.Error(), tt.Error) {
+				t.Errorf("UpdateFromZip() = %v, expected a rejection containing %q", err, tt.Error)
+			}
+			if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+				t.Errorf("UpdateFromZip() installed a rejected dataset")
+			}
+		})
+	}
+}
+
+func TestUpdateFromZipRefusesDowngrade(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, DbFileName)
+
+	if err := UpdateFromZip(dbPath, packageTestDataset(t, dir, "2025.10", nil, nil)); err != nil {
+		t.Fatalf("UpdateFromZip() returned error: %v", err)
+	}
+
+	err := UpdateFromZip(dbPath, packageTestDataset(t, dir, "2025.07", nil, nil))
+	if !IsUpdateRejected(err) || !strings.Contains(err.Error(), "older than the installed 2025.10") {
+		t.Errorf("UpdateFromZip() = %v, expected a refused downgrade", err)
+	}
+
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+	info, err := GetDatasetInfo(database)
+	if err != nil {
+		t.Fatalf("GetDatasetInfo() returned error: %v", err)
+	}
+	if info.Version != "2025.10" {
+		t.Errorf("installed dataset is %q after a refused downgrade, expected 2025.10", info.Version)
+	}
+}
@@ -0,0 +1,560 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MatchMethod is how a restaurant of the old database was found in the new one
+type MatchMethod string
+
+const (
+	MatchWebsite      MatchMethod = "website"
+	MatchNameLocation MatchMethod = "name_location"
+	MatchPhone        MatchMethod = "phone"
+)
+
+const (
+	// defaultMatchDistanceMeters is how far a restaurant may have moved and still match by name
+	defaultMatchDistanceMeters = 150
+	// autoMatchConfidence is the lowest confidence applied without asking the user
+	autoMatchConfidence = 0.8
+	// minNameSimilarity is the lowest name similarity considered by name and location
+	minNameSimilarity = 0.5
+)
+
+// pendingMatchesTable holds low-confidence or ambiguous matches waiting for the user, one row
+// per candidate, with the user data of the old restaurant as exported by ExportUserData
+const pendingMatchesTable = `
+	CREATE TABLE IF NOT EXISTS user_pending_matches (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		restaurant_name TEXT,
+		restaurant_url TEXT NOT NULL,
+		candidate_id INTEGER NOT NULL,
+		method TEXT NOT NULL,
+		confidence REAL NOT NULL,
+		distance_m REAL,
+		ambiguous INTEGER NOT NULL DEFAULT 0,
+		user_data TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (candidate_id) REFERENCES restaurants(id),
+		UNIQUE (restaurant_url, candidate_id)
+	);
+`
+
+// RestaurantMatch is a candidate for a restaurant whose Michelin URL disappeared in an update
+type RestaurantMatch struct {
+	OldID         int64
+	NewID         int64
+	Name          string
+	URL           string
+	CandidateName string
+	Method        MatchMethod
+	Confidence    float64 // 0 to 1
+	Distance      *float64
+	Ambiguous     bool
+}
+
+// MatchReport is the outcome of the fallback matching of an update
+type MatchReport struct {
+	Matched   []RestaurantMatch // applied to the ID mapping
+	Pending   []RestaurantMatch // waiting for confirmation
+	Unmatched []string          // names of restaurants without any candidate
+}
+
+// matchRecord holds the fields of a restaurant compared by the matching cascade
+type matchRecord struct {
+	ID             int64
+	Name           string
+	URL            string
+	Website        string
+	Phone          string
+	Latitude       float64
+	Longitude      float64
+	HasCoordinates bool
+}
+
+// matchDistanceMeters returns the MATCH_DISTANCE setting in metres
+func matchDistanceMeters() float64 {
+	if value, err := strconv.ParseFloat(os.Getenv("MATCH_DISTANCE"), 64); err == nil && value > 0 {
+		return value
+	}
+	return defaultMatchDistanceMeters
+}
+
+// normalizeWebsite reduces a website URL to host and path, e.g. "example.com/menu"
+func normalizeWebsite(website string) string {
+	w := strings.ToLower(strings.TrimSpace(website))
+	w = strings.TrimPrefix(w, "https://")
+	w = strings.TrimPrefix(w, "http://")
+	w = strings.TrimPrefix(w, "www.")
+	if i := strings.IndexAny(w, "?#"); i >= 0 {
+		w = w[:i]
+	}
+	return strings.TrimRight(w, "/")
+}
+
+// normalizePhone keeps the last nine digits of a phone number, which ignores country prefixes
+func normalizePhone(phone string) string {
+	var digits []rune
+	for _, r := range phone {
+		if unicode.IsDigit(r) {
+			digits = append(digits, r)
+		}
+	}
+	if len(digits) < 6 {
+		return ""
+	}
+	if len(digits) > 9 {
+		digits = digits[len(digits)-9:]
+	}
+	return string(digits)
+}
+
+// nameSimilarity compares two restaurant names ignoring case and accents (0 to 1)
+func nameSimilarity(a, b string) float64 {
+	a, b = normalizeForSearch(strings.TrimSpace(a)), normalizeForSearch(strings.TrimSpace(b))
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+	return trigramSimilarity(a, b)
+}
+
+// matchCandidates runs the fallback cascade for a restaurant that lost its URL: same website,
+// then a similar name within maxMeters, then the same phone number. The first step with any
+// candidate wins; several candidates at that step make the match ambiguous.
+func matchCandidates(orphan matchRecord, candidates []matchRecord, maxMeters float64) []RestaurantMatch {
+	newMatch := func(c matchRecord, method MatchMethod, confidence float64) RestaurantMatch {
+		return RestaurantMatch{
+			OldID: orphan.ID, NewID: c.ID, Name: orphan.Name, URL: orphan.URL, CandidateName: c.Name,
+			Method: method, Confidence: confidence,
+		}
+	}
+
+	var matches []RestaurantMatch
+	if website := normalizeWebsite(orphan.Website); website != "" {
+		for _, c := range candidates {
+			if normalizeWebsite(c.Website) != website {
+				continue
+			}
+			// Groups share websites, so the name has to agree as well
+			confidence := 0.7
+			if nameSimilarity(orphan.Name, c.Name) >= minNameSimilarity {
+				confidence = 0.95
+			}
+			matches = append(matches, newMatch(c, MatchWebsite, confidence))
+		}
+	}
+
+	if len(matches) == 0 && orphan.HasCoordinates {
+		for _, c := range candidates {
+			if !c.HasCoordinates {
+				continue
+			}
+			meters := HaversineKm(orphan.Latitude, orphan.Longitude, c.Latitude, c.Longitude) * 1000
+			similarity := nameSimilarity(orphan.Name, c.Name)
+			if meters > maxMeters || similarity < minNameSimilarity {
+				continue
+			}
+			// 0.95 for the same name at the same place, down to 0.6 at maxMeters
+			match := newMatch(c, MatchNameLocation, 0.6*similarity+0.35*(1-meters/maxMeters))
+			match.Distance = &meters
+			matches = append(matches, match)
+		}
+	}
+
+	if phone := normalizePhone(orphan.Phone); len(matches) == 0 && phone != "" {
+		for _, c := range candidates {
+			if normalizePhone(c.Phone) != phone {
+				continue
+			}
+			confidence := 0.7
+			if nameSimilarity(orphan.Name, c.Name) >= minNameSimilarity {
+				confidence = 0.9
+			}
+			matches = append(matches, newMatch(c, MatchPhone, confidence))
+		}
+	}
+
+	if len(matches) > 1 {
+		for i := range matches {
+			matches[i].Ambiguous = true
+		}
+		sort.SliceStable(matches, func(i, j int) bool { return matches[i].Confidence > matches[j].Confidence })
+	}
+	return matches
+}
+
+// getMatchRecords reads the restaurants compared by the matching cascade; ids restricts the
+// result to those restaurants when not nil
+func getMatchRecords(db *sql.DB, ids map[int64]bool) ([]matchRecord, error) {
+	rows, err := db.Query(`
+		SELECT id, COALESCE(name, ''), COALESCE(url, ''), COALESCE(website_url, ''), COALESCE(phone_number, ''),
+			COALESCE(latitude, ''), COALESCE(longitude, '')
+		FROM restaurants
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []matchRecord
+	for rows.Next() {
+		var r matchRecord
+		var lat, lon string
+		if err := rows.Scan(&r.ID, &r.Name, &r.URL, &r.Website, &r.Phone, &lat, &lon); err != nil {
+			return nil, err
+		}
+		if ids != nil && !ids[r.ID] {
+			continue
+		}
+		latitude, errLat := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+		longitude, errLon := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+		if errLat == nil && errLon == nil {
+			r.Latitude, r.Longitude, r.HasCoordinates = latitude, longitude, true
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// getUserRestaurantIDs returns the restaurants with any favorite, visit, rating, tag or list entry
+func getUserRestaurantIDs(db *sql.DB) (map[int64]bool, error) {
+	ids := make(map[int64]bool)
+	for _, table := range []string{"user_favorites", "user_visits", "user_ratings", "user_tags", "user_list_items"} {
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name=?)", table).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		rows, err := db.Query("SELECT DISTINCT restaurant_id FROM " + table)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			ids[id] = true
+		}
+		rows.Close()
+	}
+	return ids, nil
+}
+
+// matchOrphanedRestaurants looks for the restaurants with user data that createRestaurantMapping
+// could not match by URL. Confident, unambiguous matches are added to mapping; the others are
+// returned as pending for the user to confirm.
+func matchOrphanedRestaurants(oldDb, newDb *sql.DB, mapping map[int64]int64) (MatchReport, error) {
+	var report MatchReport
+
+	userIDs, err := getUserRestaurantIDs(oldDb)
+	if err != nil {
+		return report, fmt.Errorf("failed to get restaurants with user data: %v", err)
+	}
+	orphanIDs := make(map[int64]bool)
+	for id := range userIDs {
+		if _, mapped := mapping[id]; !mapped {
+			orphanIDs[id] = true
+		}
+	}
+	if len(orphanIDs) == 0 {
+		return report, nil
+	}
+
+	orphans, err := getMatchRecords(oldDb, orphanIDs)
+	if err != nil {
+		return report, fmt.Errorf("failed to read orphaned restaurants: %v", err)
+	}
+	all, err := getMatchRecords(newDb, nil)
+	if err != nil {
+		return report, fmt.Errorf("failed to read new restaurants: %v", err)
+	}
+
+	// Restaurants already matched by URL cannot be the new home of another one
+	taken := make(map[int64]bool, len(mapping))
+	for _, newID := range mapping {
+		taken[newID] = true
+	}
+	var candidates []matchRecord
+	for _, r := range all {
+		if !taken[r.ID] {
+			candidates = append(candidates, r)
+		}
+	}
+
+	maxMeters := matchDistanceMeters()
+	for _, orphan := range orphans {
+		matches := matchCandidates(orphan, candidates, maxMeters)
+		switch {
+		case len(matches) == 0:
+			report.Unmatched = append(report.Unmatched, orphan.Name)
+		case len(matches) == 1 && matches[0].Confidence >= autoMatchConfidence && !taken[matches[0].NewID]:
+			mapping[orphan.ID] = matches[0].NewID
+			taken[matches[0].NewID] = true
+			report.Matched = append(report.Matched, matches[0])
+		default:
+			report.Pending = append(report.Pending, matches...)
+		}
+	}
+	return report, nil
+}
+
+// filterUserData keeps the favorites, visits, ratings, tags and list entries of one restaurant
+func filterUserData(data *UserData, url string) *UserData {
+	filtered := &UserData{Version: data.Version, ExportedAt: data.ExportedAt}
+	for _, f := range data.Favorites {
+		if f.URL == url {
+			filtered.Favorites = append(filtered.Favorites, f)
+		}
+	}
+	for _, v := range data.Visits {
+		if v.URL == url {
+			filtered.Visits = append(filtered.Visits, v)
+		}
+	}
+	for _, r := range data.Ratings {
+		if r.URL == url {
+			filtered.Ratings = append(filtered.Ratings, r)
+		}
+	}
+	for _, t := range data.Tags {
+		if t.URL == url {
+			filtered.Tags = append(filtered.Tags, t)
+		}
+	}
+	for _, list := range data.Lists {
+		kept := UserDataList{Name: list.Name, CreatedAt: list.CreatedAt}
+		for _, item := range list.Items {
+			if item.URL == url {
+				kept.Items = append(kept.Items, item)
+			}
+		}
+		if len(kept.Items) > 0 {
+			filtered.Lists = append(filtered.Lists, kept)
+		}
+	}
+	return filtered
+}
+
+// relinkUserData points every entry of the user data at another restaurant URL
+func relinkUserData(data *UserData, url string) {
+	for i := range data.Favorites {
+		data.Favorites[i].URL = url
+	}
+	for i := range data.Visits {
+		data.Visits[i].URL = url
+	}
+	for i := range data.Ratings {
+		data.Ratings[i].URL = url
+	}
+	for i := range data.Tags {
+		data.Tags[i].URL = url
+	}
+	for i := range data.Lists {
+		for j := range data.Lists[i].Items {
+			data.Lists[i].Items[j].URL = url
+		}
+	}
+}
+
+// savePendingMatches stores pending matches in the new database with the user data of their
+// old restaurant, and carries over the pending matches of earlier updates whose candidate survived
+func savePendingMatches(oldDb, newDb *sql.DB, pending []RestaurantMatch, mapping map[int64]int64) (int, error) {
+	previous, err := GetPendingMatches(oldDb)
+	if err != nil {
+		return 0, err
+	}
+	var data *UserData
+	if len(pending) > 0 {
+		if data, err = ExportUserData(oldDb); err != nil {
+			return 0, err
+		}
+	}
+
+	tx, err := newDb.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin saving pending matches: %v", err)
+	}
+	defer tx.Rollback()
+
+	insert := func(m RestaurantMatch, userData *UserData) error {
+		encoded, err := json.Marshal(userData)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT OR IGNORE INTO user_pending_matches
+				(restaurant_name, restaurant_url, candidate_id, method, confidence, distance_m, ambiguous, user_data)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, m.Name, m.URL, m.NewID, string(m.Method), m.Confidence, m.Distance, m.Ambiguous, string(encoded))
+		return err
+	}
+
+	saved := 0
+	for _, m := range pending {
+		if err := insert(m, filterUserData(data, m.URL)); err != nil {
+			return 0, fmt.Errorf("failed to save pending match for %s: %v", m.Name, err)
+		}
+		saved++
+	}
+	for _, p := range previous {
+		newID, ok := mapping[p.CandidateID]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "[UPDATE WARN] Pending match dropped, candidate no longer exists: %s\n", p.Name)
+			continue
+		}
+		p.NewID = newID
+		if err := insert(p.RestaurantMatch, p.UserData); err != nil {
+			return 0, fmt.Errorf("failed to carry over pending match for %s: %v", p.Name, err)
+		}
+		saved++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit pending matches: %v", err)
+	}
+	return saved, nil
+}
+
+// PendingMatch is a stored candidate waiting for the user to confirm or dismiss it
+type PendingMatch struct {
+	RestaurantMatch
+	ID                int64
+	CandidateID       int64
+	CandidateLocation string
+	UserData          *UserData
+}
+
+// GetPendingMatches lists the pending matches, best candidates first
+func GetPendingMatches(db *sql.DB) ([]PendingMatch, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='user_pending_matches')").Scan(&exists)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT pm.id, COALESCE(pm.restaurant_name, ''), pm.restaurant_url, pm.candidate_id,
+			COALESCE(r.name, ''), COALESCE(r.location, ''), pm.method, pm.confidence, pm.distance_m, pm.ambiguous, pm.user_data
+		FROM user_pending_matches pm
+		LEFT JOIN restaurants r ON r.id = pm.candidate_id
+		ORDER BY pm.restaurant_name, pm.confidence DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending matches: %v", err)
+	}
+	defer rows.Close()
+
+	var matches []PendingMatch
+	for rows.Next() {
+		var m PendingMatch
+		var method, userData string
+		err := rows.Scan(&m.ID, &m.Name, &m.URL, &m.CandidateID, &m.CandidateName, &m.CandidateLocation,
+			&method, &m.Confidence, &m.Distance, &m.Ambiguous, &userData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan pending match: %v", err)
+		}
+		m.Method = MatchMethod(method)
+		m.NewID = m.CandidateID
+		m.UserData = &UserData{}
+		if err := json.Unmarshal([]byte(userData), m.UserData); err != nil {
+			return nil, fmt.Errorf("failed to read user data of pending match %d: %v", m.ID, err)
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
+
+// getPendingMatch returns one pending match by ID
+func getPendingMatch(db *sql.DB, id int64) (PendingMatch, error) {
+	matches, err := GetPendingMatches(db)
+	if err != nil {
+		return PendingMatch{}, err
+	}
+	for _, m := range matches {
+		if m.ID == id {
+			return m, nil
+		}
+	}
+	return PendingMatch{}, fmt.Errorf("pending match %d not found", id)
+}
+
+// ConfirmPendingMatch moves the user data of a pending match to its candidate restaurant and
+// drops the other candidates of the same restaurant
+func ConfirmPendingMatch(db *sql.DB, id int64) (PendingMatch, error) {
+	match, err := getPendingMatch(db, id)
+	if err != nil {
+		return match, err
+	}
+
+	var url string
+	if err := db.QueryRow("SELECT url FROM restaurants WHERE id = ?", match.CandidateID).Scan(&url); err != nil {
+		return match, fmt.Errorf("failed to get candidate restaurant %d: %v", match.CandidateID, err)
+	}
+	relinkUserData(match.UserData, url)
+
+	// The user data, the pending match and the events change together, so that a failure leaves
+	// the match to confirm again
+	tx, err := db.Begin()
+	if err != nil {
+		return match, fmt.Errorf("failed to begin confirming match: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := importUserData(tx, match.UserData); err != nil {
+		return match, err
+	}
+	if _, err := tx.Exec("DELETE FROM user_pending_matches WHERE restaurant_url = ?", match.URL); err != nil {
+		return match, fmt.Errorf("failed to remove pending match: %v", err)
+	}
+	// Link the update events of the old restaurant to its new entry
+	_, err = tx.Exec("UPDATE user_update_events SET restaurant_id = ? WHERE restaurant_url = ? AND event_type = ?",
+		match.CandidateID, match.URL, string(EventUnmapped))
+	if err != nil {
+		return match, fmt.Errorf("failed to update events of %s: %v", match.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return match, fmt.Errorf("failed to commit confirmed match: %v", err)
+	}
+	return match, nil
+}
+
+// DismissPendingMatch rejects one candidate; once no candidate is left, the user data of the
+// old restaurant is discarded
+func DismissPendingMatch(db *sql.DB, id int64) (PendingMatch, error) {
+	match, err := getPendingMatch(db, id)
+	if err != nil {
+		return match, err
+	}
+	if _, err := db.Exec("DELETE FROM user_pending_matches WHERE id = ?", id); err != nil {
+		return match, fmt.Errorf("failed to dismiss pending match: %v", err)
+	}
+	return match, nil
+}
+
+// CountPendingMatches returns the number of restaurants with candidates to review
+func CountPendingMatches(db *sql.DB) (int, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='user_pending_matches')").Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+	var count int
+	err = db.QueryRow("SELECT COUNT(DISTINCT restaurant_url) FROM user_pending_matches").Scan(&count)
+	return count, err
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchCandidates(t *testing.T) {
+	orphan := matchRecord{
+		ID: 1, Name: "Trattoria Milanese", Website: "https://www.trattoria.example/",
+		Phone: "+39 02 864 5991", Latitude: 45.4630, Longitude: 9.1830, HasCoordinates: true,
+	}
+
+	cases := []struct {
+		Name       string
+		Candidates []matchRecord
+		Method     MatchMethod
+		Count      int
+		Ambiguous  bool
+		MinScore   float64
+	}{
+		{"no candidates", []matchRecord{{ID: 2, Name: "Seta", Latitude: 45.4690, Longitude: 9.19, HasCoordinates: true}}, "", 0, false, 0},
+		{"same website", []matchRecord{{ID: 2, Name: "Trattoria Milanese", Website: "http://trattoria.example"}}, MatchWebsite, 1, false, 0.9},
+		{"same name nearby", []matchRecord{{ID: 2, Name: "Trattoria Milanése", Latitude: 45.4631, Longitude: 9.1830, HasCoordinates: true}}, MatchNameLocation, 1, false, 0.9},
+		{"same name too far", []matchRecord{{ID: 2, Name: "Trattoria Milanese", Latitude: 45.4730, Longitude: 9.1830, HasCoordinates: true}}, "", 0, false, 0},
+		{"same phone", []matchRecord{{ID: 2, Name: "Da Mario", Phone: "028645991"}}, MatchPhone, 1, false, 0.7},
+		{"shared website", []matchRecord{
+			{ID: 2, Name: "Trattoria Milanese", Website: "trattoria.example"},
+			{ID: 3, Name: "Bar Milanese", Website: "trattoria.example"},
+		}, MatchWebsite, 2, true, 0.7},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			matches := matchCandidates(orphan, tt.Candidates, 150)
+			if len(matches) != tt.Count {
+				t.Fatalf("matchCandidates() returned %d matches, expected %d", len(matches), tt.Count)
+			}
+			for _, m := range matches {
+				if m.Method != tt.Method || m.Ambiguous != tt.Ambiguous || m.Confidence < tt.MinScore {
+					t.Errorf("matchCandidates() = %+v, expected method %s, ambiguous %v, confidence >= %.2f",
+						m, tt.Method, tt.Ambiguous, tt.MinScore)
+				}
+			}
+		})
+	}
+}
+
+func TestPendingMatches(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createDeltaBase(t, dbPath)
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	// Alpha and Beta lose their URLs and are only found by phone, Gamma by its website
+	_, err = database.Exec(`
+		UPDATE restaurants SET phone_number = '+33 1 42 00 00 01' WHERE id = 1;
+		UPDATE restaurants SET phone_number = '+33 4 72 00 00 02' WHERE id = 2;
+		UPDATE restaurants SET website_url = 'https://gamma.example' WHERE id = 3;
+		INSERT INTO user_favorites (restaurant_id) VALUES (1);
+		INSERT INTO user_visits (restaurant_id, visited_date) VALUES (2, '2025-03-14');
+	`)
+	if err != nil {
+		t.Fatalf("failed to add user data: %v", err)
+	}
+	if err := SetRating(database, 1, 5, "Perfect"); err != nil {
+		t.Fatalf("SetRating() returned error: %v", err)
+	}
+	if err := AddTag(database, 1, "client"); err != nil {
+		t.Fatalf("AddTag() returned error: %v", err)
+	}
+
+	newDb := createShippedDatabase(t, filepath.Join(t.TempDir(), "new.db"), `
+		INSERT INTO restaurants (url, name, description, address, location, latitude, longitude, cuisine, phone_number, website_url, in_guide) VALUES
+			('https://guide.michelin.com/d', 'Delta', '', '', 'Rome, Italy', '', '', 'Italian', NULL, NULL, 1),
+			('https://guide.michelin.com/a2', 'Alpha Bistro', '', '', 'Paris, France', '', '', 'French', '01 42 00 00 01', NULL, 1),
+			('https://guide.michelin.com/a3', 'Alpha Bar', '', '', 'Paris, France', '', '', 'French', '01 42 00 00 01', NULL, 1),
+			('https://guide.michelin.com/b2', 'Le Comptoir', '', '', 'Lyon, France', '', '', 'French', '04 72 00 00 02', NULL, 1),
+			('https://guide.michelin.com/c2', 'Gamma', '', '', 'Nice, France', '', '', 'French', NULL, 'http://www.gamma.example/', 1);
+	`)
+	defer newDb.Close()
+	const bistroID, comptoirID, gammaID = 2, 4, 5
+
+	mapping, err := createRestaurantMapping(database, newDb)
+	if err != nil {
+		t.Fatalf("createRestaurantMapping() returned error: %v", err)
+	}
+	report, err := matchOrphanedRestaurants(database, newDb, mapping)
+	if err != nil {
+		t.Fatalf("matchOrphanedRestaurants() returned error: %v", err)
+	}
+	if len(report.Matched) != 1 || report.Matched[0].NewID != gammaID || mapping[3] != gammaID {
+		t.Errorf("matched %+v, expected Gamma by website", report.Matched)
+	}
+	if len(report.Pending) != 3 || len(report.Unmatched) != 0 {
+		t.Errorf("pending %+v, unmatched %v, expected two candidates for Alpha and one for Beta", report.Pending, report.Unmatched)
+	}
+
+	if err := preserveUserDataDuringUpdate(database, newDb); err != nil {
+		t.Fatalf("preserveUserDataDuringUpdate() returned error: %v", err)
+	}
+	if count, err := CountPendingMatches(newDb); err != nil || count != 2 {
+		t.Fatalf("CountPendingMatches() = %d, %v, expected Alpha and Beta", count, err)
+	}
+	matches, err := GetPendingMatches(newDb)
+	if err != nil {
+		t.Fatalf("GetPendingMatches() returned error: %v", err)
+	}
+	byCandidate := make(map[string]PendingMatch)
+	for _, m := range matches {
+		byCandidate[m.CandidateName] = m
+	}
+	if !byCandidate["Alpha Bistro"].Ambiguous || byCandidate["Le Comptoir"].Ambiguous {
+		t.Errorf("pending matches %+v, expected Alpha to be ambiguous and Beta not", matches)
+	}
+	if favorite := byCandidate["Alpha Bistro"].UserData.Favorites; len(favorite) != 1 || favorite[0].URL != "https://guide.michelin.com/a" {
+		t.Errorf("pending user data of Alpha has favorites %+v", favorite)
+	}
+
+	// Confirming moves the user data, drops the other candidate and links the events
+	if _, err := ConfirmPendingMatch(newDb, byCandidate["Alpha Bistro"].ID); err != nil {
+		t.Fatalf("ConfirmPendingMatch() returned error: %v", err)
+	}
+	if rating, found, _ := GetRating(newDb, bistroID); !found || rating.Rating != 5 {
+		t.Errorf("Alpha Bistro rating = %+v, %v, expected 5", rating, found)
+	}
+	if tags, _ := GetTags(newDb, bistroID); !reflect.DeepEqual(tags, []string{"client"}) {
+		t.Errorf("Alpha Bistro tags = %v, expected [client]", tags)
+	}
+	isFavorite := func(id int64) bool {
+		var favorite bool
+		newDb.QueryRow("SELECT EXISTS(SELECT 1 FROM user_favorites WHERE restaurant_id = ?)", id).Scan(&favorite)
+		return favorite
+	}
+	if !isFavorite(bistroID) {
+		t.Errorf("Alpha Bistro is not a favorite after confirming")
+	}
+	if !isFavorite(gammaID) {
+		t.Errorf("Gamma lost its favorite matched by website")
+	}
+	var linked int64
+	err = newDb.QueryRow("SELECT restaurant_id FROM user_update_events WHERE restaurant_url = ? AND event_type = ?",
+		"https://guide.michelin.com/a", string(EventUnmapped)).Scan(&linked)
+	if err != nil || linked != bistroID {
+		t.Errorf("unmapped event of Alpha links to %d, %v, expected %d", linked, err, bistroID)
+	}
+	if count, _ := CountPendingMatches(newDb); count != 1 {
+		t.Errorf("CountPendingMatches() = %d after confirming, expected 1", count)
+	}
+
+	// A failure after the import leaves the pending match and the user data untouched
+	beta := byCandidate["Le Comptoir"]
+	if _, err := newDb.Exec("ALTER TABLE user_update_events RENAME TO user_update_events_hidden"); err != nil {
+		t.Fatalf("failed to hide update events: %v", err)
+	}
+	if _, err := ConfirmPendingMatch(newDb, beta.ID); err == nil {
+		t.Fatalf("ConfirmPendingMatch() succeeded without an update event table")
+	}
+	if _, err := newDb.Exec("ALTER TABLE user_update_events_hidden RENAME TO user_update_events"); err != nil {
+		t.Fatalf("failed to restore update events: %v", err)
+	}
+	var visits int
+	if err := newDb.QueryRow("SELECT COUNT(*) FROM user_visits WHERE restaurant_id = ?", comptoirID).Scan(&visits); err != nil || visits != 0 {
+		t.Errorf("Le Comptoir has %d visits after a failed confirmation, %v", visits, err)
+	}
+	if count, _ := CountPendingMatches(newDb); count != 1 {
+		t.Errorf("CountPendingMatches() = %d after a failed confirmation, expected 1", count)
+	}
+
+	if _, err := DismissPendingMatch(newDb, beta.ID); err != nil {
+		t.Fatalf("DismissPendingMatch() returned error: %v", err)
+	}
+	if count, _ := CountPendingMatches(newDb); count != 0 {
+		t.Errorf("CountPendingMatches() = %d after dismissing, expected 0", count)
+	}
+	if _, err := DismissPendingMatch(newDb, beta.ID); err == nil {
+		t.Errorf("DismissPendingMatch() dismissed a match twice")
+	}
+}
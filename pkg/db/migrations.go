@@ -0,0 +1,195 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// schemaMigrationsTable records the migrations applied to a database
+const schemaMigrationsTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+`
+
+// userTablesSchema creates the tables holding personal data
+const userTablesSchema = `
+	CREATE TABLE IF NOT EXISTS user_favorites (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		restaurant_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (restaurant_id) REFERENCES restaurants(id),
+		UNIQUE (restaurant_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS user_visits (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		restaurant_id INTEGER NOT NULL,
+		visited_date TEXT,
+		notes TEXT,
+		party_size INTEGER,
+		rating INTEGER,
+		spend REAL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (restaurant_id) REFERENCES restaurants(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS user_locations (
+		name TEXT PRIMARY KEY,
+		latitude REAL NOT NULL,
+		longitude REAL NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS user_ratings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		restaurant_id INTEGER NOT NULL,
+		rating INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+		review TEXT,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (restaurant_id) REFERENCES restaurants(id),
+		UNIQUE (restaurant_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS user_tags (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		restaurant_id INTEGER NOT NULL,
+		tag TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (restaurant_id) REFERENCES restaurants(id),
+		UNIQUE (restaurant_id, tag)
+	);
+
+	CREATE TABLE IF NOT EXISTS user_lists (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS user_list_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		list_id INTEGER NOT NULL,
+		restaurant_id INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		notes TEXT,
+		added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (list_id) REFERENCES user_lists(id),
+		FOREIGN KEY (restaurant_id) REFERENCES restaurants(id),
+		UNIQUE (list_id, restaurant_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_user_favorites_restaurant ON user_favorites(restaurant_id);
+	CREATE INDEX IF NOT EXISTS idx_user_visits_restaurant ON user_visits(restaurant_id);
+	CREATE INDEX IF NOT EXISTS idx_user_tags_tag ON user_tags(tag);
+	CREATE INDEX IF NOT EXISTS idx_user_list_items_list ON user_list_items(list_id, position);
+`
+
+// awardIndexesSchema adds the indexes the award lookups of every search rely on
+const awardIndexesSchema = `
+	CREATE INDEX IF NOT EXISTS idx_restaurant_awards_restaurant_id ON restaurant_awards(restaurant_id);
+	CREATE INDEX IF NOT EXISTS idx_restaurant_awards_year ON restaurant_awards(year);
+	CREATE INDEX IF NOT EXISTS idx_restaurant_awards_distinction ON restaurant_awards(distinction);
+	CREATE INDEX IF NOT EXISTS idx_restaurant_awards_composite ON restaurant_awards(restaurant_id, distinction, year);
+`
+
+// migration is a numbered schema change, given either as SQL run in the transaction that
+// records it, or as a function for changes that manage their own transactions
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+	Func    func(db *sql.DB) error
+}
+
+// migrations lists the schema changes in the order they are applied. Append new migrations
+// with the next version number and never change one that has shipped. Versions up to 6
+// predate schema_migrations and also accept databases that already have their changes.
+var migrations = []migration{
+	{Version: 1, Name: "create user tables", SQL: userTablesSchema},
+	{Version: 2, Name: "index restaurant awards", SQL: awardIndexesSchema},
+	{Version: 3, Name: "convert visits into a visit log", Func: MigrateVisitLog},
+	{Version: 4, Name: "create update events", SQL: updateEventsTable},
+	{Version: 5, Name: "create pending matches", SQL: pendingMatchesTable},
+	{Version: 6, Name: "add normalized search columns", Func: migrateNormalizedColumns},
+	{Version: 7, Name: "precompute current awards", SQL: currentAwardTable},
+	{Version: 8, Name: "add price levels", Func: migratePriceLevels},
+	{Version: 9, Name: "parse restaurant facilities", Func: migrateFacilities},
+	{Version: 10, Name: "track restaurants retired by deltas", SQL: retiredRestaurantsTable},
+}
+
+// Migrate applies the migrations a database has not recorded in schema_migrations yet, in order
+func Migrate(db *sql.DB) error {
+	if _, err := db.Exec(schemaMigrationsTable); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("failed to read schema migrations: %v", err)
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read schema migrations: %v", err)
+		}
+		applied[version] = true
+	}
+	rows.Close()
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %v", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs one migration and records it
+func applyMigration(db *sql.DB, m migration) error {
+	if m.Func != nil {
+		if err := m.Func(db); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if m.SQL != "" {
+		if _, err := tx.Exec(m.SQL); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion returns the highest migration applied to a database
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	return int(version.Int64), nil
+}
+
+// hasColumn reports whether a table has a column
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM pragma_table_info(?) WHERE name = ?)", table, column).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "michelin.db")
+	createTestDatabase(t, path, 2, "")
+
+	database, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	// Running twice must neither fail nor apply anything again
+	for i := 0; i < 2; i++ {
+		if err := Migrate(database); err != nil {
+			t.Fatalf("Migrate() run %d returned error: %v", i+1, err)
+		}
+	}
+
+	var applied int
+	if err := database.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatalf("failed to count migrations: %v", err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("schema_migrations has %d rows, expected %d", applied, len(migrations))
+	}
+
+	version, err := SchemaVersion(database)
+	if err != nil {
+		t.Fatalf("SchemaVersion() returned error: %v", err)
+	}
+	if expected := migrations[len(migrations)-1].Version; version != expected {
+		t.Errorf("SchemaVersion() = %d, expected %d", version, expected)
+	}
+
+	for _, table := range []string{"user_favorites", "user_visits", "user_update_events", "user_pending_matches"} {
+		if ok, err := hasColumn(database, table, "id"); err != nil || !ok {
+			t.Errorf("table %s was not created: %v", table, err)
+		}
+	}
+	if ok, _ := hasColumn(database, "restaurants", "name_normalized"); !ok {
+		t.Error("restaurants.name_normalized was not added")
+	}
+}
+
+func TestMigrationVersionsAreOrdered(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %q has version %d, expected %d", m.Name, m.Version, i+1)
+		}
+		if (m.SQL == "") == (m.Func == nil) {
+			t.Errorf("migration %d must have exactly one of SQL or Func", m.Version)
+		}
+	}
+}
+
+func TestDatasetInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "michelin.db")
+	createTestDatabase(t, path, 2, "INSERT INTO restaurant_awards (restaurant_id, year) VALUES (1, 2024), (1, 2025);")
+
+	database, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	info, err := GetDatasetInfo(database)
+	if err != nil {
+		t.Fatalf("GetDatasetInfo() returned error: %v", err)
+	}
+	if info.Version != "" || info.LatestYear != 2025 || info.Restaurants != 2 {
+		t.Errorf("GetDatasetInfo() before stamping = %+v", info)
+	}
+
+	if err := StampDataset(database, "2025.07", "test"); err != nil {
+		t.Fatalf("StampDataset() returned error: %v", err)
+	}
+	info, err = GetDatasetInfo(database)
+	if err != nil {
+		t.Fatalf("GetDatasetInfo() returned error: %v", err)
+	}
+	if info.Version != "2025.07" || info.Source != "test" || info.BuiltAt == "" {
+		t.Errorf("GetDatasetInfo() after stamping = %+v", info)
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// priceLevelsTable maps each price found in restaurant_awards to a level from 1 to 4, so that
+// budgets compare across currencies; applied by migration 8
+const priceLevelsTable = `
+	CREATE TABLE IF NOT EXISTS price_levels (
+		price TEXT PRIMARY KEY,
+		level INTEGER NOT NULL
+	);
+`
+
+// PriceLevel normalises a price to a level from 1 to 4: the number of currency symbols, as in
+// "$$", "€€€" or "¥¥", or the category of the guide's "CAT_P02" codes. Returns 0 when unknown.
+func PriceLevel(price string) int {
+	price = strings.TrimSpace(price)
+	if category, ok := strings.CutPrefix(price, "CAT_P"); ok {
+		level, err := strconv.Atoi(category)
+		if err != nil || level < 1 || level > 4 {
+			return 0
+		}
+		return level
+	}
+
+	// Count the first run of symbols, so that a range such as "€€-€€€" reads as its lower end
+	level := 0
+	for _, c := range price {
+		if unicode.Is(unicode.Sc, c) {
+			level++
+		} else if level > 0 {
+			break
+		}
+	}
+	return min(level, 4)
+}
+
+// migratePriceLevels creates price_levels and fills it from the award history
+func migratePriceLevels(db *sql.DB) error {
+	if _, err := db.Exec(priceLevelsTable); err != nil {
+		return fmt.Errorf("failed to create price levels: %v", err)
+	}
+	return UpdatePriceLevels(db)
+}
+
+// UpdatePriceLevels records the level of every price in restaurant_awards, for a newly
+// imported dataset. Prices without a known level are left out and match no price filter.
+func UpdatePriceLevels(db *sql.DB) error {
+	// Read everything first: updating while the read is still open would lock the database
+	rows, err := db.Query("SELECT DISTINCT price FROM restaurant_awards WHERE price IS NOT NULL")
+	if err != nil {
+		return fmt.Errorf("failed to query prices: %v", err)
+	}
+	var prices []string
+	for rows.Next() {
+		var price string
+		if err := rows.Scan(&price); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan price: %v", err)
+		}
+		prices = append(prices, price)
+	}
+	rows.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin price level update: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, price := range prices {
+		if level := PriceLevel(price); level > 0 {
+			if _, err := tx.Exec("INSERT OR REPLACE INTO price_levels (price, level) VALUES (?, ?)", price, level); err != nil {
+				return fmt.Errorf("failed to record price level of %s: %v", price, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// parsePriceFilter parses the value of a price: search term, a level or currency symbols with an
+// optional comparison, e.g. "<=2", "2" or "€€"
+func parsePriceFilter(value string) (string, int, error) {
+	operator := "="
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			operator = op
+			value = strings.TrimPrefix(value, op)
+			break
+		}
+	}
+
+	level, err := strconv.Atoi(value)
+	if err != nil {
+		level = PriceLevel(value)
+	}
+	if level < 1 || level > 4 {
+		return "", 0, fmt.Errorf("invalid price filter '%s', expected e.g. price:<=2 or price:€€", value)
+	}
+	return operator, level, nil
+}
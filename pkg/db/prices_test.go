@@ -0,0 +1,71 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPriceLevel(t *testing.T) {
+	cases := []struct {
+		Got      string
+		Expected int
+	}{
+		{"$", 1},
+		{"€€€", 3},
+		{" ¥¥ ", 2},
+		{"££££", 4},
+		{"CAT_P02", 2},
+		{"CAT_P09", 0},
+		{"€€-€€€", 2},
+		{"150 - 300 EUR", 0},
+		{"", 0},
+	}
+
+	for _, tt := range cases {
+		if got := PriceLevel(tt.Got); got != tt.Expected {
+			t.Errorf("PriceLevel(%q) = %d, expected %d", tt.Got, got, tt.Expected)
+		}
+	}
+}
+
+func TestSearchPriceLevel(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createDeltaBase(t, dbPath)
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	search := func(query string) string {
+		restaurants, _, err := SearchRestaurants(database, query)
+		if err != nil {
+			t.Fatalf("SearchRestaurants(%q) returned error: %v", query, err)
+		}
+		var names []string
+		for _, r := range restaurants {
+			names = append(names, *r.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	if got := search("price:<=2"); got != "Gamma,Beta" {
+		t.Errorf("price:<=2 = %q, expected Gamma,Beta (favorites first)", got)
+	}
+	if got := search("price:$$$"); got != "Alpha" {
+		t.Errorf("price:$$$ = %q, expected Alpha", got)
+	}
+
+	// A price in another currency gets its level when the dataset is imported
+	if _, err := database.Exec("INSERT INTO restaurant_awards (restaurant_id, year, distinction, price) VALUES (1, 2026, '1 Star', '¥¥')"); err != nil {
+		t.Fatalf("failed to add award: %v", err)
+	}
+	if err := UpdatePriceLevels(database); err != nil {
+		t.Fatalf("UpdatePriceLevels() returned error: %v", err)
+	}
+	if got := search("price:<=2"); got != "Gamma,Alpha,Beta" {
+		t.Errorf("price:<=2 after import = %q, expected Gamma,Alpha,Beta", got)
+	}
+}
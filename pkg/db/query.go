@@ -0,0 +1,506 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// awardShorthands maps the short award tokens accepted in searches to distinctions
+var awardShorthands = map[string]string{
+	"1s": "1 Star",
+	"2s": "2 Stars",
+	"3s": "3 Stars",
+	"bg": "Bib Gourmand",
+	"sr": "Selected Restaurants",
+}
+
+// SearchQuery is a parsed search string.
+//
+// The syntax is a whitespace-separated list of terms that must all match:
+//
+//	sushi                free text in name, location or cuisine
+//	"new york"           quoted phrase
+//	city:milan           field prefix (city, country, name, cuisine, price, year)
+//	tag:client           personal tag, tag:"date night" for tags with spaces
+//	rated:>=4            personal rating, also rated:5, rated:<3
+//	price:<=2            price level from 1 to 4, also price:2, price:€€
+//	has:terrace          facility or service, see FacilityKey
+//	-sushi               negation, also for groups and prefixed terms
+//	sushi OR ramen       alternatives, "|" is accepted as well
+//	(sushi OR ramen) 1s  parentheses group terms
+//	1s 2s 3s bg sr gs    award and green star shorthands
+//	near:45.46,9.19      geographic filter, see GeoFilter
+//	within:5km
+type SearchQuery struct {
+	Near   string
+	Within string
+
+	root queryNode
+}
+
+// queryNode is a node of the parsed query tree
+type queryNode interface{}
+
+type andNode struct{ children []queryNode }
+
+type orNode struct{ children []queryNode }
+
+type notNode struct{ child queryNode }
+
+type termNode struct {
+	field  string
+	value  string
+	quoted bool
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenOpen
+	tokenClose
+	tokenOr
+)
+
+type queryToken struct {
+	kind    tokenKind
+	text    string
+	quoted  bool
+	negated bool
+}
+
+// ParseSearchQuery parses a search string into a SearchQuery
+func ParseSearchQuery(query string) *SearchQuery {
+	p := &queryParser{tokens: tokenizeQuery(query), query: &SearchQuery{}}
+	p.query.root = p.parseAnd()
+	return p.query
+}
+
+// IsEmpty reports whether the query has no conditions at all
+func (q *SearchQuery) IsEmpty() bool {
+	return q.root == nil && q.Near == "" && q.Within == ""
+}
+
+// tokenizeQuery splits a query into words, quoted phrases, parentheses and OR operators
+func tokenizeQuery(query string) []queryToken {
+	var tokens []queryToken
+	runes := []rune(query)
+
+	for i := 0; i < len(runes); {
+		c := runes[i]
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c == ')':
+			tokens = append(tokens, queryToken{kind: tokenClose})
+			i++
+			continue
+		case c == '|':
+			tokens = append(tokens, queryToken{kind: tokenOr})
+			i++
+			continue
+		}
+
+		negated := false
+		if c == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			negated = true
+			i++
+			c = runes[i]
+		}
+
+		if c == '(' {
+			tokens = append(tokens, queryToken{kind: tokenOpen, negated: negated})
+			i++
+			continue
+		}
+
+		// Read a word, allowing a quoted phrase either on its own or after a field prefix
+		var text strings.Builder
+		quoted := false
+		for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' {
+			if runes[i] == '"' {
+				end := i + 1
+				for end < len(runes) && runes[end] != '"' {
+					end++
+				}
+				text.WriteString(string(runes[i+1 : min(end, len(runes))]))
+				quoted = true
+				i = end + 1
+				break
+			}
+			text.WriteRune(runes[i])
+			i++
+		}
+
+		word := text.String()
+		if word == "" && !quoted {
+			continue
+		}
+		if word == "OR" && !quoted && !negated {
+			tokens = append(tokens, queryToken{kind: tokenOr})
+			continue
+		}
+		tokens = append(tokens, queryToken{kind: tokenWord, text: word, quoted: quoted, negated: negated})
+	}
+
+	return tokens
+}
+
+// queryParser is a small recursive-descent parser over the query tokens
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+	query  *SearchQuery
+}
+
+func (p *queryParser) peek() *queryToken {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.pos]
+}
+
+// parseAnd parses terms until the end of the input or a closing parenthesis
+func (p *queryParser) parseAnd() queryNode {
+	var children []queryNode
+	for {
+		tok := p.peek()
+		if tok == nil || tok.kind == tokenClose {
+			break
+		}
+		if tok.kind == tokenOr {
+			// Dangling OR, ignore it
+			p.pos++
+			continue
+		}
+		if node := p.parseOr(); node != nil {
+			children = append(children, node)
+		}
+	}
+
+	switch len(children) {
+	case 0:
+		return nil
+	case 1:
+		return children[0]
+	default:
+		return &andNode{children: children}
+	}
+}
+
+// parseOr parses one or more unary terms joined by OR
+func (p *queryParser) parseOr() queryNode {
+	var children []queryNode
+	if node := p.parseUnary(); node != nil {
+		children = append(children, node)
+	}
+
+	for {
+		tok := p.peek()
+		if tok == nil || tok.kind != tokenOr {
+			break
+		}
+		p.pos++
+		next := p.peek()
+		if next == nil || next.kind == tokenClose || next.kind == tokenOr {
+			continue
+		}
+		if node := p.parseUnary(); node != nil {
+			children = append(children, node)
+		}
+	}
+
+	switch len(children) {
+	case 0:
+		return nil
+	case 1:
+		return children[0]
+	default:
+		return &orNode{children: children}
+	}
+}
+
+// parseUnary parses a single term or a parenthesised group, possibly negated
+func (p *queryParser) parseUnary() queryNode {
+	tok := p.peek()
+	if tok == nil {
+		return nil
+	}
+	p.pos++
+
+	var node queryNode
+	switch tok.kind {
+	case tokenOpen:
+		node = p.parseAnd()
+		if next := p.peek(); next != nil && next.kind == tokenClose {
+			p.pos++
+		}
+	case tokenWord:
+		node = p.newTerm(tok)
+	default:
+		return nil
+	}
+
+	if node != nil && tok.negated {
+		return &notNode{child: node}
+	}
+	return node
+}
+
+// newTerm builds a term node, splitting off a known field prefix.
+// Geographic tokens are stored on the query instead of becoming SQL conditions.
+func (p *queryParser) newTerm(tok *queryToken) queryNode {
+	term := &termNode{value: tok.text, quoted: tok.quoted}
+
+	if idx := strings.Index(tok.text, ":"); idx > 0 {
+		field := strings.ToLower(tok.text[:idx])
+		value := tok.text[idx+1:]
+
+		switch field {
+		case "near":
+			p.query.Near = strings.ToLower(value)
+			return nil
+		case "within":
+			p.query.Within = value
+			return nil
+		case "city", "country", "name", "cuisine", "price", "year", "tag", "rated", "has":
+			term.field = field
+			term.value = value
+		}
+	}
+
+	if term.value == "" {
+		return nil
+	}
+	return term
+}
+
+// sqlCondition compiles the query into a parameterized SQL condition.
+// When fullText is set, free-text terms are matched through the FTS5 index.
+// Returns an empty string when the query has no conditions.
+func (q *SearchQuery) sqlCondition(fullText bool) (string, []interface{}, error) {
+	if q.root == nil {
+		return "", nil, nil
+	}
+	c := &queryCompiler{fullText: fullText}
+	return c.compileNode(q.root)
+}
+
+// queryCompiler turns a query tree into SQL
+type queryCompiler struct {
+	fullText bool
+}
+
+func (c *queryCompiler) compileNode(node queryNode) (string, []interface{}, error) {
+	switch n := node.(type) {
+	case *andNode:
+		return c.compileNodes(n.children, " AND ")
+	case *orNode:
+		return c.compileNodes(n.children, " OR ")
+	case *notNode:
+		condition, args, err := c.compileNode(n.child)
+		if err != nil {
+			return "", nil, err
+		}
+		// COALESCE keeps rows whose compared columns are NULL (e.g. no award)
+		return "NOT COALESCE(" + condition + ", 0)", args, nil
+	case *termNode:
+		return c.compileTerm(n)
+	}
+	return "", nil, fmt.Errorf("unexpected query node %T", node)
+}
+
+func (c *queryCompiler) compileNodes(children []queryNode, operator string) (string, []interface{}, error) {
+	conditions := make([]string, 0, len(children))
+	var args []interface{}
+	for _, child := range children {
+		condition, childArgs, err := c.compileNode(child)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, condition)
+		args = append(args, childArgs...)
+	}
+	return "(" + strings.Join(conditions, operator) + ")", args, nil
+}
+
+// compileTerm compiles a single term into a SQL condition over restaurants r, current award ra and
+// its price level pl
+func (c *queryCompiler) compileTerm(t *termNode) (string, []interface{}, error) {
+	lower := strings.ToLower(t.value)
+	pattern := "%" + lower + "%"
+	normalizedPattern := "%" + normalizeForSearch(t.value) + "%"
+
+	switch t.field {
+	case "name":
+		return "(LOWER(r.name) LIKE ? OR r.name_normalized LIKE ? OR r.name_transliterated LIKE ?)",
+			[]interface{}{pattern, normalizedPattern, normalizedPattern}, nil
+
+	case "cuisine":
+		return "(LOWER(r.cuisine) LIKE ? OR r.cuisine_normalized LIKE ? OR r.cuisine_transliterated LIKE ?)",
+			[]interface{}{pattern, normalizedPattern, normalizedPattern}, nil
+
+	case "city":
+		// The city is the part of the location before the first comma
+		return eitherSpelling("SUBSTR({location}, 1, INSTR({location} || ',', ',') - 1) LIKE ?", normalizedPattern)
+
+	case "country":
+		// The country is the part after a comma, or the whole location for city-states
+		normalized := normalizeForSearch(t.value)
+		return eitherSpelling("{location} LIKE ? OR (INSTR({location}, ',') = 0 AND {location} LIKE ?)",
+			"%, "+normalized+"%", normalized+"%")
+
+	case "price":
+		operator, level, err := parsePriceFilter(t.value)
+		if err != nil {
+			return "", nil, err
+		}
+		return "(pl.level " + operator + " ?)", []interface{}{level}, nil
+
+	case "year":
+		year, err := strconv.Atoi(t.value)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid year '%s'", t.value)
+		}
+		return "EXISTS (SELECT 1 FROM restaurant_awards ya WHERE ya.restaurant_id = r.id AND ya.year = ?)",
+			[]interface{}{year}, nil
+
+	case "tag":
+		return "EXISTS (SELECT 1 FROM user_tags ut WHERE ut.restaurant_id = r.id AND ut.tag LIKE ?)",
+			[]interface{}{"%" + normalizeTag(t.value) + "%"}, nil
+
+	case "has":
+		return "EXISTS (SELECT 1 FROM restaurant_facilities rf WHERE rf.restaurant_id = r.id AND rf.facility = ?)",
+			[]interface{}{FacilityKey(t.value)}, nil
+
+	case "rated":
+		operator, rating, err := parseRatingFilter(t.value)
+		if err != nil {
+			return "", nil, err
+		}
+		return "EXISTS (SELECT 1 FROM user_ratings ur WHERE ur.restaurant_id = r.id AND ur.rating " + operator + " ?)",
+			[]interface{}{rating}, nil
+	}
+
+	// Free text: award shorthands first
+	if !t.quoted {
+		if distinction, ok := awardShorthands[lower]; ok {
+			return "(ra.distinction = ?)", []interface{}{distinction}, nil
+		}
+		if lower == "gs" {
+			return "(ra.green_star = 1)", nil, nil
+		}
+	}
+
+	if c.fullText && isFullTextTerm(t) {
+		if phrase, ok := fullTextPhrase(t.value); ok {
+			return "r.id IN (SELECT rowid FROM " + fullTextTable + " WHERE " + fullTextTable + " MATCH ?)",
+				[]interface{}{phrase}, nil
+		}
+	}
+
+	if isCaseSensitiveSearch(t.value) {
+		// Case-sensitive search for terms like "USA", "Italy", "New York"
+		searchTerm := "*" + t.value + "*"
+		return "(r.name GLOB ? OR r.location GLOB ? OR r.cuisine GLOB ?)",
+			[]interface{}{searchTerm, searchTerm, searchTerm}, nil
+	}
+
+	// Case-insensitive search, also against accent-free normalized columns
+	return `(LOWER(r.name) LIKE ? OR LOWER(r.location) LIKE ? OR LOWER(r.cuisine) LIKE ? OR
+		r.name_normalized LIKE ? OR r.location_normalized LIKE ? OR r.cuisine_normalized LIKE ? OR
+		r.name_transliterated LIKE ? OR r.location_transliterated LIKE ? OR r.cuisine_transliterated LIKE ?)`,
+		[]interface{}{pattern, pattern, pattern, normalizedPattern, normalizedPattern, normalizedPattern,
+			normalizedPattern, normalizedPattern, normalizedPattern}, nil
+}
+
+// eitherSpelling matches a condition over {location} against the accent-free and the
+// transliterated location, so that city:zurich and city:zuerich both find Zürich
+func eitherSpelling(condition string, args ...interface{}) (string, []interface{}, error) {
+	normalized := strings.ReplaceAll(condition, "{location}", "r.location_normalized")
+	transliterated := strings.ReplaceAll(condition, "{location}", "r.location_transliterated")
+	return "((" + normalized + ") OR (" + transliterated + "))", append(args, args...), nil
+}
+
+// searchFilter is the compiled form of a search string shared by all search functions
+type searchFilter struct {
+	whereClause string
+	args        []interface{}
+	geo         *GeoFilter
+	isEmpty     bool
+
+	// Optional relevance join over the FTS5 index, placed before the WHERE clause
+	rankJoin string
+	rankArgs []interface{}
+}
+
+// buildSearchFilter parses a search string and compiles it into a WHERE clause,
+// including the INCLUDE_FORMER setting and the geographic bounding box
+func buildSearchFilter(db *sql.DB, query string) (*searchFilter, error) {
+	parsed := ParseSearchQuery(query)
+	fullText := hasFullTextIndex(db)
+
+	condition, args, err := parsed.sqlCondition(fullText)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &searchFilter{whereClause: "WHERE 1=1", args: args, isEmpty: parsed.IsEmpty()}
+	if condition != "" {
+		filter.whereClause += " AND " + condition
+	}
+
+	// Rank by bm25 relevance over the positive free-text terms
+	if fullText {
+		if rankQuery := parsed.fullTextRankQuery(); rankQuery != "" {
+			filter.rankJoin = "LEFT JOIN (SELECT rowid, " + fullTextRankExpr + " AS rank FROM " + fullTextTable +
+				" WHERE " + fullTextTable + " MATCH ?) fts ON fts.rowid = r.id"
+			filter.rankArgs = []interface{}{rankQuery}
+		}
+	}
+
+	// Add filter for restaurants not in guide based on INCLUDE_FORMER setting
+	if os.Getenv("INCLUDE_FORMER") != "1" {
+		filter.whereClause += " AND r.in_guide = 1"
+	}
+
+	// Resolve near:/within: into a distance filter (coordinates or a saved location)
+	filter.geo, err = resolveGeoFilter(db, parsed.Near, parsed.Within)
+	if err != nil {
+		return nil, err
+	}
+	if filter.geo != nil {
+		boxClause, boxArgs := filter.geo.boundingBoxClause()
+		filter.whereClause += boxClause
+		filter.args = append(filter.args, boxArgs...)
+	}
+
+	return filter, nil
+}
+
+// queryArgs returns the arguments for a query using both rankJoin and whereClause
+func (f *searchFilter) queryArgs() []interface{} {
+	return append(append([]interface{}{}, f.rankArgs...), f.args...)
+}
+
+// rankOrder returns the ORDER BY term for relevance, or an empty string without ranking
+func (f *searchFilter) rankOrder() string {
+	if f.rankJoin == "" {
+		return ""
+	}
+	return "COALESCE(fts.rank, 0),"
+}
+
+// limitClause limits empty searches to the first 100 rows
+func (f *searchFilter) limitClause() string {
+	if f.isEmpty {
+		return " LIMIT 100"
+	}
+	return ""
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenizeQuery(t *testing.T) {
+	cases := []struct {
+		Got      string
+		Expected []queryToken
+	}{
+		{"sushi tokyo", []queryToken{
+			{kind: tokenWord, text: "sushi"},
+			{kind: tokenWord, text: "tokyo"},
+		}},
+		{`"new york" -sushi`, []queryToken{
+			{kind: tokenWord, text: "new york", quoted: true},
+			{kind: tokenWord, text: "sushi", negated: true},
+		}},
+		{`city:"san francisco"`, []queryToken{
+			{kind: tokenWord, text: "city:san francisco", quoted: true},
+		}},
+		{"-(sushi OR ramen) | bg", []queryToken{
+			{kind: tokenOpen, negated: true},
+			{kind: tokenWord, text: "sushi"},
+			{kind: tokenOr},
+			{kind: tokenWord, text: "ramen"},
+			{kind: tokenClose},
+			{kind: tokenOr},
+			{kind: tokenWord, text: "bg"},
+		}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Got, func(t *testing.T) {
+			got := tokenizeQuery(tt.Got)
+			if !reflect.DeepEqual(got, tt.Expected) {
+				t.Errorf("tokenizeQuery(%q) = %+v, expected %+v", tt.Got, got, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	cases := []struct {
+		Got       string
+		Condition string
+		Args      []interface{}
+		Near      string
+		Within    string
+	}{
+		{"", "", nil, "", ""},
+		{"3s gs", "((ra.distinction = ?) AND (ra.green_star = 1))", []interface{}{"3 Stars"}, "", ""},
+		{"city:milan -bg", "(((SUBSTR(r.location_normalized, 1, INSTR(r.location_normalized || ',', ',') - 1) LIKE ?) OR (SUBSTR(r.location_transliterated, 1, INSTR(r.location_transliterated || ',', ',') - 1) LIKE ?)) AND NOT COALESCE((ra.distinction = ?), 0))",
+			[]interface{}{"%milan%", "%milan%", "Bib Gourmand"}, "", ""},
+		{"1s OR 2s", "((ra.distinction = ?) OR (ra.distinction = ?))", []interface{}{"1 Star", "2 Stars"}, "", ""},
+		{"year:2019 price:$$", "(EXISTS (SELECT 1 FROM restaurant_awards ya WHERE ya.restaurant_id = r.id AND ya.year = ?) AND (pl.level = ?))",
+			[]interface{}{2019, 2}, "", ""},
+		{"price:<=2 -price:€", "((pl.level <= ?) AND NOT COALESCE((pl.level = ?), 0))", []interface{}{2, 1}, "", ""},
+		{"near:Hotel within:800m 1s", "(ra.distinction = ?)", []interface{}{"1 Star"}, "hotel", "800m"},
+		{"USA", "(r.name GLOB ? OR r.location GLOB ? OR r.cuisine GLOB ?)", []interface{}{"*USA*", "*USA*", "*USA*"}, "", ""},
+		{`tag:"Date Night" rated:>=4`, "(EXISTS (SELECT 1 FROM user_tags ut WHERE ut.restaurant_id = r.id AND ut.tag LIKE ?) AND EXISTS (SELECT 1 FROM user_ratings ur WHERE ur.restaurant_id = r.id AND ur.rating >= ?))",
+			[]interface{}{"%date night%", 4}, "", ""},
+		{"-rated:5", "NOT COALESCE(EXISTS (SELECT 1 FROM user_ratings ur WHERE ur.restaurant_id = r.id AND ur.rating = ?), 0)", []interface{}{5}, "", ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Got, func(t *testing.T) {
+			q := ParseSearchQuery(tt.Got)
+			condition, args, err := q.sqlCondition(false)
+			if err != nil {
+				t.Fatalf("sqlCondition() error = %v", err)
+			}
+			if condition != tt.Condition {
+				t.Errorf("condition = %q, expected %q", condition, tt.Condition)
+			}
+			if !reflect.DeepEqual(args, tt.Args) {
+				t.Errorf("args = %v, expected %v", args, tt.Args)
+			}
+			if q.Near != tt.Near || q.Within != tt.Within {
+				t.Errorf("near/within = %q/%q, expected %q/%q", q.Near, q.Within, tt.Near, tt.Within)
+			}
+		})
+	}
+}
+
+func TestParseSearchQueryFreeText(t *testing.T) {
+	q := ParseSearchQuery(`"truffle pasta" -sushi`)
+	condition, args, err := q.sqlCondition(false)
+	if err != nil {
+		t.Fatalf("sqlCondition() error = %v", err)
+	}
+	if !strings.Contains(condition, "NOT COALESCE(") {
+		t.Errorf("expected negated condition, got %q", condition)
+	}
+	if len(args) != 18 || args[0] != "%truffle pasta%" || args[9] != "%sushi%" {
+		t.Errorf("unexpected args %v", args)
+	}
+}
+
+func TestParseSearchQueryInvalidYear(t *testing.T) {
+	q := ParseSearchQuery("year:latest")
+	if _, _, err := q.sqlCondition(false); err == nil {
+		t.Error("expected an error for an invalid year")
+	}
+}
+
+func TestParseSearchQueryInvalidRating(t *testing.T) {
+	for _, query := range []string{"rated:>=6", "rated:good", "rated:>4.5"} {
+		q := ParseSearchQuery(query)
+		if _, _, err := q.sqlCondition(false); err == nil {
+			t.Errorf("expected an error for %q", query)
+		}
+	}
+}
+
+func TestParseSearchQueryInvalidPrice(t *testing.T) {
+	for _, query := range []string{"price:<=5", "price:cheap", "price:0"} {
+		q := ParseSearchQuery(query)
+		if _, _, err := q.sqlCondition(false); err == nil {
+			t.Errorf("expected an error for %q", query)
+		}
+	}
+}
+
+func TestParseSearchQueryFullText(t *testing.T) {
+	q := ParseSearchQuery(`truffle "pasta fresca" -sushi 1s`)
+	condition, args, err := q.sqlCondition(true)
+	if err != nil {
+		t.Fatalf("sqlCondition() error = %v", err)
+	}
+	if strings.Count(condition, "MATCH ?") != 3 {
+		t.Errorf("expected three full-text conditions, got %q", condition)
+	}
+	expectedArgs := []interface{}{`"truffle"*`, `"pasta fresca"*`, `"sushi"*`, "1 Star"}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("args = %v, expected %v", args, expectedArgs)
+	}
+	if rank := q.fullTextRankQuery(); rank != `"truffle"* OR "pasta fresca"*` {
+		t.Errorf("fullTextRankQuery() = %q", rank)
+	}
+}
+
+func TestSearchSpellings(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createDeltaBase(t, dbPath)
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	_, err = database.Exec(`
+		INSERT INTO restaurants (url, name, description, address, location, latitude, longitude, cuisine, in_guide) VALUES
+			('https://guide.michelin.com/z', 'Zunfthaus', '', '', 'Zürich, Switzerland', '0', '0', 'Swiss', 1);
+		INSERT INTO restaurant_awards (restaurant_id, year, distinction, price) VALUES
+			(4, 2024, 'Bib Gourmand', '€€'), (4, 2025, '1 Star', '€€€');
+	`)
+	if err != nil {
+		t.Fatalf("failed to add restaurant: %v", err)
+	}
+	if err := populateNormalizedColumns(database); err != nil {
+		t.Fatalf("populateNormalizedColumns() returned error: %v", err)
+	}
+	if err := RebuildCurrentAwards(database); err != nil {
+		t.Fatalf("RebuildCurrentAwards() returned error: %v", err)
+	}
+	if hasFullTextIndex(database) {
+		if err := RebuildFullTextIndex(database); err != nil {
+			t.Fatalf("RebuildFullTextIndex() returned error: %v", err)
+		}
+	}
+
+	for _, query := range []string{"zurich", "zürich", "zuerich", "city:zurich", "city:zuerich", "city:zürich", "country:switzerland"} {
+		t.Run(query, func(t *testing.T) {
+			restaurants, _, err := SearchRestaurants(database, query)
+			if err != nil {
+				t.Fatalf("SearchRestaurants(%q) returned error: %v", query, err)
+			}
+			if len(restaurants) != 1 || *restaurants[0].Name != "Zunfthaus" {
+				t.Errorf("SearchRestaurants(%q) found %d restaurants, expected Zunfthaus", query, len(restaurants))
+			}
+		})
+	}
+
+	for _, place := range []string{"zurich", "zuerich"} {
+		report, err := GetAwardChanges(database, 2025, place)
+		if err != nil {
+			t.Fatalf("GetAwardChanges(%q) returned error: %v", place, err)
+		}
+		if len(report.Changes) != 1 || *report.Changes[0].Restaurant.Name != "Zunfthaus" {
+			t.Errorf("GetAwardChanges(%q) found %d changes, expected Zunfthaus", place, len(report.Changes))
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UserRating represents a user's personal rating and review of a restaurant
+type UserRating struct {
+	RestaurantID int64
+	Rating       int
+	Review       *string
+	UpdatedAt    string
+}
+
+// UserTag represents a personal tag attached to a restaurant
+type UserTag struct {
+	RestaurantID int64
+	Tag          string
+	CreatedAt    string
+}
+
+// TagCount is a tag with the number of restaurants carrying it
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// SetRating stores or replaces the personal rating (1 to 5) and review of a restaurant
+func SetRating(db *sql.DB, restaurantID int64, rating int, review string) error {
+	if rating < 1 || rating > 5 {
+		return fmt.Errorf("invalid rating %d, expected 1 to 5", rating)
+	}
+
+	var reviewParam interface{}
+	if review != "" {
+		reviewParam = review
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO user_ratings (restaurant_id, rating, review, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(restaurant_id) DO UPDATE SET
+			rating = excluded.rating,
+			review = excluded.review,
+			updated_at = CURRENT_TIMESTAMP
+	`, restaurantID, rating, reviewParam)
+	if err != nil {
+		return fmt.Errorf("failed to set rating: %v", err)
+	}
+	return nil
+}
+
+// ClearRating removes the personal rating of a restaurant
+func ClearRating(db *sql.DB, restaurantID int64) error {
+	_, err := db.Exec("DELETE FROM user_ratings WHERE restaurant_id = ?", restaurantID)
+	if err != nil {
+		return fmt.Errorf("failed to clear rating: %v", err)
+	}
+	return nil
+}
+
+// GetRating retrieves the personal rating of a restaurant
+func GetRating(db *sql.DB, restaurantID int64) (UserRating, bool, error) {
+	var rating UserRating
+	err := db.QueryRow(
+		"SELECT restaurant_id, rating, review, updated_at FROM user_ratings WHERE restaurant_id = ?",
+		restaurantID,
+	).Scan(&rating.RestaurantID, &rating.Rating, &rating.Review, &rating.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return UserRating{}, false, nil
+	}
+	if err != nil {
+		return UserRating{}, false, fmt.Errorf("failed to get rating: %v", err)
+	}
+	return rating, true, nil
+}
+
+// normalizeTag trims a tag and collapses inner whitespace; tags are stored lowercase
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(tag), " "))
+}
+
+// AddTag attaches a tag to a restaurant; adding an existing tag is a no-op
+func AddTag(db *sql.DB, restaurantID int64, tag string) error {
+	tag = normalizeTag(tag)
+	if tag == "" {
+		return fmt.Errorf("empty tag")
+	}
+
+	_, err := db.Exec("INSERT OR IGNORE INTO user_tags (restaurant_id, tag) VALUES (?, ?)", restaurantID, tag)
+	if err != nil {
+		return fmt.Errorf("failed to add tag: %v", err)
+	}
+	return nil
+}
+
+// RemoveTag detaches a tag from a restaurant
+func RemoveTag(db *sql.DB, restaurantID int64, tag string) error {
+	result, err := db.Exec("DELETE FROM user_tags WHERE restaurant_id = ? AND tag = ?", restaurantID, normalizeTag(tag))
+	if err != nil {
+		return fmt.Errorf("failed to remove tag: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to remove tag: %v", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("restaurant is not tagged '%s'", normalizeTag(tag))
+	}
+	return nil
+}
+
+// GetTags retrieves the tags of a restaurant in alphabetical order
+func GetTags(db *sql.DB, restaurantID int64) ([]string, error) {
+	rows, err := db.Query("SELECT tag FROM user_tags WHERE restaurant_id = ? ORDER BY tag", restaurantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags: %v", err)
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %v", err)
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, nil
+}
+
+// GetAllTags retrieves every tag in use with the number of tagged restaurants, most used first
+func GetAllTags(db *sql.DB) ([]TagCount, error) {
+	rows, err := db.Query("SELECT tag, COUNT(*) FROM user_tags GROUP BY tag ORDER BY COUNT(*) DESC, tag")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags: %v", err)
+	}
+	defer rows.Close()
+
+	var tags []TagCount
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Tag, &tag.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %v", err)
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, nil
+}
+
+// parseRatingFilter parses the value of a rated: token such as ">=4", "<3" or "5"
+func parseRatingFilter(value string) (string, int, error) {
+	operator := "="
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			operator = op
+			value = strings.TrimPrefix(value, op)
+			break
+		}
+	}
+
+	rating, err := strconv.Atoi(value)
+	if err != nil || rating < 1 || rating > 5 {
+		return "", 0, fmt.Errorf("invalid rating filter '%s', expected e.g. rated:>=4", value)
+	}
+	return operator, rating, nil
+}
+
+// getUserRatings retrieves all ratings for migration, tolerating databases without the table
+func getUserRatings(db *sql.DB) ([]UserRating, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='user_ratings')").Scan(&exists)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT restaurant_id, rating, review, updated_at FROM user_ratings")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ratings []UserRating
+	for rows.Next() {
+		var rating UserRating
+		if err := rows.Scan(&rating.RestaurantID, &rating.Rating, &rating.Review, &rating.UpdatedAt); err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, rating)
+	}
+
+	return ratings, nil
+}
+
+// getUserTags retrieves all tags for migration, tolerating databases without the table
+func getUserTags(db *sql.DB) ([]UserTag, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name='user_tags')").Scan(&exists)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT restaurant_id, tag, created_at FROM user_tags")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []UserTag
+	for rows.Next() {
+		var tag UserTag
+		if err := rows.Scan(&tag.RestaurantID, &tag.Tag, &tag.CreatedAt); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// searchNames runs a search and returns the names of the restaurants found in alphabetical order
+func searchNames(t *testing.T, database *sql.DB, query string) string {
+	t.Helper()
+	restaurants, _, err := SearchRestaurants(database, query)
+	if err != nil {
+		t.Fatalf("SearchRestaurants(%q) returned error: %v", query, err)
+	}
+
+	var names []string
+	for _, r := range restaurants {
+		names = append(names, *r.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// createRatedBase opens the delta base with Alpha rated 5, Beta rated 3 and Alpha and Gamma tagged
+func createRatedBase(t *testing.T) *sql.DB {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createDeltaBase(t, dbPath)
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if err := SetRating(database, 1, 5, "Perfect tasting menu"); err != nil {
+		t.Fatalf("SetRating() returned error: %v", err)
+	}
+	if err := SetRating(database, 2, 3, ""); err != nil {
+		t.Fatalf("SetRating() returned error: %v", err)
+	}
+	for _, tag := range []struct {
+		RestaurantID int64
+		Tag          string
+	}{
+		{1, "Date  Night"}, {1, "client"}, {3, "date night"}, {3, "terrace"},
+	} {
+		if err := AddTag(database, tag.RestaurantID, tag.Tag); err != nil {
+			t.Fatalf("AddTag(%d, %q) returned error: %v", tag.RestaurantID, tag.Tag, err)
+		}
+	}
+	return database
+}
+
+func TestRatings(t *testing.T) {
+	database := createRatedBase(t)
+
+	rating, found, err := GetRating(database, 1)
+	if err != nil || !found || rating.Rating != 5 || rating.Review == nil || *rating.Review != "Perfect tasting menu" {
+		t.Fatalf("GetRating(1) = %+v, %v, %v, expected 5 with a review", rating, found, err)
+	}
+
+	// Setting a rating again replaces it, including the review
+	if err := SetRating(database, 1, 4, ""); err != nil {
+		t.Fatalf("SetRating() returned error: %v", err)
+	}
+	rating, _, _ = GetRating(database, 1)
+	if rating.Rating != 4 || rating.Review != nil {
+		t.Errorf("GetRating(1) = %+v, expected 4 without a review", rating)
+	}
+
+	for _, invalid := range []int{0, 6} {
+		if err := SetRating(database, 1, invalid, ""); err == nil {
+			t.Errorf("SetRating() accepted rating %d", invalid)
+		}
+	}
+
+	if err := ClearRating(database, 1); err != nil {
+		t.Fatalf("ClearRating() returned error: %v", err)
+	}
+	if _, found, err := GetRating(database, 1); err != nil || found {
+		t.Errorf("GetRating(1) found a cleared rating: %v", err)
+	}
+}
+
+func TestTags(t *testing.T) {
+	database := createRatedBase(t)
+
+	// Tags are normalized, so adding one again in another spelling changes nothing
+	if err := AddTag(database, 1, " DATE night "); err != nil {
+		t.Fatalf("AddTag() returned error: %v", err)
+	}
+	if err := AddTag(database, 1, "  "); err == nil {
+		t.Errorf("AddTag() accepted an empty tag")
+	}
+	tags, err := GetTags(database, 1)
+	if err != nil {
+		t.Fatalf("GetTags() returned error: %v", err)
+	}
+	if expected := []string{"client", "date night"}; !reflect.DeepEqual(tags, expected) {
+		t.Errorf("GetTags(1) = %v, expected %v", tags, expected)
+	}
+
+	all, err := GetAllTags(database)
+	if err != nil {
+		t.Fatalf("GetAllTags() returned error: %v", err)
+	}
+	if expected := []TagCount{{"date night", 2}, {"client", 1}, {"terrace", 1}}; !reflect.DeepEqual(all, expected) {
+		t.Errorf("GetAllTags() = %v, expected %v", all, expected)
+	}
+
+	if err := RemoveTag(database, 1, "Client"); err != nil {
+		t.Fatalf("RemoveTag() returned error: %v", err)
+	}
+	if err := RemoveTag(database, 1, "client"); err == nil {
+		t.Errorf("RemoveTag() removed a tag twice")
+	}
+	if tags, _ := GetTags(database, 1); !reflect.DeepEqual(tags, []string{"date night"}) {
+		t.Errorf("GetTags(1) = %v after removal, expected [date night]", tags)
+	}
+}
+
+func TestSearchRatingsAndTags(t *testing.T) {
+	database := createRatedBase(t)
+
+	cases := []struct {
+		Query    string
+		Expected string
+	}{
+		{"tag:client", "Alpha"},
+		{`tag:"date night"`, "Alpha,Gamma"},
+		{"tag:DATE", "Alpha,Gamma"},
+		{"tag:terrace nice", "Gamma"},
+		{"tag:unknown", ""},
+		{"rated:5", "Alpha"},
+		{"rated:>=3", "Alpha,Beta"},
+		{"rated:<5", "Beta"},
+		{"rated:<3", ""},
+		{"rated:>=3 tag:client", "Alpha"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Query, func(t *testing.T) {
+			if got := searchNames(t, database, tt.Query); got != tt.Expected {
+				t.Errorf("SearchRestaurants(%q) = %q, expected %q", tt.Query, got, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestUpdateKeepsRatingsAndTags(t *testing.T) {
+	database := createRatedBase(t)
+
+	// Beta leaves the dataset and the remaining restaurants get new IDs
+	newDb := updateDeltaBase(t, database, `
+		INSERT INTO restaurants (url, name, description, address, location, latitude, longitude, cuisine, in_guide) VALUES
+			('https://guide.michelin.com/d', 'Delta', '', '', 'Rome, Italy', '0', '0', 'Italian', 1),
+			('https://guide.michelin.com/c', 'Gamma', '', '', 'Nice, France', '0', '0', 'French', 1),
+			('https://guide.michelin.com/a', 'Alpha', '', '', 'Paris, France', '0', '0', 'French', 1);
+	`)
+
+	rating, found, err := GetRating(newDb, 3)
+	if err != nil || !found || rating.Rating != 5 || rating.Review == nil || *rating.Review != "Perfect tasting menu" {
+		t.Errorf("GetRating() of Alpha = %+v, %v, %v, expected 5 with its review", rating, found, err)
+	}
+	if tags, _ := GetTags(newDb, 2); !reflect.DeepEqual(tags, []string{"date night", "terrace"}) {
+		t.Errorf("GetTags() of Gamma = %v, expected [date night terrace]", tags)
+	}
+	if tags, _ := GetTags(newDb, 1); len(tags) != 0 {
+		t.Errorf("Delta carries tags %v of a removed restaurant", tags)
+	}
+
+	for query, expected := range map[string]string{
+		"rated:>=3":        "Alpha",
+		`tag:"date night"`: "Alpha,Gamma",
+	} {
+		if got := searchNames(t, newDb, query); got != expected {
+			t.Errorf("SearchRestaurants(%q) after update = %q, expected %q", query, got, expected)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// UpdateArchiveName is the zipped database an update source provides
+	UpdateArchiveName = "michelin.db.zip"
+	// UpdateManifestName is the manifest a local directory may provide next to the archive
+	UpdateManifestName = "manifest.json"
+	// updateCheckInterval throttles the automatic check of a local update source
+	updateCheckInterval = time.Hour
+	// maxManifestSize caps how much of a manifest is read
+	maxManifestSize = 1 << 20
+)
+
+// updateClient downloads manifests and archives
+var updateClient = &http.Client{Timeout: 5 * time.Minute}
+
+// UpdateSourceKind is where an update source points
+type UpdateSourceKind string
+
+const (
+	SourceDirectory UpdateSourceKind = "directory"
+	SourceFile      UpdateSourceKind = "file"
+	SourceURL       UpdateSourceKind = "url"
+)
+
+// UpdateSource is the UPDATE_SOURCE setting: a directory holding michelin.db.zip (and optionally
+// manifest.json), the path of a zipped database, or the HTTP(S) URL of a manifest
+type UpdateSource struct {
+	Kind     UpdateSourceKind
+	Location string
+}
+
+// AvailableUpdate is a dataset the source offers that differs from the installed one
+type AvailableUpdate struct {
+	Source    UpdateSource
+	Manifest  UpdateManifest
+	Installed string // installed dataset version, empty if unknown
+	archive   string // resolved archive path or URL
+	delta     string // resolved delta path or URL, empty without a delta from the installed version
+}
+
+// ParseUpdateSource reads an UPDATE_SOURCE value; ~ expands to the home directory
+func ParseUpdateSource(value string) (UpdateSource, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return UpdateSource{}, fmt.Errorf("no update source configured")
+	}
+
+	if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+		if _, err := url.Parse(value); err != nil {
+			return UpdateSource{}, fmt.Errorf("invalid update URL: %v", err)
+		}
+		return UpdateSource{Kind: SourceURL, Location: value}, nil
+	}
+
+	if strings.HasPrefix(value, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			value = filepath.Join(home, value[2:])
+		}
+	}
+	info, err := os.Stat(value)
+	if err != nil {
+		return UpdateSource{}, fmt.Errorf("update source not found: %v", err)
+	}
+	if info.IsDir() {
+		return UpdateSource{Kind: SourceDirectory, Location: value}, nil
+	}
+	return UpdateSource{Kind: SourceFile, Location: value}, nil
+}
+
+// String describes an update source for messages
+func (s UpdateSource) String() string {
+	return fmt.Sprintf("%s %s", s.Kind, s.Location)
+}
+
+// CheckForUpdate asks a source for its dataset and returns it if it differs from the one installed
+// at dbPath, or nil when the installed dataset is current
+func CheckForUpdate(dbPath string, source UpdateSource) (*AvailableUpdate, error) {
+	update := &AvailableUpdate{Source: source}
+
+	switch source.Kind {
+	case SourceURL:
+		manifest, err := fetchManifest(source.Location)
+		if err != nil {
+			return nil, err
+		}
+		archive, err := resolveArchiveURL(source.Location, manifest.URL)
+		if err != nil {
+			return nil, err
+		}
+		update.Manifest, update.archive = manifest, archive
+
+	case SourceDirectory:
+		update.archive = filepath.Join(source.Location, UpdateArchiveName)
+		manifestPath := filepath.Join(source.Location, UpdateManifestName)
+		if data, err := os.ReadFile(manifestPath); err == nil {
+			if err := json.Unmarshal(data, &update.Manifest); err != nil {
+				return nil, fmt.Errorf("failed to read %s: %v", manifestPath, err)
+			}
+			if update.Manifest.URL != "" {
+				update.archive = filepath.Join(source.Location, filepath.Base(update.Manifest.URL))
+			}
+		}
+		if _, err := os.Stat(update.archive); err != nil {
+			return nil, &NoUpdateAvailableError{path: update.archive}
+		}
+
+	case SourceFile:
+		update.archive = source.Location
+
+	default:
+		return nil, fmt.Errorf("unknown update source %q", source.Kind)
+	}
+
+	// A local archive without a manifest is identified by its checksum
+	if update.Manifest.SHA256 == "" && source.Kind != SourceURL {
+		sum, err := fileSHA256(update.archive)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", update.archive, err)
+		}
+		update.Manifest.SHA256 = sum
+	}
+
+	// The dataset fields of a local archive are in its embedded manifest
+	if update.Manifest.Version == "" && source.Kind != SourceURL {
+		if embedded, err := readEmbeddedManifest(update.archive); err == nil && embedded != nil {
+			update.Manifest.Version = embedded.Version
+			update.Manifest.DatasetDate = embedded.DatasetDate
+		}
+	}
+
+	installed, err := installedDataset(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	update.Installed = installed.Version
+
+	if ref := update.Manifest.Delta; ref != nil && installed.Version != "" && ref.BaseVersion == installed.Version {
+		if source.Kind == SourceURL {
+			if update.delta, err = resolveArchiveURL(source.Location, ref.URL); err != nil {
+				return nil, err
+			}
+		} else {
+			update.delta = filepath.Join(filepath.Dir(update.archive), filepath.Base(ref.URL))
+		}
+	}
+
+	if installed.ArchiveSHA256 != "" && strings.EqualFold(installed.ArchiveSHA256, update.Manifest.SHA256) {
+		return nil, nil
+	}
+	if update.Manifest.Version != "" && update.Manifest.Version == installed.Version {
+		return nil, nil
+	}
+	if err := checkDowngrade(update.Manifest, installed); err != nil {
+		return nil, err
+	}
+	return update, nil
+}
+
+// InstallUpdate downloads or copies the archive of an update and installs it with installArchive,
+// keeping the user data. It returns the dataset info of the installed database.
+func InstallUpdate(dbPath string, update *AvailableUpdate) (DatasetInfo, error) {
+	tempDir, err := os.MkdirTemp("", "michelin_download_")
+	if err != nil {
+		return DatasetInfo{}, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A delta from the installed version is much smaller; the full archive remains the fallback
+	if update.delta != "" {
+		info, err := installDelta(dbPath, update, tempDir)
+		if err == nil {
+			return info, nil
+		}
+		fmt.Fprintf(os.Stderr, "[UPDATE WARN] Delta update failed, installing the full dataset: %v\n", err)
+	}
+
+	archive := filepath.Join(tempDir, UpdateArchiveName)
+	if update.Source.Kind == SourceURL {
+		fmt.Fprintf(os.Stderr, "[UPDATE INFO] Downloading %s\n", update.archive)
+		err = downloadFile(update.archive, archive)
+	} else {
+		err = copyFile(update.archive, archive)
+	}
+	if err != nil {
+		return DatasetInfo{}, fmt.Errorf("failed to fetch update: %v", err)
+	}
+
+	if _, err := installArchive(dbPath, archive, &update.Manifest); err != nil {
+		return DatasetInfo{}, err
+	}
+
+	// Remember the archive so that it is not applied again
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return DatasetInfo{}, fmt.Errorf("failed to open updated database: %v", err)
+	}
+	defer database.Close()
+	if err := setDatasetValues(database, map[string]string{"archive_sha256": strings.ToLower(update.Manifest.SHA256)}); err != nil {
+		return DatasetInfo{}, err
+	}
+	return GetDatasetInfo(database)
+}
+
+// installDelta fetches the delta of an update, verifies it and applies it with ApplyDelta
+func installDelta(dbPath string, update *AvailableUpdate, tempDir string) (DatasetInfo, error) {
+	ref := update.Manifest.Delta
+	path := filepath.Join(tempDir, "delta.json.gz")
+	var err error
+	if update.Source.Kind == SourceURL {
+		fmt.Fprintf(os.Stderr, "[UPDATE INFO] Downloading %s\n", update.delta)
+		err = downloadFile(update.delta, path)
+	} else {
+		err = copyFile(update.delta, path)
+	}
+	if err != nil {
+		return DatasetInfo{}, fmt.Errorf("failed to fetch delta: %v", err)
+	}
+
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return DatasetInfo{}, fmt.Errorf("failed to checksum delta: %v", err)
+	}
+	if !strings.EqualFold(sum, ref.SHA256) {
+		return DatasetInfo{}, &UpdateRejectedError{Reason: "the delta does not match its manifest checksum, the download is corrupted or was modified"}
+	}
+	verified, err := verifySignature(&update.Manifest)
+	if err != nil {
+		return DatasetInfo{}, err
+	}
+
+	d, err := ReadDelta(path)
+	if err != nil {
+		return DatasetInfo{}, err
+	}
+	if update.Manifest.Version != "" && d.Version != update.Manifest.Version {
+		return DatasetInfo{}, &UpdateRejectedError{Reason: fmt.Sprintf("the delta is for dataset %s, the manifest for %s", d.Version, update.Manifest.Version)}
+	}
+	if _, err := ApplyDelta(dbPath, d, &update.Manifest); err != nil {
+		return DatasetInfo{}, err
+	}
+
+	database, err := Open(dbPath, false)
+	if err != nil {
+		return DatasetInfo{}, err
+	}
+	defer database.Close()
+	values := map[string]string{"archive_sha256": strings.ToLower(update.Manifest.SHA256), "signature": "unsigned"}
+	if verified {
+		values["signature"] = "verified"
+	}
+	if err := setDatasetValues(database, values); err != nil {
+		return DatasetInfo{}, err
+	}
+	return GetDatasetInfo(database)
+}
+
+// UpdateDatabase checks the local UPDATE_SOURCE for a new dataset, at most once per
+// updateCheckInterval, and installs it. HTTP sources are only checked by the update command, to
+// keep the network out of searches. Returns a NoUpdateAvailableError when there is nothing to do.
+func UpdateDatabase(currentDbPath string) error {
+	value := os.Getenv("UPDATE_SOURCE")
+	if value == "" {
+		return &NoUpdateAvailableError{path: "UPDATE_SOURCE"}
+	}
+	if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+		return &NoUpdateAvailableError{path: value}
+	}
+
+	marker := filepath.Join(filepath.Dir(currentDbPath), ".update_check")
+	if info, err := os.Stat(marker); err == nil && time.Since(info.ModTime()) < updateCheckInterval {
+		return &NoUpdateAvailableError{path: value}
+	}
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "[UPDATE WARN] Failed to record update check: %v\n", err)
+	}
+
+	source, err := ParseUpdateSource(value)
+	if err != nil {
+		return &NoUpdateAvailableError{path: value}
+	}
+	update, err := CheckForUpdate(currentDbPath, source)
+	if err != nil {
+		return err
+	}
+	if update == nil {
+		return &NoUpdateAvailableError{path: value}
+	}
+
+	fmt.Fprintf(os.Stderr, "[UPDATE INFO] Found database update in %s\n", source)
+	if _, err := InstallUpdate(currentDbPath, update); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "[UPDATE SUCCESS] Database update completed successfully\n")
+	return nil
+}
+
+// installedDataset reads the dataset info of the live database, if there is one
+func installedDataset(dbPath string) (DatasetInfo, error) {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return DatasetInfo{}, nil
+	}
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return DatasetInfo{}, fmt.Errorf("failed to open database: %v", err)
+	}
+	defer database.Close()
+	return GetDatasetInfo(database)
+}
+
+// fetchManifest downloads and decodes a manifest
+func fetchManifest(manifestURL string) (UpdateManifest, error) {
+	var manifest UpdateManifest
+
+	resp, err := updateClient.Get(manifestURL)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to fetch manifest: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return manifest, fmt.Errorf("failed to fetch manifest: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&manifest); err != nil {
+		return manifest, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	if manifest.URL == "" || manifest.SHA256 == "" {
+		return manifest, &UpdateRejectedError{Reason: "the manifest must have a url and a sha256 of the archive"}
+	}
+	return manifest, nil
+}
+
+// resolveArchiveURL resolves the archive location of a manifest against the manifest URL
+func resolveArchiveURL(manifestURL, archive string) (string, error) {
+	base, err := url.Parse(manifestURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid manifest URL: %v", err)
+	}
+	ref, err := url.Parse(archive)
+	if err != nil {
+		return "", fmt.Errorf("invalid archive URL in manifest: %v", err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// downloadFile saves the body of a URL to a file
+func downloadFile(fileURL, path string) error {
+	resp, err := updateClient.Get(fileURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// fileSHA256 returns the hex SHA-256 of a file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
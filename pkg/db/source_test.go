@@ -0,0 +1,141 @@
+package db
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// zipTestDatabase writes a zipped restaurant database and returns the archive contents
+func zipTestDatabase(t *testing.T, dir string, restaurants int) []byte {
+	t.Helper()
+	dbPath := filepath.Join(dir, "source.db")
+	createTestDatabase(t, dbPath, restaurants, "")
+
+	zipPath := filepath.Join(dir, UpdateArchiveName)
+	out, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatalf("failed to create zip: %v", err)
+	}
+	w := zip.NewWriter(out)
+	entry, err := w.Create(DbFileName)
+	if err != nil {
+		t.Fatalf("failed to add zip entry: %v", err)
+	}
+	data, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatalf("failed to read database: %v", err)
+	}
+	entry.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to write zip: %v", err)
+	}
+	out.Close()
+
+	archive, err := os.ReadFile(zipPath)
+	if err != nil {
+		t.Fatalf("failed to read zip: %v", err)
+	}
+	return archive
+}
+
+// serveUpdate starts a stand-in update server publishing a manifest and an archive
+func serveUpdate(t *testing.T, manifest UpdateManifest, archive []byte) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/datasets/manifest.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(manifest)
+	})
+	mux.HandleFunc("/datasets/"+UpdateArchiveName, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestUpdateFromURL(t *testing.T) {
+	dir := t.TempDir()
+	archive := zipTestDatabase(t, dir, 3)
+	sum, err := fileSHA256(filepath.Join(dir, UpdateArchiveName))
+	if err != nil {
+		t.Fatalf("failed to checksum archive: %v", err)
+	}
+	server := serveUpdate(t, UpdateManifest{Version: "2025.07", SHA256: sum, URL: UpdateArchiveName}, archive)
+
+	source, err := ParseUpdateSource(server.URL + "/datasets/manifest.json")
+	if err != nil || source.Kind != SourceURL {
+		t.Fatalf("ParseUpdateSource() = %v, %v", source, err)
+	}
+
+	dbPath := filepath.Join(dir, DbFileName)
+	update, err := CheckForUpdate(dbPath, source)
+	if err != nil || update == nil {
+		t.Fatalf("CheckForUpdate() = %v, %v, expected an update", update, err)
+	}
+
+	installed, err := InstallUpdate(dbPath, update)
+	if err != nil {
+		t.Fatalf("InstallUpdate() returned error: %v", err)
+	}
+	if installed.Version != "2025.07" || installed.Restaurants != 3 {
+		t.Errorf("InstallUpdate() installed %+v", installed)
+	}
+
+	// The same dataset is not offered again
+	update, err = CheckForUpdate(dbPath, source)
+	if err != nil || update != nil {
+		t.Errorf("CheckForUpdate() after installing = %v, %v, expected no update", update, err)
+	}
+}
+
+func TestUpdateRejectsChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	archive := zipTestDatabase(t, dir, 3)
+	server := serveUpdate(t, UpdateManifest{Version: "2025.07", SHA256: strings.Repeat("0", 64), URL: UpdateArchiveName}, archive)
+
+	source, _ := ParseUpdateSource(server.URL + "/datasets/manifest.json")
+	dbPath := filepath.Join(dir, DbFileName)
+	update, err := CheckForUpdate(dbPath, source)
+	if err != nil || update == nil {
+		t.Fatalf("CheckForUpdate() = %v, %v, expected an update", update, err)
+	}
+
+	_, err = InstallUpdate(dbPath, update)
+	if !IsUpdateRejected(err) || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("InstallUpdate() = %v, expected a rejected checksum", err)
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("InstallUpdate() installed a database that failed its checksum")
+	}
+}
+
+func TestUpdateFromDirectory(t *testing.T) {
+	sourceDir := t.TempDir()
+	zipTestDatabase(t, sourceDir, 2)
+
+	source, err := ParseUpdateSource(sourceDir)
+	if err != nil || source.Kind != SourceDirectory {
+		t.Fatalf("ParseUpdateSource() = %v, %v", source, err)
+	}
+
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	update, err := CheckForUpdate(dbPath, source)
+	if err != nil || update == nil {
+		t.Fatalf("CheckForUpdate() = %v, %v, expected an update", update, err)
+	}
+	if _, err := InstallUpdate(dbPath, update); err != nil {
+		t.Fatalf("InstallUpdate() returned error: %v", err)
+	}
+
+	// Without a manifest the archive checksum identifies what is installed
+	update, err = CheckForUpdate(dbPath, source)
+	if err != nil || update != nil {
+		t.Errorf("CheckForUpdate() after installing = %v, %v, expected no update", update, err)
+	}
+}
@@ -0,0 +1,436 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DatabaseSchemaVersion is the newest PRAGMA user_version of the restaurant data this workflow reads
+	DatabaseSchemaVersion = 1
+	// defaultBackupCount is how many dated backups are kept when BACKUP_COUNT is not set
+	defaultBackupCount = 3
+	// minRestaurantRatio is the smallest share of the current restaurants an update may contain
+	minRestaurantRatio = 0.5
+	// backupTimeFormat dates backup file names so that they sort chronologically
+	backupTimeFormat = "20060102-150405"
+)
+
+// requiredColumns lists the tables and columns the workflow reads from the restaurant data
+var requiredColumns = map[string][]string{
+	"restaurants":       {"id", "url", "name", "address", "location", "latitude", "longitude", "cuisine", "in_guide"},
+	"restaurant_awards": {"restaurant_id", "year", "distinction", "green_star", "price"},
+}
+
+// stagingPath returns the file an update is prepared in, next to the live database so that
+// the final rename stays on one file system
+func stagingPath(dbPath string) string {
+	return strings.TrimSuffix(dbPath, ".db") + "_staging.db"
+}
+
+// backupPattern returns the glob matching the dated backups of a database
+func backupPattern(dbPath string) string {
+	return strings.TrimSuffix(dbPath, ".db") + "_backup_*.db"
+}
+
+// backupCount returns the BACKUP_COUNT setting
+func backupCount() int {
+	if count, err := strconv.Atoi(os.Getenv("BACKUP_COUNT")); err == nil && count >= 0 {
+		return count
+	}
+	return defaultBackupCount
+}
+
+// UpdateFromZip installs a zipped database with installArchive, using manifest.json next to the
+// archive when the archive does not embed one
+func UpdateFromZip(dbPath, zipPath string) error {
+	var alongside *UpdateManifest
+	manifestPath := filepath.Join(filepath.Dir(zipPath), UpdateManifestName)
+	if data, err := os.ReadFile(manifestPath); err == nil {
+		alongside = &UpdateManifest{}
+		if err := json.Unmarshal(data, alongside); err != nil {
+			return &UpdateRejectedError{Reason: fmt.Sprintf("%s cannot be read: %v", manifestPath, err)}
+		}
+	}
+	_, err := installArchive(dbPath, zipPath, alongside)
+	return err
+}
+
+// installArchive checks an update archive and the database in it against their manifest, refusing
+// corrupted, tampered, unsupported or older datasets, and installs it with ApplyUpdate. It returns
+// the dataset info of the installed database.
+func installArchive(dbPath, zipPath string, alongside *UpdateManifest) (DatasetInfo, error) {
+	if alongside != nil && alongside.Size > 0 {
+		if info, err := os.Stat(zipPath); err == nil && info.Size() != alongside.Size {
+			return DatasetInfo{}, &UpdateRejectedError{Reason: fmt.Sprintf("the archive has %d bytes, the manifest lists %d", info.Size(), alongside.Size)}
+		}
+	}
+	if alongside != nil && alongside.SHA256 != "" {
+		sum, err := fileSHA256(zipPath)
+		if err != nil {
+			return DatasetInfo{}, fmt.Errorf("failed to checksum archive: %v", err)
+		}
+		if !strings.EqualFold(sum, alongside.SHA256) {
+			return DatasetInfo{}, &UpdateRejectedError{Reason: "the archive does not match its manifest checksum, the download is corrupted or was modified"}
+		}
+	}
+
+	tempDir, err := os.MkdirTemp("", "michelin_update_")
+	if err != nil {
+		return DatasetInfo{}, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	newDbPath, manifest, err := extractArchive(zipPath, tempDir)
+	if err != nil {
+		return DatasetInfo{}, err
+	}
+	if manifest == nil {
+		manifest = alongside
+	}
+
+	verified, err := verifySignature(manifest)
+	if err != nil {
+		return DatasetInfo{}, err
+	}
+	if manifest == nil {
+		fmt.Fprintf(os.Stderr, "[UPDATE WARN] The update has no manifest, only SQLite checks apply\n")
+	} else {
+		installed, err := installedDataset(dbPath)
+		if err != nil {
+			return DatasetInfo{}, err
+		}
+		if err := verifyManifest(manifest, newDbPath, installed); err != nil {
+			return DatasetInfo{}, err
+		}
+	}
+
+	if err := ApplyUpdate(dbPath, newDbPath); err != nil {
+		return DatasetInfo{}, err
+	}
+
+	// Record what was installed, so that it can be shown and compared against later updates
+	values := map[string]string{"signature": "unsigned"}
+	if verified {
+		values["signature"] = "verified"
+	}
+	if manifest != nil {
+		if manifest.Version != "" {
+			values["version"] = manifest.Version
+		}
+		if manifest.DatasetDate != "" {
+			values["dataset_date"] = manifest.DatasetDate
+		}
+	}
+
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return DatasetInfo{}, fmt.Errorf("failed to open updated database: %v", err)
+	}
+	defer database.Close()
+	if err := setDatasetValues(database, values); err != nil {
+		return DatasetInfo{}, err
+	}
+	return GetDatasetInfo(database)
+}
+
+// ApplyUpdate replaces the database at dbPath with the one at newDbPath, keeping the user data.
+// The new database is prepared and checked in a staging file, then renamed over the live file,
+// so the live database is never missing or half-written. The current database is backed up
+// first and restored when any later step fails. Callers hold Lock and have no connection of their
+// own open to the live database, which would keep writing to the replaced file. Other runs write
+// under LockShared, so none writes between copying the user data and the rename.
+func ApplyUpdate(dbPath, newDbPath string) error {
+	staging := stagingPath(dbPath)
+	os.Remove(staging)
+	os.Remove(staging + "-journal")
+	defer os.Remove(staging)
+
+	if err := copyFile(newDbPath, staging); err != nil {
+		return fmt.Errorf("failed to stage new database: %v", err)
+	}
+
+	// A first install has no current database, backup or user data
+	backup := ""
+	minRestaurants := 1
+	if _, err := os.Stat(dbPath); err == nil {
+		if backup, err = createBackup(dbPath); err != nil {
+			return fmt.Errorf("failed to back up current database: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "[UPDATE INFO] Backed up current database to: %s\n", backup)
+	}
+
+	// Any failure from here on puts the backup back in place
+	rollback := func(err error) error {
+		if backup == "" {
+			return err
+		}
+		if restoreErr := restoreBackup(backup, dbPath); restoreErr != nil {
+			return fmt.Errorf("%v; restoring %s failed: %v", err, backup, restoreErr)
+		}
+		fmt.Fprintf(os.Stderr, "[UPDATE WARN] Update failed, restored %s\n", backup)
+		return err
+	}
+
+	if backup != "" {
+		var err error
+		if minRestaurants, err = prepareStagedDatabase(dbPath, staging); err != nil {
+			return rollback(err)
+		}
+	}
+
+	if err := verifyDatabase(staging, minRestaurants); err != nil {
+		return rollback(fmt.Errorf("new database failed verification: %v", err))
+	}
+	if err := syncFile(staging); err != nil {
+		return rollback(fmt.Errorf("failed to flush new database: %v", err))
+	}
+
+	if backup != "" {
+		if err := checkpoint(dbPath); err != nil {
+			return rollback(fmt.Errorf("failed to checkpoint current database: %v", err))
+		}
+	}
+	if err := os.Rename(staging, dbPath); err != nil {
+		return rollback(fmt.Errorf("failed to install new database: %v", err))
+	}
+	syncDir(filepath.Dir(dbPath))
+
+	// The rename cannot be partial, but check what is live before dropping old backups
+	if err := quickCheck(dbPath); err != nil {
+		return rollback(fmt.Errorf("installed database failed verification: %v", err))
+	}
+
+	if err := pruneBackups(dbPath, backupCount()); err != nil {
+		fmt.Fprintf(os.Stderr, "[UPDATE WARN] Failed to remove old backups: %v\n", err)
+	}
+	return nil
+}
+
+// prepareStagedDatabase moves the user data of the live database into the staged one and
+// returns the smallest restaurant count the staged database may have
+func prepareStagedDatabase(dbPath, staging string) (int, error) {
+	currentDb, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open current database: %v", err)
+	}
+	defer currentDb.Close()
+
+	newDb, err := sql.Open("sqlite3", staging)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open new database: %v", err)
+	}
+	defer newDb.Close()
+
+	var current int
+	if err := currentDb.QueryRow("SELECT COUNT(*) FROM restaurants").Scan(&current); err != nil {
+		return 0, fmt.Errorf("failed to count current restaurants: %v", err)
+	}
+
+	if err := preserveUserDataDuringUpdate(currentDb, newDb); err != nil {
+		return 0, fmt.Errorf("failed to preserve user data during update: %v", err)
+	}
+
+	return max(1, int(float64(current)*minRestaurantRatio)), nil
+}
+
+// verifyDatabase checks a database before it goes live: SQLite integrity, the tables and
+// columns the workflow reads, a supported schema version and a plausible restaurant count
+func verifyDatabase(path string, minRestaurants int) error {
+	database, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	rows, err := database.Query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("failed to run integrity check: %v", err)
+	}
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read integrity check: %v", err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	rows.Close()
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+
+	var version int
+	if err := database.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if version > DatabaseSchemaVersion {
+		return fmt.Errorf("schema version %d is newer than supported version %d, please update the workflow", version, DatabaseSchemaVersion)
+	}
+
+	for table, columns := range requiredColumns {
+		existing := make(map[string]bool)
+		rows, err := database.Query("SELECT name FROM pragma_table_info(?)", table)
+		if err != nil {
+			return fmt.Errorf("failed to read columns of %s: %v", table, err)
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to read columns of %s: %v", table, err)
+			}
+			existing[name] = true
+		}
+		rows.Close()
+
+		if len(existing) == 0 {
+			return fmt.Errorf("missing table %s", table)
+		}
+		for _, column := range columns {
+			if !existing[column] {
+				return fmt.Errorf("missing column %s.%s", table, column)
+			}
+		}
+	}
+
+	var count int
+	if err := database.QueryRow("SELECT COUNT(*) FROM restaurants").Scan(&count); err != nil {
+		return fmt.Errorf("failed to count restaurants: %v", err)
+	}
+	if count < minRestaurants {
+		return fmt.Errorf("%d restaurants is below the expected minimum of %d", count, minRestaurants)
+	}
+
+	return nil
+}
+
+// quickCheck runs PRAGMA quick_check on a database
+func quickCheck(path string) error {
+	database, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	var result string
+	if err := database.QueryRow("PRAGMA quick_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("quick check failed: %s", result)
+	}
+	return nil
+}
+
+// createBackup writes a consistent, dated copy of a database next to it and returns its path
+func createBackup(dbPath string) (string, error) {
+	backup := strings.TrimSuffix(dbPath, ".db") + "_backup_" + time.Now().Format(backupTimeFormat) + ".db"
+	os.Remove(backup)
+
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return "", err
+	}
+	defer database.Close()
+
+	// VACUUM INTO copies a consistent snapshot, unlike copying the file while it may be in use
+	if _, err := database.Exec("VACUUM INTO ?", backup); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+// restoreBackup puts a backup back in place through the staging file
+func restoreBackup(backup, dbPath string) error {
+	staging := stagingPath(dbPath)
+	if err := copyFile(backup, staging); err != nil {
+		os.Remove(staging)
+		return err
+	}
+	if err := syncFile(staging); err != nil {
+		os.Remove(staging)
+		return err
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		checkpoint(dbPath)
+	}
+	if err := os.Rename(staging, dbPath); err != nil {
+		os.Remove(staging)
+		return err
+	}
+	syncDir(filepath.Dir(dbPath))
+	return nil
+}
+
+// ListBackups returns the dated backups of a database, newest first
+func ListBackups(dbPath string) ([]string, error) {
+	backups, err := filepath.Glob(backupPattern(dbPath))
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// pruneBackups removes all but the newest keep backups of a database
+func pruneBackups(dbPath string, keep int) error {
+	backups, err := ListBackups(dbPath)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(backups); i++ {
+		if err := os.Remove(backups[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreLatestBackup restores the newest backup when the live database is missing, e.g. after
+// an interrupted update of an older version. It returns the restored backup, or "" if none exists.
+func RestoreLatestBackup(dbPath string) (string, error) {
+	backups, err := ListBackups(dbPath)
+	if err != nil {
+		return "", err
+	}
+	for _, backup := range backups {
+		if quickCheck(backup) != nil {
+			continue
+		}
+		if err := restoreBackup(backup, dbPath); err != nil {
+			return "", fmt.Errorf("failed to restore %s: %v", backup, err)
+		}
+		return backup, nil
+	}
+	return "", nil
+}
+
+// syncFile flushes a file to disk
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// syncDir flushes a directory entry after a rename; failures are ignored as not every
+// file system supports it
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createTestDatabase writes a restaurant database with the given number of restaurants
+func createTestDatabase(t *testing.T, path string, restaurants int, extra string) {
+	t.Helper()
+	database, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	defer database.Close()
+
+	_, err = database.Exec(`
+		CREATE TABLE restaurants (id INTEGER PRIMARY KEY, url TEXT, name TEXT, address TEXT, location TEXT,
+			latitude TEXT, longitude TEXT, cuisine TEXT, in_guide NUMERIC);
+		CREATE TABLE restaurant_awards (restaurant_id INTEGER, year INTEGER, distinction TEXT, green_star NUMERIC, price TEXT);
+	` + extra)
+	if err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	for i := 0; i < restaurants; i++ {
+		if _, err := database.Exec("INSERT INTO restaurants (name) VALUES ('r')"); err != nil {
+			t.Fatalf("failed to insert restaurant: %v", err)
+		}
+	}
+}
+
+func TestVerifyDatabase(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Restaurants int
+		Extra       string
+		Error       string
+	}{
+		{"valid", 3, "", ""},
+		{"too few restaurants", 1, "", "below the expected minimum"},
+		{"newer schema", 3, "PRAGMA user_version = 99;", "newer than supported"},
+		{"missing column", 3, "ALTER TABLE restaurant_awards DROP COLUMN price;", "missing column restaurant_awards.price"},
+		{"missing table", 3, "DROP TABLE restaurant_awards;", "missing table restaurant_awards"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "michelin.db")
+			createTestDatabase(t, path, tt.Restaurants, tt.Extra)
+
+			err := verifyDatabase(path, 2)
+			if tt.Error == "" && err != nil {
+				t.Errorf("verifyDatabase() returned error: %v", err)
+			}
+			if tt.Error != "" && (err == nil || !strings.Contains(err.Error(), tt.Error)) {
+				t.Errorf("verifyDatabase() = %v, expected an error containing %q", err, tt.Error)
+			}
+		})
+	}
+}
+
+func TestApplyUpdateKeepsDatabaseOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "michelin.db")
+	newDbPath := filepath.Join(dir, "new.db")
+	createTestDatabase(t, newDbPath, 0, "")
+
+	// A first install of an empty database must not leave anything behind
+	if err := ApplyUpdate(dbPath, newDbPath); err == nil {
+		t.Fatal("ApplyUpdate() accepted a database without restaurants")
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("ApplyUpdate() left a database after failing: %v", err)
+	}
+	if _, err := os.Stat(stagingPath(dbPath)); !os.IsNotExist(err) {
+		t.Errorf("ApplyUpdate() left the staging file after failing: %v", err)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "michelin.db")
+	for _, name := range []string{
+		"michelin_backup_20240101-000000.db",
+		"michelin_backup_20250101-000000.db",
+		"michelin_backup_20230101-000000.db",
+		"michelin_backup.db", // legacy backup, not managed
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneBackups(dbPath, 2); err != nil {
+		t.Fatalf("pruneBackups() returned error: %v", err)
+	}
+
+	backups, err := ListBackups(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join(dir, "michelin_backup_20250101-000000.db"),
+		filepath.Join(dir, "michelin_backup_20240101-000000.db"),
+	}
+	if !reflect.DeepEqual(backups, expected) {
+		t.Errorf("backups after pruning = %v, expected %v", backups, expected)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "michelin_backup.db")); err != nil {
+		t.Errorf("pruneBackups() removed the legacy backup: %v", err)
+	}
+}
+
+func TestApplyUpdateKeepsConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, DbFileName)
+	newDbPath := filepath.Join(dir, "new.db")
+	createDeltaBase(t, dbPath)
+	createShippedDatabase(t, newDbPath, `
+		INSERT INTO restaurants (url, name, description, address, location, latitude, longitude, cuisine, in_guide) VALUES
+			('https://guide.michelin.com/a', 'Alpha', '', '', 'Paris, France', '0', '0', 'French', 1),
+			('https://guide.michelin.com/b', 'Beta', '', '', 'Lyon, France', '0', '0', 'French', 1),
+			('https://guide.michelin.com/c', 'Gamma', '', '', 'Nice, France', '0', '0', 'French', 1),
+			('https://guide.michelin.com/d', 'Delta', '', '', 'Rome, Italy', '0', '0', 'Italian', 1);
+	`).Close()
+
+	unlock, err := Lock(dbPath)
+	if err != nil {
+		t.Fatalf("Lock() returned error: %v", err)
+	}
+
+	// Another run favorites Alpha once the update has backed up the database and is moving its user data
+	written := make(chan error, 1)
+	go func() {
+		for {
+			if backups, _ := ListBackups(dbPath); len(backups) > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		unlockWriter, err := LockShared(dbPath)
+		if err != nil {
+			written <- err
+			return
+		}
+		defer unlockWriter()
+		database, err := Open(dbPath, false)
+		if err != nil {
+			written <- err
+			return
+		}
+		defer database.Close()
+		_, err = database.Exec("INSERT INTO user_favorites (restaurant_id) SELECT id FROM restaurants WHERE url = 'https://guide.michelin.com/a'")
+		written <- err
+	}()
+
+	err = ApplyUpdate(dbPath, newDbPath)
+	unlock()
+	if err != nil {
+		t.Fatalf("ApplyUpdate() returned error: %v", err)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("concurrent write failed: %v", err)
+	}
+
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+	var favorites string
+	var restaurants int
+	err = database.QueryRow(`
+		SELECT group_concat(name, ',') FROM (
+			SELECT r.name FROM user_favorites f JOIN restaurants r ON r.id = f.restaurant_id ORDER BY r.name)
+	`).Scan(&favorites)
+	if err != nil {
+		t.Fatalf("failed to read favorites: %v", err)
+	}
+	if err := database.QueryRow("SELECT COUNT(*) FROM restaurants").Scan(&restaurants); err != nil {
+		t.Fatalf("failed to count restaurants: %v", err)
+	}
+	if favorites != "Alpha,Gamma" || restaurants != 4 {
+		t.Errorf("after the update favorites = %q and restaurants = %d, expected Alpha,Gamma and 4", favorites, restaurants)
+	}
+}
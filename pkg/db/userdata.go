@@ -0,0 +1,626 @@
+package db
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserDataVersion is the version of the export format written by ExportUserData.
+// Imports accept this version and older ones.
+const UserDataVersion = 1
+
+// userDataCSVHeader is the first line of a CSV export, followed by the version
+const userDataCSVHeader = "# alfred-michelin user data, version "
+
+// UserData is the portable form of all personal data. Restaurants are keyed by their
+// Michelin URL, since restaurant IDs change between database releases.
+type UserData struct {
+	Version    int                `json:"version"`
+	ExportedAt string             `json:"exported_at"`
+	Favorites  []UserDataFavorite `json:"favorites"`
+	Visits     []UserDataVisit    `json:"visits"`
+	Ratings    []UserDataRating   `json:"ratings"`
+	Tags       []UserDataTag      `json:"tags"`
+	Lists      []UserDataList     `json:"lists"`
+	Locations  []UserDataLocation `json:"locations"`
+}
+
+// UserDataRestaurant identifies a restaurant in an export; the name is informative only
+type UserDataRestaurant struct {
+	URL  string `json:"url"`
+	Name string `json:"name,omitempty"`
+}
+
+// UserDataFavorite is an exported favorite
+type UserDataFavorite struct {
+	UserDataRestaurant
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+// UserDataVisit is an exported visit
+type UserDataVisit struct {
+	UserDataRestaurant
+	Date      string   `json:"date,omitempty"`
+	PartySize *int     `json:"party_size,omitempty"`
+	Rating    *int     `json:"rating,omitempty"`
+	Spend     *float64 `json:"spend,omitempty"`
+	Notes     string   `json:"notes,omitempty"`
+	CreatedAt string   `json:"created_at,omitempty"`
+}
+
+// UserDataRating is an exported personal rating
+type UserDataRating struct {
+	UserDataRestaurant
+	Rating    int    `json:"rating"`
+	Review    string `json:"review,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// UserDataTag is an exported tag
+type UserDataTag struct {
+	UserDataRestaurant
+	Tag       string `json:"tag"`
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+// UserDataList is an exported list with its entries in order
+type UserDataList struct {
+	Name      string             `json:"name"`
+	CreatedAt string             `json:"created_at,omitempty"`
+	Items     []UserDataListItem `json:"items"`
+}
+
+// UserDataListItem is an exported list entry
+type UserDataListItem struct {
+	UserDataRestaurant
+	Position int    `json:"position"`
+	Notes    string `json:"notes,omitempty"`
+	AddedAt  string `json:"added_at,omitempty"`
+}
+
+// UserDataLocation is an exported saved location
+type UserDataLocation struct {
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	UpdatedAt string  `json:"updated_at,omitempty"`
+}
+
+// UserDataImportStats counts what an import added and what it could not match
+type UserDataImportStats struct {
+	Favorites int
+	Visits    int
+	Ratings   int
+	Tags      int
+	Lists     int
+	ListItems int
+	Locations int
+	// Entries whose restaurant URL is not in this database
+	Unmatched int
+	// Entries already present, e.g. when importing the same file twice
+	Duplicates int
+}
+
+// ExportUserData collects favorites, visits, ratings, tags, lists and saved locations
+func ExportUserData(db *sql.DB) (*UserData, error) {
+	data := &UserData{Version: UserDataVersion, ExportedAt: time.Now().UTC().Format(time.RFC3339)}
+
+	rows, err := db.Query(`
+		SELECT COALESCE(r.url, ''), COALESCE(r.name, ''), COALESCE(uf.created_at, '')
+		FROM user_favorites uf JOIN restaurants r ON r.id = uf.restaurant_id
+		ORDER BY uf.created_at, uf.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export favorites: %v", err)
+	}
+	for rows.Next() {
+		var f UserDataFavorite
+		if err := rows.Scan(&f.URL, &f.Name, &f.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to export favorites: %v", err)
+		}
+		data.Favorites = append(data.Favorites, f)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT COALESCE(r.url, ''), COALESCE(r.name, ''), COALESCE(uv.visited_date, ''),
+			uv.party_size, uv.rating, uv.spend, COALESCE(uv.notes, ''), COALESCE(uv.created_at, '')
+		FROM user_visits uv JOIN restaurants r ON r.id = uv.restaurant_id
+		ORDER BY uv.visited_date, uv.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export visits: %v", err)
+	}
+	for rows.Next() {
+		var v UserDataVisit
+		if err := rows.Scan(&v.URL, &v.Name, &v.Date, &v.PartySize, &v.Rating, &v.Spend, &v.Notes, &v.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to export visits: %v", err)
+		}
+		data.Visits = append(data.Visits, v)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT COALESCE(r.url, ''), COALESCE(r.name, ''), ur.rating, COALESCE(ur.review, ''), COALESCE(ur.updated_at, '')
+		FROM user_ratings ur JOIN restaurants r ON r.id = ur.restaurant_id
+		ORDER BY r.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export ratings: %v", err)
+	}
+	for rows.Next() {
+		var rt UserDataRating
+		if err := rows.Scan(&rt.URL, &rt.Name, &rt.Rating, &rt.Review, &rt.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to export ratings: %v", err)
+		}
+		data.Ratings = append(data.Ratings, rt)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT COALESCE(r.url, ''), COALESCE(r.name, ''), ut.tag, COALESCE(ut.created_at, '')
+		FROM user_tags ut JOIN restaurants r ON r.id = ut.restaurant_id
+		ORDER BY ut.tag, r.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export tags: %v", err)
+	}
+	for rows.Next() {
+		var t UserDataTag
+		if err := rows.Scan(&t.URL, &t.Name, &t.Tag, &t.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to export tags: %v", err)
+		}
+		data.Tags = append(data.Tags, t)
+	}
+	rows.Close()
+
+	lists, err := GetLists(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export lists: %v", err)
+	}
+	for _, list := range lists {
+		exported := UserDataList{Name: list.Name, CreatedAt: list.CreatedAt}
+		rows, err := db.Query(`
+			SELECT COALESCE(r.url, ''), COALESCE(r.name, ''), li.position, COALESCE(li.notes, ''), COALESCE(li.added_at, '')
+			FROM user_list_items li JOIN restaurants r ON r.id = li.restaurant_id
+			WHERE li.list_id = ?
+			ORDER BY li.position
+		`, list.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export list %s: %v", list.Name, err)
+		}
+		for rows.Next() {
+			var item UserDataListItem
+			if err := rows.Scan(&item.URL, &item.Name, &item.Position, &item.Notes, &item.AddedAt); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to export list %s: %v", list.Name, err)
+			}
+			exported.Items = append(exported.Items, item)
+		}
+		rows.Close()
+		data.Lists = append(data.Lists, exported)
+	}
+
+	locations, err := GetLocations(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export locations: %v", err)
+	}
+	for _, location := range locations {
+		data.Locations = append(data.Locations, UserDataLocation{
+			Name: location.Name, Latitude: location.Latitude, Longitude: location.Longitude, UpdatedAt: location.UpdatedAt,
+		})
+	}
+
+	return data, nil
+}
+
+// ImportUserData merges exported personal data into the database in a single transaction.
+// Entries already present are skipped, so importing the same file twice is harmless.
+func ImportUserData(db *sql.DB, data *UserData) (UserDataImportStats, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return UserDataImportStats{}, fmt.Errorf("failed to begin import: %v", err)
+	}
+	defer tx.Rollback()
+
+	stats, err := importUserData(tx, data)
+	if err != nil {
+		return stats, err
+	}
+	if err := tx.Commit(); err != nil {
+		return stats, fmt.Errorf("failed to commit import: %v", err)
+	}
+	return stats, nil
+}
+
+// importUserData merges personal data within tx, for callers that change more in the same transaction
+func importUserData(tx *sql.Tx, data *UserData) (UserDataImportStats, error) {
+	var stats UserDataImportStats
+	if data.Version > UserDataVersion {
+		return stats, fmt.Errorf("user data version %d is newer than supported version %d, please update the workflow", data.Version, UserDataVersion)
+	}
+
+	// Map Michelin URLs to the restaurant IDs of this database
+	restaurantIDs := make(map[string]int64)
+	rows, err := tx.Query("SELECT id, url FROM restaurants WHERE url IS NOT NULL AND url != ''")
+	if err != nil {
+		return stats, fmt.Errorf("failed to read restaurant URLs: %v", err)
+	}
+	for rows.Next() {
+		var id int64
+		var url string
+		if err := rows.Scan(&id, &url); err != nil {
+			rows.Close()
+			return stats, fmt.Errorf("failed to read restaurant URLs: %v", err)
+		}
+		restaurantIDs[url] = id
+	}
+	rows.Close()
+
+	lookup := func(r UserDataRestaurant) (int64, bool) {
+		id, ok := restaurantIDs[r.URL]
+		if !ok {
+			stats.Unmatched++
+			fmt.Fprintf(os.Stderr, "[DEBUG] Import: restaurant not found: %s (%s)\n", r.Name, r.URL)
+		}
+		return id, ok
+	}
+	// count records an insert, or a duplicate when nothing was inserted
+	count := func(result sql.Result, counter *int) {
+		if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+			*counter++
+		} else {
+			stats.Duplicates++
+		}
+	}
+
+	for _, f := range data.Favorites {
+		id, ok := lookup(f.UserDataRestaurant)
+		if !ok {
+			continue
+		}
+		result, err := tx.Exec("INSERT OR IGNORE INTO user_favorites (restaurant_id, created_at) VALUES (?, COALESCE(?, CURRENT_TIMESTAMP))",
+			id, nullIfEmpty(f.CreatedAt))
+		if err != nil {
+			return stats, fmt.Errorf("failed to import favorite %s: %v", f.Name, err)
+		}
+		count(result, &stats.Favorites)
+	}
+
+	for _, v := range data.Visits {
+		id, ok := lookup(v.UserDataRestaurant)
+		if !ok {
+			continue
+		}
+		// A visit with the same restaurant, date and notes is the same visit
+		result, err := tx.Exec(`
+			INSERT INTO user_visits (restaurant_id, visited_date, notes, party_size, rating, spend, created_at)
+			SELECT ?, ?, ?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP)
+			WHERE NOT EXISTS (
+				SELECT 1 FROM user_visits
+				WHERE restaurant_id = ? AND visited_date IS ? AND notes IS ?
+			)
+		`, id, nullIfEmpty(v.Date), nullIfEmpty(v.Notes), v.PartySize, v.Rating, v.Spend, nullIfEmpty(v.CreatedAt),
+			id, nullIfEmpty(v.Date), nullIfEmpty(v.Notes))
+		if err != nil {
+			return stats, fmt.Errorf("failed to import visit to %s: %v", v.Name, err)
+		}
+		count(result, &stats.Visits)
+	}
+
+	for _, rt := range data.Ratings {
+		id, ok := lookup(rt.UserDataRestaurant)
+		if !ok {
+			continue
+		}
+		if rt.Rating < 1 || rt.Rating > 5 {
+			return stats, fmt.Errorf("invalid rating %d for %s", rt.Rating, rt.Name)
+		}
+		// The imported rating replaces the current one
+		_, err := tx.Exec(`
+			INSERT INTO user_ratings (restaurant_id, rating, review, updated_at)
+			VALUES (?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP))
+			ON CONFLICT(restaurant_id) DO UPDATE SET
+				rating = excluded.rating,
+				review = excluded.review,
+				updated_at = excluded.updated_at
+		`, id, rt.Rating, nullIfEmpty(rt.Review), nullIfEmpty(rt.UpdatedAt))
+		if err != nil {
+			return stats, fmt.Errorf("failed to import rating for %s: %v", rt.Name, err)
+		}
+		stats.Ratings++
+	}
+
+	for _, t := range data.Tags {
+		id, ok := lookup(t.UserDataRestaurant)
+		if !ok {
+			continue
+		}
+		tag := normalizeTag(t.Tag)
+		if tag == "" {
+			continue
+		}
+		result, err := tx.Exec("INSERT OR IGNORE INTO user_tags (restaurant_id, tag, created_at) VALUES (?, ?, COALESCE(?, CURRENT_TIMESTAMP))",
+			id, tag, nullIfEmpty(t.CreatedAt))
+		if err != nil {
+			return stats, fmt.Errorf("failed to import tag %s: %v", t.Tag, err)
+		}
+		count(result, &stats.Tags)
+	}
+
+	for _, list := range data.Lists {
+		// Lists are matched by name; entries are appended after the existing ones
+		result, err := tx.Exec("INSERT OR IGNORE INTO user_lists (name, created_at) VALUES (?, COALESCE(?, CURRENT_TIMESTAMP))",
+			list.Name, nullIfEmpty(list.CreatedAt))
+		if err != nil {
+			return stats, fmt.Errorf("failed to import list %s: %v", list.Name, err)
+		}
+		count(result, &stats.Lists)
+
+		var listID int64
+		if err := tx.QueryRow("SELECT id FROM user_lists WHERE name = ? COLLATE NOCASE", list.Name).Scan(&listID); err != nil {
+			return stats, fmt.Errorf("failed to import list %s: %v", list.Name, err)
+		}
+
+		for _, item := range list.Items {
+			id, ok := lookup(item.UserDataRestaurant)
+			if !ok {
+				continue
+			}
+			result, err := tx.Exec(`
+				INSERT OR IGNORE INTO user_list_items (list_id, restaurant_id, position, notes, added_at)
+				VALUES (?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM user_list_items WHERE list_id = ?), ?, COALESCE(?, CURRENT_TIMESTAMP))
+			`, listID, id, listID, nullIfEmpty(item.Notes), nullIfEmpty(item.AddedAt))
+			if err != nil {
+				return stats, fmt.Errorf("failed to import list entry %s: %v", item.Name, err)
+			}
+			count(result, &stats.ListItems)
+		}
+	}
+
+	for _, location := range data.Locations {
+		_, err := tx.Exec(`
+			INSERT INTO user_locations (name, latitude, longitude, updated_at)
+			VALUES (?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP))
+			ON CONFLICT(name) DO UPDATE SET
+				latitude = excluded.latitude,
+				longitude = excluded.longitude,
+				updated_at = excluded.updated_at
+		`, strings.ToLower(location.Name), location.Latitude, location.Longitude, nullIfEmpty(location.UpdatedAt))
+		if err != nil {
+			return stats, fmt.Errorf("failed to import location %s: %v", location.Name, err)
+		}
+		stats.Locations++
+	}
+
+	return stats, nil
+}
+
+// nullIfEmpty maps an empty string to NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// WriteUserDataJSON writes user data as indented JSON
+func WriteUserDataJSON(w io.Writer, data *UserData) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return fmt.Errorf("failed to write JSON: %v", err)
+	}
+	return nil
+}
+
+// ReadUserDataJSON reads user data written by WriteUserDataJSON
+func ReadUserDataJSON(r io.Reader) (*UserData, error) {
+	var data UserData
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to read JSON: %v", err)
+	}
+	if data.Version == 0 {
+		return nil, fmt.Errorf("not an alfred-michelin user data file (missing version)")
+	}
+	return &data, nil
+}
+
+// userDataCSVColumns are the columns of the CSV export; each row is one record of the given type
+var userDataCSVColumns = []string{
+	"record", "url", "name", "list", "date", "position", "party_size", "rating", "spend", "text",
+	"latitude", "longitude", "created_at",
+}
+
+// WriteUserDataCSV writes user data as a single CSV table, one row per record, after a version line
+func WriteUserDataCSV(w io.Writer, data *UserData) error {
+	if _, err := fmt.Fprintf(w, "%s%d\n", userDataCSVHeader, data.Version); err != nil {
+		return fmt.Errorf("failed to write CSV: %v", err)
+	}
+
+	writer := csv.NewWriter(w)
+	records := [][]string{userDataCSVColumns}
+	row := func(values map[string]string) {
+		record := make([]string, len(userDataCSVColumns))
+		for i, column := range userDataCSVColumns {
+			record[i] = values[column]
+		}
+		records = append(records, record)
+	}
+
+	for _, f := range data.Favorites {
+		row(map[string]string{"record": "favorite", "url": f.URL, "name": f.Name, "created_at": f.CreatedAt})
+	}
+	for _, v := range data.Visits {
+		row(map[string]string{"record": "visit", "url": v.URL, "name": v.Name, "date": v.Date,
+			"party_size": formatOptionalInt(v.PartySize), "rating": formatOptionalInt(v.Rating),
+			"spend": formatOptionalFloat(v.Spend), "text": v.Notes, "created_at": v.CreatedAt})
+	}
+	for _, rt := range data.Ratings {
+		row(map[string]string{"record": "rating", "url": rt.URL, "name": rt.Name,
+			"rating": strconv.Itoa(rt.Rating), "text": rt.Review, "created_at": rt.UpdatedAt})
+	}
+	for _, t := range data.Tags {
+		row(map[string]string{"record": "tag", "url": t.URL, "name": t.Name, "text": t.Tag, "created_at": t.CreatedAt})
+	}
+	for _, list := range data.Lists {
+		row(map[string]string{"record": "list", "list": list.Name, "created_at": list.CreatedAt})
+		for _, item := range list.Items {
+			row(map[string]string{"record": "list_item", "url": item.URL, "name": item.Name, "list": list.Name,
+				"position": strconv.Itoa(item.Position), "text": item.Notes, "created_at": item.AddedAt})
+		}
+	}
+	for _, location := range data.Locations {
+		row(map[string]string{"record": "location", "name": location.Name,
+			"latitude":  strconv.FormatFloat(location.Latitude, 'f', -1, 64),
+			"longitude": strconv.FormatFloat(location.Longitude, 'f', -1, 64), "created_at": location.UpdatedAt})
+	}
+
+	if err := writer.WriteAll(records); err != nil {
+		return fmt.Errorf("failed to write CSV: %v", err)
+	}
+	return nil
+}
+
+// ReadUserDataCSV reads user data written by WriteUserDataCSV
+func ReadUserDataCSV(r io.Reader) (*UserData, error) {
+	buffered := bufio.NewReader(r)
+	firstLine, err := buffered.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read CSV: %v", err)
+	}
+	if !strings.HasPrefix(firstLine, userDataCSVHeader) {
+		return nil, fmt.Errorf("not an alfred-michelin user data file (missing version line)")
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(firstLine, userDataCSVHeader)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid user data version line: %s", strings.TrimSpace(firstLine))
+	}
+
+	reader := csv.NewReader(buffered)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("failed to read CSV: missing header")
+	}
+
+	columns := make(map[string]int)
+	for i, column := range records[0] {
+		columns[column] = i
+	}
+	data := &UserData{Version: version}
+	listIndex := make(map[string]int)
+
+	for line, record := range records[1:] {
+		get := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		restaurant := UserDataRestaurant{URL: get("url"), Name: get("name")}
+		fail := func(err error) (*UserData, error) {
+			return nil, fmt.Errorf("line %d: %v", line+3, err)
+		}
+
+		switch get("record") {
+		case "favorite":
+			data.Favorites = append(data.Favorites, UserDataFavorite{restaurant, get("created_at")})
+		case "visit":
+			visit := UserDataVisit{UserDataRestaurant: restaurant, Date: get("date"), Notes: get("text"), CreatedAt: get("created_at")}
+			if visit.PartySize, err = parseOptionalInt(get("party_size")); err != nil {
+				return fail(err)
+			}
+			if visit.Rating, err = parseOptionalInt(get("rating")); err != nil {
+				return fail(err)
+			}
+			if visit.Spend, err = parseOptionalFloat(get("spend")); err != nil {
+				return fail(err)
+			}
+			data.Visits = append(data.Visits, visit)
+		case "rating":
+			rating, err := strconv.Atoi(get("rating"))
+			if err != nil {
+				return fail(fmt.Errorf("invalid rating '%s'", get("rating")))
+			}
+			data.Ratings = append(data.Ratings, UserDataRating{restaurant, rating, get("text"), get("created_at")})
+		case "tag":
+			data.Tags = append(data.Tags, UserDataTag{restaurant, get("text"), get("created_at")})
+		case "list", "list_item":
+			name := get("list")
+			i, ok := listIndex[strings.ToLower(name)]
+			if !ok {
+				i = len(data.Lists)
+				listIndex[strings.ToLower(name)] = i
+				data.Lists = append(data.Lists, UserDataList{Name: name})
+			}
+			if get("record") == "list" {
+				data.Lists[i].CreatedAt = get("created_at")
+				continue
+			}
+			position, _ := strconv.Atoi(get("position"))
+			data.Lists[i].Items = append(data.Lists[i].Items, UserDataListItem{restaurant, position, get("text"), get("created_at")})
+		case "location":
+			latitude, errLat := strconv.ParseFloat(get("latitude"), 64)
+			longitude, errLon := strconv.ParseFloat(get("longitude"), 64)
+			if errLat != nil || errLon != nil {
+				return fail(fmt.Errorf("invalid coordinates for location %s", get("name")))
+			}
+			data.Locations = append(data.Locations, UserDataLocation{get("name"), latitude, longitude, get("created_at")})
+		default:
+			return fail(fmt.Errorf("unknown record type '%s'", get("record")))
+		}
+	}
+
+	return data, nil
+}
+
+func formatOptionalInt(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.Itoa(*n)
+}
+
+func formatOptionalFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+func parseOptionalInt(s string) (*int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number '%s'", s)
+	}
+	return &n, nil
+}
+
+func parseOptionalFloat(s string) (*float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount '%s'", s)
+	}
+	return &f, nil
+}
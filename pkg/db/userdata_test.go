@@ -0,0 +1,95 @@
+package db
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func sampleUserData() *UserData {
+	party := 4
+	rating := 5
+	spend := 320.5
+	noma := UserDataRestaurant{URL: "https://guide.michelin.com/en/hovedstaden/kbenhavn/restaurant/noma", Name: "Noma"}
+	seta := UserDataRestaurant{URL: "https://guide.michelin.com/en/it/lombardia/milano/restaurant/seta", Name: "Seta"}
+
+	return &UserData{
+		Version:   UserDataVersion,
+		Favorites: []UserDataFavorite{{seta, "2024-01-02 10:00:00"}},
+		Visits: []UserDataVisit{
+			{UserDataRestaurant: noma, Date: "2024-05-01", PartySize: &party, Rating: &rating, Spend: &spend, Notes: "Anniversary, with \"wine pairing\""},
+			{UserDataRestaurant: noma, Date: "2024-06-01"},
+		},
+		Ratings: []UserDataRating{{noma, 4, "lovely", "2024-05-02 09:00:00"}},
+		Tags:    []UserDataTag{{seta, "date night", ""}},
+		Lists: []UserDataList{
+			{Name: "Tokyo trip", CreatedAt: "2024-01-01 00:00:00", Items: []UserDataListItem{{noma, 1, "", ""}, {seta, 2, "try lunch", ""}}},
+			{Name: "Empty"},
+		},
+		Locations: []UserDataLocation{{"home", 45.4642, 9.19, ""}},
+	}
+}
+
+func TestUserDataCSVRoundTrip(t *testing.T) {
+	data := sampleUserData()
+
+	var buf bytes.Buffer
+	if err := WriteUserDataCSV(&buf, data); err != nil {
+		t.Fatalf("WriteUserDataCSV returned error: %v", err)
+	}
+	got, err := ReadUserDataCSV(&buf)
+	if err != nil {
+		t.Fatalf("ReadUserDataCSV returned error: %v", err)
+	}
+	if !reflect.DeepEqual(got, data) {
+		t.Errorf("CSV round trip = %+v, expected %+v", got, data)
+	}
+}
+
+func TestUserDataJSONRoundTrip(t *testing.T) {
+	data := sampleUserData()
+
+	var buf bytes.Buffer
+	if err := WriteUserDataJSON(&buf, data); err != nil {
+		t.Fatalf("WriteUserDataJSON returned error: %v", err)
+	}
+	got, err := ReadUserDataJSON(&buf)
+	if err != nil {
+		t.Fatalf("ReadUserDataJSON returned error: %v", err)
+	}
+	if !reflect.DeepEqual(got, data) {
+		t.Errorf("JSON round trip = %+v, expected %+v", got, data)
+	}
+}
+
+func TestReadUserDataInvalid(t *testing.T) {
+	cases := []struct {
+		Name string
+		Read func(string) error
+		Got  string
+	}{
+		{"csv without version", readCSV, "record,url\nfavorite,x\n"},
+		{"csv unknown record", readCSV, userDataCSVHeader + "1\nrecord,url\nreview,x\n"},
+		{"csv bad rating", readCSV, userDataCSVHeader + "1\nrecord,url,rating\nrating,x,five\n"},
+		{"json without version", readJSON, `{"favorites": []}`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			if err := tt.Read(tt.Got); err == nil {
+				t.Errorf("reading %q expected an error", tt.Got)
+			}
+		})
+	}
+}
+
+func readCSV(s string) error {
+	_, err := ReadUserDataCSV(strings.NewReader(s))
+	return err
+}
+
+func readJSON(s string) error {
+	_, err := ReadUserDataJSON(strings.NewReader(s))
+	return err
+}
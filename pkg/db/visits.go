@@ -0,0 +1,309 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VisitDateLayout is the format of visit dates
+const VisitDateLayout = "2006-01-02"
+
+// visitSummaryTable aggregates the visit log per restaurant for the restaurant queries:
+// the number of visits, the last visit date and the notes of that visit
+// (SQLite takes bare columns from the row holding the MAX)
+const visitSummaryTable = `(
+			SELECT restaurant_id, COUNT(*) AS visit_count, MAX(visited_date) AS visited_date, notes
+			FROM user_visits
+			GROUP BY restaurant_id
+		)`
+
+// VisitDetails holds the fields of a visit given to add-visit or edit-visit.
+// Nil fields are left unset (or unchanged when editing).
+type VisitDetails struct {
+	Date      *string
+	PartySize *int
+	Rating    *int
+	Spend     *float64
+	Notes     *string
+}
+
+// ParseVisitDetails parses the free-form details of a visit, e.g.
+//
+//	2024-05-01 party:4 rating:5 spend:320 Anniversary dinner
+//
+// A date and the party:, rating: and spend: tokens may appear anywhere;
+// all remaining words become the notes.
+func ParseVisitDetails(text string) (VisitDetails, error) {
+	var details VisitDetails
+	var notes []string
+
+	for _, word := range strings.Fields(text) {
+		field, value, hasField := strings.Cut(word, ":")
+		switch {
+		case hasField && strings.EqualFold(field, "party"):
+			size, err := strconv.Atoi(value)
+			if err != nil || size < 1 {
+				return VisitDetails{}, fmt.Errorf("invalid party size '%s'", value)
+			}
+			details.PartySize = &size
+		case hasField && strings.EqualFold(field, "rating"):
+			rating, err := strconv.Atoi(value)
+			if err != nil || rating < 1 || rating > 5 {
+				return VisitDetails{}, fmt.Errorf("invalid rating '%s', expected 1 to 5", value)
+			}
+			details.Rating = &rating
+		case hasField && strings.EqualFold(field, "spend"):
+			spend, err := strconv.ParseFloat(value, 64)
+			if err != nil || spend < 0 {
+				return VisitDetails{}, fmt.Errorf("invalid spend '%s'", value)
+			}
+			details.Spend = &spend
+		case details.Date == nil && isVisitDate(word):
+			date := word
+			details.Date = &date
+		default:
+			notes = append(notes, word)
+		}
+	}
+
+	if len(notes) > 0 {
+		joined := strings.Join(notes, " ")
+		details.Notes = &joined
+	}
+
+	return details, nil
+}
+
+// isVisitDate reports whether a word is a date in VisitDateLayout
+func isVisitDate(word string) bool {
+	_, err := time.Parse(VisitDateLayout, word)
+	return err == nil
+}
+
+// MigrateVisitLog converts the user_visits table of older versions, which allowed a single visit
+// per restaurant, into the visit log with party size, rating and spend. Existing visits are kept.
+func MigrateVisitLog(db *sql.DB) error {
+	rows, err := db.Query("PRAGMA table_info(user_visits)")
+	if err != nil {
+		return fmt.Errorf("failed to check user_visits schema: %v", err)
+	}
+
+	hasTable := false
+	hasPartySize := false
+	for rows.Next() {
+		var cid int
+		var name, dataType string
+		var notNull, pk int
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan user_visits schema: %v", err)
+		}
+		hasTable = true
+		if name == "party_size" {
+			hasPartySize = true
+		}
+	}
+	rows.Close()
+
+	if !hasTable || hasPartySize {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "[DEBUG] Converting user_visits into a visit log\n")
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin visit migration: %v", err)
+	}
+	defer tx.Rollback()
+
+	// SQLite cannot drop a UNIQUE constraint, so the table is recreated
+	_, err = tx.Exec(`
+		CREATE TABLE user_visits_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			restaurant_id INTEGER NOT NULL,
+			visited_date TEXT,
+			notes TEXT,
+			party_size INTEGER,
+			rating INTEGER,
+			spend REAL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (restaurant_id) REFERENCES restaurants(id)
+		);
+
+		INSERT INTO user_visits_log (id, restaurant_id, visited_date, notes, created_at)
+		SELECT id, restaurant_id, visited_date, notes, created_at FROM user_visits;
+
+		DROP TABLE user_visits;
+		ALTER TABLE user_visits_log RENAME TO user_visits;
+		CREATE INDEX IF NOT EXISTS idx_user_visits_restaurant ON user_visits(restaurant_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to migrate user_visits: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// AddVisit logs a visit to a restaurant and returns its ID
+func AddVisit(db *sql.DB, restaurantID int64, details VisitDetails) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO user_visits (restaurant_id, visited_date, notes, party_size, rating, spend)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, restaurantID, details.Date, details.Notes, details.PartySize, details.Rating, details.Spend)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add visit: %v", err)
+	}
+
+	return result.LastInsertId()
+}
+
+// UpdateVisit changes the fields of a visit that are set in details
+func UpdateVisit(db *sql.DB, visitID int64, details VisitDetails) error {
+	var assignments []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		assignments = append(assignments, column+" = ?")
+		args = append(args, value)
+	}
+
+	if details.Date != nil {
+		set("visited_date", *details.Date)
+	}
+	if details.Notes != nil {
+		set("notes", *details.Notes)
+	}
+	if details.PartySize != nil {
+		set("party_size", *details.PartySize)
+	}
+	if details.Rating != nil {
+		set("rating", *details.Rating)
+	}
+	if details.Spend != nil {
+		set("spend", *details.Spend)
+	}
+	if len(assignments) == 0 {
+		return fmt.Errorf("nothing to update")
+	}
+
+	args = append(args, visitID)
+	result, err := db.Exec("UPDATE user_visits SET "+strings.Join(assignments, ", ")+" WHERE id = ?", args...)
+	if err != nil {
+		return fmt.Errorf("failed to update visit: %v", err)
+	}
+
+	return expectVisitRow(result, visitID)
+}
+
+// DeleteVisit removes a single visit from the log
+func DeleteVisit(db *sql.DB, visitID int64) error {
+	result, err := db.Exec("DELETE FROM user_visits WHERE id = ?", visitID)
+	if err != nil {
+		return fmt.Errorf("failed to delete visit: %v", err)
+	}
+
+	return expectVisitRow(result, visitID)
+}
+
+// expectVisitRow reports an error when a statement did not touch the given visit
+func expectVisitRow(result sql.Result, visitID int64) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check visit: %v", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("visit %d not found", visitID)
+	}
+	return nil
+}
+
+// GetRestaurantVisits retrieves the visits to a restaurant, most recent first
+func GetRestaurantVisits(db *sql.DB, restaurantID int64) ([]UserVisit, error) {
+	rows, err := db.Query(`
+		SELECT id, restaurant_id, visited_date, notes, party_size, rating, spend, created_at
+		FROM user_visits
+		WHERE restaurant_id = ?
+		ORDER BY visited_date DESC, id DESC
+	`, restaurantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get visits: %v", err)
+	}
+	defer rows.Close()
+
+	var visits []UserVisit
+	for rows.Next() {
+		var visit UserVisit
+		err := rows.Scan(&visit.ID, &visit.RestaurantID, &visit.VisitedDate, &visit.Notes,
+			&visit.PartySize, &visit.Rating, &visit.Spend, &visit.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan visit: %v", err)
+		}
+		visits = append(visits, visit)
+	}
+
+	return visits, nil
+}
+
+// VisitFilter narrows the visits of a calendar export; zero fields match every visit
+type VisitFilter struct {
+	From   string // first date, in VisitDateLayout
+	To     string // last date, in VisitDateLayout
+	ListID int64  // restaurants of a list
+}
+
+// CalendarVisit is a dated visit with its restaurant
+type CalendarVisit struct {
+	Visit      UserVisit
+	Restaurant Restaurant
+}
+
+// GetCalendarVisits retrieves the dated visits matching filter, past visits and those planned for
+// a later date alike, oldest first
+func GetCalendarVisits(db *sql.DB, filter VisitFilter) ([]CalendarVisit, error) {
+	conditions := []string{"v.visited_date IS NOT NULL"}
+	var args []interface{}
+	if filter.From != "" {
+		conditions = append(conditions, "v.visited_date >= ?")
+		args = append(args, filter.From)
+	}
+	if filter.To != "" {
+		conditions = append(conditions, "v.visited_date <= ?")
+		args = append(args, filter.To)
+	}
+	if filter.ListID != 0 {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM user_list_items li WHERE li.list_id = ? AND li.restaurant_id = r.id)")
+		args = append(args, filter.ListID)
+	}
+
+	rows, err := db.Query(restaurantQuery(
+		"user_visits v INNER JOIN restaurants r ON r.id = v.restaurant_id",
+		"v.id, v.visited_date, v.notes, v.party_size, v.rating, v.spend, v.created_at",
+		"WHERE "+strings.Join(conditions, " AND ")+" ORDER BY v.visited_date, v.id",
+	), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get visits: %v", err)
+	}
+	defer rows.Close()
+
+	var visits []CalendarVisit
+	for rows.Next() {
+		var visit UserVisit
+		r, err := scanRestaurant(rows, &visit.ID, &visit.VisitedDate, &visit.Notes,
+			&visit.PartySize, &visit.Rating, &visit.Spend, &visit.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan visit: %v", err)
+		}
+		visit.RestaurantID = r.ID
+		visits = append(visits, CalendarVisit{Visit: visit, Restaurant: r})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read visits: %v", err)
+	}
+
+	return visits, nil
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseVisitDetails(t *testing.T) {
+	str := func(s string) *string { return &s }
+	num := func(n int) *int { return &n }
+	amount := func(f float64) *float64 { return &f }
+
+	cases := []struct {
+		Got      string
+		Expected VisitDetails
+	}{
+		{"", VisitDetails{}},
+		{"2024-05-01", VisitDetails{Date: str("2024-05-01")}},
+		{"2024-05-01 party:4 rating:5 spend:320.50 Anniversary dinner",
+			VisitDetails{Date: str("2024-05-01"), PartySize: num(4), Rating: num(5), Spend: amount(320.5), Notes: str("Anniversary dinner")}},
+		{"Great tasting menu rating:4", VisitDetails{Rating: num(4), Notes: str("Great tasting menu")}},
+		{"2024-13-01 lunch", VisitDetails{Notes: str("2024-13-01 lunch")}},
+		{"Note: book early", VisitDetails{Notes: str("Note: book early")}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Got, func(t *testing.T) {
+			got, err := ParseVisitDetails(tt.Got)
+			if err != nil {
+				t.Fatalf("ParseVisitDetails(%q) returned error: %v", tt.Got, err)
+			}
+			if !equalVisitDetails(got, tt.Expected) {
+				t.Errorf("ParseVisitDetails(%q) = %s, expected %s", tt.Got, formatVisitDetails(got), formatVisitDetails(tt.Expected))
+			}
+		})
+	}
+}
+
+func TestParseVisitDetailsInvalid(t *testing.T) {
+	for _, text := range []string{"rating:6", "rating:0", "party:none", "spend:-5"} {
+		t.Run(text, func(t *testing.T) {
+			if _, err := ParseVisitDetails(text); err == nil {
+				t.Errorf("ParseVisitDetails(%q) expected an error", text)
+			}
+		})
+	}
+}
+
+func equalVisitDetails(a, b VisitDetails) bool {
+	return formatVisitDetails(a) == formatVisitDetails(b)
+}
+
+func formatVisitDetails(d VisitDetails) string {
+	s := "{"
+	if d.Date != nil {
+		s += " date=" + *d.Date
+	}
+	if d.PartySize != nil {
+		s += fmt.Sprintf(" party=%d", *d.PartySize)
+	}
+	if d.Rating != nil {
+		s += fmt.Sprintf(" rating=%d", *d.Rating)
+	}
+	if d.Spend != nil {
+		s += fmt.Sprintf(" spend=%.2f", *d.Spend)
+	}
+	if d.Notes != nil {
+		s += " notes=" + *d.Notes
+	}
+	return s + " }"
+}
+
+func TestGetCalendarVisits(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), DbFileName)
+	createDeltaBase(t, dbPath)
+	database, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	// The last visit has no date and is left out of the calendar
+	for _, visit := range []struct {
+		RestaurantID int64
+		Details      string
+	}{
+		{1, "2025-03-14"}, {2, "2025-11-02 party:6 Team dinner"}, {3, "2024-12-31"}, {1, "Undated"},
+	} {
+		details, err := ParseVisitDetails(visit.Details)
+		if err != nil {
+			t.Fatalf("ParseVisitDetails(%q) returned error: %v", visit.Details, err)
+		}
+		if _, err := AddVisit(database, visit.RestaurantID, details); err != nil {
+			t.Fatalf("AddVisit() returned error: %v", err)
+		}
+	}
+	listID, err := CreateList(database, "Team dinners")
+	if err != nil {
+		t.Fatalf("CreateList() returned error: %v", err)
+	}
+	if err := AddToList(database, listID, 2, ""); err != nil {
+		t.Fatalf("AddToList() returned error: %v", err)
+	}
+
+	cases := []struct {
+		Name     string
+		Filter   VisitFilter
+		Expected string
+	}{
+		{"all dated", VisitFilter{}, "Gamma 2024-12-31,Alpha 2025-03-14,Beta 2025-11-02"},
+		{"range", VisitFilter{From: "2025-01-01", To: "2025-06-30"}, "Alpha 2025-03-14"},
+		{"list", VisitFilter{ListID: listID}, "Beta 2025-11-02"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			visits, err := GetCalendarVisits(database, tt.Filter)
+			if err != nil {
+				t.Fatalf("GetCalendarVisits() returned error: %v", err)
+			}
+			var got []string
+			for _, v := range visits {
+				got = append(got, *v.Restaurant.Name+" "+*v.Visit.VisitedDate)
+			}
+			if strings.Join(got, ",") != tt.Expected {
+				t.Errorf("GetCalendarVisits() = %q, expected %q", strings.Join(got, ","), tt.Expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+// Package export writes restaurants as map files (GeoJSON, KML and GPX) and contacts (vCard), and
+// visits as calendar events (iCalendar)
+package export
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/giovanni/alfred-michelin/db"
+)
+
+// Format is a map file format
+type Format string
+
+const (
+	GeoJSON Format = "geojson"
+	KML     Format = "kml"
+	GPX     Format = "gpx"
+)
+
+// ParseFormat parses a format name such as "kml" or ".gpx"
+func ParseFormat(name string) (Format, error) {
+	switch strings.TrimPrefix(strings.ToLower(name), ".") {
+	case "geojson", "json":
+		return GeoJSON, nil
+	case "kml":
+		return KML, nil
+	case "gpx":
+		return GPX, nil
+	}
+	return "", fmt.Errorf("unknown format '%s', expected geojson, kml or gpx", name)
+}
+
+// Extension returns the file extension of the format, including the dot
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// Place is a restaurant with parsed coordinates and the properties written to map files
+type Place struct {
+	Name      string
+	Award     string
+	GreenStar bool
+	Cuisine   string
+	Price     string
+	Location  string
+	Address   string
+	URL       string
+	Latitude  float64
+	Longitude float64
+	// Shown in the popups of the HTML map
+	AwardYear   int
+	Description string
+	ImageURL    string
+	WebsiteURL  string
+}
+
+// Places converts restaurants into places, skipping those without valid coordinates.
+// It returns the places and the number of restaurants skipped.
+func Places(restaurants []db.Restaurant) ([]Place, int) {
+	var places []Place
+	skipped := 0
+	for _, r := range restaurants {
+		lat, lon, ok := coordinates(r)
+		if !ok {
+			skipped++
+			continue
+		}
+
+		places = append(places, Place{
+			Name:      value(r.Name),
+			Award:     value(r.CurrentAward),
+			GreenStar: r.CurrentGreenStar != nil && *r.CurrentGreenStar,
+			Cuisine:   value(r.Cuisine),
+			Price:     value(r.CurrentPrice),
+			Location:  value(r.Location),
+			Address:   value(r.Address),
+			URL:       value(r.Url),
+			Latitude:  lat,
+			Longitude: lon,
+
+			Description: value(r.Description),
+			ImageURL:    value(r.ImageURL),
+			WebsiteURL:  value(r.WebsiteUrl),
+		})
+		if r.CurrentAwardYear != nil {
+			places[len(places)-1].AwardYear = *r.CurrentAwardYear
+		}
+	}
+	return places, skipped
+}
+
+// coordinates returns the parsed coordinates of a restaurant, and false when they are missing or
+// out of range
+func coordinates(r db.Restaurant) (float64, float64, bool) {
+	lat, errLat := parseCoordinate(r.Latitude)
+	lon, errLon := parseCoordinate(r.Longitude)
+	if errLat != nil || errLon != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
+
+func parseCoordinate(s *string) (float64, error) {
+	if s == nil {
+		return 0, fmt.Errorf("missing coordinate")
+	}
+	return strconv.ParseFloat(strings.TrimSpace(*s), 64)
+}
+
+func value(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Summary describes a place in one line, e.g. "2 Stars · Creative · €€€€"
+func (p Place) Summary() string {
+	var parts []string
+	award := p.Award
+	if p.GreenStar {
+		if award == "" {
+			award = "Green Star"
+		} else {
+			award += " + Green Star"
+		}
+	}
+	for _, part := range []string{award, p.Cuisine, p.Price} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " · ")
+}
+
+// restaurantSummary describes a restaurant in one line, like Place.Summary
+func restaurantSummary(r db.Restaurant) string {
+	return Place{
+		Award:     value(r.CurrentAward),
+		GreenStar: r.CurrentGreenStar != nil && *r.CurrentGreenStar,
+		Cuisine:   value(r.Cuisine),
+		Price:     value(r.CurrentPrice),
+	}.Summary()
+}
+
+// Write writes places in the given format; title names the collection where the format allows it
+func Write(w io.Writer, format Format, title string, places []Place) error {
+	switch format {
+	case GeoJSON:
+		return writeGeoJSON(w, places)
+	case KML:
+		return writeKML(w, title, places)
+	case GPX:
+		return writeGPX(w, title, places)
+	}
+	return fmt.Errorf("unknown format '%s'", format)
+}
+
+type geoJSONCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONGeometry   `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type geoJSONProperties struct {
+	Name      string `json:"name"`
+	Award     string `json:"award,omitempty"`
+	GreenStar bool   `json:"green_star,omitempty"`
+	Cuisine   string `json:"cuisine,omitempty"`
+	Price     string `json:"price,omitempty"`
+	Location  string `json:"location,omitempty"`
+	Address   string `json:"address,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+func writeGeoJSON(w io.Writer, places []Place) error {
+	collection := geoJSONCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	for _, p := range places {
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type: "Feature",
+			// GeoJSON positions are longitude first
+			Geometry: geoJSONGeometry{Type: "Point", Coordinates: [2]float64{p.Longitude, p.Latitude}},
+			Properties: geoJSONProperties{
+				Name: p.Name, Award: p.Award, GreenStar: p.GreenStar, Cuisine: p.Cuisine,
+				Price: p.Price, Location: p.Location, Address: p.Address, URL: p.URL,
+			},
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(collection); err != nil {
+		return fmt.Errorf("failed to write GeoJSON: %v", err)
+	}
+	return nil
+}
+
+type kmlDocument struct {
+	XMLName    xml.Name       `xml:"kml"`
+	Xmlns      string         `xml:"xmlns,attr"`
+	Name       string         `xml:"Document>name"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name        string    `xml:"name"`
+	Description string    `xml:"description,omitempty"`
+	Data        []kmlData `xml:"ExtendedData>Data"`
+	Coordinates string    `xml:"Point>coordinates"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+func writeKML(w io.Writer, title string, places []Place) error {
+	doc := kmlDocument{Xmlns: "http://www.opengis.net/kml/2.2", Name: title}
+	for _, p := range places {
+		description := p.Summary()
+		if p.Address != "" {
+			description = strings.TrimPrefix(description+"\n"+p.Address, "\n")
+		}
+		if p.URL != "" {
+			description = strings.TrimPrefix(description+"\n"+p.URL, "\n")
+		}
+
+		var data []kmlData
+		for _, field := range [][2]string{
+			{"award", p.Award}, {"cuisine", p.Cuisine}, {"price", p.Price}, {"location", p.Location}, {"url", p.URL},
+		} {
+			if field[1] != "" {
+				data = append(data, kmlData{Name: field[0], Value: field[1]})
+			}
+		}
+		if p.GreenStar {
+			data = append(data, kmlData{Name: "green_star", Value: "true"})
+		}
+
+		doc.Placemarks = append(doc.Placemarks, kmlPlacemark{
+			Name:        p.Name,
+			Description: description,
+			Data:        data,
+			// KML coordinates are longitude,latitude
+			Coordinates: formatFloat(p.Longitude) + "," + formatFloat(p.Latitude),
+		})
+	}
+
+	return writeXML(w, doc, "KML")
+}
+
+type gpxDocument struct {
+	XMLName   xml.Name      `xml:"gpx"`
+	Version   string        `xml:"version,attr"`
+	Creator   string        `xml:"creator,attr"`
+	Xmlns     string        `xml:"xmlns,attr"`
+	Name      string        `xml:"metadata>name"`
+	Waypoints []gpxWaypoint `xml:"wpt"`
+}
+
+type gpxWaypoint struct {
+	Latitude    string   `xml:"lat,attr"`
+	Longitude   string   `xml:"lon,attr"`
+	Name        string   `xml:"name"`
+	Description string   `xml:"desc,omitempty"`
+	Link        *gpxLink `xml:"link,omitempty"`
+	Type        string   `xml:"type,omitempty"`
+}
+
+type gpxLink struct {
+	Href string `xml:"href,attr"`
+	Text string `xml:"text"`
+}
+
+func writeGPX(w io.Writer, title string, places []Place) error {
+	doc := gpxDocument{Version: "1.1", Creator: "alfred-michelin", Xmlns: "http://www.topografix.com/GPX/1/1", Name: title}
+	for _, p := range places {
+		waypoint := gpxWaypoint{
+			Latitude:    formatFloat(p.Latitude),
+			Longitude:   formatFloat(p.Longitude),
+			Name:        p.Name,
+			Description: p.Summary(),
+			Type:        p.Award,
+		}
+		if p.URL != "" {
+			waypoint.Link = &gpxLink{Href: p.URL, Text: "Michelin Guide"}
+		}
+		doc.Waypoints = append(doc.Waypoints, waypoint)
+	}
+
+	return writeXML(w, doc, "GPX")
+}
+
+func writeXML(w io.Writer, doc interface{}, name string) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
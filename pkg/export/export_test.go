@@ -0,0 +1,229 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/giovanni/alfred-michelin/db"
+)
+
+func str(s string) *string { return &s }
+
+func samplePlaces(t *testing.T) []Place {
+	green := true
+	restaurants := []db.Restaurant{
+		{Name: str("Noma"), Latitude: str("55.6828"), Longitude: str("12.6104"), CurrentAward: str("3 Stars"),
+			CurrentGreenStar: &green, Cuisine: str("Creative"), CurrentPrice: str("€€€€"),
+			Url: str("https://guide.michelin.com/en/hovedstaden/kbenhavn/restaurant/noma")},
+		{Name: str("Ox & Klee"), Latitude: str("50.927"), Longitude: str("6.965"), CurrentAward: str("2 Stars")},
+		{Name: str("No coordinates")},
+		{Name: str("Bad coordinates"), Latitude: str("north"), Longitude: str("9.19")},
+	}
+
+	places, skipped := Places(restaurants)
+	if len(places) != 2 || skipped != 2 {
+		t.Fatalf("Places() = %d places, %d skipped, expected 2 and 2", len(places), skipped)
+	}
+	return places
+}
+
+func TestParseFormat(t *testing.T) {
+	cases := []struct {
+		Got      string
+		Expected Format
+		WantErr  bool
+	}{
+		{"geojson", GeoJSON, false},
+		{"JSON", GeoJSON, false},
+		{".kml", KML, false},
+		{"gpx", GPX, false},
+		{"csv", "", true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Got, func(t *testing.T) {
+			got, err := ParseFormat(tt.Got)
+			if (err != nil) != tt.WantErr || got != tt.Expected {
+				t.Errorf("ParseFormat(%q) = %q, %v, expected %q", tt.Got, got, err, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestWriteGeoJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, GeoJSON, "Trip", samplePlaces(t)); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+
+	var collection geoJSONCollection
+	if err := json.Unmarshal(buf.Bytes(), &collection); err != nil {
+		t.Fatalf("invalid GeoJSON: %v", err)
+	}
+	if len(collection.Features) != 2 {
+		t.Fatalf("expected 2 features, got %d", len(collection.Features))
+	}
+	noma := collection.Features[0]
+	if noma.Geometry.Coordinates != [2]float64{12.6104, 55.6828} {
+		t.Errorf("coordinates = %v, expected longitude first", noma.Geometry.Coordinates)
+	}
+	if noma.Properties.Award != "3 Stars" || noma.Properties.Price != "€€€€" || !strings.HasSuffix(noma.Properties.URL, "/noma") {
+		t.Errorf("unexpected properties %+v", noma.Properties)
+	}
+}
+
+func TestWriteXML(t *testing.T) {
+	for _, format := range []Format{KML, GPX} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, format, "Trip", samplePlaces(t)); err != nil {
+				t.Fatalf("Write returned error: %v", err)
+			}
+
+			decoder := xml.NewDecoder(&buf)
+			names := 0
+			for {
+				token, err := decoder.Token()
+				if err != nil {
+					if err != io.EOF {
+						t.Fatalf("invalid %s: %v", format, err)
+					}
+					break
+				}
+				if start, ok := token.(xml.StartElement); ok && (start.Name.Local == "Placemark" || start.Name.Local == "wpt") {
+					names++
+				}
+			}
+			if names != 2 {
+				t.Errorf("expected 2 places in %s, got %d", format, names)
+			}
+		})
+	}
+}
+
+func TestPlaceCategory(t *testing.T) {
+	cases := []struct {
+		Got      Place
+		Expected string
+	}{
+		{Place{Award: "3 Stars"}, "three"},
+		{Place{Award: "2 Stars", GreenStar: true}, "two"},
+		{Place{Award: "1 Star"}, "one"},
+		{Place{Award: "Bib Gourmand"}, "bib"},
+		{Place{Award: "Selected Restaurants"}, "selected"},
+		{Place{Award: "Green Star"}, "green"},
+		{Place{}, "other"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Got.Award, func(t *testing.T) {
+			if got := tt.Got.Category(); got != tt.Expected {
+				t.Errorf("Category() = %q, expected %q", got, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestWriteHTMLMapEscapes(t *testing.T) {
+	places := []Place{{Name: "</script><b>Bad</b>", Latitude: 45.46, Longitude: 9.19}}
+
+	var buf bytes.Buffer
+	if err := WriteHTMLMap(&buf, "Trip <1>", places); err != nil {
+		t.Fatalf("WriteHTMLMap returned error: %v", err)
+	}
+	html := buf.String()
+	if strings.Contains(html, "</script><b>") || strings.Contains(html, "Trip <1>") {
+		t.Errorf("WriteHTMLMap did not escape names and title")
+	}
+	if !strings.Contains(html, `"lat":45.46`) {
+		t.Errorf("WriteHTMLMap did not embed the coordinates")
+	}
+}
+
+func TestWriteVCard(t *testing.T) {
+	restaurants := []db.Restaurant{{
+		Name: str("Ox & Klee"), Address: str("Im Zollhafen 18; Kranhaus 1"), Location: str("Cologne, Germany"),
+		Latitude: str("50.927"), Longitude: str("6.965"), PhoneNumber: str("+4922116956030"),
+		WebsiteUrl: str("https://www.oxundklee.de"), CurrentAward: str("2 Stars"), Cuisine: str("Creative, Modern"),
+		Url: str("https://guide.michelin.com/en/nordrhein-westfalen/kln/restaurant/ox-klee-with-a-long-name-to-fold"),
+	}}
+
+	var buf bytes.Buffer
+	if err := WriteVCard(&buf, restaurants); err != nil {
+		t.Fatalf("WriteVCard returned error: %v", err)
+	}
+	vcard := buf.String()
+
+	for _, line := range []string{
+		"BEGIN:VCARD\r\n",
+		"FN:Ox & Klee\r\n",
+		"ADR;TYPE=WORK:;;Im Zollhafen 18\\; Kranhaus 1;Cologne;;;Germany\r\n",
+		"GEO:50.927;6.965\r\n",
+		"TEL;TYPE=WORK,VOICE:+4922116956030\r\n",
+		"URL:https://www.oxundklee.de\r\n",
+		"NOTE:2 Stars · Creative\\, Modern\r\n",
+		"END:VCARD\r\n",
+	} {
+		if !strings.Contains(vcard, line) {
+			t.Errorf("vCard is missing %q:\n%s", line, vcard)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(vcard, "\r\n"), "\r\n") {
+		if len(line) > maxLineLength {
+			t.Errorf("line longer than %d octets: %q", maxLineLength, line)
+		}
+	}
+	unfolded := strings.ReplaceAll(vcard, "\r\n ", "")
+	if !strings.Contains(unfolded, "item1.URL:"+*restaurants[0].Url+"\r\n") {
+		t.Errorf("folded Michelin URL does not unfold to the original:\n%s", vcard)
+	}
+}
+
+func TestWriteICS(t *testing.T) {
+	party, spend := 6, 840.5
+	visits := []db.CalendarVisit{
+		{
+			Visit: db.UserVisit{ID: 7, VisitedDate: str("2025-11-02"), Notes: str("Team dinner, Q4"), PartySize: &party, Spend: &spend},
+			Restaurant: db.Restaurant{Name: str("Seta"), Address: str("Via Andegari 9"), Location: str("Milan, Italy"),
+				Latitude: str("45.469"), Longitude: str("9.19"), CurrentAward: str("2 Stars"),
+				Url: str("https://guide.michelin.com/en/it/lombardia/milano/restaurant/seta")},
+		},
+		{Visit: db.UserVisit{ID: 8, VisitedDate: str("someday")}, Restaurant: db.Restaurant{Name: str("Undated")}},
+	}
+
+	var buf bytes.Buffer
+	count, err := WriteICS(&buf, "Michelin visits", visits, time.Date(2025, 11, 3, 9, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("WriteICS returned error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("WriteICS wrote %d events, expected 1", count)
+	}
+
+	ics := strings.ReplaceAll(buf.String(), "\r\n ", "")
+	for _, line := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"UID:visit-7@alfred-michelin\r\n",
+		"DTSTAMP:20251103T093000Z\r\n",
+		"DTSTART;VALUE=DATE:20251102\r\n",
+		"DTEND;VALUE=DATE:20251103\r\n",
+		"SUMMARY:Seta\r\n",
+		"LOCATION:Via Andegari 9\\, Milan\\, Italy\r\n",
+		"GEO:45.469;9.19\r\n",
+		"URL:https://guide.michelin.com/en/it/lombardia/milano/restaurant/seta\r\n",
+		"DESCRIPTION:Team dinner\\, Q4\\nParty: 6\\nSpend: 840.5\\n2 Stars\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, line) {
+			t.Errorf("iCalendar is missing %q:\n%s", line, ics)
+		}
+	}
+	if strings.Contains(ics, "Undated") {
+		t.Errorf("iCalendar includes a visit without a valid date")
+	}
+}
@@ -0,0 +1,94 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/giovanni/alfred-michelin/db"
+)
+
+// WriteICS writes visits as all-day events of an iCalendar file named title. stamp is the time of
+// the export, written as the DTSTAMP of every event. Visits whose date does not parse are left out;
+// it returns the number of events written.
+func WriteICS(w io.Writer, title string, visits []db.CalendarVisit, stamp time.Time) (int, error) {
+	var b strings.Builder
+	writeContentLine(&b, "BEGIN:VCALENDAR")
+	writeContentLine(&b, "VERSION:2.0")
+	writeContentLine(&b, "PRODID:-//alfred-michelin//Visits//EN")
+	writeContentLine(&b, "CALSCALE:GREGORIAN")
+	writeContentLine(&b, "X-WR-CALNAME:"+escapeText(title))
+
+	count := 0
+	for _, cv := range visits {
+		date, err := time.Parse(db.VisitDateLayout, value(cv.Visit.VisitedDate))
+		if err != nil {
+			continue
+		}
+		r := cv.Restaurant
+
+		writeContentLine(&b, "BEGIN:VEVENT")
+		// The visit ID keeps the UID stable, so importing a later export updates the same events
+		writeContentLine(&b, fmt.Sprintf("UID:visit-%d@alfred-michelin", cv.Visit.ID))
+		writeContentLine(&b, "DTSTAMP:"+stamp.UTC().Format("20060102T150405Z"))
+		writeContentLine(&b, "DTSTART;VALUE=DATE:"+date.Format("20060102"))
+		writeContentLine(&b, "DTEND;VALUE=DATE:"+date.AddDate(0, 0, 1).Format("20060102"))
+		writeContentLine(&b, "SUMMARY:"+escapeText(value(r.Name)))
+
+		var location []string
+		for _, part := range []string{value(r.Address), value(r.Location)} {
+			if part != "" {
+				location = append(location, part)
+			}
+		}
+		if len(location) > 0 {
+			writeContentLine(&b, "LOCATION:"+escapeText(strings.Join(location, ", ")))
+		}
+		if lat, lon, ok := coordinates(r); ok {
+			writeContentLine(&b, "GEO:"+formatFloat(lat)+";"+formatFloat(lon))
+		}
+		if url := value(r.Url); url != "" {
+			writeContentLine(&b, "URL:"+url)
+		}
+		if description := visitDescription(cv); description != "" {
+			writeContentLine(&b, "DESCRIPTION:"+escapeText(description))
+		}
+		writeContentLine(&b, "END:VEVENT")
+		count++
+	}
+	writeContentLine(&b, "END:VCALENDAR")
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return 0, fmt.Errorf("failed to write iCalendar: %v", err)
+	}
+	return count, nil
+}
+
+// visitDescription describes a visit for the calendar, one detail per line: the notes, the party
+// size, spend and rating, then the restaurant's award, cuisine, price and website
+func visitDescription(cv db.CalendarVisit) string {
+	var lines []string
+	if notes := value(cv.Visit.Notes); notes != "" {
+		lines = append(lines, notes)
+	}
+	if cv.Visit.PartySize != nil {
+		lines = append(lines, fmt.Sprintf("Party: %d", *cv.Visit.PartySize))
+	}
+	if cv.Visit.Spend != nil {
+		lines = append(lines, "Spend: "+strconv.FormatFloat(*cv.Visit.Spend, 'f', -1, 64))
+	}
+	if cv.Visit.Rating != nil {
+		lines = append(lines, fmt.Sprintf("Rating: %d/5", *cv.Visit.Rating))
+	}
+
+	r := cv.Restaurant
+	if summary := restaurantSummary(r); summary != "" {
+		lines = append(lines, summary)
+	}
+	if website := value(r.WebsiteUrl); website != "" {
+		lines = append(lines, website)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,325 @@
+package export
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// mapPoint is a place as embedded in the HTML map
+type mapPoint struct {
+	Name        string  `json:"name"`
+	Category    string  `json:"category"`
+	Award       string  `json:"award"`
+	GreenStar   bool    `json:"green"`
+	Cuisine     string  `json:"cuisine"`
+	Price       string  `json:"price"`
+	Address     string  `json:"address"`
+	Description string  `json:"description"`
+	ImageURL    string  `json:"image"`
+	WebsiteURL  string  `json:"website"`
+	URL         string  `json:"url"`
+	Latitude    float64 `json:"lat"`
+	Longitude   float64 `json:"lon"`
+}
+
+// Category groups a place by distinction for the marker colours of the HTML map
+func (p Place) Category() string {
+	award := strings.ToLower(p.Award)
+	switch {
+	case strings.Contains(award, "3 star"):
+		return "three"
+	case strings.Contains(award, "2 star"):
+		return "two"
+	case strings.Contains(award, "1 star"):
+		return "one"
+	case strings.Contains(award, "bib gourmand"):
+		return "bib"
+	case strings.Contains(award, "selected"):
+		return "selected"
+	case p.GreenStar || strings.Contains(award, "green star"):
+		return "green"
+	}
+	return "other"
+}
+
+// WriteHTMLMap writes a self-contained HTML page showing the places on a map.
+// Points are drawn as an SVG Web Mercator projection, so the page works offline;
+// OpenStreetMap tiles can be switched on as a background when online.
+func WriteHTMLMap(w io.Writer, title string, places []Place) error {
+	points := make([]mapPoint, 0, len(places))
+	for _, p := range places {
+		award := p.Award
+		if p.AwardYear > 0 {
+			award = fmt.Sprintf("%s (%d)", award, p.AwardYear)
+		}
+		points = append(points, mapPoint{
+			Name: p.Name, Category: p.Category(), Award: award, GreenStar: p.GreenStar,
+			Cuisine: p.Cuisine, Price: p.Price, Address: p.Address, Description: p.Description,
+			ImageURL: p.ImageURL, WebsiteURL: p.WebsiteURL, URL: p.URL,
+			Latitude: p.Latitude, Longitude: p.Longitude,
+		})
+	}
+
+	err := mapTemplate.Execute(w, struct {
+		Title  string
+		Points []mapPoint
+	}{title, points})
+	if err != nil {
+		return fmt.Errorf("failed to write map: %v", err)
+	}
+	return nil
+}
+
+var mapTemplate = template.Must(template.New("map").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+  html, body { margin: 0; height: 100%; font: 14px -apple-system, BlinkMacSystemFont, "Helvetica Neue", sans-serif; }
+  #map { position: absolute; inset: 0; width: 100%; height: 100%; background: #eef2f5; cursor: grab; touch-action: none; }
+  #map.dragging { cursor: grabbing; }
+  .grid { stroke: #d3dbe2; fill: none; }
+  .marker { cursor: pointer; stroke: #fff; }
+  .marker.green { stroke: #2e8b57; }
+  .count { fill: #fff; font-weight: 600; text-anchor: middle; dominant-baseline: central; pointer-events: none; }
+  .panel { position: absolute; background: rgba(255, 255, 255, 0.95); border-radius: 8px; box-shadow: 0 1px 4px rgba(0, 0, 0, 0.25); padding: 8px 12px; }
+  #header { top: 12px; left: 12px; }
+  #header h1 { font-size: 16px; margin: 0 0 4px; }
+  #legend { bottom: 12px; left: 12px; line-height: 1.6; }
+  #legend span { display: inline-block; width: 10px; height: 10px; border-radius: 50%; margin-right: 6px; border: 2px solid #fff; }
+  #attribution { bottom: 12px; right: 12px; font-size: 11px; display: none; }
+  #popup { display: none; max-width: 320px; max-height: 60%; overflow: auto; }
+  #popup img { max-width: 100%; border-radius: 4px; margin-top: 6px; }
+  #popup h2 { font-size: 15px; margin: 0 16px 4px 0; }
+  #popup p { margin: 6px 0; }
+  #popup .close { position: absolute; top: 4px; right: 8px; cursor: pointer; color: #888; }
+</style>
+</head>
+<body>
+<svg id="map" xmlns="http://www.w3.org/2000/svg"><g id="tiles"></g><g id="grid"></g><g id="markers"></g></svg>
+<div id="header" class="panel">
+  <h1>{{.Title}}</h1>
+  <div id="summary"></div>
+  <label><input type="checkbox" id="tileToggle"> Online map tiles</label>
+</div>
+<div id="legend" class="panel"></div>
+<div id="attribution" class="panel">© <a href="https://www.openstreetmap.org/copyright" target="_blank">OpenStreetMap</a> contributors</div>
+<div id="popup" class="panel"></div>
+<script>
+"use strict";
+var points = {{.Points}};
+var categories = [
+  ["three", "3 Stars", "#7b0d1e"],
+  ["two", "2 Stars", "#c8102e"],
+  ["one", "1 Star", "#ef6f3c"],
+  ["bib", "Bib Gourmand", "#f2a541"],
+  ["selected", "Selected", "#6c757d"],
+  ["green", "Green Star", "#2e8b57"],
+  ["other", "Other", "#9aa5b1"]
+];
+var rank = {}, colors = {};
+categories.forEach(function (c, i) { rank[c[0]] = i; colors[c[0]] = c[2]; });
+
+var TILE = 256, CLUSTER_PX = 30;
+var svg = document.getElementById("map");
+var ns = "http://www.w3.org/2000/svg";
+var showTiles = false;
+var view = { x: 0, y: 0, w: TILE, h: TILE };
+
+function project(lat, lon) {
+  var s = Math.sin(Math.max(-85, Math.min(85, lat)) * Math.PI / 180);
+  return [(lon + 180) / 360 * TILE, (0.5 - Math.log((1 + s) / (1 - s)) / (4 * Math.PI)) * TILE];
+}
+function unprojectLat(y) {
+  return Math.atan(Math.sinh(Math.PI * (1 - 2 * y / TILE))) * 180 / Math.PI;
+}
+function esc(s) {
+  return String(s).replace(/[&<>"']/g, function (c) {
+    return { "&": "&amp;", "<": "&lt;", ">": "&gt;", "\"": "&quot;", "'": "&#39;" }[c];
+  });
+}
+function el(name, attrs, parent) {
+  var e = document.createElementNS(ns, name);
+  for (var k in attrs) e.setAttribute(k, attrs[k]);
+  parent.appendChild(e);
+  return e;
+}
+function scale() { return svg.clientWidth / view.w; }
+
+points.forEach(function (p) { var xy = project(p.lat, p.lon); p.x = xy[0]; p.y = xy[1]; });
+points.sort(function (a, b) { return rank[a.category] - rank[b.category]; });
+
+// fit shows the given points with some padding, keeping the aspect ratio of the window
+function fit(list) {
+  var minX = Infinity, minY = Infinity, maxX = -Infinity, maxY = -Infinity;
+  list.forEach(function (p) {
+    minX = Math.min(minX, p.x); maxX = Math.max(maxX, p.x);
+    minY = Math.min(minY, p.y); maxY = Math.max(maxY, p.y);
+  });
+  var w = Math.max(maxX - minX, 0.002) * 1.3, h = Math.max(maxY - minY, 0.002) * 1.3;
+  var aspect = svg.clientWidth / svg.clientHeight;
+  if (w / h < aspect) w = h * aspect; else h = w / aspect;
+  view = { x: (minX + maxX - w) / 2, y: (minY + maxY - h) / 2, w: w, h: h };
+  render();
+}
+
+function drawTiles(group) {
+  var z = Math.max(0, Math.min(19, Math.round(Math.log2(scale()))));
+  var n = Math.pow(2, z), size = TILE / n;
+  for (var tx = Math.floor(view.x / size); tx * size < view.x + view.w; tx++) {
+    for (var ty = Math.max(0, Math.floor(view.y / size)); ty * size < view.y + view.h && ty < n; ty++) {
+      var wrapped = ((tx % n) + n) % n;
+      el("image", {
+        href: "https://tile.openstreetmap.org/" + z + "/" + wrapped + "/" + ty + ".png",
+        x: tx * size, y: ty * size, width: size * 1.002, height: size * 1.002
+      }, group);
+    }
+  }
+}
+
+function drawGrid(group) {
+  var west = view.x / TILE * 360 - 180, east = (view.x + view.w) / TILE * 360 - 180;
+  var steps = [30, 10, 5, 2, 1, 0.5, 0.2, 0.1, 0.05, 0.02, 0.01, 0.005, 0.002, 0.001];
+  var step = steps.find(function (s) { return (east - west) / s >= 4; }) || 0.001;
+  var px = 1 / scale();
+  for (var lon = Math.ceil(west / step) * step; lon <= east; lon += step) {
+    var x = (lon + 180) / 360 * TILE;
+    el("line", { x1: x, x2: x, y1: view.y, y2: view.y + view.h, class: "grid", "stroke-width": px }, group);
+  }
+  var south = unprojectLat(view.y + view.h), north = unprojectLat(view.y);
+  for (var lat = Math.ceil(south / step) * step; lat <= north; lat += step) {
+    var y = project(lat, 0)[1];
+    el("line", { x1: view.x, x2: view.x + view.w, y1: y, y2: y, class: "grid", "stroke-width": px }, group);
+  }
+}
+
+// cluster groups points closer than CLUSTER_PX on screen; the best distinction leads each cluster
+function cluster() {
+  var radius = CLUSTER_PX / scale(), clusters = [];
+  points.forEach(function (p) {
+    var c = clusters.find(function (c) { return Math.abs(c.x - p.x) < radius && Math.abs(c.y - p.y) < radius; });
+    if (c) c.members.push(p); else clusters.push({ x: p.x, y: p.y, members: [p] });
+  });
+  return clusters;
+}
+
+function render() {
+  svg.setAttribute("viewBox", [view.x, view.y, view.w, view.h].join(" "));
+  ["tiles", "grid", "markers"].forEach(function (id) { document.getElementById(id).innerHTML = ""; });
+  if (showTiles) drawTiles(document.getElementById("tiles")); else drawGrid(document.getElementById("grid"));
+
+  var px = 1 / scale(), markers = document.getElementById("markers");
+  cluster().forEach(function (c) {
+    var lead = c.members[0];
+    var green = c.members.some(function (p) { return p.green; });
+    var r = (c.members.length > 1 ? 11 + Math.min(8, Math.log2(c.members.length) * 2) : 7) * px;
+    var circle = el("circle", {
+      cx: c.x, cy: c.y, r: r, fill: colors[lead.category],
+      class: "marker" + (green ? " green" : ""), "stroke-width": 2.5 * px
+    }, markers);
+    if (c.members.length > 1) {
+      var label = el("text", { x: c.x, y: c.y, class: "count", "font-size": 11 * px }, markers);
+      label.textContent = c.members.length;
+      circle.addEventListener("click", function (e) { e.stopPropagation(); zoomTo(c.members); });
+    } else {
+      el("title", {}, circle).textContent = lead.name;
+      circle.addEventListener("click", function (e) { e.stopPropagation(); showPopup(lead, e); });
+    }
+  });
+}
+
+function zoomTo(members) {
+  var same = members.every(function (p) { return p.x === members[0].x && p.y === members[0].y; });
+  if (same) {
+    zoom(members[0].x, members[0].y, 0.25);
+  } else {
+    fit(members);
+  }
+}
+
+function zoom(cx, cy, factor) {
+  if (view.w * factor < 0.0005 || view.w * factor > TILE * 4) return;
+  view.x = cx - (cx - view.x) * factor;
+  view.y = cy - (cy - view.y) * factor;
+  view.w *= factor;
+  view.h *= factor;
+  render();
+}
+
+function showPopup(p, e) {
+  var popup = document.getElementById("popup");
+  var award = p.award + (p.green ? " 🍀" : "");
+  var html = "<span class=\"close\">✕</span><h2>" + esc(p.name) + "</h2>";
+  if (p.image) html += "<img src=\"" + esc(p.image) + "\" alt=\"\" onerror=\"this.remove()\">";
+  html += "<p>🏆 " + esc(award || "No Michelin distinction") + "</p>";
+  var details = [p.cuisine, p.price].filter(Boolean).join(" · ");
+  if (details) html += "<p>" + esc(details) + "</p>";
+  if (p.description) html += "<p>" + esc(p.description) + "</p>";
+  if (p.address) html += "<p>📍 " + esc(p.address) + "</p>";
+  if (p.website) html += "<p>🔗 <a href=\"" + esc(p.website) + "\" target=\"_blank\">" + esc(p.website) + "</a></p>";
+  if (p.url) html += "<p>🌟 <a href=\"" + esc(p.url) + "\" target=\"_blank\">Michelin Guide</a></p>";
+  popup.innerHTML = html;
+  popup.style.display = "block";
+  popup.style.left = Math.min(e.clientX + 12, window.innerWidth - 340) + "px";
+  popup.style.top = Math.max(12, Math.min(e.clientY - 20, window.innerHeight - popup.offsetHeight - 12)) + "px";
+  popup.querySelector(".close").addEventListener("click", hidePopup);
+}
+function hidePopup() { document.getElementById("popup").style.display = "none"; }
+
+var drag = null;
+svg.addEventListener("pointerdown", function (e) {
+  drag = { x: e.clientX, y: e.clientY, moved: false };
+  svg.setPointerCapture(e.pointerId);
+});
+svg.addEventListener("pointermove", function (e) {
+  if (!drag) return;
+  var dx = e.clientX - drag.x, dy = e.clientY - drag.y;
+  if (!drag.moved && Math.abs(dx) + Math.abs(dy) < 3) return;
+  drag.moved = true;
+  svg.classList.add("dragging");
+  view.x -= dx / scale();
+  view.y -= dy / scale();
+  drag.x = e.clientX;
+  drag.y = e.clientY;
+  render();
+});
+svg.addEventListener("pointerup", function () {
+  if (drag && !drag.moved) hidePopup();
+  drag = null;
+  svg.classList.remove("dragging");
+});
+svg.addEventListener("wheel", function (e) {
+  e.preventDefault();
+  var rect = svg.getBoundingClientRect();
+  var cx = view.x + (e.clientX - rect.left) / scale();
+  var cy = view.y + (e.clientY - rect.top) / scale();
+  zoom(cx, cy, Math.exp(e.deltaY * 0.002));
+}, { passive: false });
+window.addEventListener("resize", function () {
+  view.h = view.w * svg.clientHeight / svg.clientWidth;
+  render();
+});
+document.getElementById("tileToggle").addEventListener("change", function (e) {
+  showTiles = e.target.checked;
+  document.getElementById("attribution").style.display = showTiles ? "block" : "none";
+  render();
+});
+
+var counts = {};
+points.forEach(function (p) { counts[p.category] = (counts[p.category] || 0) + 1; });
+document.getElementById("legend").innerHTML = categories.filter(function (c) { return counts[c[0]]; }).map(function (c) {
+  return "<div><span style=\"background:" + c[2] + "\"></span>" + c[1] + " (" + counts[c[0]] + ")</div>";
+}).join("");
+if (points.some(function (p) { return p.green; })) {
+  document.getElementById("legend").innerHTML += "<div><span style=\"background:#fff;border-color:#2e8b57\"></span>Green Star</div>";
+}
+document.getElementById("summary").textContent = points.length + (points.length === 1 ? " restaurant" : " restaurants");
+fit(points);
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,88 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/giovanni/alfred-michelin/db"
+)
+
+// maxLineLength is the longest content line of a vCard or iCalendar file in octets, longer lines
+// are folded
+const maxLineLength = 75
+
+// WriteVCard writes restaurants as vCard 3.0 contacts, which Contacts imports as companies
+func WriteVCard(w io.Writer, restaurants []db.Restaurant) error {
+	var b strings.Builder
+	for _, r := range restaurants {
+		name := value(r.Name)
+		writeContentLine(&b, "BEGIN:VCARD")
+		writeContentLine(&b, "VERSION:3.0")
+		writeContentLine(&b, "N:"+escapeText(name)+";;;;")
+		writeContentLine(&b, "FN:"+escapeText(name))
+		writeContentLine(&b, "ORG:"+escapeText(name))
+		writeContentLine(&b, "X-ABShowAs:COMPANY")
+
+		if address := value(r.Address); address != "" {
+			// The guide's addresses are one line, so they go in the street with the location's
+			// city and country next to them
+			city, country := splitLocation(value(r.Location))
+			writeContentLine(&b, "ADR;TYPE=WORK:;;"+escapeText(address)+";"+escapeText(city)+";;;"+escapeText(country))
+		}
+		if lat, lon, ok := coordinates(r); ok {
+			writeContentLine(&b, "GEO:"+formatFloat(lat)+";"+formatFloat(lon))
+		}
+		if phone := value(r.PhoneNumber); phone != "" {
+			writeContentLine(&b, "TEL;TYPE=WORK,VOICE:"+escapeText(phone))
+		}
+		if website := value(r.WebsiteUrl); website != "" {
+			writeContentLine(&b, "URL:"+website)
+		}
+		if url := value(r.Url); url != "" {
+			writeContentLine(&b, "item1.URL:"+url)
+			writeContentLine(&b, "item1.X-ABLabel:Michelin Guide")
+		}
+		if summary := restaurantSummary(r); summary != "" {
+			writeContentLine(&b, "NOTE:"+escapeText(summary))
+		}
+		writeContentLine(&b, "END:VCARD")
+	}
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("failed to write vCard: %v", err)
+	}
+	return nil
+}
+
+// splitLocation splits a location such as "Paris, France" into its city and country
+func splitLocation(location string) (string, string) {
+	city, country, found := strings.Cut(location, ", ")
+	if !found {
+		return location, ""
+	}
+	return city, country
+}
+
+// escapeText escapes a text value of a vCard or iCalendar property
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// writeContentLine writes a line ending in CRLF, folded into lines of at most maxLineLength octets
+// that continue with a space, without splitting a UTF-8 character
+func writeContentLine(b *strings.Builder, line string) {
+	length := 0
+	for _, c := range line {
+		size := utf8.RuneLen(c)
+		if length+size > maxLineLength {
+			b.WriteString("\r\n ")
+			// The leading space counts towards the length of a continuation line
+			length = 1
+		}
+		b.WriteRune(c)
+		length += size
+	}
+	b.WriteString("\r\n")
+}
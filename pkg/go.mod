@@ -3,6 +3,6 @@ module github.com/giovanni/alfred-michelin
 go 1.23.4
 
 require (
-	github.com/mattn/go-sqlite3 v1.14.28 // indirect
-	golang.org/x/text v0.26.0 // indirect
+	github.com/mattn/go-sqlite3 v1.14.28
+	golang.org/x/text v0.26.0
 )
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"archive/zip"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -9,12 +8,14 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/giovanni/alfred-michelin/db"
+	"github.com/giovanni/alfred-michelin/export"
 )
 
 // Constants
@@ -26,6 +27,9 @@ const (
 	Icon         = "Icon"
 	Valid        = "Valid"
 	Autocomplete = "Autocomplete"
+
+	// workflowBundleID names the workflow's data folder when run outside Alfred
+	workflowBundleID = "com.giovanni.alfred-michelin"
 )
 
 // Mod represents modifier-specific configuration
@@ -92,10 +96,16 @@ func initializeDatabase() error {
 		zipExists = true
 	}
 
+	dbPath := filepath.Join(workflowDataDir, db.DbFileName)
 	if !zipExists {
 		// Check if michelin.db exists in workflow data folder
-		dbPath := filepath.Join(workflowDataDir, "michelin.db")
 		if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+			// An interrupted update of an older version may have left only a backup
+			if backup, err := db.RestoreLatestBackup(dbPath); err == nil && backup != "" {
+				fmt.Fprintf(os.Stderr, "[WARNING] Database was missing, restored %s\n", backup)
+				return nil
+			}
+
 			// Database doesn't exist, show error
 			items := []AlfredItem{
 				{
@@ -112,50 +122,31 @@ func initializeDatabase() error {
 		return nil
 	}
 
-	// 3. Zip file exists, extract and move database
-	fmt.Fprintf(os.Stderr, "[DEBUG] Found michelin.db.zip, extracting...\n")
-
-	// Create temporary directory for extraction
-	tempDir, err := os.MkdirTemp("", "michelin_extract_")
+	// 3. Zip file exists, install it keeping the user data of an existing database
+	unlock, err := db.Lock(dbPath)
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	// Extract the zip file
-	extractedDbPath := filepath.Join(tempDir, "michelin.db")
-	if err := extractZipFile(zipPath, extractedDbPath); err != nil {
-		return fmt.Errorf("failed to extract zip file: %v", err)
+		return err
 	}
-
-	// Check if extracted database exists
-	if _, err := os.Stat(extractedDbPath); os.IsNotExist(err) {
-		return fmt.Errorf("no michelin.db found in zip file")
+	defer unlock()
+	if _, err := os.Stat(zipPath); err != nil {
+		// Another run installed it while this one waited
+		return nil
 	}
 
-	// Move to workflow data directory
-	targetDbPath := filepath.Join(workflowDataDir, "michelin.db")
-
-	// Check if database already exists and preserve user data
-	if _, err := os.Stat(targetDbPath); err == nil {
-		backupPath := filepath.Join(workflowDataDir, "michelin_backup.db")
-		if err := os.Rename(targetDbPath, backupPath); err != nil {
-			return fmt.Errorf("failed to create backup of existing database: %v", err)
+	fmt.Fprintf(os.Stderr, "[DEBUG] Found michelin.db.zip, updating database...\n")
+	if err := db.UpdateFromZip(dbPath, zipPath); err != nil {
+		if _, statErr := os.Stat(dbPath); statErr != nil {
+			return fmt.Errorf("failed to install database: %v", err)
 		}
-		fmt.Fprintf(os.Stderr, "[DEBUG] Created backup of existing database\n")
-
-		// Preserve user data from backup to new database
-		if err := preserveUserData(backupPath, extractedDbPath); err != nil {
-			fmt.Fprintf(os.Stderr, "[WARNING] Failed to preserve user data: %v\n", err)
-			// Continue anyway, but log the warning
-		} else {
-			fmt.Fprintf(os.Stderr, "[DEBUG] User data preserved successfully\n")
+		// The current database is still in place, keep using it
+		fmt.Fprintf(os.Stderr, "[ERROR] Database update failed: %v\n", err)
+		if db.IsUpdateRejected(err) {
+			// A refused archive will not become acceptable, don't check it on every run
+			if err := os.Remove(zipPath); err != nil {
+				fmt.Fprintf(os.Stderr, "[WARNING] Failed to remove rejected zip file: %v\n", err)
+			}
 		}
-	}
-
-	// Copy new database to target location
-	if err := copyFile(extractedDbPath, targetDbPath); err != nil {
-		return fmt.Errorf("failed to copy database to target location: %v", err)
+		return nil
 	}
 
 	fmt.Fprintf(os.Stderr, "[DEBUG] Database updated successfully\n")
@@ -171,6 +162,60 @@ func initializeDatabase() error {
 	return nil
 }
 
+// checkForUpdates installs a database update from UPDATE_SOURCE, if there is one. A run that finds
+// another one updating skips the check instead of waiting, so typing stays responsive.
+func checkForUpdates(dbPath string) {
+	unlock, ok, err := db.TryLock(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] Database update failed: %v\n", err)
+		return
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Another run is updating the database, skipping update check\n")
+		return
+	}
+	defer unlock()
+
+	fmt.Fprintf(os.Stderr, "[DEBUG] Checking for database updates...\n")
+	err = db.UpdateDatabase(dbPath)
+	if err != nil {
+		if db.IsNoUpdateAvailable(err) {
+			// Nothing new from UPDATE_SOURCE - this is normal, just log at debug level
+			fmt.Fprintf(os.Stderr, "[DEBUG] %v\n", err)
+		} else {
+			// Real error occurred during update - log it but continue
+			fmt.Fprintf(os.Stderr, "[ERROR] Database update failed: %v\n", err)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[DEBUG] Database update completed successfully\n")
+}
+
+// readOnlyCommands only search and list, so they open the database read-only
+var readOnlyCommands = map[string]bool{
+	"search":          true,
+	"back":            true,
+	"favorites":       true,
+	"visited":         true,
+	"visits":          true,
+	"award-history":   true,
+	"tags":            true,
+	"lists":           true,
+	"list-actions":    true,
+	"list":            true,
+	"export-list":     true,
+	"export":          true,
+	"map":             true,
+	"vcard":           true,
+	"ics":             true,
+	"changes":         true,
+	"matches":         true,
+	"locations":       true,
+	"status":          true,
+	"check-update":    true,
+	"showDescription": true,
+}
+
 // Main function
 func main() {
 	// Check command-line arguments
@@ -179,6 +224,13 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Run from a terminal, use the data folder Alfred keeps for the workflow
+	if os.Getenv("alfred_workflow_data") == "" {
+		if dir, err := defaultWorkflowDataDir(); err == nil {
+			os.Setenv("alfred_workflow_data", dir)
+		}
+	}
+
 	// Initialize database and check for updates
 	if err := initializeDatabase(); err != nil {
 		showError(fmt.Sprintf("Database initialization failed: %v", err))
@@ -198,40 +250,47 @@ func main() {
 		workflowDataDir = workDir // fallback to workflow directory
 	}
 	dbPath := filepath.Join(workflowDataDir, db.DbFileName)
-	database, err := db.Initialize(dbPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "[ERROR] Error initializing database: %v\n", err)
-		os.Exit(1)
-	}
-	defer database.Close()
 
 	// Skip CSV import for new database - data should already exist
 	// The new database comes pre-populated with restaurant data
 
 	// Automatically check for database updates before processing any commands
-	fmt.Fprintf(os.Stderr, "[DEBUG] Checking for database updates...\n")
-	err = db.UpdateDatabase(dbPath)
-	if err != nil {
-		if db.IsNoUpdateAvailable(err) {
-			// No update file found - this is normal, just log at debug level
-			fmt.Fprintf(os.Stderr, "[DEBUG] No database update file found\n")
-		} else {
-			// Real error occurred during update - log it but continue
-			fmt.Fprintf(os.Stderr, "[ERROR] Database update failed: %v\n", err)
-		}
-	} else {
-		fmt.Fprintf(os.Stderr, "[DEBUG] Database update completed successfully\n")
-		// Reinitialize database connection after update
-		database.Close()
-		database, err = db.Initialize(dbPath)
+	checkForUpdates(dbPath)
+
+	// Migrations only run when the schema is behind, not on every keystroke
+	if err := db.Prepare(dbPath); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] Error initializing database: %v\n", err)
+		os.Exit(1)
+	}
+
+	command := os.Args[1]
+
+	// An update renames a new file over the database, so it runs before this run opens a
+	// connection that would keep using the replaced file
+	if command == "update" {
+		handleUpdate(dbPath)
+		return
+	}
+
+	// Writing runs hold the lock shared, so that another run's update waits for them to finish
+	readOnly := readOnlyCommands[command]
+	if !readOnly {
+		unlock, err := db.LockShared(dbPath)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "[ERROR] Error reinitializing database after update: %v\n", err)
+			fmt.Fprintf(os.Stderr, "[ERROR] Error opening database: %v\n", err)
 			os.Exit(1)
 		}
+		defer unlock()
 	}
 
+	database, err := db.Open(dbPath, readOnly)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
 	// Process commands
-	command := os.Args[1]
 	switch command {
 	case "search":
 		var query string
@@ -278,7 +337,7 @@ func main() {
 		}
 		handleToggleFavorite(database, id)
 
-	case "toggle-visited":
+	case "add-visit":
 		if len(os.Args) < 3 {
 			showError("Missing restaurant ID")
 			return
@@ -288,12 +347,43 @@ func main() {
 			showError("Invalid restaurant ID")
 			return
 		}
-		date := time.Now().Format("2006-01-02")
-		notes := ""
-		if len(os.Args) > 3 {
-			notes = os.Args[3]
+		handleAddVisit(database, id, strings.Join(os.Args[3:], " "))
+
+	case "edit-visit":
+		if len(os.Args) < 4 {
+			showError("Usage: edit-visit <visit_id> [date] [party:N] [rating:1-5] [spend:X] [notes]")
+			return
+		}
+		visitID, err := strconv.ParseInt(os.Args[2], 10, 64)
+		if err != nil {
+			showError("Invalid visit ID")
+			return
+		}
+		handleEditVisit(database, visitID, strings.Join(os.Args[3:], " "))
+
+	case "delete-visit":
+		if len(os.Args) < 3 {
+			showError("Missing visit ID")
+			return
+		}
+		visitID, err := strconv.ParseInt(os.Args[2], 10, 64)
+		if err != nil {
+			showError("Invalid visit ID")
+			return
+		}
+		handleDeleteVisit(database, visitID)
+
+	case "visits":
+		if len(os.Args) < 3 {
+			showError("Missing restaurant ID")
+			return
+		}
+		id, err := strconv.ParseInt(os.Args[2], 10, 64)
+		if err != nil {
+			showError("Invalid restaurant ID")
+			return
 		}
-		handleToggleVisited(database, id, date, notes)
+		handleVisits(database, id, strings.TrimSpace(strings.Join(os.Args[3:], " ")))
 
 	case "favorites":
 		if len(os.Args) >= 3 {
@@ -392,15 +482,256 @@ func main() {
 			handleSearch(database, query)
 		}
 
+	case "rate":
+		if len(os.Args) < 4 {
+			showError("Usage: rate <restaurant_id> <1-5|clear> [review]")
+			return
+		}
+		id, err := strconv.ParseInt(os.Args[2], 10, 64)
+		if err != nil {
+			showError("Invalid restaurant ID")
+			return
+		}
+		// The rating and review may also come as one argument, as Alfred passes them
+		value, review, _ := strings.Cut(strings.TrimSpace(strings.Join(os.Args[3:], " ")), " ")
+		handleRate(database, id, value, strings.TrimSpace(review))
+
+	case "tag", "untag":
+		if len(os.Args) < 4 {
+			showError(fmt.Sprintf("Usage: %s <restaurant_id> <tag>[, <tag>...]", command))
+			return
+		}
+		id, err := strconv.ParseInt(os.Args[2], 10, 64)
+		if err != nil {
+			showError("Invalid restaurant ID")
+			return
+		}
+		handleTag(database, id, strings.Join(os.Args[3:], " "), command == "untag")
+
+	case "tags":
+		if len(os.Args) >= 3 {
+			id, err := strconv.ParseInt(os.Args[2], 10, 64)
+			if err != nil {
+				showError("Invalid restaurant ID")
+				return
+			}
+			handleRestaurantTags(database, id, strings.TrimSpace(strings.Join(os.Args[3:], " ")))
+		} else {
+			handleAllTags(database)
+		}
+
+	case "create-list":
+		if len(os.Args) < 3 {
+			showError("Usage: create-list <name>")
+			return
+		}
+		handleCreateList(database, strings.Join(os.Args[2:], " "))
+
+	case "rename-list":
+		if len(os.Args) < 4 {
+			showError("Usage: rename-list <list> <new name>")
+			return
+		}
+		handleRenameList(database, os.Args[2], strings.Join(os.Args[3:], " "))
+
+	case "delete-list":
+		if len(os.Args) < 3 {
+			showError("Usage: delete-list <list>")
+			return
+		}
+		handleDeleteList(database, os.Args[2])
+
+	case "add-to-list", "remove-from-list", "list-note":
+		if len(os.Args) < 4 {
+			showError(fmt.Sprintf("Usage: %s <list> <restaurant_id>", command))
+			return
+		}
+		id, err := strconv.ParseInt(os.Args[3], 10, 64)
+		if err != nil {
+			showError("Invalid restaurant ID")
+			return
+		}
+		notes := strings.Join(os.Args[4:], " ")
+		switch command {
+		case "add-to-list":
+			handleAddToList(database, os.Args[2], id, notes)
+		case "remove-from-list":
+			handleRemoveFromList(database, os.Args[2], id)
+		default:
+			handleListNote(database, os.Args[2], id, notes)
+		}
+
+	case "move-list-item":
+		if len(os.Args) < 5 {
+			showError("Usage: move-list-item <list> <restaurant_id> <position>")
+			return
+		}
+		id, err := strconv.ParseInt(os.Args[3], 10, 64)
+		if err != nil {
+			showError("Invalid restaurant ID")
+			return
+		}
+		position, err := strconv.Atoi(os.Args[4])
+		if err != nil {
+			showError("Invalid position")
+			return
+		}
+		handleMoveListItem(database, os.Args[2], id, position)
+
+	case "list-actions":
+		if len(os.Args) < 3 {
+			showError("Usage: list-actions <list> [input]")
+			return
+		}
+		handleListActions(database, os.Args[2], strings.TrimSpace(strings.Join(os.Args[3:], " ")))
+
+	case "lists":
+		query := ""
+		if len(os.Args) >= 3 {
+			query = os.Args[2]
+		}
+		handleLists(database, query)
+
+	case "list":
+		if len(os.Args) < 3 {
+			showError("Usage: list <list>")
+			return
+		}
+		handleList(database, os.Args[2])
+
+	case "export-list":
+		if len(os.Args) < 3 {
+			showError("Usage: export-list <list> [path.csv]")
+			return
+		}
+		path := ""
+		if len(os.Args) >= 4 {
+			path = os.Args[3]
+		}
+		handleExportList(database, os.Args[2], path)
+
+	case "export":
+		if len(os.Args) < 4 {
+			showError("Usage: export <geojson|kml|gpx> <search|favorites|visited|list> [query|list] [path]")
+			return
+		}
+		query, path := "", ""
+		if len(os.Args) >= 5 {
+			query = os.Args[4]
+		}
+		if len(os.Args) >= 6 {
+			path = os.Args[5]
+		}
+		handleExport(database, os.Args[2], os.Args[3], query, path)
+
+	case "map":
+		if len(os.Args) < 3 {
+			showError("Usage: map <search|favorites|visited|list> [query|list]")
+			return
+		}
+		query := ""
+		if len(os.Args) >= 4 {
+			query = os.Args[3]
+		}
+		handleMap(database, workflowDataDir, os.Args[2], query)
+
+	case "vcard":
+		if len(os.Args) < 3 {
+			showError("Usage: vcard <restaurant_id> [path.vcf]")
+			return
+		}
+		id, err := strconv.ParseInt(os.Args[2], 10, 64)
+		if err != nil {
+			showError("Invalid restaurant ID")
+			return
+		}
+		path := ""
+		if len(os.Args) >= 4 {
+			path = os.Args[3]
+		}
+		handleVCard(database, id, path)
+
+	case "ics":
+		handleICS(database, os.Args[2:])
+
+	case "changes":
+		query := ""
+		if len(os.Args) >= 3 {
+			query = strings.Join(os.Args[2:], " ")
+		}
+		handleChanges(database, query)
+
+	case "whats-new":
+		all := len(os.Args) >= 3 && os.Args[2] == "all"
+		handleWhatsNew(database, all)
+
+	case "status":
+		handleStatus(database, dbPath)
+
+	case "check-update":
+		handleCheckUpdate(dbPath)
+
+	case "matches":
+		handleMatches(database)
+
+	case "confirm-match", "dismiss-match":
+		if len(os.Args) < 3 {
+			showError(fmt.Sprintf("Usage: %s <match_id>", command))
+			return
+		}
+		id, err := strconv.ParseInt(os.Args[2], 10, 64)
+		if err != nil {
+			showError("Invalid match ID")
+			return
+		}
+		if command == "confirm-match" {
+			handleConfirmMatch(database, id)
+		} else {
+			handleDismissMatch(database, id)
+		}
+
+	case "export-user-data":
+		path := ""
+		if len(os.Args) >= 3 {
+			path = os.Args[2]
+		}
+		handleExportUserData(database, path)
+
+	case "import-user-data":
+		if len(os.Args) < 3 {
+			showError("Usage: import-user-data <path.json|path.csv>")
+			return
+		}
+		handleImportUserData(database, os.Args[2])
+
+	case "set-location":
+		if len(os.Args) < 4 {
+			showError("Usage: set-location <name> <lat,lon>")
+			return
+		}
+		handleSetLocation(database, os.Args[2], os.Args[3])
+
+	case "locations":
+		handleLocations(database)
+
 	case "showDescription":
 		fmt.Fprintf(os.Stderr, "[DEBUG] Show description command called\n")
-		handleShowDescription(workDir)
+		handleShowDescription(database, workDir)
 
 	default:
 		showError(fmt.Sprintf("Unknown command: %s", command))
 	}
 }
 
+// defaultWorkflowDataDir returns the folder where Alfred keeps the data of this workflow
+func defaultWorkflowDataDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %v", err)
+	}
+	return filepath.Join(home, "Library", "Application Support", "Alfred", "Workflow Data", workflowBundleID), nil
+}
+
 // getWorkingDirectory returns the directory where the executable is located
 func getWorkingDirectory() (string, error) {
 	// First try to get the Alfred workflow directory from environment
@@ -434,6 +765,15 @@ func handleSearch(database *sql.DB, query string) {
 		return
 	}
 
+	// Fall back to a typo-tolerant search when nothing matches exactly
+	var suggestion *AlfredItem
+	if len(restaurants) == 0 && !isEmptySearch {
+		suggestion, restaurants = searchSuggestion(database, query)
+		if suggestion != nil {
+			query = suggestion.Autocomplete
+		}
+	}
+
 	// Check if no results found
 	if len(restaurants) == 0 {
 		showNoResults("No restaurants found. Try a different search term.")
@@ -441,7 +781,44 @@ func handleSearch(database *sql.DB, query string) {
 	}
 
 	// Format results for Alfred
-	items := make([]AlfredItem, 0, len(restaurants))
+	items := make([]AlfredItem, 0, len(restaurants)+2)
+	if suggestion != nil {
+		items = append(items, *suggestion)
+	}
+
+	// Point out changes to favorite and visited restaurants after a database update
+	if isEmptySearch {
+		if unseen, err := db.CountUnseenUpdateEvents(database); err == nil && unseen > 0 {
+			keyword := os.Getenv("WHATS_NEW_KEY")
+			if keyword == "" {
+				keyword = "!mn"
+			}
+			title := fmt.Sprintf("📰 %d changes to your favorite and visited restaurants", unseen)
+			if unseen == 1 {
+				title = "📰 1 change to your favorite and visited restaurants"
+			}
+			items = append(items, AlfredItem{
+				Title:    title,
+				Subtitle: fmt.Sprintf("The database was updated, type %s to review them", keyword),
+				Valid:    false,
+			})
+		}
+		if pending, err := db.CountPendingMatches(database); err == nil && pending > 0 {
+			keyword := os.Getenv("MATCHES_KEY")
+			if keyword == "" {
+				keyword = "!mr"
+			}
+			title := fmt.Sprintf("🔗 %d restaurants changed their Michelin link", pending)
+			if pending == 1 {
+				title = "🔗 1 restaurant changed its Michelin link"
+			}
+			items = append(items, AlfredItem{
+				Title:    title,
+				Subtitle: fmt.Sprintf("Your data for them is waiting, type %s to confirm the matches", keyword),
+				Valid:    false,
+			})
+		}
+	}
 
 	// Determine total count based on search type
 	var totalCount int
@@ -457,158 +834,55 @@ func handleSearch(database *sql.DB, query string) {
 		totalCount = len(restaurants)
 	}
 
-	for i, r := range restaurants {
-		// Get cuisine or set default value
-		cuisine := "Unknown cuisine"
-		if r.Cuisine != nil && *r.Cuisine != "" {
-			cuisine = *r.Cuisine
-		}
+	items = append(items, renderRestaurants(restaurants, totalCount, resultView{
+		Variables: map[string]interface{}{"search_query": query},
+		Mods: func(r db.Restaurant) map[string]Mod {
+			return map[string]Mod{
+				"ctrl":     {Subtitle: favoriteAction(r)},
+				"alt":      {Subtitle: visitedAction(r)},
+				"cmd+ctrl": {Subtitle: "📋 add to a list"},
+				"cmd+alt":  {Subtitle: "🗺️ show these results on a map"},
+			}
+		},
+	})...)
 
-		// Get location or set default value
-		location := "Unknown location"
-		if r.Location != nil && *r.Location != "" {
-			location = *r.Location
-		}
+	// Return results
+	result := AlfredResult{Items: items}
+	if err := printJSON(result); err != nil {
+		showError(fmt.Sprintf("Error formatting results: %v", err))
+	}
+}
 
-		// Create Alfred item
-		restaurantName := "Unknown restaurant"
-		if r.Name != nil && *r.Name != "" {
-			restaurantName = *r.Name
-		}
+// searchSuggestion runs a corrected version of a query that returned no results.
+// Returns the "Did you mean" item and the restaurants found, or nil when there is no useful correction.
+func searchSuggestion(database *sql.DB, query string) (*AlfredItem, []db.Restaurant) {
+	corrected, ok, err := db.SuggestQuery(database, query)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] Failed to suggest a correction: %v\n", err)
+		return nil, nil
+	}
+	if !ok {
+		return nil, nil
+	}
 
-		// Add scroll emoji if restaurant is no longer in the guide
-		if r.InGuide == 0 {
-			restaurantName = restaurantName + " 📜"
-		}
+	restaurants, _, err := db.SearchRestaurants(database, corrected)
+	if err != nil || len(restaurants) == 0 {
+		return nil, nil
+	}
 
-		// Add heart emoji to title for favorites
-		favoriteEmoji := "❤️ add to favorites"
-		if r.IsFavorite {
-			restaurantName = restaurantName + " ❤️"
-			favoriteEmoji = "💔 remove from favorites"
-		}
+	return &AlfredItem{
+		Title:        fmt.Sprintf("Did you mean “%s”?", corrected),
+		Subtitle:     fmt.Sprintf("No results for “%s”, showing results for “%s”", query, corrected),
+		Autocomplete: corrected,
+		Valid:        false,
+	}, restaurants
+}
 
-		// Add visited emoji to title if needed
-		visitedEmoji := "✅ add to visited"
-		if r.IsVisited {
-			restaurantName = restaurantName + " ✅"
-			visitedEmoji = "❌ remove from visited"
-		}
-
-		// Format award display with stars and year
-		award := formatAwardWithYearRange(r.CurrentAward, r.CurrentAwardYear, r.CurrentAwardLastYear, r.CurrentGreenStar, r.InGuide)
-
-		// Create counter prefix
-		counter := fmt.Sprintf("%s/%s", formatNumber(i+1), formatNumber(totalCount))
-
-		// Determine the OPEN_IN_URL variable based on user preference
-		openIn := os.Getenv("OPEN_IN")
-		var openInURL string
-		if openIn == "" {
-			openIn = "restaurant" // default
-		}
-		switch openIn {
-		case "restaurant":
-			if r.WebsiteUrl != nil && *r.WebsiteUrl != "" {
-				openInURL = *r.WebsiteUrl
-			} else if r.Url != nil && *r.Url != "" {
-				openInURL = *r.Url
-			}
-		case "michelin":
-			if r.Url != nil && *r.Url != "" {
-				openInURL = *r.Url
-			}
-		case "maps":
-			if r.Latitude != nil && r.Longitude != nil && *r.Latitude != "" && *r.Longitude != "" {
-				if r.Name != nil && *r.Name != "" {
-					encodedName := url.QueryEscape(*r.Name)
-					openInURL = fmt.Sprintf("https://www.google.com/maps?q=%s&ll=%s,%s&z=15", encodedName, *r.Latitude, *r.Longitude)
-				} else {
-					openInURL = fmt.Sprintf("https://www.google.com/maps?ll=%s,%s&z=15", *r.Latitude, *r.Longitude)
-				}
-			}
-		case "apple_maps":
-			if r.Latitude != nil && r.Longitude != nil && *r.Latitude != "" && *r.Longitude != "" {
-				if r.Name != nil && *r.Name != "" {
-					openInURL = fmt.Sprintf("https://maps.apple.com/?q=%s&ll=%s,%s", url.QueryEscape(*r.Name), *r.Latitude, *r.Longitude)
-				} else {
-					openInURL = fmt.Sprintf("https://maps.apple.com/?ll=%s,%s", *r.Latitude, *r.Longitude)
-				}
-			}
-		}
-
-		item := AlfredItem{
-			Title: restaurantName,
-			Subtitle: fmt.Sprintf("%s | %s | %s | %s",
-				counter,
-				location,
-				award,
-				cuisine),
-			Arg: "",
-
-			Valid: true,
-			Variables: map[string]interface{}{
-				"restaurant_id":      r.ID,
-				"restaurant_name":    restaurantName,
-				"is_favorite":        r.IsFavorite,
-				"is_visited":         r.IsVisited,
-				"restaurant_url":     r.Url,
-				"website_url":        r.WebsiteUrl,
-				"search_query":       query,
-				"mode":               "",
-				"favorite_emoji":     favoriteEmoji,
-				"visited_emoji":      visitedEmoji,
-				"myDescription":      r.Description,
-				"imageURL":           r.ImageURL,
-				"restaurant_address": r.Address,
-				"restaurant_award":   award,
-				"OPEN_IN_URL":        openInURL,
-			},
-			Mods: map[string]Mod{
-				"ctrl": {
-					Subtitle: favoriteEmoji,
-				},
-				"alt": {
-					Subtitle: visitedEmoji,
-				},
-			},
-		}
-
-		// Add icon based on award type and guide status
-		if r.InGuide == 0 {
-			// Restaurant no longer in guide - show black star
-			item.Icon = map[string]string{
-				"path": "icons/blackStar.png",
-			}
-		} else if r.CurrentAward != nil {
-			// Restaurant in guide - show award-based icon
-			awardLower := strings.ToLower(*r.CurrentAward)
-			if strings.Contains(awardLower, "bib gourmand") {
-				item.Icon = map[string]string{
-					"path": "icons/bibg.png",
-				}
-			} else if strings.Contains(awardLower, "selected restaurant") {
-				item.Icon = map[string]string{
-					"path": "icons/star.png",
-				}
-			}
-		}
-
-		items = append(items, item)
-	}
-
-	// Return results
-	result := AlfredResult{Items: items}
-	if err := printJSON(result); err != nil {
-		showError(fmt.Sprintf("Error formatting results: %v", err))
-	}
-}
-
-// handleSearchFavorites searches within favorite restaurants and returns results in Alfred format
-func handleSearchFavorites(database *sql.DB, query string) {
-	// Search favorite restaurants with timing
-	var restaurants []db.Restaurant
-	var err error
+// handleSearchFavorites searches within favorite restaurants and returns results in Alfred format
+func handleSearchFavorites(database *sql.DB, query string) {
+	// Search favorite restaurants with timing
+	var restaurants []db.Restaurant
+	var err error
 
 	timeQuery(fmt.Sprintf("search favorites query: '%s'", query), func() error {
 		restaurants, err = db.SearchFavoriteRestaurants(database, query)
@@ -627,124 +901,10 @@ func handleSearchFavorites(database *sql.DB, query string) {
 	}
 
 	// Format results for Alfred
-	items := make([]AlfredItem, 0, len(restaurants))
-	totalCount := len(restaurants)
-
-	for i, r := range restaurants {
-		// Get cuisine or set default value
-		cuisine := "Unknown cuisine"
-		if r.Cuisine != nil && *r.Cuisine != "" {
-			cuisine = *r.Cuisine
-		}
-
-		// Get location or set default value
-		location := "Unknown location"
-		if r.Location != nil && *r.Location != "" {
-			location = *r.Location
-		}
-
-		// Create Alfred item
-		restaurantName := "Unknown restaurant"
-		if r.Name != nil && *r.Name != "" {
-			restaurantName = *r.Name
-		}
-
-		// Add scroll emoji if restaurant is no longer in the guide
-		if r.InGuide == 0 {
-			restaurantName = restaurantName + " 📜"
-		}
-
-		// Add heart emoji to title for favorites (always for favorites search)
-		restaurantName = restaurantName + " ❤️"
-
-		// Add visited emoji to title if needed
-		if r.IsVisited {
-			restaurantName = restaurantName + " ✅"
-		}
-
-		// Format award display with stars and year
-		award := formatAwardWithYearRange(r.CurrentAward, r.CurrentAwardYear, r.CurrentAwardLastYear, r.CurrentGreenStar, r.InGuide)
-
-		// Create counter prefix
-		counter := fmt.Sprintf("%s/%s", formatNumber(i+1), formatNumber(totalCount))
-
-		// Determine the OPEN_IN_URL variable based on user preference
-		openIn := os.Getenv("OPEN_IN")
-		var openInURL string
-		if openIn == "" {
-			openIn = "restaurant" // default
-		}
-		switch openIn {
-		case "restaurant":
-			if r.WebsiteUrl != nil && *r.WebsiteUrl != "" {
-				openInURL = *r.WebsiteUrl
-			} else if r.Url != nil && *r.Url != "" {
-				openInURL = *r.Url
-			}
-		case "michelin":
-			if r.Url != nil && *r.Url != "" {
-				openInURL = *r.Url
-			}
-		case "maps":
-			if r.Longitude != nil && r.Latitude != nil && *r.Longitude != "" && *r.Latitude != "" {
-				if r.Name != nil && *r.Name != "" {
-					openInURL = fmt.Sprintf("https://www.google.com/maps/place/%s/@%s,%s,17z", url.QueryEscape(*r.Name), *r.Latitude, *r.Longitude)
-				} else {
-					openInURL = fmt.Sprintf("https://www.google.com/maps/@%s,%s,17z", *r.Latitude, *r.Longitude)
-				}
-			}
-		case "apple_maps":
-			if r.Latitude != nil && r.Longitude != nil && *r.Latitude != "" && *r.Longitude != "" {
-				if r.Name != nil && *r.Name != "" {
-					openInURL = fmt.Sprintf("https://maps.apple.com/?q=%s&ll=%s,%s", url.QueryEscape(*r.Name), *r.Latitude, *r.Longitude)
-				} else {
-					openInURL = fmt.Sprintf("https://maps.apple.com/?ll=%s,%s", *r.Latitude, *r.Longitude)
-				}
-			}
-		}
-
-		item := AlfredItem{
-			Title: restaurantName,
-			Subtitle: fmt.Sprintf("%s | %s | %s | %s",
-				counter,
-				location,
-				award,
-				cuisine),
-			Arg:   "",
-			Valid: true,
-			Variables: map[string]interface{}{
-				"restaurant_id":      r.ID,
-				"restaurant_name":    restaurantName,
-				"is_favorite":        r.IsFavorite,
-				"is_visited":         r.IsVisited,
-				"restaurant_url":     r.Url,
-				"website_url":        r.WebsiteUrl,
-				"search_query":       query,
-				"mode":               "favorites",
-				"imageURL":           r.ImageURL,
-				"myDescription":      r.Description,
-				"restaurant_address": r.Address,
-				"restaurant_award":   award,
-				"OPEN_IN_URL":        openInURL,
-			},
-		}
-
-		// Add icon based on award type
-		if r.CurrentAward != nil {
-			awardLower := strings.ToLower(*r.CurrentAward)
-			if strings.Contains(awardLower, "bib gourmand") {
-				item.Icon = map[string]string{
-					"path": "icons/bibg.png",
-				}
-			} else if strings.Contains(awardLower, "selected restaurant") {
-				item.Icon = map[string]string{
-					"path": "icons/star.png",
-				}
-			}
-		}
-
-		items = append(items, item)
-	}
+	items := renderRestaurants(restaurants, len(restaurants), resultView{
+		Mode:      "favorites",
+		Variables: map[string]interface{}{"search_query": query},
+	})
 
 	// Return results
 	result := AlfredResult{Items: items}
@@ -776,132 +936,10 @@ func handleSearchVisited(database *sql.DB, query string) {
 	}
 
 	// Format results for Alfred
-	items := make([]AlfredItem, 0, len(restaurants))
-	totalCount := len(restaurants)
-
-	for i, r := range restaurants {
-		// Get cuisine or set default value
-		cuisine := "Unknown cuisine"
-		if r.Cuisine != nil && *r.Cuisine != "" {
-			cuisine = *r.Cuisine
-		}
-
-		// Get location or set default value
-		location := "Unknown location"
-		if r.Location != nil && *r.Location != "" {
-			location = *r.Location
-		}
-
-		// Get restaurant name
-		restaurantName := "Unknown restaurant"
-		if r.Name != nil && *r.Name != "" {
-			restaurantName = *r.Name
-		}
-
-		// Add scroll emoji if restaurant is no longer in the guide
-		if r.InGuide == 0 {
-			restaurantName = restaurantName + " 📜"
-		}
-
-		// Add heart emoji to title for favorites
-		if r.IsFavorite {
-			restaurantName = restaurantName + " ❤️"
-		}
-
-		// Add visited emoji to title (always for visited search)
-		restaurantName = restaurantName + " ✅"
-
-		// Format award display with stars and year
-		award := formatAwardWithYearRange(r.CurrentAward, r.CurrentAwardYear, r.CurrentAwardLastYear, r.CurrentGreenStar, r.InGuide)
-
-		// Create counter prefix
-		counter := fmt.Sprintf("%s/%s", formatNumber(i+1), formatNumber(totalCount))
-
-		// Create Alfred item
-		subtitle := fmt.Sprintf("%s | %s | %s | %s",
-			counter,
-			location,
-			award,
-			cuisine)
-
-		// Add visit date if available
-		if r.VisitedDate != nil && *r.VisitedDate != "" {
-			subtitle = fmt.Sprintf("%s | Visited: %s", subtitle, *r.VisitedDate)
-		}
-
-		// Determine the OPEN_IN_URL variable based on user preference
-		openIn := os.Getenv("OPEN_IN")
-		var openInURL string
-		if openIn == "" {
-			openIn = "restaurant" // default
-		}
-		switch openIn {
-		case "restaurant":
-			if r.WebsiteUrl != nil && *r.WebsiteUrl != "" {
-				openInURL = *r.WebsiteUrl
-			} else if r.Url != nil && *r.Url != "" {
-				openInURL = *r.Url
-			}
-		case "michelin":
-			if r.Url != nil && *r.Url != "" {
-				openInURL = *r.Url
-			}
-		case "maps":
-			if r.Longitude != nil && r.Latitude != nil && *r.Longitude != "" && *r.Latitude != "" {
-				if r.Name != nil && *r.Name != "" {
-					openInURL = fmt.Sprintf("https://www.google.com/maps/place/%s/@%s,%s,17z", url.QueryEscape(*r.Name), *r.Latitude, *r.Longitude)
-				} else {
-					openInURL = fmt.Sprintf("https://www.google.com/maps/@%s,%s,17z", *r.Latitude, *r.Longitude)
-				}
-			}
-		case "apple_maps":
-			if r.Latitude != nil && r.Longitude != nil && *r.Latitude != "" && *r.Longitude != "" {
-				if r.Name != nil && *r.Name != "" {
-					openInURL = fmt.Sprintf("https://maps.apple.com/?q=%s&ll=%s,%s", url.QueryEscape(*r.Name), *r.Latitude, *r.Longitude)
-				} else {
-					openInURL = fmt.Sprintf("https://maps.apple.com/?ll=%s,%s", *r.Latitude, *r.Longitude)
-				}
-			}
-		}
-
-		// Determine the argument (URL to open)
-
-		item := AlfredItem{
-			Title:    restaurantName,
-			Subtitle: subtitle,
-			Arg:      "",
-			Valid:    true,
-			Variables: map[string]interface{}{
-				"restaurant_id":      r.ID,
-				"restaurant_name":    restaurantName,
-				"restaurant_url":     r.Url,
-				"website_url":        r.WebsiteUrl,
-				"search_query":       query,
-				"mode":               "visited",
-				"imageURL":           r.ImageURL,
-				"myDescription":      r.Description,
-				"restaurant_address": r.Address,
-				"restaurant_award":   award,
-				"OPEN_IN_URL":        openInURL,
-			},
-		}
-
-		// Add icon based on award type
-		if r.CurrentAward != nil {
-			awardLower := strings.ToLower(*r.CurrentAward)
-			if strings.Contains(awardLower, "bib gourmand") {
-				item.Icon = map[string]string{
-					"path": "icons/bibg.png",
-				}
-			} else if strings.Contains(awardLower, "selected restaurant") {
-				item.Icon = map[string]string{
-					"path": "icons/star.png",
-				}
-			}
-		}
-
-		items = append(items, item)
-	}
+	items := renderRestaurants(restaurants, len(restaurants), resultView{
+		Mode:      "visited",
+		Variables: map[string]interface{}{"search_query": query},
+	})
 
 	// Return results
 	result := AlfredResult{Items: items}
@@ -938,34 +976,163 @@ func handleToggleFavorite(database *sql.DB, id int64) {
 	}
 }
 
-// handleToggleVisited toggles a restaurant's visited status
-func handleToggleVisited(database *sql.DB, id int64, date, notes string) {
-	// Toggle visited with timing
-	timeQuery(fmt.Sprintf("toggle visited id: %d", id), func() error {
-		return db.ToggleVisited(database, id, date, notes)
-	})
-
-	// Get restaurant details with timing
-	var restaurant db.Restaurant
-	var err error
+// handleAddVisit logs a visit to a restaurant, dated today unless a date is given
+func handleAddVisit(database *sql.DB, id int64, text string) {
+	details, err := db.ParseVisitDetails(text)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+	if details.Date == nil {
+		today := time.Now().Format(db.VisitDateLayout)
+		details.Date = &today
+	}
 
-	timeQuery(fmt.Sprintf("get restaurant after toggle visited id: %d", id), func() error {
-		restaurant, err = db.GetRestaurantByID(database, id)
+	timeQuery(fmt.Sprintf("add visit id: %d", id), func() error {
+		_, err = db.AddVisit(database, id, details)
 		return err
 	})
+	if err != nil {
+		showError(fmt.Sprintf("Error adding visit: %v", err))
+		return
+	}
 
+	visits, err := db.GetRestaurantVisits(database, id)
 	if err != nil {
-		showError(fmt.Sprintf("Error getting restaurant: %v", err))
+		showError(fmt.Sprintf("Error getting visits: %v", err))
 		return
 	}
 
-	if restaurant.IsVisited {
+	if len(visits) == 1 {
 		fmt.Println("Added to visited ✅")
 	} else {
-		fmt.Println("Removed from visited ❌")
+		fmt.Printf("Visit logged ✅ (%d visits)\n", len(visits))
+	}
+}
+
+// handleEditVisit changes the given fields of a logged visit
+func handleEditVisit(database *sql.DB, visitID int64, text string) {
+	details, err := db.ParseVisitDetails(text)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+
+	if err := db.UpdateVisit(database, visitID, details); err != nil {
+		showError(fmt.Sprintf("Error updating visit: %v", err))
+		return
+	}
+
+	fmt.Println("Visit updated ✏️")
+}
+
+// handleDeleteVisit removes a single visit from the log
+func handleDeleteVisit(database *sql.DB, visitID int64) {
+	if err := db.DeleteVisit(database, visitID); err != nil {
+		showError(fmt.Sprintf("Error deleting visit: %v", err))
+		return
+	}
+
+	fmt.Println("Visit deleted ❌")
+}
+
+// handleVisits shows the visit log of a restaurant, most recent first. The typed input holds visit
+// details: the first item logs a new visit with them, ↩ on a visit changes it and CMD deletes it.
+func handleVisits(database *sql.DB, id int64, input string) {
+	details, err := db.ParseVisitDetails(input)
+	if err != nil {
+		showNoResults(err.Error())
+		return
+	}
+
+	visits, err := db.GetRestaurantVisits(database, id)
+	if err != nil {
+		showError(fmt.Sprintf("Error getting visits: %v", err))
+		return
+	}
+
+	date := time.Now().Format(db.VisitDateLayout)
+	if details.Date != nil {
+		date = *details.Date
+	}
+	subtitle := "Type [YYYY-MM-DD] [party:4] [rating:1-5] [spend:120] [notes]"
+	if parts := formatVisitDetails(db.VisitDetails{PartySize: details.PartySize, Rating: details.Rating,
+		Spend: details.Spend, Notes: details.Notes}); len(parts) > 0 {
+		subtitle = strings.Join(parts, " | ")
+	}
+	items := []AlfredItem{{
+		Title:     "✅ Log a visit on " + date,
+		Subtitle:  subtitle,
+		Arg:       input,
+		Valid:     true,
+		Variables: map[string]interface{}{"visit_action": "add-visit", "visit_target": id},
+	}}
+
+	valid := input != ""
+	deleteValid := true
+	for i, visit := range visits {
+		title := "Undated visit"
+		if visit.VisitedDate != nil && *visit.VisitedDate != "" {
+			title = *visit.VisitedDate
+		}
+		if visit.Rating != nil {
+			title = fmt.Sprintf("%s %s", title, strings.Repeat("★", *visit.Rating))
+		}
+
+		parts := []string{fmt.Sprintf("%d/%d", i+1, len(visits))}
+		parts = append(parts, formatVisitDetails(db.VisitDetails{PartySize: visit.PartySize, Spend: visit.Spend, Notes: visit.Notes})...)
+		if changes := formatVisitDetails(details); valid && len(changes) > 0 {
+			parts = append([]string{"↩ change to: " + changes[0]}, changes[1:]...)
+		}
+
+		items = append(items, AlfredItem{
+			Title:    title,
+			Subtitle: strings.Join(parts, " | "),
+			Arg:      input,
+			Valid:    valid,
+			Variables: map[string]interface{}{
+				"visit_action":  "edit-visit",
+				"visit_target":  visit.ID,
+				"visit_id":      visit.ID,
+				"restaurant_id": visit.RestaurantID,
+			},
+			Mods: map[string]Mod{
+				"cmd": {
+					Subtitle:  "❌ delete this visit",
+					Valid:     &deleteValid,
+					Variables: map[string]interface{}{"visit_action": "delete-visit", "visit_target": visit.ID},
+				},
+			},
+		})
+	}
+
+	result := AlfredResult{Items: items}
+	if err := printJSON(result); err != nil {
+		showError(fmt.Sprintf("Error formatting results: %v", err))
 	}
 }
 
+// formatVisitDetails lists the details of a visit that are set, e.g. ["👥 4", "💳 120.00", "Anniversary"]
+func formatVisitDetails(details db.VisitDetails) []string {
+	var parts []string
+	if details.Date != nil {
+		parts = append(parts, "📅 "+*details.Date)
+	}
+	if details.Rating != nil {
+		parts = append(parts, strings.Repeat("★", *details.Rating))
+	}
+	if details.PartySize != nil {
+		parts = append(parts, fmt.Sprintf("👥 %d", *details.PartySize))
+	}
+	if details.Spend != nil {
+		parts = append(parts, fmt.Sprintf("💳 %.2f", *details.Spend))
+	}
+	if details.Notes != nil && *details.Notes != "" {
+		parts = append(parts, *details.Notes)
+	}
+	return parts
+}
+
 // handleFavorites shows all favorite restaurants
 func handleFavorites(database *sql.DB) {
 	// Get all favorite restaurants with timing
@@ -978,280 +1145,1560 @@ func handleFavorites(database *sql.DB) {
 	})
 
 	if err != nil {
-		showError(fmt.Sprintf("Error getting favorites: %v", err))
+		showError(fmt.Sprintf("Error getting favorites: %v", err))
+		return
+	}
+
+	// Check if no favorites found
+	if len(restaurants) == 0 {
+		showNoResults("No favorite restaurants found.")
+		return
+	}
+
+	// Format results for Alfred
+	items := renderRestaurants(restaurants, len(restaurants), resultView{Mode: "favorites"})
+
+	// Return results
+	result := AlfredResult{Items: items}
+	if err := printJSON(result); err != nil {
+		showError(fmt.Sprintf("Error formatting results: %v", err))
+	}
+}
+
+// handleVisited shows all visited restaurants
+func handleVisited(database *sql.DB) {
+	// Get all visited restaurants with timing
+	var restaurants []db.Restaurant
+	var err error
+
+	timeQuery("get visited", func() error {
+		restaurants, err = db.GetVisitedRestaurants(database)
+		return err
+	})
+
+	if err != nil {
+		showError(fmt.Sprintf("Error getting visited restaurants: %v", err))
+		return
+	}
+
+	// Check if no visited restaurants found
+	if len(restaurants) == 0 {
+		showNoResults("No visited restaurants found.")
+		return
+	}
+
+	// Format results for Alfred
+	items := renderRestaurants(restaurants, len(restaurants), resultView{Mode: "visited"})
+
+	// Return results
+	result := AlfredResult{Items: items}
+	if err := printJSON(result); err != nil {
+		showError(fmt.Sprintf("Error formatting results: %v", err))
+	}
+}
+
+// handleRate sets or clears the personal rating and review of a restaurant
+func handleRate(database *sql.DB, id int64, value, review string) {
+	if value == "clear" || value == "0" {
+		if err := db.ClearRating(database, id); err != nil {
+			showError(fmt.Sprintf("Error clearing rating: %v", err))
+			return
+		}
+		fmt.Println("Rating cleared")
+		return
+	}
+
+	rating, err := strconv.Atoi(value)
+	if err != nil {
+		showError("Rating must be a number from 1 to 5")
+		return
+	}
+
+	if err := db.SetRating(database, id, rating, review); err != nil {
+		showError(fmt.Sprintf("Error setting rating: %v", err))
+		return
+	}
+
+	fmt.Printf("Rated%s\n", formatUserRating(&rating))
+}
+
+// handleTag adds or removes comma-separated tags on a restaurant
+func handleTag(database *sql.DB, id int64, text string, remove bool) {
+	var tags []string
+	for _, tag := range strings.Split(text, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		showError("Missing tag")
+		return
+	}
+
+	for _, tag := range tags {
+		var err error
+		if remove {
+			err = db.RemoveTag(database, id, tag)
+		} else {
+			err = db.AddTag(database, id, tag)
+		}
+		if err != nil {
+			showError(fmt.Sprintf("Error updating tags: %v", err))
+			return
+		}
+	}
+
+	if remove {
+		fmt.Printf("Removed tag %s 🏷️\n", strings.Join(tags, ", "))
+	} else {
+		fmt.Printf("Tagged %s 🏷️\n", strings.Join(tags, ", "))
+	}
+}
+
+// handleRestaurantTags shows the tags and personal rating of a restaurant. Input starting with a rating
+// (1-5 or clear) offers to rate the restaurant, other input to tag it, and the tags can be removed.
+func handleRestaurantTags(database *sql.DB, id int64, input string) {
+	tags, err := db.GetTags(database, id)
+	if err != nil {
+		showError(fmt.Sprintf("Error getting tags: %v", err))
+		return
+	}
+	rating, rated, err := db.GetRating(database, id)
+	if err != nil {
+		showError(fmt.Sprintf("Error getting rating: %v", err))
+		return
+	}
+
+	var items []AlfredItem
+	if input != "" {
+		items = append(items, tagActionItem(id, input))
+	}
+	if rated {
+		subtitle := "No review"
+		if rating.Review != nil && *rating.Review != "" {
+			subtitle = *rating.Review
+		}
+		items = append(items, AlfredItem{
+			Title:    "Your rating:" + formatUserRating(&rating.Rating),
+			Subtitle: subtitle,
+			Valid:    false,
+		})
+	}
+	for _, tag := range tags {
+		items = append(items, AlfredItem{
+			Title:     "🏷️ " + tag,
+			Subtitle:  "↩ remove this tag",
+			Arg:       tag,
+			Valid:     true,
+			Variables: map[string]interface{}{"tag_action": "untag", "restaurant_id": id},
+		})
+	}
+
+	if len(items) == 0 {
+		showNoResults("Type 1-5 and a review to rate this restaurant, or tags separated by commas.")
+		return
+	}
+
+	result := AlfredResult{Items: items}
+	if err := printJSON(result); err != nil {
+		showError(fmt.Sprintf("Error formatting results: %v", err))
+	}
+}
+
+// tagActionItem offers to rate a restaurant when the input starts with 1-5 or clear, and to tag it otherwise
+func tagActionItem(id int64, input string) AlfredItem {
+	value, review, _ := strings.Cut(input, " ")
+	review = strings.TrimSpace(review)
+
+	if value == "clear" || value == "0" {
+		return AlfredItem{
+			Title:     "Clear your rating",
+			Arg:       input,
+			Valid:     true,
+			Variables: map[string]interface{}{"tag_action": "rate", "restaurant_id": id},
+		}
+	}
+	if rating, err := strconv.Atoi(value); err == nil {
+		if rating < 1 || rating > 5 {
+			return AlfredItem{Title: "Rating must be a number from 1 to 5", Valid: false}
+		}
+		subtitle := "Without a review"
+		if review != "" {
+			subtitle = review
+		}
+		return AlfredItem{
+			Title:     "Rate" + formatUserRating(&rating),
+			Subtitle:  subtitle,
+			Arg:       input,
+			Valid:     true,
+			Variables: map[string]interface{}{"tag_action": "rate", "restaurant_id": id},
+		}
+	}
+
+	return AlfredItem{
+		Title:     "🏷️ Tag with " + input,
+		Subtitle:  "Separate tags with commas",
+		Arg:       input,
+		Valid:     true,
+		Variables: map[string]interface{}{"tag_action": "tag", "restaurant_id": id},
+	}
+}
+
+// handleAllTags shows every tag in use with the number of restaurants carrying it
+func handleAllTags(database *sql.DB) {
+	tags, err := db.GetAllTags(database)
+	if err != nil {
+		showError(fmt.Sprintf("Error getting tags: %v", err))
+		return
+	}
+
+	if len(tags) == 0 {
+		showNoResults("No tags yet. Use CTRL+ALT on a restaurant to rate or tag it.")
+		return
+	}
+
+	items := make([]AlfredItem, 0, len(tags))
+	for _, tag := range tags {
+		subtitle := fmt.Sprintf("%d restaurants", tag.Count)
+		if tag.Count == 1 {
+			subtitle = "1 restaurant"
+		}
+		items = append(items, AlfredItem{
+			Title:    "🏷️ " + tag.Tag,
+			Subtitle: subtitle,
+			Arg:      strings.TrimSpace(tagToken(tag.Tag)),
+			Valid:    true,
+		})
+	}
+
+	result := AlfredResult{Items: items}
+	if err := printJSON(result); err != nil {
+		showError(fmt.Sprintf("Error formatting results: %v", err))
+	}
+}
+
+// tagToken returns the search token for a tag, quoted when it contains spaces
+func tagToken(tag string) string {
+	if strings.Contains(tag, " ") {
+		return fmt.Sprintf("tag:\"%s\" ", tag)
+	}
+	return fmt.Sprintf("tag:%s ", tag)
+}
+
+// handleCreateList creates a new empty list
+func handleCreateList(database *sql.DB, name string) {
+	if _, err := db.CreateList(database, name); err != nil {
+		showError(fmt.Sprintf("Error creating list: %v", err))
+		return
+	}
+
+	fmt.Printf("Created list %s 📋\n", strings.TrimSpace(name))
+}
+
+// handleRenameList renames a list
+func handleRenameList(database *sql.DB, ref, name string) {
+	list, err := db.ResolveList(database, ref)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+
+	if err := db.RenameList(database, list.ID, name); err != nil {
+		showError(fmt.Sprintf("Error renaming list: %v", err))
+		return
+	}
+
+	fmt.Printf("Renamed list %s to %s 📋\n", list.Name, strings.TrimSpace(name))
+}
+
+// handleDeleteList deletes a list and its entries
+func handleDeleteList(database *sql.DB, ref string) {
+	list, err := db.ResolveList(database, ref)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+
+	if err := db.DeleteList(database, list.ID); err != nil {
+		showError(fmt.Sprintf("Error deleting list: %v", err))
+		return
+	}
+
+	fmt.Printf("Deleted list %s 🗑️\n", list.Name)
+}
+
+// handleAddToList adds a restaurant to a list, creating the list when no list has that name
+func handleAddToList(database *sql.DB, ref string, id int64, notes string) {
+	list, err := db.ResolveList(database, ref)
+	created := false
+	if err != nil {
+		// Only a name can create a list, an unknown ID is an error
+		if _, parseErr := strconv.ParseInt(ref, 10, 64); parseErr == nil {
+			showError(err.Error())
+			return
+		}
+		if list.ID, err = db.CreateList(database, ref); err != nil {
+			showError(fmt.Sprintf("Error creating list: %v", err))
+			return
+		}
+		list.Name = strings.TrimSpace(ref)
+		created = true
+	}
+
+	if err := db.AddToList(database, list.ID, id, notes); err != nil {
+		showError(fmt.Sprintf("Error adding to list: %v", err))
+		return
+	}
+
+	if created {
+		fmt.Printf("Created list %s and added restaurant 📋\n", list.Name)
+	} else {
+		fmt.Printf("Added to %s 📋\n", list.Name)
+	}
+}
+
+// handleRemoveFromList removes a restaurant from a list
+func handleRemoveFromList(database *sql.DB, ref string, id int64) {
+	list, err := db.ResolveList(database, ref)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+
+	if err := db.RemoveFromList(database, list.ID, id); err != nil {
+		showError(fmt.Sprintf("Error removing from list: %v", err))
+		return
+	}
+
+	fmt.Printf("Removed from %s 📋\n", list.Name)
+}
+
+// handleListNote sets or clears the notes of a restaurant in a list
+func handleListNote(database *sql.DB, ref string, id int64, notes string) {
+	list, err := db.ResolveList(database, ref)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+
+	if err := db.SetListItemNotes(database, list.ID, id, notes); err != nil {
+		showError(fmt.Sprintf("Error updating notes: %v", err))
+		return
+	}
+
+	if notes == "" {
+		fmt.Println("Notes cleared 📝")
+	} else {
+		fmt.Println("Notes saved 📝")
+	}
+}
+
+// handleMoveListItem moves a restaurant to a new position in a list
+func handleMoveListItem(database *sql.DB, ref string, id int64, position int) {
+	list, err := db.ResolveList(database, ref)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+
+	if err := db.MoveListItem(database, list.ID, id, position); err != nil {
+		showError(fmt.Sprintf("Error moving list entry: %v", err))
+		return
+	}
+
+	fmt.Printf("Moved in %s 📋\n", list.Name)
+}
+
+// handleLists shows all lists matching the query. When a restaurant is selected
+// (restaurant_id variable set by the result modifier) the lists become targets to add it to,
+// with an extra item to create a new list named after the query.
+func handleLists(database *sql.DB, query string) {
+	lists, err := db.GetLists(database)
+	if err != nil {
+		showError(fmt.Sprintf("Error getting lists: %v", err))
+		return
+	}
+
+	restaurantID := os.Getenv("restaurant_id")
+	restaurantName := os.Getenv("restaurant_name")
+	query = strings.TrimSpace(query)
+
+	items := make([]AlfredItem, 0, len(lists)+1)
+	exactMatch := false
+	for _, list := range lists {
+		if query != "" && !strings.Contains(strings.ToLower(list.Name), strings.ToLower(query)) {
+			continue
+		}
+		if strings.EqualFold(list.Name, query) {
+			exactMatch = true
+		}
+
+		subtitle := fmt.Sprintf("%d restaurants", list.ItemCount)
+		if list.ItemCount == 1 {
+			subtitle = "1 restaurant"
+		}
+		title := "📋 " + list.Name
+		if restaurantID != "" {
+			title = fmt.Sprintf("📋 Add to %s", list.Name)
+			subtitle = fmt.Sprintf("%s | %s", restaurantName, subtitle)
+		}
+
+		items = append(items, AlfredItem{
+			Title:        title,
+			Subtitle:     subtitle,
+			Arg:          strconv.FormatInt(list.ID, 10),
+			Autocomplete: list.Name,
+			Valid:        true,
+			Variables: map[string]interface{}{
+				"list_id":     list.ID,
+				"list_name":   list.Name,
+				"list_action": "",
+			},
+		})
+	}
+
+	// Browsing lists, offer to create one named after the query
+	if restaurantID == "" && query != "" && !exactMatch {
+		items = append(items, AlfredItem{
+			Title:    fmt.Sprintf("➕ Create list “%s”", query),
+			Subtitle: "Create an empty list",
+			Arg:      query,
+			Valid:    true,
+			Variables: map[string]interface{}{
+				"list_id":     "",
+				"list_name":   query,
+				"list_action": "create-list",
+				"list_entry":  "",
+			},
+		})
+	}
+
+	if restaurantID != "" && query != "" && !exactMatch {
+		items = append(items, AlfredItem{
+			Title:    fmt.Sprintf("➕ Create list “%s”", query),
+			Subtitle: fmt.Sprintf("Create the list and add %s", restaurantName),
+			Arg:      query,
+			Valid:    true,
+			Variables: map[string]interface{}{
+				"list_id":   "",
+				"list_name": query,
+			},
+		})
+	}
+
+	if len(items) == 0 {
+		if restaurantID != "" {
+			showNoResults("Type a name to create your first list")
+		} else {
+			showNoResults("No lists found. Type a name to create one.")
+		}
+		return
+	}
+
+	result := AlfredResult{Items: items}
+	if err := printJSON(result); err != nil {
+		showError(fmt.Sprintf("Error formatting results: %v", err))
+	}
+}
+
+// handleList shows the restaurants of a list in list order
+func handleList(database *sql.DB, ref string) {
+	list, err := db.ResolveList(database, ref)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+
+	// Get the list restaurants with timing
+	var restaurants []db.Restaurant
+	timeQuery(fmt.Sprintf("get list id: %d", list.ID), func() error {
+		restaurants, err = db.GetListRestaurants(database, list.ID)
+		return err
+	})
+
+	if err != nil {
+		showError(fmt.Sprintf("Error getting list: %v", err))
+		return
+	}
+
+	// Check if the list is empty
+	if len(restaurants) == 0 {
+		showNoResults(fmt.Sprintf("%s is empty. Add restaurants from the search results.", list.Name))
+		return
+	}
+
+	// Format results for Alfred
+	items := renderRestaurants(restaurants, len(restaurants), resultView{
+		Mode:      "list",
+		Variables: map[string]interface{}{"list_id": list.ID, "list_name": list.Name},
+		Mods: func(r db.Restaurant) map[string]Mod {
+			return map[string]Mod{"cmd": {Subtitle: fmt.Sprintf("🗑️ remove from %s", list.Name)}}
+		},
+	})
+
+	// Return results
+	result := AlfredResult{Items: items}
+	if err := printJSON(result); err != nil {
+		showError(fmt.Sprintf("Error formatting results: %v", err))
+	}
+}
+
+// handleListActions offers the actions on a list: rename it to the input or export it as CSV, KML,
+// GPX or GeoJSON, and export the dated visits to its restaurants as calendar events. When a
+// restaurant of the list is selected (restaurant_id variable set by the list view) it offers to set
+// its notes to the input or, for a number, to move it to that position.
+func handleListActions(database *sql.DB, ref, input string) {
+	list, err := db.ResolveList(database, ref)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+
+	action := func(name string, entry interface{}) map[string]interface{} {
+		return map[string]interface{}{"list_action": name, "list_id": list.ID, "list_entry": entry}
+	}
+
+	var items []AlfredItem
+	if restaurantID := os.Getenv("restaurant_id"); restaurantID != "" {
+		id, err := strconv.ParseInt(restaurantID, 10, 64)
+		if err != nil {
+			showError("Invalid restaurant ID")
+			return
+		}
+		if position, err := strconv.Atoi(input); err == nil {
+			items = append(items, AlfredItem{
+				Title:     fmt.Sprintf("↕️ Move to position %d", position),
+				Subtitle:  fmt.Sprintf("%s in %s", os.Getenv("restaurant_name"), list.Name),
+				Arg:       input,
+				Valid:     true,
+				Variables: action("move-list-item", id),
+			})
+		}
+
+		title := "📝 Clear the notes"
+		if input != "" {
+			title = fmt.Sprintf("📝 Set the notes to “%s”", input)
+		}
+		items = append(items, AlfredItem{
+			Title:     title,
+			Subtitle:  fmt.Sprintf("%s in %s, type a number to move it", os.Getenv("restaurant_name"), list.Name),
+			Arg:       input,
+			Valid:     true,
+			Variables: action("list-note", id),
+		})
+	} else {
+		if input != "" && input != list.Name {
+			items = append(items, AlfredItem{
+				Title:     fmt.Sprintf("✏️ Rename to “%s”", input),
+				Subtitle:  list.Name,
+				Arg:       input,
+				Valid:     true,
+				Variables: action("rename-list", ""),
+			})
+		} else {
+			items = append(items, AlfredItem{
+				Title:    "✏️ Rename",
+				Subtitle: fmt.Sprintf("Type a new name for %s", list.Name),
+				Valid:    false,
+			})
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			showError(fmt.Sprintf("Error finding home directory: %v", err))
+			return
+		}
+		path := filepath.Join(home, "Downloads", exportFileName(list.Name, ".csv"))
+		items = append(items, AlfredItem{
+			Title:     "📤 Export to CSV",
+			Subtitle:  path,
+			Arg:       path,
+			Valid:     true,
+			Variables: action("export-list", ""),
+		})
+
+		// The list action runs as a shell command, so "export kml list" takes the list as its source
+		for _, format := range []struct {
+			Format export.Format
+			Label  string
+		}{{export.KML, "KML"}, {export.GPX, "GPX"}, {export.GeoJSON, "GeoJSON"}} {
+			path := filepath.Join(home, "Downloads", exportFileName(list.Name, format.Format.Extension()))
+			items = append(items, AlfredItem{
+				Title:     "🗺️ Export as " + format.Label,
+				Subtitle:  path,
+				Arg:       path,
+				Valid:     true,
+				Variables: action(fmt.Sprintf("export %s list", format.Format), ""),
+			})
+		}
+
+		path = filepath.Join(home, "Downloads", exportFileName("Michelin visits: "+list.Name, ".ics"))
+		items = append(items, AlfredItem{
+			Title:    "📅 Export visits as calendar events",
+			Subtitle: path,
+			Arg:      path,
+			Valid:    true,
+			Variables: map[string]interface{}{
+				"list_action": "ics",
+				"list_id":     fmt.Sprintf("list:%d", list.ID),
+				"list_entry":  "",
+			},
+		})
+	}
+
+	result := AlfredResult{Items: items}
+	if err := printJSON(result); err != nil {
+		showError(fmt.Sprintf("Error formatting results: %v", err))
+	}
+}
+
+// handleExportList writes a list to a CSV file, by default in ~/Downloads
+func handleExportList(database *sql.DB, ref, path string) {
+	list, err := db.ResolveList(database, ref)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			showError(fmt.Sprintf("Error finding home directory: %v", err))
+			return
+		}
+		path = filepath.Join(home, "Downloads", exportFileName(list.Name, ".csv"))
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		showError(fmt.Sprintf("Error creating export file: %v", err))
+		return
+	}
+	defer file.Close()
+
+	count, err := db.WriteListCSV(database, list.ID, file)
+	if err != nil {
+		showError(fmt.Sprintf("Error exporting list: %v", err))
+		return
+	}
+
+	fmt.Printf("Exported %d restaurants to %s 📤\n", count, path)
+}
+
+// loadResultSet loads the restaurants of a search, favorites, visited or a list for export, with a title
+func loadResultSet(database *sql.DB, source, query string) (string, []db.Restaurant, error) {
+	var restaurants []db.Restaurant
+	var title string
+	var err error
+	switch source {
+	case "search":
+		if strings.TrimSpace(query) == "" {
+			return "", nil, fmt.Errorf("a search query is required")
+		}
+		title = "Michelin: " + query
+		restaurants, _, err = db.SearchRestaurants(database, query)
+	case "favorites":
+		title = "Michelin favorites"
+		if query == "" {
+			restaurants, err = db.GetFavoriteRestaurants(database)
+		} else {
+			title += ": " + query
+			restaurants, err = db.SearchFavoriteRestaurants(database, query)
+		}
+	case "visited":
+		title = "Michelin visited"
+		if query == "" {
+			restaurants, err = db.GetVisitedRestaurants(database)
+		} else {
+			title += ": " + query
+			restaurants, err = db.SearchVisitedRestaurants(database, query)
+		}
+	case "list":
+		var list db.UserList
+		if list, err = db.ResolveList(database, query); err != nil {
+			return "", nil, err
+		}
+		title = list.Name
+		restaurants, err = db.GetListRestaurants(database, list.ID)
+	default:
+		return "", nil, fmt.Errorf("unknown source '%s', expected search, favorites, visited or list", source)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load restaurants: %v", err)
+	}
+	return title, restaurants, nil
+}
+
+// handleMap renders a result set as an HTML map in the workflow data directory and opens it
+func handleMap(database *sql.DB, dataDir, source, query string) {
+	title, restaurants, err := loadResultSet(database, source, query)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+	if len(restaurants) == 0 {
+		showError("No restaurants found to map")
+		return
+	}
+
+	places, skipped := export.Places(restaurants)
+	if len(places) == 0 {
+		showError("No restaurants with coordinates to map")
+		return
+	}
+
+	path := filepath.Join(dataDir, "map.html")
+	file, err := os.Create(path)
+	if err != nil {
+		showError(fmt.Sprintf("Error creating map file: %v", err))
+		return
+	}
+	defer file.Close()
+
+	if err := export.WriteHTMLMap(file, title, places); err != nil {
+		showError(fmt.Sprintf("Error writing map: %v", err))
+		return
+	}
+
+	if err := exec.Command("open", path).Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] Failed to open map: %v\n", err)
+	}
+
+	fmt.Printf("Mapped %d restaurants 🗺️\n", len(places))
+	if skipped > 0 {
+		fmt.Printf("Skipped %d without coordinates\n", skipped)
+	}
+}
+
+// handleExport writes a result set (a search, favorites, visited or a list) as a GeoJSON, KML or GPX file
+func handleExport(database *sql.DB, formatName, source, query, path string) {
+	format, err := export.ParseFormat(formatName)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+
+	title, restaurants, err := loadResultSet(database, source, query)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
+	if len(restaurants) == 0 {
+		showError("No restaurants found to export")
+		return
+	}
+
+	places, skipped := export.Places(restaurants)
+	if len(places) == 0 {
+		showError("No restaurants with coordinates to export")
+		return
+	}
+
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			showError(fmt.Sprintf("Error finding home directory: %v", err))
+			return
+		}
+		path = filepath.Join(home, "Downloads", exportFileName(title, format.Extension()))
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		showError(fmt.Sprintf("Error creating export file: %v", err))
+		return
+	}
+	defer file.Close()
+
+	if err := export.Write(file, format, title, places); err != nil {
+		showError(fmt.Sprintf("Error exporting restaurants: %v", err))
+		return
+	}
+
+	fmt.Printf("Exported %d restaurants to %s 🗺️\n", len(places), path)
+	if skipped > 0 {
+		fmt.Printf("Skipped %d without coordinates\n", skipped)
+	}
+}
+
+// handleVCard writes a restaurant as a vCard contact, by default in ~/Downloads
+func handleVCard(database *sql.DB, id int64, path string) {
+	restaurant, err := db.GetRestaurantByID(database, id)
+	if err != nil {
+		showError(fmt.Sprintf("Error getting restaurant: %v", err))
+		return
+	}
+	name := "Restaurant"
+	if restaurant.Name != nil {
+		name = *restaurant.Name
+	}
+
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			showError(fmt.Sprintf("Error finding home directory: %v", err))
+			return
+		}
+		path = filepath.Join(home, "Downloads", exportFileName(name, ".vcf"))
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		showError(fmt.Sprintf("Error creating vCard file: %v", err))
+		return
+	}
+	defer file.Close()
+
+	if err := export.WriteVCard(file, []db.Restaurant{restaurant}); err != nil {
+		showError(fmt.Sprintf("Error writing vCard: %v", err))
+		return
+	}
+
+	fmt.Printf("Saved %s to %s 📇\n", name, path)
+}
+
+// handleICS writes dated visits as an iCalendar file, by default in ~/Downloads. The arguments are
+// optional: a year or from:/to: dates, list:<list> and the path of the file.
+func handleICS(database *sql.DB, args []string) {
+	var filter db.VisitFilter
+	title, path := "Michelin visits", ""
+	for _, arg := range args {
+		if arg == "" {
+			continue
+		}
+		field, value, hasField := strings.Cut(arg, ":")
+		switch {
+		case hasField && (field == "from" || field == "to"):
+			if _, err := time.Parse(db.VisitDateLayout, value); err != nil {
+				showError(fmt.Sprintf("Invalid date '%s', expected YYYY-MM-DD", value))
+				return
+			}
+			if field == "from" {
+				filter.From = value
+			} else {
+				filter.To = value
+			}
+		case hasField && field == "list":
+			list, err := db.ResolveList(database, value)
+			if err != nil {
+				showError(err.Error())
+				return
+			}
+			filter.ListID = list.ID
+			title += ": " + list.Name
+		case len(arg) == 4 && strings.Trim(arg, "0123456789") == "":
+			filter.From, filter.To = arg+"-01-01", arg+"-12-31"
+			title += " " + arg
+		case strings.HasSuffix(strings.ToLower(arg), ".ics"):
+			path = arg
+		default:
+			showError("Usage: ics [year] [from:YYYY-MM-DD] [to:YYYY-MM-DD] [list:<list>] [path.ics]")
+			return
+		}
+	}
+
+	visits, err := db.GetCalendarVisits(database, filter)
+	if err != nil {
+		showError(fmt.Sprintf("Error getting visits: %v", err))
+		return
+	}
+	if len(visits) == 0 {
+		showError("No dated visits found to export")
+		return
+	}
+
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			showError(fmt.Sprintf("Error finding home directory: %v", err))
+			return
+		}
+		path = filepath.Join(home, "Downloads", exportFileName(title, ".ics"))
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		showError(fmt.Sprintf("Error creating calendar file: %v", err))
+		return
+	}
+	defer file.Close()
+
+	count, err := export.WriteICS(file, title, visits, time.Now())
+	if err != nil {
+		showError(fmt.Sprintf("Error exporting visits: %v", err))
+		return
+	}
+
+	fmt.Printf("Exported %d visits to %s 📅\n", count, path)
+}
+
+// awardChangeLabels are the group headers of the changes command
+var awardChangeLabels = map[db.AwardChangeType]string{
+	db.ChangeNewStar:     "⭐️ New stars",
+	db.ChangePromotion:   "⬆️ Promotions",
+	db.ChangeNewBib:      "🍽️ New Bib Gourmands",
+	db.ChangeGreenGained: "🍀 New green stars",
+	db.ChangeDemotion:    "⬇️ Demotions",
+	db.ChangeGreenLost:   "🥀 Lost green stars",
+	db.ChangeDropped:     "📜 Dropped out of the guide",
+}
+
+// handleChanges lists what changed in a guide year, grouped by kind of change.
+// The query is an optional year followed by an optional city or country, e.g. "2025 italy".
+func handleChanges(database *sql.DB, query string) {
+	year := 0
+	words := strings.Fields(query)
+	if len(words) > 0 && len(words[0]) == 4 {
+		if y, err := strconv.Atoi(words[0]); err == nil {
+			year = y
+			words = words[1:]
+		}
+	}
+	place := strings.Join(words, " ")
+
+	var report db.AwardChangeReport
+	var err error
+	timeQuery(fmt.Sprintf("award changes year: %d place: %s", year, place), func() error {
+		report, err = db.GetAwardChanges(database, year, place)
+		return err
+	})
+	if err != nil {
+		showError(fmt.Sprintf("Error getting award changes: %v", err))
+		return
+	}
+
+	scope := fmt.Sprintf("%d vs %d", report.Year, report.PreviousYear)
+	if place != "" {
+		scope += " | " + place
+	}
+	if len(report.Changes) == 0 {
+		showNoResults(fmt.Sprintf("No changes in %s", scope))
+		return
+	}
+
+	groups := make(map[db.AwardChangeType][]db.AwardChange)
+	for _, change := range report.Changes {
+		groups[change.Type] = append(groups[change.Type], change)
+	}
+
+	items := make([]AlfredItem, 0, len(report.Changes)+len(groups))
+	for _, changeType := range db.AwardChangeTypes {
+		changes := groups[changeType]
+		if len(changes) == 0 {
+			continue
+		}
+
+		// Group header
+		items = append(items, AlfredItem{
+			Title:    fmt.Sprintf("%s (%s)", awardChangeLabels[changeType], formatNumber(len(changes))),
+			Subtitle: scope,
+			Valid:    false,
+		})
+
+		restaurants := make([]db.Restaurant, len(changes))
+		for i, change := range changes {
+			restaurants[i] = change.Restaurant
+		}
+		items = append(items, renderRestaurants(restaurants, len(restaurants), resultView{
+			Mode:      "changes",
+			Variables: map[string]interface{}{"search_query": query},
+			Mods: func(r db.Restaurant) map[string]Mod {
+				return map[string]Mod{"cmd": {Subtitle: "🏆️ award history"}}
+			},
+			// Show the distinction before and after the change in place of the award
+			Fields: func(i int, fields map[string]string) {
+				fields["award"] = formatAwardChange(changes[i], report.PreviousYear)
+			},
+		})...)
+	}
+
+	// Return results
+	result := AlfredResult{Items: items}
+	if err := printJSON(result); err != nil {
+		showError(fmt.Sprintf("Error formatting results: %v", err))
+	}
+}
+
+// formatAwardChange describes the distinction of a restaurant before and after a change
+func formatAwardChange(change db.AwardChange, previousYear int) string {
+	previousAward := formatAwardWithStarsAndGreenStar(&change.PreviousDistinction, nil, &change.PreviousGreenStar)
+	currentAward := formatAwardWithStarsAndGreenStar(&change.Distinction, nil, &change.GreenStar)
+	switch {
+	case change.Distinction == "":
+		return fmt.Sprintf("was %s in %d", previousAward, previousYear)
+	case change.PreviousDistinction == "":
+		return fmt.Sprintf("new: %s", currentAward)
+	}
+	return fmt.Sprintf("%s → %s", previousAward, currentAward)
+}
+
+// handleWhatsNew shows the changes to favorite and visited restaurants found by the latest database update
+func handleWhatsNew(database *sql.DB, all bool) {
+	var events []db.UpdateEvent
+	var err error
+	timeQuery("get update events", func() error {
+		events, err = db.GetUpdateEvents(database, all)
+		return err
+	})
+	if err != nil {
+		showError(fmt.Sprintf("Error getting update events: %v", err))
+		return
+	}
+
+	if len(events) == 0 {
+		showNoResults("No changes to your favorite or visited restaurants in the last database update")
+		return
+	}
+
+	// Restaurants whose data waits for a confirmed match are not lost yet
+	pendingURLs := make(map[string]bool)
+	if matches, err := db.GetPendingMatches(database); err == nil {
+		for _, m := range matches {
+			pendingURLs[m.URL] = true
+		}
+	}
+
+	// Restaurants still in the database are rendered like search results, in the order of the events
+	var restaurants []db.Restaurant
+	var restaurantChanges []string
+	rendered := make([]bool, len(events))
+	changes := make([]string, len(events))
+	for i, event := range events {
+		changes[i] = formatUpdateEvent(event, pendingURLs)
+		if event.RestaurantID == nil {
+			continue
+		}
+		if r, err := db.GetRestaurantByID(database, *event.RestaurantID); err == nil {
+			restaurants = append(restaurants, r)
+			restaurantChanges = append(restaurantChanges, changes[i])
+			rendered[i] = true
+		}
+	}
+	restaurantItems := renderRestaurants(restaurants, len(restaurants), resultView{
+		Mode: "whats-new",
+		Mods: func(r db.Restaurant) map[string]Mod {
+			return map[string]Mod{"cmd": {Subtitle: "🏆️ award history"}}
+		},
+		Fields: func(i int, fields map[string]string) {
+			fields["award"] = restaurantChanges[i]
+		},
+	})
+
+	items := make([]AlfredItem, 0, len(events))
+	for i, event := range events {
+		if rendered[i] {
+			items = append(items, restaurantItems[0])
+			restaurantItems = restaurantItems[1:]
+			continue
+		}
+
+		restaurantName := event.RestaurantName
+		if restaurantName == "" {
+			restaurantName = "Unknown restaurant"
+		}
+		if event.IsFavorite {
+			restaurantName = restaurantName + " ❤️"
+		}
+		if event.IsVisited {
+			restaurantName = restaurantName + " ✅"
+		}
+		items = append(items, AlfredItem{
+			Title:    restaurantName,
+			Subtitle: changes[i],
+			Valid:    false,
+		})
+	}
+
+	if err := db.MarkUpdateEventsSeen(database); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+	}
+
+	// Return results
+	result := AlfredResult{Items: items}
+	if err := printJSON(result); err != nil {
+		showError(fmt.Sprintf("Error formatting results: %v", err))
+	}
+}
+
+// formatUpdateEvent describes an update event with the date of the update, e.g.
+// "⬆️ 1 Star → 2 Stars | updated 2025-07-01"
+func formatUpdateEvent(event db.UpdateEvent, pendingURLs map[string]bool) string {
+	oldAward := formatAwardWithStarsAndGreenStar(&event.OldValue, nil, nil)
+	newAward := formatAwardWithStarsAndGreenStar(&event.NewValue, nil, nil)
+	var change string
+	switch event.Type {
+	case db.EventAwardChanged:
+		arrow := "⬇️"
+		if event.Improved() {
+			arrow = "⬆️"
+		}
+		change = fmt.Sprintf("%s %s → %s", arrow, oldAward, newAward)
+	case db.EventGreenStarGained:
+		change = "🍀 Gained a green star"
+	case db.EventGreenStarLost:
+		change = "🥀 Lost its green star"
+	case db.EventPriceChanged:
+		change = fmt.Sprintf("💶 Price %s → %s", event.OldValue, event.NewValue)
+	case db.EventLeftGuide:
+		change = fmt.Sprintf("📜 Left the guide (was %s)", oldAward)
+	case db.EventUnmapped:
+		change = "❓ No longer in the database, your data for it could not be kept"
+		if pendingURLs[event.RestaurantURL] {
+			change = "🔗 Michelin link changed, confirm its new entry to keep your data"
+		} else if event.RestaurantID != nil {
+			change = "🔗 Michelin link changed, your data moved to its new entry"
+		}
+	default:
+		change = string(event.Type)
+	}
+
+	updated := event.UpdateID
+	if t, err := time.Parse(time.RFC3339, event.UpdateID); err == nil {
+		updated = t.Local().Format("2006-01-02")
+	}
+	return fmt.Sprintf("%s | updated %s", change, updated)
+}
+
+// matchMethodLabels describes how a pending match was found
+var matchMethodLabels = map[db.MatchMethod]string{
+	db.MatchWebsite:      "🌐 same website",
+	db.MatchNameLocation: "📍 same name nearby",
+	db.MatchPhone:        "📞 same phone number",
+}
+
+// handleMatches lists the candidates for restaurants whose Michelin link changed in an update
+func handleMatches(database *sql.DB) {
+	matches, err := db.GetPendingMatches(database)
+	if err != nil {
+		showError(fmt.Sprintf("Error getting pending matches: %v", err))
+		return
+	}
+
+	if len(matches) == 0 {
+		showNoResults("No matches waiting for confirmation")
+		return
+	}
+
+	items := make([]AlfredItem, 0, len(matches))
+	for _, m := range matches {
+		// What would be moved to the candidate
+		var kept []string
+		if len(m.UserData.Favorites) > 0 {
+			kept = append(kept, "❤️ favorite")
+		}
+		if n := len(m.UserData.Visits); n == 1 {
+			kept = append(kept, "✅ 1 visit")
+		} else if n > 1 {
+			kept = append(kept, fmt.Sprintf("✅ %d visits", n))
+		}
+		if len(m.UserData.Ratings) > 0 {
+			kept = append(kept, fmt.Sprintf("⭐ %d/5", m.UserData.Ratings[0].Rating))
+		}
+		if n := len(m.UserData.Tags); n == 1 {
+			kept = append(kept, "🏷️ 1 tag")
+		} else if n > 1 {
+			kept = append(kept, fmt.Sprintf("🏷️ %d tags", n))
+		}
+		if n := len(m.UserData.Lists); n == 1 {
+			kept = append(kept, "📋 1 list")
+		} else if n > 1 {
+			kept = append(kept, fmt.Sprintf("📋 %d lists", n))
+		}
+
+		method := matchMethodLabels[m.Method]
+		if method == "" {
+			method = string(m.Method)
+		}
+		if m.Distance != nil {
+			method = fmt.Sprintf("%s (%.0f m)", method, *m.Distance)
+		}
+		confidence := fmt.Sprintf("%.0f%% sure", m.Confidence*100)
+		if m.Ambiguous {
+			confidence += ", ⚠️ several candidates"
+		}
+
+		candidate := m.CandidateName
+		if m.CandidateLocation != "" {
+			candidate = fmt.Sprintf("%s, %s", candidate, m.CandidateLocation)
+		}
+
+		items = append(items, AlfredItem{
+			Title:    fmt.Sprintf("%s → %s", m.Name, candidate),
+			Subtitle: fmt.Sprintf("%s · %s | %s", method, confidence, strings.Join(kept, " ")),
+			Arg:      strconv.FormatInt(m.ID, 10),
+			Valid:    true,
+			Variables: map[string]interface{}{
+				"match_id":        m.ID,
+				"restaurant_id":   m.CandidateID,
+				"restaurant_name": m.CandidateName,
+			},
+			Mods: map[string]Mod{
+				"cmd": {
+					Subtitle: "🚫 not the same restaurant, dismiss this candidate",
+				},
+			},
+		})
+	}
+
+	result := AlfredResult{Items: items}
+	if err := printJSON(result); err != nil {
+		showError(fmt.Sprintf("Error formatting results: %v", err))
+	}
+}
+
+// handleConfirmMatch moves the user data of a pending match to its candidate restaurant
+func handleConfirmMatch(database *sql.DB, id int64) {
+	match, err := db.ConfirmPendingMatch(database, id)
+	if err != nil {
+		showError(fmt.Sprintf("Error confirming match: %v", err))
+		return
+	}
+	fmt.Printf("Moved your data for %s to %s 🔗\n", match.Name, match.CandidateName)
+}
+
+// handleDismissMatch rejects a pending match candidate
+func handleDismissMatch(database *sql.DB, id int64) {
+	match, err := db.DismissPendingMatch(database, id)
+	if err != nil {
+		showError(fmt.Sprintf("Error dismissing match: %v", err))
+		return
+	}
+	fmt.Printf("Dismissed %s as a match for %s 🚫\n", match.CandidateName, match.Name)
+}
+
+// datasetVersionLabel describes the installed dataset for display
+func datasetVersionLabel(version string) string {
+	if version == "" {
+		return "unversioned dataset"
+	}
+	return "dataset " + version
+}
+
+// handleStatus shows the installed dataset, schema versions, update source and backups
+func handleStatus(database *sql.DB, dbPath string) {
+	info, err := db.GetDatasetInfo(database)
+	if err != nil {
+		showError(fmt.Sprintf("Error reading dataset info: %v", err))
+		return
+	}
+	migrations, err := db.SchemaVersion(database)
+	if err != nil {
+		showError(fmt.Sprintf("Error reading schema version: %v", err))
+		return
+	}
+
+	var details []string
+	if info.LatestYear > 0 {
+		details = append(details, fmt.Sprintf("Guide %d", info.LatestYear))
+	}
+	details = append(details, fmt.Sprintf("%s restaurants", formatNumber(info.Restaurants)))
+	if info.DatasetDate != "" {
+		details = append(details, "from "+info.DatasetDate)
+	} else if len(info.BuiltAt) >= 10 {
+		details = append(details, "built "+info.BuiltAt[:10])
+	}
+	if info.Signed {
+		details = append(details, "✅ signature verified")
+	} else {
+		details = append(details, "signature not verified")
+	}
+
+	source := os.Getenv("UPDATE_SOURCE")
+	sourceSubtitle := source
+	if source == "" {
+		sourceSubtitle = "Not configured, set UPDATE_SOURCE in the workflow configuration"
+	}
+
+	backupSubtitle := "No backups yet, one is made before every update"
+	if backups, err := db.ListBackups(dbPath); err == nil && len(backups) > 0 {
+		backupSubtitle = fmt.Sprintf("%d kept, newest %s", len(backups), filepath.Base(backups[0]))
+	}
+
+	items := []AlfredItem{
+		{
+			Title:    "📦 Installed " + datasetVersionLabel(info.Version),
+			Subtitle: strings.Join(details, " · "),
+			Valid:    false,
+		},
+		{
+			Title:    fmt.Sprintf("🗄️ Schema migration %d", migrations),
+			Subtitle: fmt.Sprintf("Restaurant data format %d, this workflow reads up to %d", info.SchemaVersion, db.DatabaseSchemaVersion),
+			Valid:    false,
+		},
+		{
+			Title:    "⬇️ Update source",
+			Subtitle: sourceSubtitle,
+			Valid:    false,
+		},
+		{
+			Title:    "💾 Backups",
+			Subtitle: backupSubtitle,
+			Valid:    false,
+		},
+	}
+
+	if err := printJSON(AlfredResult{Items: items}); err != nil {
+		showError(fmt.Sprintf("Error formatting results: %v", err))
+	}
+}
+
+// handleCheckUpdate shows whether UPDATE_SOURCE offers a newer dataset; actioning it runs update
+func handleCheckUpdate(dbPath string) {
+	source, err := db.ParseUpdateSource(os.Getenv("UPDATE_SOURCE"))
+	if err != nil {
+		showNoResults(fmt.Sprintf("Set an update source in the workflow configuration (%v)", err))
 		return
 	}
 
-	// Check if no favorites found
-	if len(restaurants) == 0 {
-		showNoResults("No favorite restaurants found.")
+	update, err := db.CheckForUpdate(dbPath, source)
+	if db.IsNoUpdateAvailable(err) {
+		showNoResults(fmt.Sprintf("No dataset found in %s", source.Location))
+		return
+	}
+	if db.IsUpdateRejected(err) {
+		showNoResults(fmt.Sprintf("⛔ %v", err))
+		return
+	}
+	if err != nil {
+		showError(fmt.Sprintf("Error checking for updates: %v", err))
 		return
 	}
 
-	// Format results for Alfred
-	items := make([]AlfredItem, 0, len(restaurants))
-	totalCount := len(restaurants)
-
-	for i, r := range restaurants {
-		// Get cuisine or set default value
-		cuisine := "Unknown cuisine"
-		if r.Cuisine != nil && *r.Cuisine != "" {
-			cuisine = *r.Cuisine
+	var item AlfredItem
+	if update == nil {
+		item = AlfredItem{
+			Title:    "✅ The restaurant database is up to date",
+			Subtitle: fmt.Sprintf("Checked %s", source),
+			Valid:    false,
 		}
-
-		// Get location or set default value
-		location := "Unknown location"
-		if r.Location != nil && *r.Location != "" {
-			location = *r.Location
+	} else {
+		available := update.Manifest.Version
+		if available == "" {
+			available = "a new dataset"
+		} else {
+			available = "dataset " + available
 		}
-
-		// Get restaurant name
-		restaurantName := "Unknown restaurant"
-		if r.Name != nil && *r.Name != "" {
-			restaurantName = *r.Name
+		item = AlfredItem{
+			Title:    fmt.Sprintf("⬇️ Install %s", available),
+			Subtitle: fmt.Sprintf("Installed: %s · from %s · your favorites, visits and lists are kept", datasetVersionLabel(update.Installed), source),
+			Arg:      "update",
+			Valid:    true,
 		}
+	}
 
-		// Add scroll emoji if restaurant is no longer in the guide
-		if r.InGuide == 0 {
-			restaurantName = restaurantName + " 📜"
-		}
+	if err := printJSON(AlfredResult{Items: []AlfredItem{item}}); err != nil {
+		showError(fmt.Sprintf("Error formatting results: %v", err))
+	}
+}
 
-		// Add heart emoji to title (always for favorites list)
-		restaurantName = restaurantName + " ❤️"
+// handleUpdate downloads, verifies and installs a new dataset from UPDATE_SOURCE
+func handleUpdate(dbPath string) {
+	source, err := db.ParseUpdateSource(os.Getenv("UPDATE_SOURCE"))
+	if err != nil {
+		fmt.Printf("Cannot update: %v\n", err)
+		return
+	}
 
-		// Add visited emoji to title if needed
-		if r.IsVisited {
-			restaurantName = restaurantName + " ✅"
-		}
+	unlock, err := db.Lock(dbPath)
+	if err != nil {
+		fmt.Printf("Cannot update: %v\n", err)
+		return
+	}
+	defer unlock()
 
-		// Format award display with stars and year
-		award := formatAwardWithYearRange(r.CurrentAward, r.CurrentAwardYear, r.CurrentAwardLastYear, r.CurrentGreenStar, r.InGuide)
+	update, err := db.CheckForUpdate(dbPath, source)
+	if db.IsNoUpdateAvailable(err) {
+		fmt.Printf("No dataset found in %s\n", source.Location)
+		return
+	}
+	if db.IsUpdateRejected(err) {
+		fmt.Printf("⛔ Update refused: %s\n", err.(*db.UpdateRejectedError).Reason)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Update check failed: %v\n", err)
+		return
+	}
+	if update == nil {
+		fmt.Println("The restaurant database is already up to date ✅")
+		return
+	}
 
-		// Create counter prefix
-		counter := fmt.Sprintf("%s/%s", formatNumber(i+1), formatNumber(totalCount))
+	installed, err := db.InstallUpdate(dbPath, update)
+	if db.IsUpdateRejected(err) {
+		fmt.Printf("⛔ Update refused, your database was not changed: %s\n", err.(*db.UpdateRejectedError).Reason)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Update failed, your database was not changed: %v\n", err)
+		return
+	}
+	fmt.Printf("Updated from %s to %s: %d restaurants ⬇️\n", datasetVersionLabel(update.Installed), datasetVersionLabel(installed.Version), installed.Restaurants)
+}
 
-		// Determine the OPEN_IN_URL variable based on user preference
-		openIn := os.Getenv("OPEN_IN")
-		var openInURL string
-		if openIn == "" {
-			openIn = "restaurant" // default
-		}
-		switch openIn {
-		case "restaurant":
-			if r.WebsiteUrl != nil && *r.WebsiteUrl != "" {
-				openInURL = *r.WebsiteUrl
-			} else if r.Url != nil && *r.Url != "" {
-				openInURL = *r.Url
-			}
-		case "michelin":
-			if r.Url != nil && *r.Url != "" {
-				openInURL = *r.Url
-			}
-		case "maps":
-			if r.Longitude != nil && r.Latitude != nil && *r.Longitude != "" && *r.Latitude != "" {
-				openInURL = fmt.Sprintf("https://www.google.com/maps/@%s,%s,17z", *r.Latitude, *r.Longitude)
-			}
-		case "apple_maps":
-			if r.Latitude != nil && r.Longitude != nil && *r.Latitude != "" && *r.Longitude != "" {
-				if r.Name != nil && *r.Name != "" {
-					openInURL = fmt.Sprintf("https://maps.apple.com/?q=%s&ll=%s,%s", url.QueryEscape(*r.Name), *r.Latitude, *r.Longitude)
-				} else {
-					openInURL = fmt.Sprintf("https://maps.apple.com/?ll=%s,%s", *r.Latitude, *r.Longitude)
-				}
-			}
+// restaurantOpenURL returns the URL opened for a restaurant according to the OPEN_IN preference
+func restaurantOpenURL(r db.Restaurant) string {
+	hasCoordinates := r.Latitude != nil && r.Longitude != nil && *r.Latitude != "" && *r.Longitude != ""
+	switch os.Getenv("OPEN_IN") {
+	case "michelin":
+		if r.Url != nil {
+			return *r.Url
+		}
+	case "maps":
+		if hasCoordinates && r.Name != nil && *r.Name != "" {
+			return fmt.Sprintf("https://www.google.com/maps?q=%s&ll=%s,%s&z=15", url.QueryEscape(*r.Name), *r.Latitude, *r.Longitude)
+		} else if hasCoordinates {
+			return fmt.Sprintf("https://www.google.com/maps?ll=%s,%s&z=15", *r.Latitude, *r.Longitude)
+		}
+	case "apple_maps":
+		if hasCoordinates && r.Name != nil && *r.Name != "" {
+			return fmt.Sprintf("https://maps.apple.com/?q=%s&ll=%s,%s", url.QueryEscape(*r.Name), *r.Latitude, *r.Longitude)
+		} else if hasCoordinates {
+			return fmt.Sprintf("https://maps.apple.com/?ll=%s,%s", *r.Latitude, *r.Longitude)
 		}
-
-		item := AlfredItem{
-			Title: restaurantName,
-			Subtitle: fmt.Sprintf("%s | %s | %s | %s",
-				counter,
-				location,
-				award,
-				cuisine),
-			Arg:   "",
-			Valid: true,
-			Variables: map[string]interface{}{
-				"restaurant_id":      r.ID,
-				"restaurant_name":    restaurantName,
-				"restaurant_url":     r.Url,
-				"website_url":        r.WebsiteUrl,
-				"mode":               "favorites",
-				"imageURL":           r.ImageURL,
-				"myDescription":      r.Description,
-				"restaurant_address": r.Address,
-				"restaurant_award":   award,
-				"OPEN_IN_URL":        openInURL,
-			},
+	default:
+		if r.WebsiteUrl != nil && *r.WebsiteUrl != "" {
+			return *r.WebsiteUrl
+		} else if r.Url != nil {
+			return *r.Url
 		}
+	}
+	return ""
+}
 
-		// Add icon based on award type
-		if r.CurrentAward != nil {
-			awardLower := strings.ToLower(*r.CurrentAward)
-			if strings.Contains(awardLower, "bib gourmand") {
-				item.Icon = map[string]string{
-					"path": "icons/bibg.png",
-				}
-			} else if strings.Contains(awardLower, "selected restaurant") {
-				item.Icon = map[string]string{
-					"path": "icons/star.png",
-				}
-			}
+// handleExportUserData writes all personal data to a JSON file, or CSV when the path ends in .csv
+func handleExportUserData(database *sql.DB, path string) {
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			showError(fmt.Sprintf("Error finding home directory: %v", err))
+			return
 		}
-
-		items = append(items, item)
+		path = filepath.Join(home, "Downloads", "michelin-user-data-"+time.Now().Format("2006-01-02")+".json")
 	}
 
-	// Return results
-	result := AlfredResult{Items: items}
-	if err := printJSON(result); err != nil {
-		showError(fmt.Sprintf("Error formatting results: %v", err))
+	data, err := db.ExportUserData(database)
+	if err != nil {
+		showError(fmt.Sprintf("Error exporting user data: %v", err))
+		return
 	}
-}
-
-// handleVisited shows all visited restaurants
-func handleVisited(database *sql.DB) {
-	// Get all visited restaurants with timing
-	var restaurants []db.Restaurant
-	var err error
-
-	timeQuery("get visited", func() error {
-		restaurants, err = db.GetVisitedRestaurants(database)
-		return err
-	})
 
+	file, err := os.Create(path)
 	if err != nil {
-		showError(fmt.Sprintf("Error getting visited restaurants: %v", err))
+		showError(fmt.Sprintf("Error creating export file: %v", err))
 		return
 	}
+	defer file.Close()
 
-	// Check if no visited restaurants found
-	if len(restaurants) == 0 {
-		showNoResults("No visited restaurants found.")
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = db.WriteUserDataCSV(file, data)
+	} else {
+		err = db.WriteUserDataJSON(file, data)
+	}
+	if err != nil {
+		showError(fmt.Sprintf("Error exporting user data: %v", err))
 		return
 	}
 
-	// Format results for Alfred
-	items := make([]AlfredItem, 0, len(restaurants))
-	totalCount := len(restaurants)
+	fmt.Printf("Exported %d favorites, %d visits, %d ratings, %d tags and %d lists to %s 📤\n",
+		len(data.Favorites), len(data.Visits), len(data.Ratings), len(data.Tags), len(data.Lists), path)
+}
 
-	for i, r := range restaurants {
-		// Get cuisine or set default value
-		cuisine := "Unknown cuisine"
-		if r.Cuisine != nil && *r.Cuisine != "" {
-			cuisine = *r.Cuisine
-		}
+// handleImportUserData merges a file written by export-user-data into the database
+func handleImportUserData(database *sql.DB, path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		showError(fmt.Sprintf("Error opening import file: %v", err))
+		return
+	}
+	defer file.Close()
 
-		// Get location or set default value
-		location := "Unknown location"
-		if r.Location != nil && *r.Location != "" {
-			location = *r.Location
-		}
+	var data *db.UserData
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		data, err = db.ReadUserDataCSV(file)
+	} else {
+		data, err = db.ReadUserDataJSON(file)
+	}
+	if err != nil {
+		showError(fmt.Sprintf("Error reading %s: %v", path, err))
+		return
+	}
 
-		// Get restaurant name
-		restaurantName := "Unknown restaurant"
-		if r.Name != nil && *r.Name != "" {
-			restaurantName = *r.Name
-		}
+	stats, err := db.ImportUserData(database, data)
+	if err != nil {
+		showError(fmt.Sprintf("Error importing user data: %v", err))
+		return
+	}
 
-		// Add scroll emoji if restaurant is no longer in the guide
-		if r.InGuide == 0 {
-			restaurantName = restaurantName + " 📜"
-		}
+	fmt.Printf("Imported %d favorites, %d visits, %d ratings, %d tags, %d lists (%d entries) and %d locations 📥\n",
+		stats.Favorites, stats.Visits, stats.Ratings, stats.Tags, stats.Lists, stats.ListItems, stats.Locations)
+	if stats.Duplicates > 0 {
+		fmt.Printf("Skipped %d entries already present\n", stats.Duplicates)
+	}
+	if stats.Unmatched > 0 {
+		fmt.Printf("⚠️ %d entries refer to restaurants not in this database\n", stats.Unmatched)
+	}
+}
 
-		// Add heart emoji to title for favorites
-		if r.IsFavorite {
-			restaurantName = restaurantName + " ❤️"
+// exportFileName turns a name into a safe file name, e.g. "Tokyo trip 2026" -> "tokyo-trip-2026.csv"
+func exportFileName(name, extension string) string {
+	var b strings.Builder
+	lastDash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			lastDash = false
+		} else if !lastDash && b.Len() > 0 {
+			b.WriteRune('-')
+			lastDash = true
 		}
+	}
+	slug := strings.TrimSuffix(b.String(), "-")
+	if slug == "" {
+		slug = "michelin"
+	}
+	return slug + extension
+}
 
-		// Add visited emoji to title (always for visited list)
-		restaurantName = restaurantName + " ✅"
-
-		// Format award display with stars and year
-		award := formatAwardWithYearRange(r.CurrentAward, r.CurrentAwardYear, r.CurrentAwardLastYear, r.CurrentGreenStar, r.InGuide)
-
-		// Create counter prefix
-		counter := fmt.Sprintf("%s/%s", formatNumber(i+1), formatNumber(totalCount))
-
-		// Create Alfred item
-		subtitle := fmt.Sprintf("%s | %s | %s | %s",
-			counter,
-			location,
-			award,
-			cuisine)
+// handleSetLocation saves a named location used by the near: and within: search tokens
+func handleSetLocation(database *sql.DB, name, coordinates string) {
+	lat, lon, err := db.ParseCoordinates(coordinates)
+	if err != nil {
+		showError(err.Error())
+		return
+	}
 
-		// Add visit date at the end if available
-		if r.VisitedDate != nil && *r.VisitedDate != "" {
-			subtitle = fmt.Sprintf("%s | Visited: %s", subtitle, *r.VisitedDate)
-		}
+	if err := db.SaveLocation(database, name, lat, lon); err != nil {
+		showError(fmt.Sprintf("Error saving location: %v", err))
+		return
+	}
 
-		// Determine the OPEN_IN_URL variable based on user preference
-		openIn := os.Getenv("OPEN_IN")
-		var openInURL string
-		if openIn == "" {
-			openIn = "restaurant" // default
-		}
-		switch openIn {
-		case "restaurant":
-			if r.WebsiteUrl != nil && *r.WebsiteUrl != "" {
-				openInURL = *r.WebsiteUrl
-			} else if r.Url != nil && *r.Url != "" {
-				openInURL = *r.Url
-			}
-		case "michelin":
-			if r.Url != nil && *r.Url != "" {
-				openInURL = *r.Url
-			}
-		case "maps":
-			if r.Longitude != nil && r.Latitude != nil && *r.Longitude != "" && *r.Latitude != "" {
-				openInURL = fmt.Sprintf("https://www.google.com/maps/@%s,%s,17z", *r.Latitude, *r.Longitude)
-			}
-		case "apple_maps":
-			if r.Latitude != nil && r.Longitude != nil && *r.Latitude != "" && *r.Longitude != "" {
-				if r.Name != nil && *r.Name != "" {
-					openInURL = fmt.Sprintf("https://maps.apple.com/?q=%s&ll=%s,%s", url.QueryEscape(*r.Name), *r.Latitude, *r.Longitude)
-				} else {
-					openInURL = fmt.Sprintf("https://maps.apple.com/?ll=%s,%s", *r.Latitude, *r.Longitude)
-				}
-			}
-		}
+	fmt.Printf("Saved location %s 📍\n", strings.ToLower(name))
+}
 
-		item := AlfredItem{
-			Title:    restaurantName,
-			Subtitle: subtitle,
-			Arg:      "",
-			Valid:    true,
-			Variables: map[string]interface{}{
-				"restaurant_id":      r.ID,
-				"restaurant_name":    restaurantName,
-				"restaurant_url":     r.Url,
-				"website_url":        r.WebsiteUrl,
-				"mode":               "visited",
-				"imageURL":           r.ImageURL,
-				"myDescription":      r.Description,
-				"restaurant_address": r.Address,
-				"restaurant_award":   award,
-				"OPEN_IN_URL":        openInURL,
-			},
-		}
+// handleLocations shows all saved locations
+func handleLocations(database *sql.DB) {
+	locations, err := db.GetLocations(database)
+	if err != nil {
+		showError(fmt.Sprintf("Error getting locations: %v", err))
+		return
+	}
 
-		// Add icon based on award type
-		if r.CurrentAward != nil {
-			awardLower := strings.ToLower(*r.CurrentAward)
-			if strings.Contains(awardLower, "bib gourmand") {
-				item.Icon = map[string]string{
-					"path": "icons/bibg.png",
-				}
-			} else if strings.Contains(awardLower, "selected restaurant") {
-				item.Icon = map[string]string{
-					"path": "icons/star.png",
-				}
-			}
-		}
+	if len(locations) == 0 {
+		showNoResults("No saved locations. Use set-location <name> <lat,lon>.")
+		return
+	}
 
-		items = append(items, item)
+	items := make([]AlfredItem, 0, len(locations))
+	for _, location := range locations {
+		items = append(items, AlfredItem{
+			Title:        fmt.Sprintf("📍 %s", location.Name),
+			Subtitle:     fmt.Sprintf("%.5f,%.5f | saved %s", location.Latitude, location.Longitude, location.UpdatedAt),
+			Arg:          fmt.Sprintf("near:%s", location.Name),
+			Autocomplete: fmt.Sprintf("near:%s ", location.Name),
+			Valid:        true,
+		})
 	}
 
-	// Return results
 	result := AlfredResult{Items: items}
 	if err := printJSON(result); err != nil {
 		showError(fmt.Sprintf("Error formatting results: %v", err))
@@ -1387,6 +2834,50 @@ func formatNumber(n int) string {
 	return result
 }
 
+// formatDistance formats a distance in kilometres, switching to metres below 1 km
+func formatDistance(km float64) string {
+	if km < 1 {
+		return fmt.Sprintf("%d m", int(km*1000))
+	}
+	return fmt.Sprintf("%.1f km", km)
+}
+
+// formatPrice formats a price as the guide shows it, e.g. "€€€", or as dollar signs for the guide's
+// price category codes; "" when there is no price
+func formatPrice(price *string, level int) string {
+	if price == nil {
+		return ""
+	}
+	if strings.HasPrefix(*price, "CAT_P") && level > 0 {
+		return strings.Repeat("$", level)
+	}
+	return strings.TrimSpace(*price)
+}
+
+// formatUserRating formats a personal rating as a title suffix, e.g. " ★★★★☆", or "" when unrated
+func formatUserRating(rating *int) string {
+	if rating == nil {
+		return ""
+	}
+	return " " + strings.Repeat("★", *rating) + strings.Repeat("☆", 5-*rating)
+}
+
+// formatVisitSummary formats the number of visits and the last visit date, e.g. "3 visits, last 2024-05-01"
+func formatVisitSummary(count int, lastDate *string) string {
+	summary := "Visited"
+	if count > 1 {
+		summary = fmt.Sprintf("%d visits", count)
+	}
+	if lastDate != nil && *lastDate != "" {
+		if count > 1 {
+			summary = fmt.Sprintf("%s, last %s", summary, *lastDate)
+		} else {
+			summary = fmt.Sprintf("%s: %s", summary, *lastDate)
+		}
+	}
+	return summary
+}
+
 // formatAwardWithStarsAndGreenStar formats award display with stars replacing text, year in parentheses, and green star emoji
 func formatAwardWithStarsAndGreenStar(award *string, year *int, greenStar *bool) string {
 	if award == nil || *award == "" {
@@ -1466,7 +2957,7 @@ func formatAwardWithYearRange(award *string, firstYear *int, lastYear *int, gree
 }
 
 // handleShowDescription handles the showDescription command
-func handleShowDescription(workDir string) {
+func handleShowDescription(database *sql.DB, workDir string) {
 	// Step 1: Retrieve environmental variables
 	myDescription := os.Getenv("myDescription")
 	imageURL := os.Getenv("imageURL")
@@ -1477,6 +2968,9 @@ func handleShowDescription(workDir string) {
 	if myDescription == "" {
 		myDescription = "No description available"
 	}
+	if facilities := formatFacilities(database, os.Getenv("restaurant_id")); facilities != "" {
+		myDescription += "\n\n" + facilities
+	}
 	if restaurantAddress == "" {
 		restaurantAddress = "No address available"
 	}
@@ -1673,6 +3167,50 @@ func handleShowDescription(workDir string) {
 	fmt.Println(string(jsonBytes))
 }
 
+// facilityIcons are shown next to the facilities of a restaurant in the description panel
+var facilityIcons = map[string]string{
+	"wheelchair":    "♿",
+	"terrace":       "☀️",
+	"parking":       "🅿️",
+	"view":          "🏞️",
+	"garden":        "🌳",
+	"aircon":        "❄️",
+	"counter":       "🍽️",
+	"private":       "🚪",
+	"vegetarian":    "🥗",
+	"wine":          "🍷",
+	"sake":          "🍶",
+	"cocktails":     "🍸",
+	"cash":          "💵",
+	"brunch":        "🥞",
+	"accommodation": "🛏️",
+	"shoes":         "👟",
+}
+
+// formatFacilities formats the facilities of a restaurant for the description panel, e.g.
+// "♿ Wheelchair access · ☀️ Terrace", or "" when it has none
+func formatFacilities(database *sql.DB, restaurantID string) string {
+	id, err := strconv.ParseInt(restaurantID, 10, 64)
+	if err != nil {
+		return ""
+	}
+	facilities, err := db.GetRestaurantFacilities(database, id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] Failed to get facilities: %v\n", err)
+		return ""
+	}
+
+	parts := make([]string, 0, len(facilities))
+	for _, f := range facilities {
+		icon, ok := facilityIcons[f.Key]
+		if !ok {
+			icon = "•"
+		}
+		parts = append(parts, icon+" "+f.Name)
+	}
+	return strings.Join(parts, " · ")
+}
+
 // extractFilenameFromURL extracts the filename from a URL
 func extractFilenameFromURL(url string) string {
 	// Split by '/' and get the last part
@@ -1736,182 +3274,6 @@ func printJSON(result AlfredResult) error {
 	return nil
 }
 
-// extractZipFile extracts a zip file containing a database to the specified path
-func extractZipFile(zipPath, extractPath string) error {
-	// Open zip file
-	r, err := zip.OpenReader(zipPath)
-	if err != nil {
-		return err
-	}
-	defer r.Close()
-
-	// Find the database file in the zip
-	var dbFile *zip.File
-	for _, f := range r.File {
-		if strings.HasSuffix(f.Name, ".db") {
-			dbFile = f
-			break
-		}
-	}
-
-	if dbFile == nil {
-		return fmt.Errorf("no .db file found in zip archive")
-	}
-
-	// Extract the database file
-	rc, err := dbFile.Open()
-	if err != nil {
-		return err
-	}
-	defer rc.Close()
-
-	outFile, err := os.OpenFile(extractPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, dbFile.Mode())
-	if err != nil {
-		return err
-	}
-	defer outFile.Close()
-
-	_, err = io.Copy(outFile, rc)
-	return err
-}
-
-// copyFile copies a file from src to dst
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer sourceFile.Close()
-
-	destFile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer destFile.Close()
-
-	_, err = io.Copy(destFile, sourceFile)
-	return err
-}
-
-// preserveUserData copies user-generated tables from old database to new database
-func preserveUserData(oldDbPath, newDbPath string) error {
-	// Open old database
-	oldDb, err := sql.Open("sqlite3", oldDbPath)
-	if err != nil {
-		return fmt.Errorf("failed to open old database: %v", err)
-	}
-	defer oldDb.Close()
-
-	// Open new database
-	newDb, err := sql.Open("sqlite3", newDbPath)
-	if err != nil {
-		return fmt.Errorf("failed to open new database: %v", err)
-	}
-	defer newDb.Close()
-
-	// Check if user tables exist in old database
-	var userFavoritesExist, userVisitsExist bool
-
-	// Check for user_favorites table
-	rows, err := oldDb.Query("SELECT name FROM sqlite_master WHERE type='table' AND name='user_favorites'")
-	if err != nil {
-		return fmt.Errorf("failed to check for user_favorites table: %v", err)
-	}
-	userFavoritesExist = rows.Next()
-	rows.Close()
-
-	// Check for user_visits table
-	rows, err = oldDb.Query("SELECT name FROM sqlite_master WHERE type='table' AND name='user_visits'")
-	if err != nil {
-		return fmt.Errorf("failed to check for user_visits table: %v", err)
-	}
-	userVisitsExist = rows.Next()
-	rows.Close()
-
-	if !userFavoritesExist && !userVisitsExist {
-		// No user data to preserve
-		return nil
-	}
-
-	// Create user tables in new database if they don't exist
-	_, err = newDb.Exec(`
-		CREATE TABLE IF NOT EXISTS user_favorites (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			restaurant_id INTEGER NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (restaurant_id) REFERENCES restaurants(id),
-			UNIQUE (restaurant_id)
-		);
-		
-		CREATE TABLE IF NOT EXISTS user_visits (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			restaurant_id INTEGER NOT NULL,
-			visited_date TEXT,
-			notes TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (restaurant_id) REFERENCES restaurants(id),
-			UNIQUE (restaurant_id)
-		);
-		
-		CREATE INDEX IF NOT EXISTS idx_user_favorites_restaurant ON user_favorites(restaurant_id);
-		CREATE INDEX IF NOT EXISTS idx_user_visits_restaurant ON user_visits(restaurant_id);
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to create user tables in new database: %v", err)
-	}
-
-	// Copy user_favorites if it exists
-	if userFavoritesExist {
-		rows, err := oldDb.Query("SELECT restaurant_id, created_at FROM user_favorites")
-		if err != nil {
-			return fmt.Errorf("failed to query user_favorites from old database: %v", err)
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var restaurantID int64
-			var createdAt string
-			if err := rows.Scan(&restaurantID, &createdAt); err != nil {
-				return fmt.Errorf("failed to scan user_favorites row: %v", err)
-			}
-
-			// Insert into new database
-			_, err := newDb.Exec("INSERT OR IGNORE INTO user_favorites (restaurant_id, created_at) VALUES (?, ?)", restaurantID, createdAt)
-			if err != nil {
-				return fmt.Errorf("failed to insert user_favorites into new database: %v", err)
-			}
-		}
-		fmt.Fprintf(os.Stderr, "[DEBUG] Copied user_favorites table\n")
-	}
-
-	// Copy user_visits if it exists
-	if userVisitsExist {
-		rows, err := oldDb.Query("SELECT restaurant_id, visited_date, notes, created_at FROM user_visits")
-		if err != nil {
-			return fmt.Errorf("failed to query user_visits from old database: %v", err)
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var restaurantID int64
-			var visitedDate, notes, createdAt sql.NullString
-			if err := rows.Scan(&restaurantID, &visitedDate, &notes, &createdAt); err != nil {
-				return fmt.Errorf("failed to scan user_visits row: %v", err)
-			}
-
-			// Insert into new database
-			_, err := newDb.Exec("INSERT OR IGNORE INTO user_visits (restaurant_id, visited_date, notes, created_at) VALUES (?, ?, ?, ?)",
-				restaurantID, visitedDate, notes, createdAt)
-			if err != nil {
-				return fmt.Errorf("failed to insert user_visits into new database: %v", err)
-			}
-		}
-		fmt.Fprintf(os.Stderr, "[DEBUG] Copied user_visits table\n")
-	}
-
-	return nil
-}
-
 // DescriptionResponse represents the JSON response for showDescription
 type DescriptionResponse struct {
 	Variables map[string]interface{} `json:"variables"`
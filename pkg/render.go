@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/giovanni/alfred-michelin/db"
+)
+
+const (
+	// defaultTitleFormat is used when TITLE_FORMAT is not set
+	defaultTitleFormat = "{name} {guide} {favorite} {visited} {rating}"
+	// defaultSubtitleFormat is used when SUBTITLE_FORMAT is not set
+	defaultSubtitleFormat = "{counter} | {distance} | {city} | {award} | {price} | {cuisine} | {visits} | {notes}"
+)
+
+// resultView describes a list of restaurant results: the mode passed on to the actions, the
+// variables it adds to every result and the modifiers offered on each one. Fields, when set,
+// adjusts the template fields of the i-th result, e.g. to show what changed in place of the award.
+type resultView struct {
+	Mode      string
+	Variables map[string]interface{}
+	Mods      func(r db.Restaurant) map[string]Mod
+	Fields    func(i int, fields map[string]string)
+}
+
+// restaurantTemplate is a title or subtitle format, text with {field} placeholders
+type restaurantTemplate []templatePart
+
+// templatePart is either literal text or, when field is set, a placeholder
+type templatePart struct {
+	text  string
+	field string
+}
+
+// parseTemplate splits a format into text and placeholders; unmatched braces are kept as text
+func parseTemplate(format string) restaurantTemplate {
+	var t restaurantTemplate
+	for format != "" {
+		start := strings.Index(format, "{")
+		end := strings.Index(format[max(start, 0):], "}") + max(start, 0)
+		if start < 0 || end < start {
+			t = append(t, templatePart{text: format})
+			break
+		}
+		if start > 0 {
+			t = append(t, templatePart{text: format[:start]})
+		}
+		t = append(t, templatePart{field: format[start+1 : end]})
+		format = format[end+1:]
+	}
+	return t
+}
+
+// render fills in the placeholders. A placeholder without a value is left out together with the
+// text before it, so that "{counter} | {distance} | {city}" has no empty segment when there is
+// no distance. Unknown placeholders are shown as written.
+func (t restaurantTemplate) render(values map[string]string) string {
+	var b strings.Builder
+	separator := ""
+	shown, dropped := false, false
+	for i, p := range t {
+		if p.field == "" && i == 0 {
+			b.WriteString(p.text)
+		} else if p.field == "" {
+			separator = p.text
+		} else if value, ok := values[p.field]; ok && value == "" {
+			separator, dropped = "", true
+		} else {
+			if !ok {
+				value = "{" + p.field + "}"
+			}
+			if shown {
+				b.WriteString(separator)
+			}
+			b.WriteString(value)
+			separator, shown, dropped = "", true, false
+		}
+	}
+	// Closing text, such as the ")" of "({price})", goes with the last placeholder
+	if !dropped {
+		b.WriteString(separator)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// restaurantFields returns the values of the template placeholders for a restaurant shown at
+// position out of total
+func restaurantFields(r db.Restaurant, position, total int) map[string]string {
+	fields := map[string]string{
+		"name":     "Unknown restaurant",
+		"city":     "Unknown location",
+		"cuisine":  "Unknown cuisine",
+		"counter":  fmt.Sprintf("%s/%s", formatNumber(position), formatNumber(total)),
+		"award":    formatAwardWithYearRange(r.CurrentAward, r.CurrentAwardYear, r.CurrentAwardLastYear, r.CurrentGreenStar, r.InGuide),
+		"rating":   strings.TrimSpace(formatUserRating(r.UserRating)),
+		"guide":    "",
+		"favorite": "",
+		"visited":  "",
+		"visits":   "",
+		"distance": "",
+		"price":    "",
+		"address":  "",
+		"notes":    "",
+	}
+	if r.Name != nil && *r.Name != "" {
+		fields["name"] = *r.Name
+	}
+	if r.Location != nil && *r.Location != "" {
+		fields["city"] = *r.Location
+	}
+	fields["location"] = fields["city"]
+	if r.Cuisine != nil && *r.Cuisine != "" {
+		fields["cuisine"] = *r.Cuisine
+	}
+	if r.InGuide == 0 {
+		fields["guide"] = "📜"
+	}
+	if r.IsFavorite {
+		fields["favorite"] = "❤️"
+	}
+	if r.IsVisited {
+		fields["visited"] = "✅"
+		fields["visits"] = formatVisitSummary(r.VisitCount, r.VisitedDate)
+	}
+	if r.DistanceKm != nil {
+		fields["distance"] = "📍 " + formatDistance(*r.DistanceKm)
+	}
+	fields["price"] = formatPrice(r.CurrentPrice, r.CurrentPriceLevel)
+	if r.Address != nil {
+		fields["address"] = *r.Address
+	}
+	if r.ListNotes != nil && *r.ListNotes != "" {
+		fields["notes"] = "📝 " + *r.ListNotes
+	}
+	return fields
+}
+
+// resultFormats returns the title and subtitle templates set in TITLE_FORMAT and SUBTITLE_FORMAT
+func resultFormats() (restaurantTemplate, restaurantTemplate) {
+	title := os.Getenv("TITLE_FORMAT")
+	if strings.TrimSpace(title) == "" {
+		title = defaultTitleFormat
+	}
+	subtitle := os.Getenv("SUBTITLE_FORMAT")
+	if strings.TrimSpace(subtitle) == "" {
+		subtitle = defaultSubtitleFormat
+	}
+	return parseTemplate(title), parseTemplate(subtitle)
+}
+
+// restaurantIcon returns the icon of a result: a black star for restaurants no longer in the guide,
+// otherwise the Bib Gourmand or Selected Restaurants icon, or nil for the workflow icon
+func restaurantIcon(r db.Restaurant) map[string]string {
+	if r.InGuide == 0 {
+		return map[string]string{"path": "icons/blackStar.png"}
+	}
+	if r.CurrentAward == nil {
+		return nil
+	}
+	award := strings.ToLower(*r.CurrentAward)
+	if strings.Contains(award, "bib gourmand") {
+		return map[string]string{"path": "icons/bibg.png"}
+	} else if strings.Contains(award, "selected restaurant") {
+		return map[string]string{"path": "icons/star.png"}
+	}
+	return nil
+}
+
+// favoriteAction and visitedAction describe what the favorite and visit modifiers do for a restaurant
+func favoriteAction(r db.Restaurant) string {
+	if r.IsFavorite {
+		return "💔 remove from favorites"
+	}
+	return "❤️ add to favorites"
+}
+
+func visitedAction(r db.Restaurant) string {
+	if r.IsVisited {
+		return fmt.Sprintf("✅ log, edit or delete visits (%s)", formatVisitSummary(r.VisitCount, r.VisitedDate))
+	}
+	return "✅ log a visit"
+}
+
+// contactMods are the modifiers shared by every list of restaurants: call or copy its phone number
+// and save it as a contact
+func contactMods(r db.Restaurant) map[string]Mod {
+	mods := map[string]Mod{"cmd+shift": {Subtitle: "📇 save as a contact (vCard)"}}
+	if r.PhoneNumber == nil || *r.PhoneNumber == "" {
+		invalid := false
+		mods["ctrl+shift"] = Mod{Subtitle: "📞 no phone number", Valid: &invalid}
+		mods["alt+shift"] = Mod{Subtitle: "📞 no phone number", Valid: &invalid}
+		return mods
+	}
+	mods["ctrl+shift"] = Mod{Subtitle: fmt.Sprintf("📞 call %s", *r.PhoneNumber)}
+	mods["alt+shift"] = Mod{Subtitle: fmt.Sprintf("📋 copy %s", *r.PhoneNumber)}
+	return mods
+}
+
+// telURL returns the tel: URL of a phone number, or "" without one
+func telURL(phone *string) string {
+	if phone == nil || *phone == "" {
+		return ""
+	}
+	return "tel:" + strings.Join(strings.Fields(*phone), "")
+}
+
+// renderRestaurants turns restaurants into Alfred items numbered out of total, the way every list of
+// restaurants in the workflow shows them
+func renderRestaurants(restaurants []db.Restaurant, total int, view resultView) []AlfredItem {
+	title, subtitle := resultFormats()
+	items := make([]AlfredItem, 0, len(restaurants))
+	for i, r := range restaurants {
+		fields := restaurantFields(r, i+1, total)
+		if view.Fields != nil {
+			view.Fields(i, fields)
+		}
+		name := title.render(fields)
+
+		variables := map[string]interface{}{
+			"restaurant_id":      r.ID,
+			"restaurant_name":    name,
+			"is_favorite":        r.IsFavorite,
+			"is_visited":         r.IsVisited,
+			"restaurant_url":     r.Url,
+			"website_url":        r.WebsiteUrl,
+			"mode":               view.Mode,
+			"favorite_emoji":     favoriteAction(r),
+			"visited_emoji":      visitedAction(r),
+			"myDescription":      r.Description,
+			"imageURL":           r.ImageURL,
+			"restaurant_address": r.Address,
+			"restaurant_award":   fields["award"],
+			"phone_number":       r.PhoneNumber,
+			"tel_url":            telURL(r.PhoneNumber),
+			"OPEN_IN_URL":        restaurantOpenURL(r),
+		}
+		for key, value := range view.Variables {
+			variables[key] = value
+		}
+
+		item := AlfredItem{
+			Title:     name,
+			Subtitle:  subtitle.render(fields),
+			Valid:     true,
+			Variables: variables,
+			Icon:      restaurantIcon(r),
+		}
+		item.Mods = contactMods(r)
+		if view.Mods != nil {
+			for key, mod := range view.Mods(r) {
+				item.Mods[key] = mod
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/giovanni/alfred-michelin/db"
+)
+
+func str(s string) *string { return &s }
+
+func TestRenderTemplate(t *testing.T) {
+	values := map[string]string{
+		"counter":  "1/2",
+		"distance": "",
+		"city":     "Milan, Italy",
+		"price":    "€€€",
+		"rating":   "",
+		"name":     "Seta",
+	}
+
+	cases := []struct {
+		Format   string
+		Expected string
+	}{
+		{"{counter} | {distance} | {city}", "1/2 | Milan, Italy"},
+		{"{distance} | {city} | {price}", "Milan, Italy | €€€"},
+		{"{name} ({rating})", "Seta"},
+		{"{city} ({price})", "Milan, Italy (€€€)"},
+		{"📍 {distance}", "📍"},
+		{"{name} {unknown}", "Seta {unknown}"},
+		{"{name} {unclosed", "Seta {unclosed"},
+	}
+
+	for _, tt := range cases {
+		if got := parseTemplate(tt.Format).render(values); got != tt.Expected {
+			t.Errorf("render(%q) = %q, expected %q", tt.Format, got, tt.Expected)
+		}
+	}
+}
+
+func TestRestaurantFields(t *testing.T) {
+	fields := restaurantFields(db.Restaurant{InGuide: 1}, 3, 1200)
+
+	expected := map[string]string{
+		"name":     "Unknown restaurant",
+		"city":     "Unknown location",
+		"cuisine":  "Unknown cuisine",
+		"counter":  "3/1,200",
+		"award":    "No Michelin distinction",
+		"guide":    "",
+		"favorite": "",
+		"visited":  "",
+		"visits":   "",
+		"distance": "",
+		"price":    "",
+		"rating":   "",
+		"notes":    "",
+	}
+	for field, value := range expected {
+		if fields[field] != value {
+			t.Errorf("fields[%q] = %q, expected %q", field, fields[field], value)
+		}
+	}
+
+	rating, km := 4, 0.35
+	fields = restaurantFields(db.Restaurant{
+		Name: str("Seta"), Location: str("Milan, Italy"), IsFavorite: true, UserRating: &rating,
+		DistanceKm: &km, CurrentPrice: str("CAT_P03"), CurrentPriceLevel: 3, ListNotes: str("Book early"),
+	}, 1, 1)
+	for field, value := range map[string]string{
+		"name": "Seta", "city": "Milan, Italy", "guide": "📜", "favorite": "❤️", "rating": "★★★★☆",
+		"distance": "📍 350 m", "price": "$$$", "notes": "📝 Book early",
+	} {
+		if fields[field] != value {
+			t.Errorf("fields[%q] = %q, expected %q", field, fields[field], value)
+		}
+	}
+}
+
+func TestRenderRestaurants(t *testing.T) {
+	t.Setenv("TITLE_FORMAT", "")
+	t.Setenv("SUBTITLE_FORMAT", "{counter} | {city} | {award}")
+
+	restaurants := []db.Restaurant{
+		{ID: 1, Name: str("Seta"), Location: str("Milan, Italy"), PhoneNumber: str("+39 02 8731 8897"), InGuide: 1},
+		{ID: 2, Name: str("Former"), Location: str("Paris, France"), InGuide: 0},
+	}
+	items := renderRestaurants(restaurants, 2, resultView{
+		Mode: "changes",
+		Mods: func(r db.Restaurant) map[string]Mod {
+			return map[string]Mod{"cmd+shift": {Subtitle: "view modifier"}}
+		},
+		Fields: func(i int, fields map[string]string) {
+			if i == 0 {
+				fields["award"] = "new: ⭐️"
+			}
+		},
+	})
+	if len(items) != 2 {
+		t.Fatalf("renderRestaurants returned %d items, expected 2", len(items))
+	}
+
+	seta := items[0]
+	if seta.Title != "Seta" || seta.Subtitle != "1/2 | Milan, Italy | new: ⭐️" {
+		t.Errorf("item = %q / %q, expected the award replaced by Fields", seta.Title, seta.Subtitle)
+	}
+	if seta.Variables["tel_url"] != "tel:+390287318897" || seta.Variables["mode"] != "changes" {
+		t.Errorf("unexpected variables %v", seta.Variables)
+	}
+	if seta.Mods["cmd+shift"].Subtitle != "view modifier" {
+		t.Errorf("view modifier did not override the contact modifier: %+v", seta.Mods["cmd+shift"])
+	}
+	if call := seta.Mods["ctrl+shift"]; call.Subtitle != "📞 call +39 02 8731 8897" || call.Valid != nil {
+		t.Errorf("unexpected call modifier %+v", call)
+	}
+
+	// A restaurant no longer in the guide is marked in the title and gets the black star icon,
+	// and its phone modifiers are disabled without a number
+	former := items[1]
+	if former.Title != "Former 📜" || former.Icon["path"] != "icons/blackStar.png" {
+		t.Errorf("former restaurant rendered as %q with icon %v", former.Title, former.Icon)
+	}
+	if call := former.Mods["ctrl+shift"]; call.Valid == nil || *call.Valid {
+		t.Errorf("call modifier without a phone number should be invalid: %+v", call)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/giovanni/alfred-michelin/db"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// stampdataset records the dataset version of a freshly built database and packages it with a
+// manifest, optionally signed, so that the workflow can verify what it installs
+func main() {
+	dbPath := flag.String("db", "michelin.db", "database to stamp")
+	version := flag.String("version", "", "dataset version, e.g. 2025.07")
+	source := flag.String("source", "michelin-my-maps", "where the data was scraped from")
+	zipPath := flag.String("zip", "", "write an update archive with an embedded manifest.json")
+	keyPath := flag.String("key", "", "file holding the base64 ed25519 private key to sign the manifest with")
+	genKey := flag.String("genkey", "", "write a new base64 ed25519 private key to this file and print its public key")
+	deltaPath := flag.String("delta", "", "delta from the previous release, built with the database builder's cmd/delta")
+	deltaBase := flag.String("delta-base", "", "dataset version the delta applies to")
+	flag.Parse()
+
+	if *genKey != "" {
+		public, private, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			fail("Failed to generate key: %v", err)
+		}
+		if err := os.WriteFile(*genKey, []byte(base64.StdEncoding.EncodeToString(private)+"\n"), 0600); err != nil {
+			fail("Failed to write key: %v", err)
+		}
+		fmt.Printf("Private key written to %s, keep it out of the repository\n", *genKey)
+		fmt.Printf("Public key (UPDATE_PUBLIC_KEY): %s\n", base64.StdEncoding.EncodeToString(public))
+		return
+	}
+
+	if *version == "" {
+		fmt.Fprintln(os.Stderr, "Usage: go run ./tools/stampdataset -db michelin.db -version 2025.07 [-zip michelin.db.zip] [-key private.key] [-delta michelin.delta.json.gz -delta-base 2025.04]")
+		os.Exit(2)
+	}
+
+	database, err := sql.Open("sqlite3", *dbPath)
+	if err != nil {
+		fail("Failed to open database: %v", err)
+	}
+	if err := db.StampDataset(database, *version, *source); err != nil {
+		fail("Failed to stamp database: %v", err)
+	}
+	info, err := db.GetDatasetInfo(database)
+	if err != nil {
+		fail("Failed to read dataset info: %v", err)
+	}
+	database.Close()
+	fmt.Printf("Stamped %s as dataset %s: %d restaurants, guide %d\n", *dbPath, info.Version, info.Restaurants, info.LatestYear)
+
+	if *zipPath == "" {
+		return
+	}
+
+	manifest, err := db.BuildManifest(*dbPath)
+	if err != nil {
+		fail("Failed to build manifest: %v", err)
+	}
+	if *deltaPath != "" {
+		if *deltaBase == "" {
+			fail("-delta-base is required with -delta")
+		}
+		sum, err := sha256File(*deltaPath)
+		if err != nil {
+			fail("Failed to checksum delta: %v", err)
+		}
+		manifest.Delta = &db.DeltaRef{BaseVersion: *deltaBase, URL: filepath.Base(*deltaPath), SHA256: sum}
+	}
+	if *keyPath != "" {
+		data, err := os.ReadFile(*keyPath)
+		if err != nil {
+			fail("Failed to read key: %v", err)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != ed25519.PrivateKeySize {
+			fail("%s is not a base64 ed25519 private key", *keyPath)
+		}
+		db.SignManifest(&manifest, ed25519.PrivateKey(key))
+	}
+	if err := db.WriteArchive(*zipPath, *dbPath, manifest); err != nil {
+		fail("Failed to write archive: %v", err)
+	}
+
+	signed := "unsigned"
+	if manifest.Signature != "" {
+		signed = "signed"
+	}
+	fmt.Printf("Wrote %s (%s, database sha256 %s)\n", *zipPath, signed, manifest.DatabaseSHA256)
+
+	// The manifest published next to the archive also locates and checksums it
+	if manifest.SHA256, err = sha256File(*zipPath); err != nil {
+		fail("Failed to checksum archive: %v", err)
+	}
+	manifest.URL = filepath.Base(*zipPath)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		fail("Failed to encode manifest: %v", err)
+	}
+	manifestPath := filepath.Join(filepath.Dir(*zipPath), db.UpdateManifestName)
+	if err := os.WriteFile(manifestPath, append(data, '\n'), 0644); err != nil {
+		fail("Failed to write manifest: %v", err)
+	}
+	fmt.Printf("Wrote %s\n", manifestPath)
+}
+
+// sha256File returns the hex SHA-256 of a file
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fail prints an error and exits
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}